@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -20,6 +22,11 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "reindex" {
+		reindex(os.Args[2:])
+		return
+	}
+
 	app := Setup()
 
 	value, exists := os.LookupEnv("API_PORT")
@@ -32,12 +39,35 @@ func main() {
 	log.Fatal(app.Listen(fmt.Sprintf(":%d", api_port)))
 }
 
+// reindex moves the documents of indices with an outdated mapping into new
+// indices: of the collections and all items, or of the items of the
+// collections given.
+func reindex(args []string) {
+	flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+	force := flags.Bool("force", false, "reindex the indices whose mapping is up to date too")
+	flags.Parse(args)
+
+	database.ConnectES()
+	if err := database.Reindex(context.Background(), flags.Args(), *force); err != nil {
+		log.Fatalf("Could not reindex: %v", err)
+	}
+}
+
 func Setup() *fiber.App {
 	// connect to database: elastic search
 	database.ConnectES()
 
-	// create new fiber app
-	app := fiber.New()
+	// create new fiber app, whose body limit fits bulk item requests
+	bodyLimit := 64 * 1024 * 1024
+	if value, exists := os.LookupEnv("BODY_LIMIT"); exists {
+		// fiber takes a limit that is not positive for its default of 4MB
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			log.Fatalf("BODY_LIMIT must be a positive number of bytes, not %q", value)
+		}
+		bodyLimit = limit
+	}
+	app := fiber.New(fiber.Config{BodyLimit: bodyLimit})
 
 	// register middleware
 	app.Use(cors.New())
@@ -67,6 +97,7 @@ func Setup() *fiber.App {
 
 	router.ESCollectionRoute(app)
 	router.ESItemRoute(app)
+	router.ESSearchRoute(app)
 
 	app.All("*", func(c *fiber.Ctx) error {
 		errorMessage := fmt.Sprintf("Route '%s' does not exist in this API!", c.OriginalURL())
@@ -0,0 +1,262 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jonhealy1/goapi-stac/es-api/database"
+	"github.com/jonhealy1/goapi-stac/es-api/models"
+	"github.com/olivere/elastic/v7"
+)
+
+// maxBuckets is the number of buckets a frequency distribution returns at
+// most. The items of the buckets left out are counted in its overflow.
+const maxBuckets = 1000
+
+// cloudCoverWidth is the width of the cloud_cover_frequency ranges.
+const cloudCoverWidth = 10
+
+// esAggregation is an aggregation of the items matching a search, computed
+// by the aggregation build returns. total_count has none, as it is the
+// total number of hits.
+type esAggregation struct {
+	dataType string
+	keyType  string
+	build    func(interval string) elastic.Aggregation
+}
+
+func termsAggregation(field string) func(string) elastic.Aggregation {
+	return func(string) elastic.Aggregation {
+		return elastic.NewTermsAggregation().Field(field).Size(maxBuckets)
+	}
+}
+
+// datetimeIntervals are the datetime_frequency_interval values, the
+// calendar intervals datetimes are bucketed in.
+var datetimeIntervals = map[string]bool{"year": true, "month": true, "day": true, "hour": true}
+
+// aggregations lists the aggregations /aggregate computes, the same as in
+// pg-api.
+var aggregations = map[string]esAggregation{
+	"total_count": {dataType: "integer"},
+	"collection_frequency": {
+		dataType: "frequency_distribution",
+		keyType:  "string",
+		build:    termsAggregation("collection"),
+	},
+	"platform_frequency": {
+		dataType: "frequency_distribution",
+		keyType:  "string",
+		build:    termsAggregation("properties.platform.keyword"),
+	},
+	"constellation_frequency": {
+		dataType: "frequency_distribution",
+		keyType:  "string",
+		build:    termsAggregation("properties.constellation.keyword"),
+	},
+	"datetime_frequency": {
+		dataType: "frequency_distribution",
+		keyType:  "datetime",
+		build: func(interval string) elastic.Aggregation {
+			return elastic.NewDateHistogramAggregation().
+				Field("properties.datetime").
+				CalendarInterval(interval).
+				Format("yyyy-MM-dd'T'HH:mm:ss'Z'").
+				MinDocCount(1)
+		},
+	},
+	"cloud_cover_frequency": {
+		dataType: "frequency_distribution",
+		keyType:  "numeric",
+		build: func(string) elastic.Aggregation {
+			return elastic.NewHistogramAggregation().
+				Field("properties.eo:cloud_cover").
+				Interval(cloudCoverWidth).
+				MinDocCount(1)
+		},
+	},
+}
+
+// aggregationResult reads the result of an aggregation from a search.
+func (a esAggregation) aggregationResult(name string, result *elastic.SearchResult) fiber.Map {
+	aggregation := fiber.Map{"name": name, "data_type": a.dataType}
+	if a.build == nil {
+		total, _ := numberMatched(result)
+		aggregation["value"] = total
+		return aggregation
+	}
+
+	buckets := []fiber.Map{}
+	overflow := 0
+	add := func(bucket fiber.Map, count int64) {
+		if len(buckets) >= maxBuckets {
+			overflow += int(count)
+			return
+		}
+		bucket["data_type"], bucket["frequency"] = a.keyType, count
+		buckets = append(buckets, bucket)
+	}
+
+	switch a.keyType {
+	case "string":
+		if terms, ok := result.Aggregations.Terms(name); ok {
+			overflow += int(terms.SumOfOtherDocCount)
+			for _, bucket := range terms.Buckets {
+				add(fiber.Map{"key": fmt.Sprint(bucket.Key)}, bucket.DocCount)
+			}
+		}
+	case "datetime":
+		if histogram, ok := result.Aggregations.DateHistogram(name); ok {
+			for _, bucket := range histogram.Buckets {
+				if bucket.KeyAsString != nil {
+					add(fiber.Map{"key": *bucket.KeyAsString}, bucket.DocCount)
+				}
+			}
+		}
+	case "numeric":
+		if histogram, ok := result.Aggregations.Histogram(name); ok {
+			for _, bucket := range histogram.Buckets {
+				// 100% cloud cover falls in the last range
+				lower := math.Min(bucket.Key, 100-cloudCoverWidth)
+				if n := len(buckets); n > 0 && buckets[n-1]["from"] == lower {
+					buckets[n-1]["frequency"] = buckets[n-1]["frequency"].(int64) + bucket.DocCount
+					continue
+				}
+				add(fiber.Map{
+					"key":  strconv.FormatFloat(lower, 'f', -1, 64),
+					"from": lower,
+					"to":   lower + cloudCoverWidth,
+				}, bucket.DocCount)
+			}
+		}
+	}
+
+	aggregation["overflow"] = overflow
+	aggregation["buckets"] = buckets
+	return aggregation
+}
+
+func ESAggregations(c *fiber.Ctx) error {
+	var names []string
+	for name := range aggregations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	available := []models.Aggregation{}
+	for _, name := range names {
+		available = append(available, models.Aggregation{Name: name, DataType: aggregations[name].dataType})
+	}
+
+	return c.Status(http.StatusOK).JSON(&fiber.Map{
+		"aggregations": available,
+		"links": []models.Link{
+			{Rel: "root", Type: "application/json", Href: c.BaseURL() + "/"},
+			{Rel: "self", Type: "application/json", Href: c.BaseURL() + "/aggregations"},
+		},
+	})
+}
+
+func ESGetAggregate(c *fiber.Ctx) error {
+	search, err := getSearchParams(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+
+	request := models.AggregationSearch{
+		Search:                    search,
+		DatetimeFrequencyInterval: c.Query("datetime_frequency_interval"),
+	}
+	for _, name := range strings.Split(c.Query("aggregations"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			request.Aggregations = append(request.Aggregations, name)
+		}
+	}
+	return runAggregate(c, request)
+}
+
+func ESPostAggregate(c *fiber.Ctx) error {
+	var request models.AggregationSearch
+
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+	return runAggregate(c, request)
+}
+
+// runAggregate computes the aggregations of a GET or POST request in one
+// search returning no hits. The total count is computed when none are
+// requested.
+func runAggregate(c *fiber.Ctx, request models.AggregationSearch) error {
+	names := request.Aggregations
+	if len(names) == 0 {
+		names = []string{"total_count"}
+	}
+	for _, name := range names {
+		if _, ok := aggregations[name]; !ok {
+			return c.Status(http.StatusBadRequest).JSON(
+				&fiber.Map{"message": fmt.Sprintf("unknown aggregation %q, see /aggregations for the aggregations available", name)})
+		}
+	}
+	interval := request.DatetimeFrequencyInterval
+	if interval == "" {
+		interval = "month"
+	}
+	if !datetimeIntervals[interval] {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": "datetime_frequency_interval must be one of year, month, day or hour"})
+	}
+
+	query, err := searchQuery(request.Search)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+	if err := checkQueryables(request.Search); err != nil {
+		return queryablesError(c, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	search := database.ES.Client.Search().
+		Index(database.ItemsIndices(request.Collections)...).
+		// collections without items have no index to search
+		IgnoreUnavailable(true).
+		Query(query).
+		Size(0).
+		TrackTotalHits(true)
+	for _, name := range names {
+		if build := aggregations[name].build; build != nil {
+			search = search.Aggregation(name, build(interval))
+		}
+	}
+	searchResult, err := search.Do(ctx)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(
+			&fiber.Map{"message": "error aggregating items in Elasticsearch"})
+	}
+
+	results := []fiber.Map{}
+	for _, name := range names {
+		results = append(results, aggregations[name].aggregationResult(name, searchResult))
+	}
+
+	return c.Status(http.StatusOK).JSON(&fiber.Map{
+		"type":         "AggregationCollection",
+		"aggregations": results,
+		"links": []models.Link{
+			{Rel: "root", Type: "application/json", Href: c.BaseURL() + "/"},
+			{Rel: "self", Type: "application/json", Href: c.BaseURL() + c.OriginalURL()},
+		},
+	})
+}
@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+
+	"github.com/jonhealy1/goapi-stac/es-api/database"
+	"github.com/jonhealy1/goapi-stac/shared/stac"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/olivere/elastic/v7"
+)
+
+// The outcomes of the items of a bulk request.
+const (
+	bulkCreated = "created"
+	bulkUpdated = "updated"
+	bulkSkipped = "skipped"
+	bulkFailed  = "failed"
+)
+
+// bulkOpTypes are the bulk operations writing an item, by how an item whose
+// id exists already is handled: as an error, by skipping it, or by
+// replacing it.
+var bulkOpTypes = map[string]string{"error": "create", "skip": "create", "upsert": "index"}
+
+// bulkRefreshes are the refresh parameters of a bulk request: whether the
+// items are searchable once it is answered, or waiting for that.
+var bulkRefreshes = map[string]bool{"false": true, "true": true, "wait_for": true}
+
+// bulkResult is the outcome of an item of a bulk request.
+type bulkResult struct {
+	// Index is the position of the item in the request.
+	Index   int    `json:"index"`
+	Id      string `json:"id,omitempty"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+
+	// data is the item to write, unless it failed or is skipped.
+	data []byte
+}
+
+func (r *bulkResult) fail(message string) {
+	r.Status, r.Message, r.data = bulkFailed, message, nil
+}
+
+// isItemSequence tells whether the body of a request is a sequence of
+// newline delimited items.
+func isItemSequence(c *fiber.Ctx) bool {
+	mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	return mediaType == "application/x-ndjson" || mediaType == "application/geo+json-seq"
+}
+
+// isBulkRequest tells whether a request to create items holds a
+// FeatureCollection or newline delimited items rather than one item.
+func isBulkRequest(c *fiber.Ctx) bool {
+	if isItemSequence(c) {
+		return true
+	}
+	var body struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(c.Body(), &body)
+	return body.Type == "FeatureCollection"
+}
+
+// readBulkItems reads the items of a bulk request, one per line of an
+// NDJSON body or the features of a FeatureCollection, and checks them.
+func readBulkItems(c *fiber.Ctx, collectionId string) ([]*bulkResult, error) {
+	var features []json.RawMessage
+	if isItemSequence(c) {
+		for _, line := range bytes.Split(c.Body(), []byte("\n")) {
+			// GeoJSON text sequences start each item with a record separator
+			line = bytes.TrimSpace(bytes.TrimPrefix(line, []byte("\x1e")))
+			if len(line) > 0 {
+				features = append(features, line)
+			}
+		}
+	} else {
+		var itemCollection struct {
+			Features []json.RawMessage `json:"features"`
+		}
+		if err := json.Unmarshal(c.Body(), &itemCollection); err != nil {
+			return nil, err
+		}
+		features = itemCollection.Features
+	}
+
+	results := make([]*bulkResult, len(features))
+	for i, feature := range features {
+		result := &bulkResult{Index: i}
+		results[i] = result
+
+		var item struct {
+			Id string `json:"id"`
+		}
+		json.Unmarshal(feature, &item)
+		result.Id = item.Id
+		data, _, err := stac.CheckItem(feature, collectionId)
+		if err != nil {
+			result.fail(err.Error())
+			continue
+		}
+		result.data = data
+	}
+	return results, nil
+}
+
+// esCreateItems writes the items of a bulk request through the bulk
+// indexer, and answers with the outcome of every item.
+func esCreateItems(c *fiber.Ctx, collectionId string) error {
+	conflict := c.Query("on_conflict", "error")
+	opType, ok := bulkOpTypes[conflict]
+	if !ok {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": "on_conflict must be error, skip or upsert"})
+	}
+	refresh := c.Query("refresh", "false")
+	if !bulkRefreshes[refresh] {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": "refresh must be false, true or wait_for"})
+	}
+
+	results, err := readBulkItems(c, collectionId)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": "invalid FeatureCollection: " + err.Error()})
+	}
+
+	// items of the same id would be written in any order, so only the first
+	// one is
+	var written []*bulkResult
+	var requests []elastic.BulkableRequest
+	seen := map[string]bool{}
+	for _, result := range results {
+		switch {
+		case result.data == nil:
+		case seen[result.Id] && conflict == "skip":
+			result.Status, result.data = bulkSkipped, nil
+		case seen[result.Id]:
+			result.fail("item appears twice in the request")
+		default:
+			seen[result.Id] = true
+			written = append(written, result)
+			requests = append(requests, elastic.NewBulkIndexRequest().
+				Index(database.ItemsIndex(collectionId)).
+				Id(result.Id).
+				OpType(opType).
+				Doc(json.RawMessage(result.data)))
+		}
+	}
+
+	outcomes, err := database.ES.Bulk.Write(c.UserContext(), requests, refresh)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(
+			&fiber.Map{"message": "could not index the items"})
+	}
+	var revisions []database.Revision
+	for i, outcome := range outcomes {
+		result := written[i]
+		switch {
+		case outcome.Status == http.StatusConflict && conflict == "skip":
+			result.Status = bulkSkipped
+		case outcome.Status == http.StatusConflict:
+			result.fail("item already exists")
+		case outcome.Blocked:
+			// the item can be written again once the reindex is done
+			result.fail(errWritesBlocked.message)
+			c.Set(fiber.HeaderRetryAfter, writesBlockedRetryAfter)
+		case outcome.Failed():
+			result.fail(outcome.Error)
+		case outcome.Result == "created":
+			result.Status = bulkCreated
+		default:
+			result.Status = bulkUpdated
+		}
+
+		if result.Status == bulkCreated || result.Status == bulkUpdated {
+			operation := "insert"
+			if result.Status == bulkUpdated {
+				operation = "update"
+			}
+			revisions = append(revisions, database.Revision{
+				Id: result.Id, Collection: collectionId, Operation: operation, Source: result.data,
+			})
+		}
+	}
+	if len(revisions) > 0 {
+		recordRevisions(c, database.ItemHistoryAlias, revisions...)
+	}
+
+	status := http.StatusCreated
+	for _, result := range results {
+		if result.Status == bulkFailed {
+			status = http.StatusMultiStatus
+		}
+	}
+	return c.Status(status).JSON(&fiber.Map{
+		"message":    "success",
+		"collection": collectionId,
+		"results":    results,
+	})
+}
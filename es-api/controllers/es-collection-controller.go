@@ -8,7 +8,7 @@ import (
 	"time"
 
 	"github.com/jonhealy1/goapi-stac/es-api/database"
-	"github.com/olivere/elastic"
+	"github.com/olivere/elastic/v7"
 
 	"github.com/go-playground/validator"
 	"github.com/gofiber/fiber/v2"
@@ -44,6 +44,50 @@ func Root(c *fiber.Ctx) error {
 	return c.Status(http.StatusOK).JSON(rootCatalog)
 }
 
+func Conformance(c *fiber.Ctx) error {
+	conformsTo := []string{
+		"https://api.stacspec.org/v1.0.0/core",
+		"https://api.stacspec.org/v1.0.0/collections",
+		"https://api.stacspec.org/v1.0.0/ogcapi-features",
+		"https://api.stacspec.org/v1.0.0/ogcapi-features#fields",
+		"https://api.stacspec.org/v1.0.0/ogcapi-features#query",
+		"https://api.stacspec.org/v1.0.0/ogcapi-features#sort",
+		"https://api.stacspec.org/v1.0.0/ogcapi-features#free-text",
+		"https://api.stacspec.org/v1.0.0/ogcapi-features#advanced-free-text",
+		"https://api.stacspec.org/v1.0.0/item-search",
+		"https://api.stacspec.org/v1.0.0/item-search#fields",
+		"https://api.stacspec.org/v1.0.0/item-search#query",
+		"https://api.stacspec.org/v1.0.0/item-search#sort",
+		"https://api.stacspec.org/v1.0.0/item-search#filter",
+		"https://api.stacspec.org/v1.0.0/item-search#free-text",
+		"https://api.stacspec.org/v1.0.0/item-search#advanced-free-text",
+		"https://api.stacspec.org/v1.0.0/collection-search",
+		"https://api.stacspec.org/v1.0.0/collection-search#free-text",
+		"https://api.stacspec.org/v1.0.0/collection-search#advanced-free-text",
+		"https://api.stacspec.org/v1.0.0/collection-search#sort",
+		// the aggregation extension is versioned apart from the STAC API
+		"https://api.stacspec.org/v0.3.0/aggregation",
+		"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/core",
+		"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/geojson",
+		"http://www.opengis.net/spec/ogcapi-features-2/1.0/conf/crs",
+		"http://www.opengis.net/spec/ogcapi-features-3/1.0/conf/queryables",
+		"http://www.opengis.net/spec/cql2/1.0/conf/cql2-json",
+		"http://www.opengis.net/spec/cql2/1.0/conf/cql2-text",
+		"http://www.opengis.net/spec/cql2/1.0/conf/basic-cql2",
+		"http://www.opengis.net/spec/cql2/1.0/conf/advanced-comparison-operators",
+		// basic spatial functions only require s_intersects. Elasticsearch
+		// has no relation for s_equals, s_touches, s_overlaps and
+		// s_crosses, so the spatial-functions class pg-api conforms to is
+		// not claimed.
+		"http://www.opengis.net/spec/cql2/1.0/conf/basic-spatial-functions",
+		"http://www.opengis.net/spec/cql2/1.0/conf/temporal-functions",
+	}
+
+	return c.Status(http.StatusOK).JSON(&fiber.Map{
+		"conformsTo": conformsTo,
+	})
+}
+
 func CreateESCollection(c *fiber.Ctx) error {
 	stac_collection := new(models.StacCollection)
 	err := c.BodyParser(&stac_collection)
@@ -59,6 +103,7 @@ func CreateESCollection(c *fiber.Ctx) error {
 		Id:        stac_collection.Id,
 		CreatedAt: &now,
 	}
+	setCollectionExtent(&collection, stac_collection)
 	validator := validator.New()
 	err = validator.Struct(collection)
 
@@ -92,18 +137,33 @@ func CreateESCollection(c *fiber.Ctx) error {
 		return err
 	}
 
+	// the items of the collection are kept in an index of their own
+	if err := database.CreateItemsIndex(ctx, collection.Id); err != nil {
+		c.Status(http.StatusInternalServerError).JSON(
+			&fiber.Map{"message": "could not create the items index of the collection"})
+		return err
+	}
+
 	resp, err := database.ES.Client.Index().
 		Index(indexName).
 		Id(collection.Id).
 		BodyString(string(doc)).
 		Do(ctx)
 
+	if database.WritesBlocked(err) {
+		return writeError(c, errWritesBlocked, "could not index collection")
+	}
 	if err != nil {
 		c.Status(http.StatusBadRequest).JSON(
 			&fiber.Map{"message": "could not index collection"})
 		return err
 	}
 
+	recordRevisions(c, database.CollectionHistoryAlias, database.Revision{
+		Id: collection.Id, Operation: "insert", Source: doc,
+	})
+
+	c.Set(fiber.HeaderETag, versionETag(resp.SeqNo, resp.PrimaryTerm))
 	c.Status(http.StatusCreated).JSON(&fiber.Map{
 		"message":         "success",
 		"id":              resp.Id,
@@ -146,45 +206,95 @@ func GetESCollection(c *fiber.Ctx) error {
 			&fiber.Map{"message": "Error unmarshalling the collection"})
 	}
 
+	if notModified(c, resp) {
+		return nil
+	}
+
 	// Return the stac_collection JSON
 	return c.JSON(collection.Data[0])
 }
 
+// GetESCollections lists the collections matching a collection search, a
+// page at a time.
 func GetESCollections(c *fiber.Ctx) error {
-	indexName := "collections"
+	params, err := getCollectionSearchParams(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+	limit := pageLimit(params.Limit)
+	token, err := decodeToken(params.Token)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+	reverse := token != nil && token.Prev
+	sorters, err := collectionSorters(params.Sortby, reverse)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+	if params.Q != "" && len(params.Sortby) == 0 {
+		// the most relevant collections come first
+		sorters = relevanceSorters(reverse)
+	}
+	if err := checkToken(token, sorters); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+	query, err := collectionSearchQuery(params)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
 
-	// Retrieve all collection documents from Elasticsearch
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	searchResult, err := database.ES.Client.Search().
-		Index(indexName).
-		Size(1000). // Adjust this value based on the expected number of collections
-		Sort("CreatedAt", true).
-		Do(ctx)
-
+	search, pit, err := pageSearch(ctx, []string{"collections"}, sorters, limit, token)
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(
 			&fiber.Map{"message": "Error retrieving the collections"})
 	}
+	searchResult, err := search.Query(query).Do(ctx)
+	if pitExpired(err, token) {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": "pagination token has expired"})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(
+			&fiber.Map{"message": "Error retrieving the collections"})
+	}
+
+	hits, next, prev := paginate(searchResult.Hits.Hits, limit, token, resultPit(searchResult, pit))
 
-	// Unmarshal the Elasticsearch documents sources into a list of models.Collection
-	collections := make([]models.Collection, 0, len(searchResult.Hits.Hits))
-	for _, hit := range searchResult.Hits.Hits {
+	collections := []interface{}{}
+	for _, hit := range hits {
 		var collection models.Collection
-		if err := json.Unmarshal(hit.Source, &collection); err != nil {
+		if err := json.Unmarshal(hit.Source, &collection); err != nil || len(collection.Data) == 0 {
 			return c.Status(http.StatusInternalServerError).JSON(
 				&fiber.Map{"message": "Error unmarshalling the collection"})
 		}
-		collections = append(collections, collection)
+		collections = append(collections, collection.Data[0])
 	}
 
-	// Extract and return the collection.Data from each collection
-	collectionDataList := make([]interface{}, len(collections))
-	for i, collection := range collections {
-		collectionDataList[i] = collection.Data[0]
-	}
-	return c.JSON(collectionDataList)
+	matched, estimated := numberMatched(searchResult)
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"collections": collections,
+		"context": models.Context{
+			Returned:         len(collections),
+			Limit:            limit,
+			Matched:          matched,
+			MatchedEstimated: estimated,
+		},
+		"numberMatched":  matched,
+		"numberReturned": len(collections),
+		"links": append([]models.Link{
+			{Rel: "self", Type: "application/json", Href: c.BaseURL() + c.OriginalURL()},
+			{Rel: "root", Type: "application/json", Href: c.BaseURL() + "/"},
+		}, getPageLinks(c, next, prev)...),
+	})
 }
 
 func EditESCollection(c *fiber.Ctx) error {
@@ -209,6 +319,7 @@ func EditESCollection(c *fiber.Ctx) error {
 		Id:        stac_collection.Id,
 		UpdatedAt: &now,
 	}
+	setCollectionExtent(&collection, stac_collection)
 	validator := validator.New()
 	err = validator.Struct(collection)
 
@@ -224,7 +335,7 @@ func EditESCollection(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err = database.ES.Client.Get().
+	current, err := database.ES.Client.Get().
 		Index(indexName).
 		Id(id).
 		Do(ctx)
@@ -234,6 +345,9 @@ func EditESCollection(c *fiber.Ctx) error {
 			&fiber.Map{"message": fmt.Sprintf("Collection %s not found", id)})
 		return err
 	}
+	if err := checkIfMatch(c, current); err != nil {
+		return writeError(c, err, "could not update collection")
+	}
 
 	doc, err := json.Marshal(collection)
 	if err != nil {
@@ -251,18 +365,37 @@ func EditESCollection(c *fiber.Ctx) error {
 		return err
 	}
 
-	resp, err := database.ES.Client.Update().
+	service := database.ES.Client.Update().
 		Index(indexName).
 		Id(id).
 		Doc(docMap).
-		Do(ctx)
+		FetchSource(true)
+	if conditional(c) {
+		service.IfSeqNo(*current.SeqNo).IfPrimaryTerm(*current.PrimaryTerm)
+	}
+	resp, err := service.Do(ctx)
 
+	if database.WritesBlocked(err) {
+		return writeError(c, errWritesBlocked, "could not update collection")
+	}
+	if lostUpdate(c, err) {
+		return writeError(c, errPreconditionFailed, "could not update collection")
+	}
 	if err != nil {
 		c.Status(http.StatusBadRequest).JSON(
 			&fiber.Map{"message": "could not update collection"})
 		return err
 	}
 
+	// the history keeps the collection as the update left it
+	if resp.GetResult != nil {
+		recordRevisions(c, database.CollectionHistoryAlias, database.Revision{
+			Id: id, Operation: "update", Source: resp.GetResult.Source,
+		})
+	}
+
+	c.Set(fiber.HeaderETag, versionETag(resp.SeqNo, resp.PrimaryTerm))
+
 	c.Status(http.StatusOK).JSON(&fiber.Map{
 		"message":         "success",
 		"id":              resp.Id,
@@ -284,7 +417,7 @@ func DeleteESCollection(c *fiber.Ctx) error {
 	defer cancel()
 
 	// Check if the collection exists
-	_, err := database.ES.Client.Get().
+	current, err := database.ES.Client.Get().
 		Index(indexName).
 		Id(id).
 		Do(ctx)
@@ -294,19 +427,43 @@ func DeleteESCollection(c *fiber.Ctx) error {
 			&fiber.Map{"message": fmt.Sprintf("Collection %s not found", id)})
 		return err
 	}
+	if err := checkIfMatch(c, current); err != nil {
+		return writeError(c, err, "could not delete collection")
+	}
 
 	// Delete the collection document from Elasticsearch
-	resp, err := database.ES.Client.Delete().
+	service := database.ES.Client.Delete().
 		Index(indexName).
-		Id(id).
-		Do(ctx)
+		Id(id)
+	if conditional(c) {
+		service.IfSeqNo(*current.SeqNo).IfPrimaryTerm(*current.PrimaryTerm)
+	}
+	resp, err := service.Do(ctx)
 
+	if database.WritesBlocked(err) {
+		return writeError(c, errWritesBlocked, "could not delete collection")
+	}
+	if lostUpdate(c, err) {
+		return writeError(c, errPreconditionFailed, "could not delete collection")
+	}
 	if err != nil {
 		c.Status(http.StatusBadRequest).JSON(
 			&fiber.Map{"message": "could not delete collection"})
 		return err
 	}
 
+	// deleting the index of its items deletes them all at once
+	if err := database.DeleteItemsIndex(ctx, id); err != nil {
+		c.Status(http.StatusInternalServerError).JSON(
+			&fiber.Map{"message": "could not delete the items of the collection"})
+		return err
+	}
+
+	recordRevisions(c, database.CollectionHistoryAlias, database.Revision{
+		Id: id, Operation: "delete",
+	})
+	recordItemsDeleted(c, id)
+
 	c.Status(http.StatusOK).JSON(&fiber.Map{
 		"message": "success",
 		"id":      resp.Id,
@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jonhealy1/goapi-stac/es-api/models"
+	"github.com/jonhealy1/goapi-stac/shared/freetext"
+	"github.com/jonhealy1/goapi-stac/shared/params"
+	"github.com/olivere/elastic/v7"
+)
+
+// collectionSortables lists the fields collection searches can be sorted
+// by, the same as in pg-api. The datetimes are the bounds of the temporal
+// extent.
+var collectionSortables = map[string]esSortable{
+	"id":             {"id", "keyword", stringSchema},
+	"title":          {"data.title.keyword", "keyword", stringSchema},
+	"start_datetime": {"extent_start", "date", datetimeSchema},
+	"end_datetime":   {"extent_end", "date", datetimeSchema},
+}
+
+// collectionSorters returns the sorters for a collection search, with the
+// id as a tie-breaker. Collections are listed by id by default.
+func collectionSorters(sortby []models.Sort, reverse bool) ([]elastic.Sorter, error) {
+	var sorters []elastic.Sorter
+	hasID := false
+	for _, sort := range sortby {
+		s, ok := collectionSortables[sort.Field]
+		if !ok {
+			return nil, fmt.Errorf("cannot sort collections by %q, they can be sorted by id, title, start_datetime and end_datetime", sort.Field)
+		}
+
+		ascending := true
+		switch strings.ToLower(sort.Direction) {
+		case "", "asc":
+		case "desc":
+			ascending = false
+		default:
+			return nil, fmt.Errorf("sort direction must be asc or desc, got %q", sort.Direction)
+		}
+
+		missing := "_last"
+		if reverse {
+			missing = "_first"
+		}
+		hasID = hasID || sort.Field == "id"
+		sorters = append(sorters, elastic.NewFieldSort(s.field).Order(ascending != reverse).Missing(missing).UnmappedType(s.esType))
+	}
+	if !hasID {
+		sorters = append(sorters, elastic.NewFieldSort("id").Order(!reverse))
+	}
+	return sorters, nil
+}
+
+// collectionSearchQuery builds the query matching the collections a
+// collection search selects. The bbox and datetime are matched against
+// the extents of the collections, where an open interval matches any
+// datetime on its open side.
+func collectionSearchQuery(search models.Search) (*elastic.BoolQuery, error) {
+	query := elastic.NewBoolQuery()
+
+	if search.Q != "" {
+		text, err := freetext.Parse(string(search.Q))
+		if err != nil {
+			return nil, err
+		}
+		query.Must(textQuery(text, collectionTextFields))
+	}
+
+	if len(search.Bbox) > 0 {
+		bbox, err := bboxQuery("extent_geometry", search.Bbox)
+		if err != nil {
+			return nil, err
+		}
+		query.Filter(bbox)
+	}
+
+	if search.Datetime != "" {
+		interval, err := params.ParseDatetime(search.Datetime)
+		if err != nil {
+			return nil, err
+		}
+		if interval.Start != nil {
+			query.Filter(openRangeQuery("extent_end", elastic.NewRangeQuery("extent_end").Gte(interval.Start.Format(time.RFC3339Nano))))
+		}
+		if interval.End != nil {
+			query.Filter(openRangeQuery("extent_start", elastic.NewRangeQuery("extent_start").Lte(interval.End.Format(time.RFC3339Nano))))
+		}
+	}
+
+	return query, nil
+}
+
+// openRangeQuery matches the documents in a range of field, or without it.
+func openRangeQuery(field string, inRange elastic.Query) elastic.Query {
+	return elastic.NewBoolQuery().
+		Should(inRange, elastic.NewBoolQuery().MustNot(elastic.NewExistsQuery(field))).
+		MinimumNumberShouldMatch(1)
+}
+
+// getCollectionSearchParams reads the query parameters of a collection
+// search.
+func getCollectionSearchParams(c *fiber.Ctx) (models.Search, error) {
+	search := models.Search{
+		Q:        models.FreeText(c.Query("q")),
+		Datetime: c.Query("datetime"),
+		Token:    c.Query("token"),
+		Sortby:   params.ParseSortby(c.Query("sortby")),
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		var err error
+		if search.Limit, err = strconv.Atoi(limit); err != nil || search.Limit < 1 {
+			return search, fmt.Errorf("limit must be a positive integer")
+		}
+	}
+
+	bbox, err := params.ParseBbox(c.Query("bbox"))
+	if err != nil {
+		return search, err
+	}
+	if search.Bbox, err = params.BboxToCRS84(bbox, c.Query("bbox-crs")); err != nil {
+		return search, err
+	}
+	return search, nil
+}
+
+// setCollectionExtent indexes the overall extent of a collection, its
+// first bbox and interval, for collection search.
+func setCollectionExtent(collection *models.Collection, stac *models.StacCollection) {
+	collection.ExtentGeometry, collection.ExtentStart, collection.ExtentEnd = nil, nil, nil
+
+	if bboxes := stac.Extent.Spatial.Bbox; len(bboxes) > 0 && (len(bboxes[0]) == 4 || len(bboxes[0]) == 6) {
+		bbox := bboxes[0]
+		half := len(bbox) / 2
+		west, south, east, north := bbox[0], bbox[1], bbox[half], bbox[half+1]
+		if west <= east {
+			collection.ExtentGeometry = map[string]interface{}{
+				"type":        "envelope",
+				"coordinates": [][]float64{{west, north}, {east, south}},
+			}
+		} else {
+			// split at the antimeridian
+			rectangle := func(west float64, east float64) [][][]float64 {
+				return [][][]float64{{{west, south}, {east, south}, {east, north}, {west, north}, {west, south}}}
+			}
+			collection.ExtentGeometry = map[string]interface{}{
+				"type":        "multipolygon",
+				"coordinates": [][][][]float64{rectangle(west, 180), rectangle(-180, east)},
+			}
+		}
+	}
+
+	if intervals := stac.Extent.Temporal.Interval; len(intervals) > 0 && len(intervals[0]) == 2 {
+		if start, err := time.Parse(time.RFC3339Nano, intervals[0][0]); err == nil {
+			collection.ExtentStart = &start
+		}
+		if end, err := time.Parse(time.RFC3339Nano, intervals[0][1]); err == nil {
+			collection.ExtentEnd = &end
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jonhealy1/goapi-stac/es-api/cql2es"
+	"github.com/jonhealy1/goapi-stac/es-api/models"
+	"github.com/jonhealy1/goapi-stac/shared/params"
+	"github.com/olivere/elastic/v7"
+)
+
+// datetimeQuery builds a query matching the items whose datetime, or
+// start_datetime to end_datetime range, overlaps a STAC datetime parameter.
+// It is the t_intersects filter pg-api makes of the parameter, so that both
+// backends read a range missing a bound the same way: the missing bound is
+// the datetime of the item.
+func datetimeQuery(datetime string) (elastic.Query, error) {
+	filter, err := params.DatetimeFilter(datetime)
+	if err != nil || filter == nil {
+		return nil, err
+	}
+	return cql2es.ToES(filter)
+}
+
+// sourceContext builds the _source filtering returning the fields of
+// items, or nil to return whole items. Elasticsearch lets excludes win over
+// includes, so an exclude of an included path, or of a parent of one, is
+// dropped to give includes precedence as pg-api does.
+func sourceContext(fields models.Fields) *elastic.FetchSourceContext {
+	var excludes []string
+	for _, exclude := range fields.Exclude {
+		covered := false
+		for _, include := range fields.Include {
+			if include == exclude || strings.HasPrefix(include, exclude+".") {
+				covered = true
+			}
+		}
+		if !covered {
+			excludes = append(excludes, exclude)
+		}
+	}
+	if len(fields.Include) == 0 && len(excludes) == 0 {
+		return nil
+	}
+	return elastic.NewFetchSourceContext(true).Include(fields.Include...).Exclude(excludes...)
+}
+
+// searchFilterQuery builds the query matching the filter of a search, or
+// returns nil if it has none.
+func searchFilterQuery(search models.Search) (elastic.Query, error) {
+	filter, err := params.Filter(search.Filter, search.FilterLang, search.FilterCrs)
+	if err != nil || filter == nil {
+		return nil, err
+	}
+	query, err := cql2es.ToES(filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %v", err)
+	}
+	return query, nil
+}
+
+// queryExtensionQuery turns the query object of the legacy query extension
+// into the equivalent filter, as pg-api does, and returns the query
+// matching it, or nil for an empty query.
+func queryExtensionQuery(query map[string]map[string]interface{}) (elastic.Query, error) {
+	filter, err := params.QueryFilter(query)
+	if err != nil || filter == nil {
+		return nil, err
+	}
+	return cql2es.ToES(filter)
+}
@@ -0,0 +1,26 @@
+package controllers
+
+import (
+	"github.com/olivere/elastic/v7"
+
+	"github.com/jonhealy1/goapi-stac/shared/params"
+)
+
+// defaultExactCountThreshold is the exactCountThreshold used when
+// EXACT_COUNT_THRESHOLD is not set.
+const defaultExactCountThreshold = 10000
+
+// exactCountThreshold is the number of hits up to which searches are
+// counted exactly. Above it Elasticsearch stops counting, and the threshold
+// is returned as a lower bound of the number of hits.
+var exactCountThreshold = params.EnvInt("EXACT_COUNT_THRESHOLD", defaultExactCountThreshold)
+
+// numberMatched returns the number of hits of a search, and whether it is
+// an estimate rather than an exact count.
+func numberMatched(result *elastic.SearchResult) (int, bool) {
+	if result.Hits == nil || result.Hits.TotalHits == nil {
+		return 0, false
+	}
+	total := result.Hits.TotalHits
+	return int(total.Value), total.Relation != "eq"
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jonhealy1/goapi-stac/es-api/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/olivere/elastic/v7"
+)
+
+// versionETag returns the strong entity tag of a version of a document,
+// made of its primary term and sequence number, which every write changes.
+func versionETag(seqNo, primaryTerm int64) string {
+	return fmt.Sprintf(`"%d-%d"`, primaryTerm, seqNo)
+}
+
+// documentETag returns the entity tag of a document read, empty if it was
+// read without its version.
+func documentETag(doc *elastic.GetResult) string {
+	if doc.SeqNo == nil || doc.PrimaryTerm == nil {
+		return ""
+	}
+	return versionETag(*doc.SeqNo, *doc.PrimaryTerm)
+}
+
+// etagMatches tells whether an If-Match or If-None-Match header lists an
+// entity tag. If-None-Match compares the tags weakly, If-Match strongly so
+// that a weak tag never matches.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == "*" || tag != "" && tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// conditional tells whether a write has an If-Match header, in which case
+// it is only done if the document written is still the version read.
+func conditional(c *fiber.Ctx) bool {
+	return c.Get(fiber.HeaderIfMatch) != ""
+}
+
+// checkIfMatch checks the If-Match header of a write against the version
+// of the document written.
+func checkIfMatch(c *fiber.Ctx, doc *elastic.GetResult) error {
+	if conditional(c) && !etagMatches(c.Get(fiber.HeaderIfMatch), documentETag(doc), false) {
+		return errPreconditionFailed
+	}
+	return nil
+}
+
+var errPreconditionFailed = &statusError{http.StatusPreconditionFailed, "precondition failed: the resource has changed"}
+
+// readIfMatch reads the version of a document a conditional write is
+// checked against, nil for a write that is not conditional.
+func readIfMatch(ctx context.Context, c *fiber.Ctx, index, id string) (*elastic.GetResult, error) {
+	if !conditional(c) {
+		return nil, nil
+	}
+	doc, err := database.ES.Client.Get().
+		Index(index).
+		Id(id).
+		Do(ctx)
+	if elastic.IsNotFound(err) {
+		return nil, errPreconditionFailed
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, checkIfMatch(c, doc)
+}
+
+// lostUpdate tells whether a conditional write failed as the document
+// changed since it was read.
+func lostUpdate(c *fiber.Ctx, err error) bool {
+	return conditional(c) && elastic.IsConflict(err)
+}
+
+// notModified tells whether the If-None-Match header of a read lists the
+// version of the document read, and answers it if so. The version is given
+// as an ETag in any case.
+func notModified(c *fiber.Ctx, doc *elastic.GetResult) bool {
+	etag := documentETag(doc)
+	if etag == "" {
+		return false
+	}
+	c.Set(fiber.HeaderETag, etag)
+	if header := c.Get(fiber.HeaderIfNoneMatch); header != "" && etagMatches(header, etag, true) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"github.com/jonhealy1/goapi-stac/shared/freetext"
+	"github.com/olivere/elastic/v7"
+)
+
+// itemTextFields are the fields free-text queries match items on, boosted
+// in the order pg-api weighs them.
+var itemTextFields = []string{
+	"properties.title^4",
+	"properties.keywords^3",
+	"properties.description^2",
+	"properties.platform",
+	"properties.constellation",
+	"properties.mission",
+	"properties.instruments",
+}
+
+// collectionTextFields are the fields free-text queries match collections
+// on, which are stored as a one element array.
+var collectionTextFields = []string{
+	"data.title^4",
+	"data.keywords^3",
+	"data.description^2",
+}
+
+// textQuery returns the query matching a free-text query on fields. Its
+// clauses score the documents matching them, so it is meant to be used as
+// a must clause.
+func textQuery(n *freetext.Node, fields []string) elastic.Query {
+	switch n.Op {
+	case "":
+		query := elastic.NewMultiMatchQuery(n.Term, fields...).Operator("and")
+		if n.Phrase {
+			query = query.Type("phrase")
+		}
+		return query
+	case "not":
+		return elastic.NewBoolQuery().MustNot(textQuery(n.Children[0], fields))
+	}
+
+	var clauses []elastic.Query
+	for _, child := range n.Children {
+		clauses = append(clauses, textQuery(child, fields))
+	}
+	if n.Op == "or" {
+		return elastic.NewBoolQuery().Should(clauses...).MinimumNumberShouldMatch(1)
+	}
+	return elastic.NewBoolQuery().Must(clauses...)
+}
+
+// relevanceSorters order the hits of a free-text search most relevant
+// first, with the id as a tie-breaker.
+func relevanceSorters(reverse bool) []elastic.Sorter {
+	return []elastic.Sorter{
+		elastic.NewScoreSort().Order(reverse),
+		elastic.NewFieldSort("id").Order(!reverse),
+	}
+}
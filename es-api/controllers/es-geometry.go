@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// coordinateDepths is the number of array levels above the positions in
+// the coordinates of each geometry type.
+var coordinateDepths = map[string]int{
+	"Point":           0,
+	"MultiPoint":      1,
+	"LineString":      1,
+	"MultiLineString": 2,
+	"Polygon":         2,
+	"MultiPolygon":    3,
+}
+
+type geoJSONGeometry struct {
+	Type        string            `json:"type"`
+	Coordinates json.RawMessage   `json:"coordinates,omitempty"`
+	Geometries  []json.RawMessage `json:"geometries,omitempty"`
+}
+
+// parseGeoJSON validates a GeoJSON geometry of any type, including
+// GeometryCollection, with the same checks pg-api makes. Members other than
+// the type, coordinates and geometries are dropped, while coordinates keep
+// their original encoding so that no precision is lost.
+func parseGeoJSON(data []byte) (json.RawMessage, error) {
+	var g geoJSONGeometry
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("a geometry must be a GeoJSON object")
+	}
+
+	if g.Type == "GeometryCollection" {
+		if g.Geometries == nil {
+			return nil, fmt.Errorf("a GeometryCollection requires geometries")
+		}
+		normalized := geoJSONGeometry{Type: g.Type, Geometries: []json.RawMessage{}}
+		for i, member := range g.Geometries {
+			child, err := parseGeoJSON(member)
+			if err != nil {
+				return nil, fmt.Errorf("geometry %d of GeometryCollection: %v", i, err)
+			}
+			normalized.Geometries = append(normalized.Geometries, child)
+		}
+		return json.Marshal(normalized)
+	}
+
+	depth, ok := coordinateDepths[g.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported geometry type %q", g.Type)
+	}
+	if len(g.Coordinates) == 0 {
+		return nil, fmt.Errorf("a %s requires coordinates", g.Type)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(g.Coordinates))
+	decoder.UseNumber()
+	var coordinates interface{}
+	if err := decoder.Decode(&coordinates); err != nil {
+		return nil, fmt.Errorf("invalid %s coordinates", g.Type)
+	}
+	if err := checkCoordinates(g.Type, coordinates, depth); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", g.Type, err)
+	}
+
+	var compact bytes.Buffer
+	json.Compact(&compact, g.Coordinates)
+	return json.Marshal(geoJSONGeometry{Type: g.Type, Coordinates: compact.Bytes()})
+}
+
+// checkCoordinates checks the nesting of coordinates, and the sizes of
+// their positions, lines and rings.
+func checkCoordinates(geomType string, coordinates interface{}, depth int) error {
+	if depth == 0 {
+		return checkPosition(coordinates)
+	}
+
+	list, ok := coordinates.([]interface{})
+	if !ok {
+		return fmt.Errorf("expected an array of coordinates")
+	}
+	for _, element := range list {
+		if err := checkCoordinates(geomType, element, depth-1); err != nil {
+			return err
+		}
+	}
+
+	// depth 1 is a list of positions, which is a line or a ring in all
+	// types but MultiPoint
+	if depth != 1 || geomType == "MultiPoint" {
+		return nil
+	}
+	if geomType == "LineString" || geomType == "MultiLineString" {
+		if len(list) < 2 {
+			return fmt.Errorf("a line needs at least 2 positions")
+		}
+		return nil
+	}
+	if len(list) < 4 {
+		return fmt.Errorf("a polygon ring needs at least 4 positions")
+	}
+	if !samePosition(list[0], list[len(list)-1]) {
+		return fmt.Errorf("a polygon ring must end at its first position")
+	}
+	return nil
+}
+
+func samePosition(a interface{}, b interface{}) bool {
+	as, bs := a.([]interface{}), b.([]interface{})
+	if len(as) != len(bs) {
+		return false
+	}
+	for i := range as {
+		af, _ := as[i].(json.Number).Float64()
+		bf, _ := bs[i].(json.Number).Float64()
+		if af != bf {
+			return false
+		}
+	}
+	return true
+}
+
+func checkPosition(position interface{}) error {
+	values, ok := position.([]interface{})
+	if !ok || len(values) < 2 || len(values) > 3 {
+		return fmt.Errorf("a position must have 2 or 3 numbers")
+	}
+	var coords []float64
+	for _, value := range values {
+		n, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("a position must have 2 or 3 numbers")
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return fmt.Errorf("invalid coordinate %s", n)
+		}
+		coords = append(coords, f)
+	}
+	if coords[0] < -180 || coords[0] > 180 || coords[1] < -90 || coords[1] > 90 {
+		return fmt.Errorf("position %v is outside of CRS84 bounds", coords)
+	}
+	return nil
+}
+
+// geoShapeQuery matches the documents whose geo_shape field, the item
+// geometry or the collection extent, intersects a shape.
+type geoShapeQuery struct {
+	field string
+	shape interface{}
+}
+
+func (q geoShapeQuery) Source() (interface{}, error) {
+	return map[string]interface{}{
+		"geo_shape": map[string]interface{}{
+			q.field: map[string]interface{}{
+				"shape":    q.shape,
+				"relation": "intersects",
+			},
+		},
+	}, nil
+}
+
+// intersectsQuery builds the query matching the items that intersect a
+// GeoJSON geometry.
+func intersectsQuery(geometry json.RawMessage) (elastic.Query, error) {
+	shape, err := parseGeoJSON(geometry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid intersects: %v", err)
+	}
+	return geoShapeQuery{field: "geometry", shape: shape}, nil
+}
+
+// bboxQuery builds the query matching the documents whose geo_shape field
+// intersects a bbox of 4 or 6 numbers. A bbox whose west edge lies east of
+// its east edge crosses the antimeridian, and is split in two as pg-api does.
+func bboxQuery(field string, bbox []float64) (elastic.Query, error) {
+	if len(bbox) == 6 {
+		bbox = []float64{bbox[0], bbox[1], bbox[3], bbox[4]}
+	}
+	if len(bbox) != 4 {
+		return nil, fmt.Errorf("bbox must have 4 or 6 numbers")
+	}
+	west, south, east, north := bbox[0], bbox[1], bbox[2], bbox[3]
+	if south > north {
+		return nil, fmt.Errorf("the south edge of a bbox cannot lie north of its north edge")
+	}
+	if west < -180 || east > 180 || south < -90 || north > 90 || east < -180 || west > 180 {
+		return nil, fmt.Errorf("bbox %v is outside of CRS84 bounds", bbox)
+	}
+
+	envelope := func(west float64, east float64) elastic.Query {
+		return geoShapeQuery{field: field, shape: map[string]interface{}{
+			"type":        "envelope",
+			"coordinates": [][]float64{{west, north}, {east, south}},
+		}}
+	}
+	if west <= east {
+		return envelope(west, east), nil
+	}
+	return elastic.NewBoolQuery().
+		Should(envelope(west, 180), envelope(-180, east)).
+		MinimumNumberShouldMatch(1), nil
+}
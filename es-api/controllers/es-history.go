@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jonhealy1/goapi-stac/es-api/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/olivere/elastic/v7"
+)
+
+// clientLabelHeader carries a label a client may give its writes, such as
+// the name of the job making them, which the history of the items and
+// collections records with the client address. The api does not
+// authenticate clients, so the label is not verified: any client can send
+// any label, and it does not identify who made a write.
+const clientLabelHeader = "X-Client-Label"
+
+// client returns the client of a write, as recorded in the history.
+func client(c *fiber.Ctx) database.Client {
+	return database.Client{Label: c.Get(clientLabelHeader), Address: c.IP()}
+}
+
+// recordRevisions records writes in a history index. The writes are done
+// already, so a history that could not be recorded is only logged.
+func recordRevisions(c *fiber.Ctx, alias string, revisions ...database.Revision) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := database.RecordRevisions(ctx, alias, client(c), revisions); err != nil {
+		log.Printf("could not record the history of %s: %v", alias, err)
+	}
+}
+
+// recordItemsDeleted records the deletion of the items of a deleted
+// collection in their history, which takes longer than a write of one
+// document for a large collection.
+func recordItemsDeleted(c *fiber.Ctx, collectionId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := database.RecordItemsDeleted(ctx, collectionId, client(c)); err != nil {
+		log.Printf("could not record the deletion of the items of %s: %v", collectionId, err)
+	}
+}
+
+// parseAsOf reads an asof parameter, the RFC 3339 timestamp of the past
+// state a read is answered from.
+func parseAsOf(asOf string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, asOf)
+	if err != nil {
+		return t, fmt.Errorf("asof must be an RFC 3339 timestamp")
+	}
+	return t, nil
+}
+
+// asOfQuery matches the revisions in a history index valid at a point in
+// time, but for deletes.
+func asOfQuery(t time.Time) elastic.Query {
+	instant := t.UTC().Format(database.RevisionTimeFormat)
+	return elastic.NewBoolQuery().
+		Filter(elastic.NewRangeQuery("revision.valid_from").Lte(instant)).
+		MustNot(elastic.NewRangeQuery("revision.valid_to").Lte(instant)).
+		MustNot(elastic.NewTermQuery("revision.operation", "delete"))
+}
+
+// withoutRevision leaves the revision of the documents of a history index
+// out of their source.
+func withoutRevision(source *elastic.FetchSourceContext) *elastic.FetchSourceContext {
+	if source == nil {
+		source = elastic.NewFetchSourceContext(true)
+	}
+	return source.Exclude("revision")
+}
+
+// esGetItemAsOf answers a request for an item as it was at a point in
+// time.
+func esGetItemAsOf(c *fiber.Ctx, collectionId, itemId, asOf string) error {
+	t, err := parseAsOf(asOf)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := elastic.NewBoolQuery().
+		Filter(elastic.NewTermQuery("id", itemId)).
+		Filter(elastic.NewTermQuery("collection", collectionId)).
+		Filter(asOfQuery(t))
+	searchResult, err := database.ES.Client.Search(database.ItemHistoryAlias).
+		Query(query).
+		FetchSourceContext(withoutRevision(nil)).
+		Size(1).
+		Do(ctx)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(
+			&fiber.Map{"message": "could not get item"})
+	}
+	if len(searchResult.Hits.Hits) == 0 {
+		return c.Status(http.StatusNotFound).JSON(
+			&fiber.Map{"message": fmt.Sprintf("Item %s did not exist at %s", itemId, asOf)})
+	}
+
+	var itemJson map[string]interface{}
+	if err := json.Unmarshal(searchResult.Hits.Hits[0].Source, &itemJson); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(
+			&fiber.Map{"message": "could not unmarshal item"})
+	}
+	return c.Status(http.StatusOK).JSON(itemJson)
+}
+
+// itemRevision is a revision of an item in its history.
+type itemRevision struct {
+	Revision      int64                  `json:"revision"`
+	Operation     string                 `json:"operation"`
+	ClientLabel   string                 `json:"client_label,omitempty"`
+	ClientAddress string                 `json:"client_address,omitempty"`
+	ChangedAt     string                 `json:"changed_at"`
+	StacItem      map[string]interface{} `json:"stac_item,omitempty"`
+}
+
+// ESGetItemVersions returns every revision of an item, oldest first, with
+// the client label and address that wrote it and when.
+func ESGetItemVersions(c *fiber.Ctx) error {
+	collectionId := c.Params("collectionId")
+	itemId := c.Params("itemId")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := elastic.NewBoolQuery().
+		Filter(elastic.NewTermQuery("id", itemId)).
+		Filter(elastic.NewTermQuery("collection", collectionId))
+	searchResult, err := database.ES.Client.Search(database.ItemHistoryAlias).
+		Query(query).
+		Sort("revision.number", true).
+		Size(10000).
+		Do(ctx)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(
+			&fiber.Map{"message": "could not get the versions of the item"})
+	}
+	if len(searchResult.Hits.Hits) == 0 {
+		return c.Status(http.StatusNotFound).JSON(
+			&fiber.Map{"message": fmt.Sprintf("Item %s not found", itemId)})
+	}
+
+	revisions := []itemRevision{}
+	for _, hit := range searchResult.Hits.Hits {
+		var doc struct {
+			Revision struct {
+				Number        int64  `json:"number"`
+				Operation     string `json:"operation"`
+				ClientLabel   string `json:"client_label"`
+				ClientAddress string `json:"client_address"`
+				// ChangedBy is the client label of the revisions
+				// recorded before it was named so
+				ChangedBy string `json:"changed_by"`
+				ValidFrom string `json:"valid_from"`
+			} `json:"revision"`
+		}
+		if err := json.Unmarshal(hit.Source, &doc); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(
+				&fiber.Map{"message": "could not unmarshal the versions of the item"})
+		}
+		revision := itemRevision{
+			Revision:      doc.Revision.Number,
+			Operation:     doc.Revision.Operation,
+			ClientLabel:   doc.Revision.ClientLabel,
+			ClientAddress: doc.Revision.ClientAddress,
+			ChangedAt:     doc.Revision.ValidFrom,
+		}
+		if revision.ClientLabel == "" {
+			revision.ClientLabel = doc.Revision.ChangedBy
+		}
+		// a delete keeps nothing of the item
+		if revision.Operation != "delete" {
+			json.Unmarshal(hit.Source, &revision.StacItem)
+			delete(revision.StacItem, "revision")
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return c.Status(http.StatusOK).JSON(&fiber.Map{
+		"message":    "success",
+		"id":         itemId,
+		"collection": collectionId,
+		"versions":   revisions,
+	})
+}
@@ -5,17 +5,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/jonhealy1/goapi-stac/es-api/database"
-	"github.com/olivere/elastic"
+	"github.com/olivere/elastic/v7"
 
 	"github.com/go-playground/validator"
 
 	"github.com/gofiber/fiber/v2"
 
 	"github.com/jonhealy1/goapi-stac/es-api/models"
+	"github.com/jonhealy1/goapi-stac/shared/freetext"
+	"github.com/jonhealy1/goapi-stac/shared/params"
 )
 
 func checkCollectionExists(collectionId string) (bool, error) {
@@ -36,8 +37,8 @@ func checkCollectionExists(collectionId string) (bool, error) {
 	return exists, nil
 }
 
-func ESItemExists(itemId string) (bool, error) {
-	indexName := "items"
+func ESItemExists(collectionId string, itemId string) (bool, error) {
+	indexName := database.ItemsIndex(collectionId)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -77,6 +78,11 @@ func ESCreateItem(c *fiber.Ctx) error {
 		return fmt.Errorf("collection %s not found", collectionId)
 	}
 
+	// a FeatureCollection or NDJSON body is indexed in bulk
+	if isBulkRequest(c) {
+		return esCreateItems(c, collectionId)
+	}
+
 	stac_item := new(models.StacItem)
 	err = c.BodyParser(&stac_item)
 	if err != nil {
@@ -102,7 +108,9 @@ func ESCreateItem(c *fiber.Ctx) error {
 		return err
 	}
 
-	indexName := "items"
+	// items belong to the collection whose index they are in
+	stac_item.Collection = collectionId
+	indexName := database.ItemsIndex(collectionId)
 
 	// Check if the item already exists
 	_, err = database.ES.Client.Get().
@@ -129,12 +137,20 @@ func ESCreateItem(c *fiber.Ctx) error {
 		BodyString(string(doc)).
 		Do(ctx)
 
+	if database.WritesBlocked(err) {
+		return writeError(c, errWritesBlocked, "could not index item")
+	}
 	if err != nil {
 		c.Status(http.StatusBadRequest).JSON(
 			&fiber.Map{"message": "could not index item"})
 		return err
 	}
 
+	recordRevisions(c, database.ItemHistoryAlias, database.Revision{
+		Id: itemId, Collection: collectionId, Operation: "insert", Source: doc,
+	})
+
+	c.Set(fiber.HeaderETag, versionETag(resp.SeqNo, resp.PrimaryTerm))
 	c.Status(http.StatusCreated).JSON(&fiber.Map{
 		"message":    "success",
 		"id":         resp.Id,
@@ -145,6 +161,7 @@ func ESCreateItem(c *fiber.Ctx) error {
 }
 
 func ESDeleteItem(c *fiber.Ctx) error {
+	collectionId := c.Params("collectionId")
 	itemId := c.Params("itemId")
 	if itemId == "" {
 		c.Status(http.StatusBadRequest).JSON(
@@ -152,7 +169,7 @@ func ESDeleteItem(c *fiber.Ctx) error {
 		return fmt.Errorf("missing itemId parameter")
 	}
 
-	exists, err := ESItemExists(itemId)
+	exists, err := ESItemExists(collectionId, itemId)
 	if err != nil {
 		c.Status(http.StatusInternalServerError).JSON(
 			&fiber.Map{"message": "could not check if item exists"})
@@ -166,21 +183,39 @@ func ESDeleteItem(c *fiber.Ctx) error {
 	}
 
 	// Proceed with the deletion if the item exists
-	indexName := "items"
+	indexName := database.ItemsIndex(collectionId)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err = database.ES.Client.Delete().
+	version, err := readIfMatch(ctx, c, indexName, itemId)
+	if err != nil {
+		return writeError(c, err, "could not delete item")
+	}
+
+	service := database.ES.Client.Delete().
 		Index(indexName).
-		Id(itemId).
-		Do(ctx)
+		Id(itemId)
+	if version != nil {
+		service.IfSeqNo(*version.SeqNo).IfPrimaryTerm(*version.PrimaryTerm)
+	}
+	_, err = service.Do(ctx)
 
+	if database.WritesBlocked(err) {
+		return writeError(c, errWritesBlocked, "could not delete item")
+	}
+	if lostUpdate(c, err) {
+		return writeError(c, errPreconditionFailed, "could not delete item")
+	}
 	if err != nil {
 		c.Status(http.StatusInternalServerError).JSON(
 			&fiber.Map{"message": "could not delete item"})
 		return err
 	}
 
+	recordRevisions(c, database.ItemHistoryAlias, database.Revision{
+		Id: itemId, Collection: collectionId, Operation: "delete",
+	})
+
 	c.Status(http.StatusOK).JSON(&fiber.Map{
 		"message": fmt.Sprintf("Item %s deleted successfully", itemId),
 	})
@@ -197,7 +232,7 @@ func ESUpdateItem(c *fiber.Ctx) error {
 		return fmt.Errorf("missing collectionId or itemId parameter")
 	}
 
-	exists, err := ESItemExists(itemId)
+	exists, err := ESItemExists(collectionId, itemId)
 	if err != nil {
 		c.Status(http.StatusInternalServerError).JSON(
 			&fiber.Map{"message": "error checking item existence"})
@@ -228,7 +263,19 @@ func ESUpdateItem(c *fiber.Ctx) error {
 		return err
 	}
 
-	indexName := "items"
+	// the item written is the one of the path, which the body cannot name
+	// another
+	if updatedStacItem.Id == "" {
+		updatedStacItem.Id = itemId
+	}
+	if updatedStacItem.Collection == "" {
+		updatedStacItem.Collection = collectionId
+	}
+	if updatedStacItem.Id != itemId || updatedStacItem.Collection != collectionId {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": "the id and collection of the item must be those of the path"})
+	}
+	indexName := database.ItemsIndex(collectionId)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -248,19 +295,46 @@ func ESUpdateItem(c *fiber.Ctx) error {
 		return err
 	}
 
-	_, err = database.ES.Client.Update().
+	version, err := readIfMatch(ctx, c, indexName, itemId)
+	if err != nil {
+		return writeError(c, err, "could not update item")
+	}
+
+	service := database.ES.Client.Update().
 		Index(indexName).
 		Id(itemId).
 		Doc(docMap).
-		DocAsUpsert(true).
-		Do(ctx)
+		FetchSource(true)
+	if version != nil {
+		// updates checking the version cannot be upserts, and the item
+		// exists anyway
+		service.IfSeqNo(*version.SeqNo).IfPrimaryTerm(*version.PrimaryTerm)
+	} else {
+		service.DocAsUpsert(true)
+	}
+	resp, err := service.Do(ctx)
 
+	if database.WritesBlocked(err) {
+		return writeError(c, errWritesBlocked, "could not update item")
+	}
+	if lostUpdate(c, err) {
+		return writeError(c, errPreconditionFailed, "could not update item")
+	}
 	if err != nil {
 		c.Status(http.StatusBadRequest).JSON(
 			&fiber.Map{"message": "could not update item"})
 		return err
 	}
 
+	// the history keeps the item as the update left it
+	if resp.GetResult != nil {
+		recordRevisions(c, database.ItemHistoryAlias, database.Revision{
+			Id: itemId, Collection: collectionId, Operation: "update", Source: resp.GetResult.Source,
+		})
+	}
+
+	c.Set(fiber.HeaderETag, versionETag(resp.SeqNo, resp.PrimaryTerm))
+
 	c.Status(http.StatusOK).JSON(&fiber.Map{
 		"message":    "success",
 		"id":         itemId,
@@ -280,7 +354,12 @@ func ESGetItem(c *fiber.Ctx) error {
 		return fmt.Errorf("missing collectionId or itemId parameter")
 	}
 
-	exists, err := ESItemExists(itemId)
+	// an item is read from its history as it was at a point in time
+	if asOf := c.Query("asof"); asOf != "" {
+		return esGetItemAsOf(c, collectionId, itemId, asOf)
+	}
+
+	exists, err := ESItemExists(collectionId, itemId)
 	if err != nil {
 		c.Status(http.StatusInternalServerError).JSON(
 			&fiber.Map{"message": "error checking item existence"})
@@ -293,7 +372,7 @@ func ESGetItem(c *fiber.Ctx) error {
 		return fmt.Errorf("item not found")
 	}
 
-	indexName := "items"
+	indexName := database.ItemsIndex(collectionId)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -307,6 +386,9 @@ func ESGetItem(c *fiber.Ctx) error {
 			&fiber.Map{"message": "could not get item"})
 		return err
 	}
+	if notModified(c, resp) {
+		return nil
+	}
 
 	var itemJson map[string]interface{}
 	err = json.Unmarshal(resp.Source, &itemJson)
@@ -328,46 +410,159 @@ func ESGetItemCollection(c *fiber.Ctx) error {
 		return fmt.Errorf("missing collectionId parameter")
 	}
 
-	limit, _ := strconv.Atoi(c.Query("limit", "10"))
-	offset, _ := strconv.Atoi(c.Query("offset", "0"))
+	limit, err := parseLimit(c.Query("limit"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+	token, err := decodeToken(c.Query("token"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+	var text *freetext.Node
+	if q := c.Query("q"); q != "" {
+		if text, err = freetext.Parse(q); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(
+				&fiber.Map{"message": err.Error()})
+		}
+	}
+	sortby := params.ParseSortby(c.Query("sortby"))
+	sorters, err := itemSorters(sortby, token != nil && token.Prev)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+	if text != nil && len(sortby) == 0 {
+		sorters = relevanceSorters(token != nil && token.Prev)
+	}
+	if err := checkToken(token, sorters); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+
+	query := elastic.NewBoolQuery().Filter(elastic.NewTermQuery("collection", collectionId))
+	if text != nil {
+		query = query.Must(textQuery(text, itemTextFields))
+	}
+	if datetime := c.Query("datetime"); datetime != "" {
+		datetimeFilter, err := datetimeQuery(datetime)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(
+				&fiber.Map{"message": err.Error()})
+		}
+		query = query.Filter(datetimeFilter)
+	}
+	if bbox := c.Query("bbox"); bbox != "" {
+		coordinates, err := params.ParseBbox(bbox)
+		if err == nil {
+			coordinates, err = params.BboxToCRS84(coordinates, c.Query("bbox-crs"))
+		}
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(
+				&fiber.Map{"message": err.Error()})
+		}
+		bboxFilter, err := bboxQuery("geometry", coordinates)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(
+				&fiber.Map{"message": err.Error()})
+		}
+		query = query.Filter(bboxFilter)
+	}
+	if intersects := c.Query("intersects"); intersects != "" {
+		if c.Query("bbox") != "" {
+			return c.Status(http.StatusBadRequest).JSON(
+				&fiber.Map{"message": "only one of bbox and intersects can be given"})
+		}
+		intersectsFilter, err := intersectsQuery(json.RawMessage(intersects))
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(
+				&fiber.Map{"message": err.Error()})
+		}
+		query = query.Filter(intersectsFilter)
+	}
+	if queryParam := c.Query("query"); queryParam != "" {
+		var queryExtension map[string]map[string]interface{}
+		if err := json.Unmarshal([]byte(queryParam), &queryExtension); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(
+				&fiber.Map{"message": "query must be a JSON object of property comparisons"})
+		}
+		extensionFilter, err := queryExtensionQuery(queryExtension)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(
+				&fiber.Map{"message": fmt.Sprintf("invalid query: %v", err)})
+		}
+		if extensionFilter != nil {
+			query = query.Filter(extensionFilter)
+		}
+	}
+
+	// the items as they were at a point in time are read from their history
+	indices := []string{database.ItemsIndex(collectionId)}
+	source := sourceContext(params.ParseFields(c.Query("fields")))
+	if asOf := c.Query("asof"); asOf != "" {
+		t, err := parseAsOf(asOf)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(
+				&fiber.Map{"message": err.Error()})
+		}
+		query = query.Filter(asOfQuery(t))
+		indices = []string{database.ItemHistoryAlias}
+		source = withoutRevision(source)
+	}
 
-	indexName := "items"
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	searchResult, err := database.ES.Client.Search().
-		Index(indexName).
-		Query(elastic.NewTermQuery("collection", collectionId)).
-		From(offset).
-		Size(limit).
-		Do(ctx)
-
+	search, pit, err := pageSearch(ctx, indices, sorters, limit, token)
+	if err != nil {
+		c.Status(http.StatusInternalServerError).JSON(
+			&fiber.Map{"message": "error fetching items from Elasticsearch"})
+		return err
+	}
+	search = search.Query(query)
+	if source != nil {
+		search = search.FetchSourceContext(source)
+	}
+	searchResult, err := search.Do(ctx)
+	if pitExpired(err, token) {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": "pagination token has expired"})
+	}
 	if err != nil {
 		c.Status(http.StatusInternalServerError).JSON(
 			&fiber.Map{"message": "error fetching items from Elasticsearch"})
 		return err
 	}
 
-	var stacItems []models.StacItem
-	for _, hit := range searchResult.Hits.Hits {
-		var item models.StacItem
-		err = json.Unmarshal(hit.Source, &item)
-		if err != nil {
-			c.Status(http.StatusInternalServerError).JSON(
-				&fiber.Map{"message": "error unmarshalling item"})
-			return err
-		}
-		stacItems = append(stacItems, item)
+	hits, next, prev := paginate(searchResult.Hits.Hits, limit, token, resultPit(searchResult, pit))
+
+	// items are returned as stored, which keeps out the fields left out
+	// by the fields parameter
+	stacItems := []json.RawMessage{}
+	for _, hit := range hits {
+		stacItems = append(stacItems, hit.Source)
 	}
 
+	matched, estimated := numberMatched(searchResult)
+
 	return c.Status(http.StatusOK).JSON(fiber.Map{
 		"message":    "item collection retrieved successfully",
 		"collection": collectionId,
 		"context": models.Context{
-			Returned: len(stacItems),
-			Limit:    limit,
+			Returned:         len(stacItems),
+			Limit:            limit,
+			Matched:          matched,
+			MatchedEstimated: estimated,
 		},
-		"type":     "FeatureCollection",
-		"features": stacItems,
+		"numberMatched":  matched,
+		"numberReturned": len(stacItems),
+		"type":           "FeatureCollection",
+		"features":       stacItems,
+		"links": append([]models.Link{
+			{Rel: "self", Type: "application/geo+json", Href: c.BaseURL() + c.OriginalURL()},
+			{Rel: "root", Type: "application/json", Href: c.BaseURL() + "/"},
+			{Rel: "collection", Type: "application/json", Href: c.BaseURL() + "/collections/" + collectionId},
+		}, getPageLinks(c, next, prev)...),
 	})
 }
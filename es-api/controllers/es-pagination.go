@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jonhealy1/goapi-stac/es-api/database"
+	"github.com/jonhealy1/goapi-stac/es-api/models"
+	"github.com/jonhealy1/goapi-stac/shared/params"
+	"github.com/olivere/elastic/v7"
+)
+
+// defaultPageLimit is the page size of a listing that does not set one,
+// the same as in the postgres api.
+const defaultPageLimit = 100
+
+// defaultMaxLimit is the maxLimit used when MAX_LIMIT is not set.
+const defaultMaxLimit = 10000
+
+// maxLimit is the largest page size. Listings asking for more get a page
+// of maxLimit items, as OGC API Features requires.
+var maxLimit = params.EnvInt("MAX_LIMIT", defaultMaxLimit)
+
+// parseLimit reads a limit query parameter into a page size.
+func parseLimit(limit string) (int, error) {
+	if limit == "" {
+		return defaultPageLimit, nil
+	}
+	size, err := strconv.Atoi(limit)
+	if err != nil || size < 1 {
+		return 0, fmt.Errorf("limit must be a positive integer")
+	}
+	return pageLimit(size), nil
+}
+
+// pageLimit returns the page size of a listing asking for limit items, 0
+// meaning it sets none.
+func pageLimit(limit int) int {
+	if limit <= 0 {
+		return defaultPageLimit
+	}
+	if limit > maxLimit {
+		return maxLimit
+	}
+	return limit
+}
+
+// defaultPitKeepAlive is how long a point in time is kept between pages
+// when its token outlives PIT_KEEP_ALIVE being set.
+const defaultPitKeepAlive = "1m"
+
+// pitKeepAlive is how long a point in time is kept between pages, as an
+// Elasticsearch duration such as 1m. If it is set, listings page through a
+// point in time opened by their first page, which keeps later pages
+// consistent while documents are being written. Points in time are not
+// used if it is empty.
+var pitKeepAlive = os.Getenv("PIT_KEEP_ALIVE")
+
+// paginationToken is the opaque state handed to clients in next and prev
+// links. It holds the search_after sort values of the hit a page ends (or,
+// going backwards, starts) at, so deep pages cost no more than the first,
+// and the id of the point in time the listing pages through, if any.
+type paginationToken struct {
+	Keys []interface{} `json:"k"`
+	Prev bool          `json:"p,omitempty"`
+	Pit  string        `json:"i,omitempty"`
+}
+
+func encodeToken(token paginationToken) string {
+	b, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeToken(s string) (*paginationToken, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pagination token")
+	}
+	// sort values of missing fields are 64 bit sentinels that a float64
+	// would round
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	token := &paginationToken{}
+	if err := decoder.Decode(token); err != nil {
+		return nil, fmt.Errorf("invalid pagination token")
+	}
+	return token, nil
+}
+
+// usesPit tells whether the page a token asks for is read from a point in
+// time. The first page of a listing opens one if PIT_KEEP_ALIVE is set.
+func usesPit(token *paginationToken) bool {
+	if token == nil {
+		return pitKeepAlive != ""
+	}
+	return token.Pit != ""
+}
+
+// checkToken returns an error if the sort values of a token do not match
+// the sorters of the page it asks for.
+func checkToken(token *paginationToken, sorters []elastic.Sorter) error {
+	if token == nil {
+		return nil
+	}
+	keys := len(sorters)
+	if usesPit(token) {
+		// the _shard_doc tie-breaker
+		keys++
+	}
+	if len(token.Keys) != keys {
+		return fmt.Errorf("pagination token does not match the sort order")
+	}
+	return nil
+}
+
+// pageSearch builds the search for the page of hits of indices a token
+// asks for, in the order of sorters. Pages of a point in time search it
+// rather than the indices, and are also sorted by _shard_doc. pageSearch
+// opens the point in time on the first page, and returns its id.
+func pageSearch(ctx context.Context, indices []string, sorters []elastic.Sorter, limit int, token *paginationToken) (*elastic.SearchService, string, error) {
+	search := database.ES.Client.Search()
+
+	pit := ""
+	if token != nil {
+		pit = token.Pit
+	} else if usesPit(token) {
+		opened, err := database.ES.Client.OpenPointInTime(indices...).IgnoreUnavailable(true).KeepAlive(pitKeepAlive).Do(ctx)
+		if err != nil {
+			return nil, "", err
+		}
+		pit = opened.Id
+	}
+
+	if pit == "" {
+		// the collections searched may have no items index
+		search = search.Index(indices...).IgnoreUnavailable(true)
+	} else {
+		keepAlive := pitKeepAlive
+		if keepAlive == "" {
+			keepAlive = defaultPitKeepAlive
+		}
+		reverse := token != nil && token.Prev
+		sorters = append(sorters, elastic.NewFieldSort("_shard_doc").Order(!reverse))
+		search = search.PointInTime(elastic.NewPointInTimeWithKeepAlive(pit, keepAlive))
+	}
+	if token != nil {
+		search = search.SearchAfter(token.Keys...)
+	}
+
+	search = search.
+		SortBy(sorters...).
+		// one more hit than the page holds tells whether there is a next page
+		Size(limit + 1).
+		TrackTotalHits(exactCountThreshold)
+	return search, pit, nil
+}
+
+// resultPit returns the id of the point in time to carry to the next
+// pages, which Elasticsearch may have changed.
+func resultPit(result *elastic.SearchResult, pit string) string {
+	if pit != "" && result.PitId != "" {
+		return result.PitId
+	}
+	return pit
+}
+
+// pitExpired tells whether a search failed because the point in time its
+// token carries has expired.
+func pitExpired(err error, token *paginationToken) bool {
+	return token != nil && token.Pit != "" && elastic.IsNotFound(err)
+}
+
+// paginate trims the limit+1 hits fetched for a page to the page itself,
+// restoring their order if they were fetched backwards, and returns the
+// tokens for the next and previous pages, which are empty if there is none.
+// The tokens carry the point in time pit, if the page was read from one.
+func paginate(hits []*elastic.SearchHit, limit int, token *paginationToken, pit string) ([]*elastic.SearchHit, string, string) {
+	more := len(hits) > limit
+	if more {
+		hits = hits[:limit]
+	}
+
+	backwards := token != nil && token.Prev
+	if backwards {
+		for i, j := 0, len(hits)-1; i < j; i, j = i+1, j-1 {
+			hits[i], hits[j] = hits[j], hits[i]
+		}
+	}
+	if len(hits) == 0 {
+		return hits, "", ""
+	}
+
+	hasNext, hasPrev := more, token != nil
+	if backwards {
+		hasNext, hasPrev = true, more
+	}
+
+	next, prev := "", ""
+	if hasNext {
+		next = encodeToken(paginationToken{Keys: hits[len(hits)-1].Sort, Pit: pit})
+	}
+	if hasPrev {
+		prev = encodeToken(paginationToken{Keys: hits[0].Sort, Prev: true, Pit: pit})
+	}
+	return hits, next, prev
+}
+
+// getPageLinks builds the next and prev links of a GET listing, which repeat
+// the request's query parameters with a different token.
+func getPageLinks(c *fiber.Ctx, next string, prev string) []models.Link {
+	links := []models.Link{}
+	for _, page := range []struct{ rel, token string }{{"next", next}, {"prev", prev}} {
+		if page.token == "" {
+			continue
+		}
+		query, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+		query.Set("token", page.token)
+		links = append(links, models.Link{
+			Rel:  page.rel,
+			Type: "application/geo+json",
+			Href: c.BaseURL() + c.Path() + "?" + query.Encode(),
+		})
+	}
+	return links
+}
+
+// postPageLinks builds the next and prev links of a POST search. Their body
+// only holds the token and is merged into the original request body.
+func postPageLinks(c *fiber.Ctx, next string, prev string) []models.Link {
+	links := []models.Link{}
+	for _, page := range []struct{ rel, token string }{{"next", next}, {"prev", prev}} {
+		if page.token == "" {
+			continue
+		}
+		links = append(links, models.Link{
+			Rel:    page.rel,
+			Type:   "application/geo+json",
+			Href:   c.BaseURL() + c.Path(),
+			Method: http.MethodPost,
+			Body:   map[string]interface{}{"token": page.token},
+			Merge:  true,
+		})
+	}
+	return links
+}
@@ -0,0 +1,279 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jonhealy1/goapi-stac/es-api/database"
+	"github.com/jonhealy1/goapi-stac/es-api/models"
+	"github.com/jonhealy1/goapi-stac/shared/patch"
+	"github.com/jonhealy1/goapi-stac/shared/stac"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/olivere/elastic/v7"
+)
+
+// statusError is an error answered with a status code.
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// applyPatch applies the patch in the body of a request to a document. Its
+// errors are statusErrors.
+func applyPatch(c *fiber.Ctx, doc []byte) ([]byte, error) {
+	patched, err := patch.Apply(doc, c.Get(fiber.HeaderContentType), c.Body())
+	switch {
+	case err == patch.ErrUnsupportedType:
+		return nil, &statusError{http.StatusUnsupportedMediaType, err.Error()}
+	case errors.Is(err, patch.ErrInvalidPatch):
+		return nil, &statusError{http.StatusBadRequest, err.Error()}
+	case errors.Is(err, patch.ErrConflict):
+		return nil, &statusError{http.StatusConflict, err.Error()}
+	case err != nil:
+		return nil, err
+	}
+	return patched, nil
+}
+
+// errWritesBlocked answers a write to an index a reindex is copying, which
+// can be made again once the copy takes the place of the index.
+var errWritesBlocked = &statusError{http.StatusServiceUnavailable, "writes are blocked while the index is reindexed, retry later"}
+
+// writesBlockedRetryAfter is the Retry-After, in seconds, of a write
+// blocked by a reindex.
+const writesBlockedRetryAfter = "30"
+
+// writeError answers a request whose write failed.
+func writeError(c *fiber.Ctx, err error, message string) error {
+	if err == errWritesBlocked {
+		c.Set(fiber.HeaderRetryAfter, writesBlockedRetryAfter)
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		return c.Status(se.status).JSON(
+			&fiber.Map{"message": se.message})
+	}
+	return c.Status(http.StatusBadRequest).JSON(
+		&fiber.Map{"message": message})
+}
+
+// validateItem checks a patched item and returns it, as stac.ValidateItem
+// does, with the errors of an invalid item answered with 422.
+func validateItem(doc, patched []byte, id, collectionId string) ([]byte, error) {
+	patched, _, err := stac.ValidateItem(doc, patched, id, collectionId)
+	return patched, itemError(err)
+}
+
+// itemError is a statusError answering the errors of an invalid item with
+// 422, and any other error as it is.
+func itemError(err error) error {
+	if errors.Is(err, stac.ErrInvalidItem) {
+		return &statusError{http.StatusUnprocessableEntity, err.Error()}
+	}
+	return err
+}
+
+// validateCollection checks a patched collection, which must keep its id
+// and have a valid extent, and returns it decoded.
+func validateCollection(patched []byte, id string) (*models.StacCollection, error) {
+	invalid := func(format string, args ...interface{}) error {
+		return &statusError{http.StatusUnprocessableEntity, "invalid collection: " + fmt.Sprintf(format, args...)}
+	}
+
+	var collection models.StacCollection
+	if err := json.Unmarshal(patched, &collection); err != nil {
+		return nil, invalid("%v", err)
+	}
+	if collection.Id != id {
+		return nil, invalid("the id cannot change")
+	}
+	for _, bbox := range collection.Extent.Spatial.Bbox {
+		if len(bbox) != 4 && len(bbox) != 6 {
+			return nil, invalid("a bbox of the extent must have 4 or 6 numbers")
+		}
+	}
+	for _, interval := range collection.Extent.Temporal.Interval {
+		if len(interval) != 2 {
+			return nil, invalid("an interval of the extent must have a start and an end")
+		}
+		for _, instant := range interval {
+			if _, err := time.Parse(time.RFC3339, instant); instant != "" && err != nil {
+				return nil, invalid("the interval %v of the extent is not RFC 3339", interval)
+			}
+		}
+	}
+	return &collection, nil
+}
+
+// ESPatchItem changes part of an item with a JSON Merge Patch (RFC 7396)
+// or a JSON Patch (RFC 6902).
+func ESPatchItem(c *fiber.Ctx) error {
+	collectionId := c.Params("collectionId")
+	itemId := c.Params("itemId")
+
+	indexName := database.ItemsIndex(collectionId)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := database.ES.Client.Get().
+		Index(indexName).
+		Id(itemId).
+		Do(ctx)
+
+	if elastic.IsNotFound(err) {
+		return c.Status(http.StatusNotFound).JSON(
+			&fiber.Map{"message": fmt.Sprintf("Item %s not found", itemId)})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(
+			&fiber.Map{"message": "error retrieving the item"})
+	}
+
+	if err := checkIfMatch(c, resp); err != nil {
+		return writeError(c, err, "could not update item")
+	}
+	patched, err := applyPatch(c, resp.Source)
+	if err != nil {
+		return writeError(c, err, "could not update item")
+	}
+	patched, err = validateItem(resp.Source, patched, itemId, collectionId)
+	if err != nil {
+		return writeError(c, err, "could not update item")
+	}
+
+	// the item is only written if it did not change since it was read
+	written, err := database.ES.Client.Index().
+		Index(indexName).
+		Id(itemId).
+		BodyString(string(patched)).
+		IfSeqNo(*resp.SeqNo).
+		IfPrimaryTerm(*resp.PrimaryTerm).
+		Do(ctx)
+
+	if database.WritesBlocked(err) {
+		return writeError(c, errWritesBlocked, "could not update item")
+	}
+	if lostUpdate(c, err) {
+		return writeError(c, errPreconditionFailed, "could not update item")
+	}
+	if elastic.IsConflict(err) {
+		return c.Status(http.StatusConflict).JSON(
+			&fiber.Map{"message": fmt.Sprintf("Item %s changed while it was patched", itemId)})
+	}
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": "could not update item"})
+	}
+
+	recordRevisions(c, database.ItemHistoryAlias, database.Revision{
+		Id: itemId, Collection: collectionId, Operation: "update", Source: patched,
+	})
+
+	c.Set(fiber.HeaderETag, versionETag(written.SeqNo, written.PrimaryTerm))
+
+	return c.Status(http.StatusOK).JSON(&fiber.Map{
+		"message":    "success",
+		"id":         itemId,
+		"collection": collectionId,
+		"stac_item":  json.RawMessage(patched),
+	})
+}
+
+// PatchESCollection changes part of a collection with a JSON Merge Patch
+// (RFC 7396) or a JSON Patch (RFC 6902).
+func PatchESCollection(c *fiber.Ctx) error {
+	id := c.Params("collectionId")
+
+	indexName := "collections"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := database.ES.Client.Get().
+		Index(indexName).
+		Id(id).
+		Do(ctx)
+
+	if elastic.IsNotFound(err) {
+		return c.Status(http.StatusNotFound).JSON(
+			&fiber.Map{"message": fmt.Sprintf("Collection %s not found", id)})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(
+			&fiber.Map{"message": "error retrieving the collection"})
+	}
+
+	// the collection is patched as it is stored, in the one element array
+	// of its data
+	var collection models.Collection
+	var stored struct {
+		Data []json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Source, &collection); err != nil || json.Unmarshal(resp.Source, &stored) != nil || len(stored.Data) == 0 {
+		return c.Status(http.StatusInternalServerError).JSON(
+			&fiber.Map{"message": "Error unmarshalling the collection"})
+	}
+	data := stored.Data[0]
+
+	if err := checkIfMatch(c, resp); err != nil {
+		return writeError(c, err, "could not update collection")
+	}
+	patched, err := applyPatch(c, data)
+	if err != nil {
+		return writeError(c, err, "could not update collection")
+	}
+	stacCollection, err := validateCollection(patched, id)
+	if err != nil {
+		return writeError(c, err, "could not update collection")
+	}
+
+	now := time.Now()
+	collection.Data = models.JSONB{json.RawMessage(patched)}
+	collection.UpdatedAt = &now
+	setCollectionExtent(&collection, stacCollection)
+
+	// the collection is only written if it did not change since it was read
+	written, err := database.ES.Client.Index().
+		Index(indexName).
+		Id(id).
+		BodyJson(collection).
+		IfSeqNo(*resp.SeqNo).
+		IfPrimaryTerm(*resp.PrimaryTerm).
+		Do(ctx)
+
+	if database.WritesBlocked(err) {
+		return writeError(c, errWritesBlocked, "could not update collection")
+	}
+	if lostUpdate(c, err) {
+		return writeError(c, errPreconditionFailed, "could not update collection")
+	}
+	if elastic.IsConflict(err) {
+		return c.Status(http.StatusConflict).JSON(
+			&fiber.Map{"message": fmt.Sprintf("Collection %s changed while it was patched", id)})
+	}
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": "could not update collection"})
+	}
+
+	doc, _ := json.Marshal(collection)
+	recordRevisions(c, database.CollectionHistoryAlias, database.Revision{
+		Id: id, Operation: "update", Source: doc,
+	})
+
+	c.Set(fiber.HeaderETag, versionETag(written.SeqNo, written.PrimaryTerm))
+
+	return c.Status(http.StatusOK).JSON(&fiber.Map{
+		"message":         "success",
+		"id":              id,
+		"stac_collection": json.RawMessage(patched),
+	})
+}
@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jonhealy1/goapi-stac/es-api/database"
+	"github.com/jonhealy1/goapi-stac/es-api/models"
+	"github.com/jonhealy1/goapi-stac/shared/params"
+	"github.com/jonhealy1/goapi-stac/shared/queryables"
+	"github.com/olivere/elastic/v7"
+)
+
+// esQueryables reads the queryables from the items and collections
+// indices.
+type esQueryables struct{}
+
+// Sample types the properties of a sample of the items by the JSON types
+// of their values, named as pg-api names them.
+func (esQueryables) Sample(collections []string) (map[string][]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the collections may have no items index
+	searchResult, err := database.ES.Client.Search(database.ItemsIndices(collections)...).
+		IgnoreUnavailable(true).
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Include("properties")).
+		Size(queryables.SampleSize).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	types := map[string][]string{}
+	found := map[string]bool{}
+	for _, hit := range searchResult.Hits.Hits {
+		var item struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		}
+		if err := json.Unmarshal(hit.Source, &item); err != nil {
+			continue
+		}
+		for name, value := range item.Properties {
+			jsonType := jsonTypeOf(value)
+			if !found[name+"|"+jsonType] {
+				found[name+"|"+jsonType] = true
+				types[name] = append(types[name], jsonType)
+			}
+		}
+	}
+	return types, nil
+}
+
+// jsonTypeOf returns the JSON type of a value as jsonb_typeof names it.
+func jsonTypeOf(value json.RawMessage) string {
+	if len(value) == 0 {
+		return "null"
+	}
+	switch value[0] {
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case '"':
+		return "string"
+	case 't', 'f':
+		return "boolean"
+	case 'n':
+		return "null"
+	}
+	return "number"
+}
+
+// Summaries returns the summaries of the collections, or of every
+// collection, scrolling through them as there can be more than a search
+// returns at once.
+func (esQueryables) Summaries(collections []string) ([]map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	scroll := database.ES.Client.Scroll("collections").
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Include("data.summaries")).
+		Size(1000)
+	if len(collections) > 0 {
+		scroll = scroll.Query(elastic.NewIdsQuery().Ids(collections...))
+	}
+	defer scroll.Clear(context.Background())
+
+	var summaries []map[string]interface{}
+	for {
+		page, err := scroll.Do(ctx)
+		if err == io.EOF {
+			return summaries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range page.Hits.Hits {
+			var collection struct {
+				Data []models.StacCollection `json:"data"`
+			}
+			if err := json.Unmarshal(hit.Source, &collection); err != nil || len(collection.Data) == 0 {
+				continue
+			}
+			if collection.Data[0].Summaries != nil {
+				summaries = append(summaries, collection.Data[0].Summaries)
+			}
+		}
+	}
+}
+
+// checkQueryables rejects a search whose filter references properties that
+// are not queryables of the collections searched, as pg-api does.
+func checkQueryables(search models.Search) error {
+	filter, err := params.Filter(search.Filter, search.FilterLang, search.FilterCrs)
+	if err != nil || filter == nil {
+		return err
+	}
+
+	available, err := queryables.Get(esQueryables{}, search.Collections)
+	if err != nil {
+		return err
+	}
+	return available.Check(filter)
+}
+
+// queryablesError responds to a search checkQueryables rejected: with a
+// 400 if its filter references an unknown queryable, and with a 500 if the
+// queryables could not be computed. The filter is parsed by searchQuery
+// first, so it is known to be valid.
+func queryablesError(c *fiber.Ctx, err error) error {
+	if _, ok := err.(*queryables.UnknownError); ok {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+	return c.Status(http.StatusInternalServerError).JSON(
+		&fiber.Map{"message": "could not get queryables"})
+}
+
+func queryablesResponse(c *fiber.Ctx, collections []string) error {
+	q, err := queryables.Get(esQueryables{}, collections)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(
+			&fiber.Map{"message": "could not get queryables"})
+	}
+	return c.Status(http.StatusOK).JSON(q.Schema(c.BaseURL() + c.Path()))
+}
+
+// ESQueryables responds with the properties filters can use across all
+// collections.
+func ESQueryables(c *fiber.Ctx) error {
+	return queryablesResponse(c, nil)
+}
+
+// ESCollectionQueryables responds with the properties filters on the items
+// of a collection can use.
+func ESCollectionQueryables(c *fiber.Ctx) error {
+	id := c.Params("collectionId")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := database.ES.Client.Get().
+		Index("collections").
+		Id(id).
+		FetchSource(false).
+		Do(ctx)
+	if elastic.IsNotFound(err) {
+		return c.Status(http.StatusNotFound).JSON(
+			&fiber.Map{"message": fmt.Sprintf("%s does not exist", id)})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(
+			&fiber.Map{"message": "could not get collection"})
+	}
+	return queryablesResponse(c, []string{id})
+}
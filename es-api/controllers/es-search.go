@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jonhealy1/goapi-stac/es-api/database"
+	"github.com/jonhealy1/goapi-stac/es-api/models"
+	"github.com/jonhealy1/goapi-stac/shared/freetext"
+	"github.com/jonhealy1/goapi-stac/shared/params"
+	"github.com/olivere/elastic/v7"
+)
+
+func ESGetSearch(c *fiber.Ctx) error {
+	search, err := getSearchParams(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+	return runSearch(c, search, getPageLinks)
+}
+
+func ESPostSearch(c *fiber.Ctx) error {
+	var search models.Search
+
+	if err := c.BodyParser(&search); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+	return runSearch(c, search, postPageLinks)
+}
+
+// runSearch runs a GET or POST search and responds with the requested page
+// of items, in the same form as pg-api. links builds the next and prev
+// links for the request's method.
+func runSearch(c *fiber.Ctx, search models.Search, links func(*fiber.Ctx, string, string) []models.Link) error {
+	limit := pageLimit(search.Limit)
+	token, err := decodeToken(search.Token)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+	reverse := token != nil && token.Prev
+	sorters, err := itemSorters(search.Sortby, reverse)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+	if search.Q != "" && len(search.Sortby) == 0 {
+		sorters = relevanceSorters(reverse)
+	}
+	if err := checkToken(token, sorters); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+	query, err := searchQuery(search)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": err.Error()})
+	}
+	if err := checkQueryables(search); err != nil {
+		return queryablesError(c, err)
+	}
+
+	// the items as they were at a point in time are searched in their
+	// history, which searchQuery limits to the collections searched
+	indices := database.ItemsIndices(search.Collections)
+	source := sourceContext(search.Fields)
+	if search.AsOf != "" {
+		t, err := parseAsOf(search.AsOf)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(
+				&fiber.Map{"message": err.Error()})
+		}
+		query.Filter(asOfQuery(t))
+		indices = []string{database.ItemHistoryAlias}
+		source = withoutRevision(source)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	request, pit, err := pageSearch(ctx, indices, sorters, limit, token)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(
+			&fiber.Map{"message": "error searching items in Elasticsearch"})
+	}
+	request = request.Query(query)
+	if source != nil {
+		request = request.FetchSourceContext(source)
+	}
+	searchResult, err := request.Do(ctx)
+	if pitExpired(err, token) {
+		return c.Status(http.StatusBadRequest).JSON(
+			&fiber.Map{"message": "pagination token has expired"})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(
+			&fiber.Map{"message": "error searching items in Elasticsearch"})
+	}
+
+	hits, next, prev := paginate(searchResult.Hits.Hits, limit, token, resultPit(searchResult, pit))
+	stacItems := []json.RawMessage{}
+	for _, hit := range hits {
+		stacItems = append(stacItems, hit.Source)
+	}
+
+	matched, estimated := numberMatched(searchResult)
+
+	return c.Status(http.StatusOK).JSON(&fiber.Map{
+		"context": models.Context{
+			Returned:         len(stacItems),
+			Limit:            limit,
+			Matched:          matched,
+			MatchedEstimated: estimated,
+		},
+		"numberMatched":  matched,
+		"numberReturned": len(stacItems),
+		"type":           "FeatureCollection",
+		"features":       stacItems,
+		"links":          links(c, next, prev),
+	})
+}
+
+// getSearchParams reads the query parameters of a GET search into the
+// same form a POST search body takes.
+func getSearchParams(c *fiber.Ctx) (models.Search, error) {
+	var search models.Search
+
+	if collections := c.Query("collections"); collections != "" {
+		search.Collections = strings.Split(collections, ",")
+	}
+	if ids := c.Query("ids"); ids != "" {
+		search.Ids = strings.Split(ids, ",")
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		var err error
+		if search.Limit, err = strconv.Atoi(limit); err != nil || search.Limit < 1 {
+			return search, fmt.Errorf("limit must be a positive integer")
+		}
+	}
+
+	var err error
+	if search.Bbox, err = params.ParseBbox(c.Query("bbox")); err != nil {
+		return search, err
+	}
+
+	if intersects := c.Query("intersects", c.Query("geometry")); intersects != "" {
+		search.Intersects = json.RawMessage(intersects)
+	}
+
+	search.Q = models.FreeText(c.Query("q"))
+	search.Datetime = c.Query("datetime")
+	search.Token = c.Query("token")
+	search.Fields = params.ParseFields(c.Query("fields"))
+	search.Sortby = params.ParseSortby(c.Query("sortby"))
+	search.AsOf = c.Query("asof")
+
+	if query := c.Query("query"); query != "" {
+		if err := json.Unmarshal([]byte(query), &search.Query); err != nil {
+			return search, fmt.Errorf("query must be a JSON object of property comparisons")
+		}
+	}
+
+	if filter := c.Query("filter"); filter != "" {
+		search.FilterLang = c.Query("filter-lang", "cql2-text")
+		search.FilterCrs = c.Query("filter-crs")
+		if search.FilterLang == "cql2-text" {
+			// a POST body holds cql2-text as a JSON string
+			search.Filter, _ = json.Marshal(filter)
+		} else {
+			search.Filter = json.RawMessage(filter)
+		}
+	}
+
+	return search, nil
+}
+
+// searchQuery builds the query matching the items a search selects. A
+// free-text query scores the items it matches, while the other criteria
+// only filter them.
+func searchQuery(search models.Search) (*elastic.BoolQuery, error) {
+	query := elastic.NewBoolQuery()
+
+	if search.Q != "" {
+		text, err := freetext.Parse(string(search.Q))
+		if err != nil {
+			return nil, err
+		}
+		query.Must(textQuery(text, itemTextFields))
+	}
+
+	if len(search.Ids) > 0 {
+		query.Filter(elastic.NewTermsQuery("id", stringValues(search.Ids)...))
+	}
+	if len(search.Collections) > 0 {
+		query.Filter(elastic.NewTermsQuery("collection", stringValues(search.Collections)...))
+	}
+
+	intersects := search.Intersects
+	if len(intersects) == 0 {
+		intersects = search.Geometry
+	}
+	if string(intersects) == "null" {
+		intersects = nil
+	}
+	if len(search.Bbox) > 0 {
+		if len(intersects) > 0 {
+			return nil, fmt.Errorf("only one of bbox and intersects can be given")
+		}
+		bbox, err := bboxQuery("geometry", search.Bbox)
+		if err != nil {
+			return nil, err
+		}
+		query.Filter(bbox)
+	} else if len(intersects) > 0 {
+		geometry, err := intersectsQuery(intersects)
+		if err != nil {
+			return nil, err
+		}
+		query.Filter(geometry)
+	}
+
+	if search.Datetime != "" {
+		datetime, err := datetimeQuery(search.Datetime)
+		if err != nil {
+			return nil, err
+		}
+		query.Filter(datetime)
+	}
+
+	filter, err := searchFilterQuery(search)
+	if err != nil {
+		return nil, err
+	}
+	if filter != nil {
+		query.Filter(filter)
+	}
+
+	extension, err := queryExtensionQuery(search.Query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %v", err)
+	}
+	if extension != nil {
+		query.Filter(extension)
+	}
+
+	return query, nil
+}
+
+func stringValues(values []string) []interface{} {
+	var result []interface{}
+	for _, value := range values {
+		result = append(result, value)
+	}
+	return result
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jonhealy1/goapi-stac/es-api/models"
+	"github.com/olivere/elastic/v7"
+)
+
+// esSortable is a field searches can be sorted by, and the field of the
+// items index holding its values in a sortable type. esType is the type the
+// field is sorted as while no item has it yet and it has no mapping.
+type esSortable struct {
+	field  string
+	esType string
+	schema map[string]interface{}
+}
+
+var (
+	stringSchema   = map[string]interface{}{"type": "string"}
+	numberSchema   = map[string]interface{}{"type": "number"}
+	datetimeSchema = map[string]interface{}{"type": "string", "format": "date-time"}
+)
+
+// sortables lists the fields searches can be sorted by, the same as in
+// pg-api. Dynamically mapped strings are sorted on their keyword subfield.
+var sortables = map[string]esSortable{
+	"id":                        {"id", "keyword", stringSchema},
+	"collection":                {"collection", "keyword", stringSchema},
+	"properties.datetime":       {"properties.datetime", "date", datetimeSchema},
+	"properties.start_datetime": {"properties.start_datetime", "date", datetimeSchema},
+	"properties.end_datetime":   {"properties.end_datetime", "date", datetimeSchema},
+	"properties.created":        {"properties.created", "date", datetimeSchema},
+	"properties.updated":        {"properties.updated", "date", datetimeSchema},
+	"properties.platform":       {"properties.platform.keyword", "keyword", stringSchema},
+	"properties.constellation":  {"properties.constellation.keyword", "keyword", stringSchema},
+	"properties.gsd":            {"properties.gsd", "double", numberSchema},
+	"properties.eo:cloud_cover": {"properties.eo:cloud_cover", "double", numberSchema},
+}
+
+// defaultSortby orders items newest first.
+var defaultSortby = []models.Sort{{Field: "properties.datetime", Direction: "desc"}}
+
+// itemSorters returns the sorters for sortby, with the id as a tie-breaker
+// so that the order is total. Reversed, they list items in the opposite
+// order, which is used to fetch a previous page. Items missing a field
+// always come last going forwards.
+func itemSorters(sortby []models.Sort, reverse bool) ([]elastic.Sorter, error) {
+	if len(sortby) == 0 {
+		sortby = defaultSortby
+	}
+
+	var sorters []elastic.Sorter
+	hasID := false
+	for _, sort := range sortby {
+		name := sort.Field
+		if _, ok := sortables[name]; !ok {
+			name = "properties." + name
+		}
+		s, ok := sortables[name]
+		if !ok {
+			return nil, fmt.Errorf("cannot sort by %q, see /sortables for the fields that can be sorted by", sort.Field)
+		}
+
+		ascending := true
+		switch strings.ToLower(sort.Direction) {
+		case "", "asc":
+		case "desc":
+			ascending = false
+		default:
+			return nil, fmt.Errorf("sort direction must be asc or desc, got %q", sort.Direction)
+		}
+
+		missing := "_last"
+		if reverse {
+			missing = "_first"
+		}
+		hasID = hasID || name == "id"
+		sorters = append(sorters, elastic.NewFieldSort(s.field).Order(ascending != reverse).Missing(missing).UnmappedType(s.esType))
+	}
+	if !hasID {
+		sorters = append(sorters, elastic.NewFieldSort("id").Order(!reverse))
+	}
+	return sorters, nil
+}
+
+func ESSortables(c *fiber.Ctx) error {
+	properties := map[string]interface{}{}
+	for name, s := range sortables {
+		properties[name] = s.schema
+	}
+
+	return c.Status(http.StatusOK).JSON(&fiber.Map{
+		"$schema":    "https://json-schema.org/draft/2019-09/schema",
+		"$id":        c.BaseURL() + "/sortables",
+		"type":       "object",
+		"title":      "Sortables",
+		"properties": properties,
+	})
+}
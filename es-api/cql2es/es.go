@@ -0,0 +1,516 @@
+package cql2es
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jonhealy1/goapi-stac/shared/cql2"
+	"github.com/olivere/elastic/v7"
+)
+
+type field struct {
+	name      string
+	valueType string
+}
+
+// fields maps the queryables that are not item properties, or whose type
+// is fixed by the items mapping. All other properties are read from the
+// item's properties, where strings are matched on their keyword subfield.
+var fields = map[string]field{
+	"id":             {"id", "string"},
+	"collection":     {"collection", "string"},
+	"datetime":       {"properties.datetime", "timestamp"},
+	"start_datetime": {"properties.start_datetime", "timestamp"},
+	"end_datetime":   {"properties.end_datetime", "timestamp"},
+}
+
+// coalesced lists the fields that fall back on the datetime of items
+// without a range, as the start_datetime and end_datetime columns of
+// pg-api do.
+var coalesced = map[string]bool{
+	"properties.start_datetime": true,
+	"properties.end_datetime":   true,
+}
+
+// esSpatialRelations maps the spatial operators to the geo_shape relations
+// that implement them. Elasticsearch has none for the other operators.
+var esSpatialRelations = map[string]string{
+	"s_intersects": "intersects",
+	"s_disjoint":   "disjoint",
+	"s_within":     "within",
+	"s_contains":   "contains",
+}
+
+// esTemporalConditions expresses each temporal operator as comparisons of
+// the start (S) and end (E) instants of its operands a and b, following the
+// definitions in the CQL2 standard. The comparisons of a group are ANDed
+// together, and the groups ORed.
+var esTemporalConditions = map[string][][]string{
+	"t_after":        {{"aS > bE"}},
+	"t_before":       {{"aE < bS"}},
+	"t_contains":     {{"aS < bS", "aE > bE"}},
+	"t_disjoint":     {{"aS > bE"}, {"aE < bS"}},
+	"t_during":       {{"aS > bS", "aE < bE"}},
+	"t_equals":       {{"aS = bS", "aE = bE"}},
+	"t_finishedBy":   {{"aS < bS", "aE = bE"}},
+	"t_finishes":     {{"aS > bS", "aE = bE"}},
+	"t_intersects":   {{"aS <= bE", "aE >= bS"}},
+	"t_meets":        {{"aE = bS"}},
+	"t_metBy":        {{"aS = bE"}},
+	"t_overlappedBy": {{"aS > bS", "aS < bE", "aE > bE"}},
+	"t_overlaps":     {{"aS < bS", "aE > bS", "aE < bE"}},
+	"t_startedBy":    {{"aS = bS", "aE > bE"}},
+	"t_starts":       {{"aS = bS", "aE < bE"}},
+}
+
+// converses maps each comparison operator to the one comparing its operands
+// the other way round.
+var converses = map[string]string{
+	"=": "=", "<>": "<>", "<": ">", "<=": ">=", ">": "<", ">=": "<=",
+}
+
+// ToES translates a filter expression into an Elasticsearch query on the
+// items index, matching the same items as the SQL condition pg-api
+// translates it into. Comparisons are made between a property and a
+// literal, or between two properties with a script.
+func ToES(e cql2.Expr) (elastic.Query, error) {
+	switch v := e.(type) {
+	case cql2.Logical:
+		var args []elastic.Query
+		for _, arg := range v.Args {
+			q, err := ToES(arg)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, q)
+		}
+		if v.Op == "or" {
+			return elastic.NewBoolQuery().Should(args...).MinimumNumberShouldMatch(1), nil
+		}
+		return elastic.NewBoolQuery().Filter(args...), nil
+	case cql2.Not:
+		q, err := ToES(v.Arg)
+		if err != nil {
+			return nil, err
+		}
+		return elastic.NewBoolQuery().MustNot(q), nil
+	case cql2.Literal:
+		if v.Value == true {
+			return elastic.NewMatchAllQuery(), nil
+		}
+		return elastic.NewMatchNoneQuery(), nil
+	case cql2.Comparison:
+		return comparisonQuery(v)
+	case cql2.Like:
+		return likeQuery(v)
+	case cql2.In:
+		valueType, err := valueType(append([]cql2.Expr{v.Value}, v.List...)...)
+		if err != nil {
+			return nil, err
+		}
+		f, err := propertyField(v.Value, valueType)
+		if err != nil {
+			return nil, err
+		}
+		var values []interface{}
+		for _, item := range v.List {
+			value, err := literalValue(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return onField(f, func(name string) elastic.Query {
+			return elastic.NewTermsQuery(name, values...)
+		}), nil
+	case cql2.Between:
+		valueType, err := valueType(v.Value, v.Low, v.High)
+		if err != nil {
+			return nil, err
+		}
+		f, err := propertyField(v.Value, valueType)
+		if err != nil {
+			return nil, err
+		}
+		low, err := literalValue(v.Low)
+		if err != nil {
+			return nil, err
+		}
+		high, err := literalValue(v.High)
+		if err != nil {
+			return nil, err
+		}
+		return onField(f, func(name string) elastic.Query {
+			return elastic.NewRangeQuery(name).Gte(low).Lte(high)
+		}), nil
+	case cql2.IsNull:
+		p, ok := v.Value.(cql2.Property)
+		if !ok {
+			return nil, fmt.Errorf("isNull requires a property")
+		}
+		name := "properties." + cql2.PropertyName(p)
+		if f, ok := fields[cql2.PropertyName(p)]; ok {
+			name = f.name
+		}
+		query := elastic.NewBoolQuery().MustNot(elastic.NewExistsQuery(name))
+		if coalesced[name] {
+			query.MustNot(elastic.NewExistsQuery("properties.datetime"))
+		}
+		return query, nil
+	case cql2.Spatial:
+		return spatialQuery(v)
+	case cql2.Temporal:
+		return temporalQuery(v)
+	}
+	return nil, fmt.Errorf("expected a boolean expression")
+}
+
+// valueType picks the type a comparison is made in. A property of a fixed
+// type decides it, otherwise the literals among the operands do.
+// Comparisons between properties only are made as strings.
+func valueType(operands ...cql2.Expr) (string, error) {
+	valueType := ""
+	for _, operand := range operands {
+		if p, ok := operand.(cql2.Property); ok {
+			if f, ok := fields[cql2.PropertyName(p)]; ok && f.valueType != "string" {
+				valueType = f.valueType
+			}
+		}
+	}
+	for _, operand := range operands {
+		t := literalType(operand)
+		// timestamps may be written as plain strings
+		if t == "" || (t == "string" && valueType == "timestamp") {
+			continue
+		}
+		if valueType != "" && t != valueType {
+			return "", fmt.Errorf("cannot compare a %s value with a %s value", valueType, t)
+		}
+		valueType = t
+	}
+	if valueType == "" {
+		valueType = "string"
+	}
+	return valueType, nil
+}
+
+func literalType(e cql2.Expr) string {
+	switch v := e.(type) {
+	case cql2.Literal:
+		switch v.Value.(type) {
+		case string:
+			return "string"
+		case float64:
+			return "number"
+		case bool:
+			return "boolean"
+		}
+	case cql2.Timestamp, cql2.Date:
+		return "timestamp"
+	}
+	return ""
+}
+
+// literalValue returns the value of a literal operand, with instants in
+// the RFC 3339 format of the date fields.
+func literalValue(e cql2.Expr) (interface{}, error) {
+	switch v := e.(type) {
+	case cql2.Literal:
+		return v.Value, nil
+	case cql2.Timestamp:
+		return formatInstant(v.Value), nil
+	case cql2.Date:
+		return formatInstant(v.Value), nil
+	}
+	return nil, fmt.Errorf("unsupported operand in comparison")
+}
+
+func formatInstant(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// propertyField returns the field a property operand is matched on when it
+// is compared with a value of valueType.
+func propertyField(e cql2.Expr, valueType string) (string, error) {
+	p, ok := e.(cql2.Property)
+	if !ok {
+		return "", fmt.Errorf("comparisons on Elasticsearch require a property")
+	}
+	name := cql2.PropertyName(p)
+	if name == "geometry" {
+		return "", fmt.Errorf("geometry can only be used with spatial operators")
+	}
+	if f, ok := fields[name]; ok {
+		if f.valueType != valueType {
+			return "", fmt.Errorf("%s cannot be compared with a %s value", name, valueType)
+		}
+		return f.name, nil
+	}
+	if valueType == "string" {
+		return "properties." + name + ".keyword", nil
+	}
+	return "properties." + name, nil
+}
+
+// onField builds the query on a field. Fields that fall back on datetime
+// also match the items without them whose datetime matches.
+func onField(name string, query func(name string) elastic.Query) elastic.Query {
+	if !coalesced[name] {
+		return query(name)
+	}
+	return elastic.NewBoolQuery().
+		Should(
+			query(name),
+			elastic.NewBoolQuery().
+				Filter(query("properties.datetime")).
+				MustNot(elastic.NewExistsQuery(name)),
+		).
+		MinimumNumberShouldMatch(1)
+}
+
+// compare builds the query comparing a field with a value. As in SQL,
+// items without the field match no comparison, not even <>.
+func compare(name string, op string, value interface{}) elastic.Query {
+	return onField(name, func(name string) elastic.Query {
+		switch op {
+		case "<>":
+			return elastic.NewBoolQuery().
+				Filter(elastic.NewExistsQuery(name)).
+				MustNot(elastic.NewTermQuery(name, value))
+		case "<":
+			return elastic.NewRangeQuery(name).Lt(value)
+		case "<=":
+			return elastic.NewRangeQuery(name).Lte(value)
+		case ">":
+			return elastic.NewRangeQuery(name).Gt(value)
+		case ">=":
+			return elastic.NewRangeQuery(name).Gte(value)
+		}
+		return elastic.NewTermQuery(name, value)
+	})
+}
+
+func comparisonQuery(c cql2.Comparison) (elastic.Query, error) {
+	valueType, err := valueType(c.Left, c.Right)
+	if err != nil {
+		return nil, err
+	}
+	left, right, op := c.Left, c.Right, c.Op
+	if _, ok := left.(cql2.Property); !ok {
+		left, right, op = right, left, converses[op]
+	}
+
+	leftField, err := propertyField(left, valueType)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := right.(cql2.Property); ok {
+		rightField, err := propertyField(right, valueType)
+		if err != nil {
+			return nil, err
+		}
+		return propertiesComparison(leftField, op, rightField), nil
+	}
+	value, err := literalValue(right)
+	if err != nil {
+		return nil, err
+	}
+	return compare(leftField, op, value), nil
+}
+
+// scriptOps maps the comparison operators to Painless.
+var scriptOps = map[string]string{
+	"=": "==", "<>": "!=", "<": "<", "<=": "<=", ">": ">", ">=": ">=",
+}
+
+// propertiesComparison builds the query comparing the values of two
+// fields, which Elasticsearch can only make in a script.
+func propertiesComparison(left string, op string, right string) elastic.Query {
+	script := elastic.NewScript(
+		"doc.containsKey(params.a) && doc.containsKey(params.b) && " +
+			"doc[params.a].size() > 0 && doc[params.b].size() > 0 && " +
+			"doc[params.a].value.compareTo(doc[params.b].value) " + scriptOps[op] + " 0").
+		Params(map[string]interface{}{"a": left, "b": right})
+	return elastic.NewScriptQuery(script)
+}
+
+// likeQuery builds the wildcard query matching a LIKE pattern. The %
+// and _ wildcards become * and ?, and a backslash escapes the character
+// following it.
+func likeQuery(l cql2.Like) (elastic.Query, error) {
+	name, err := propertyField(l.Value, "string")
+	if err != nil {
+		return nil, err
+	}
+	literal, _ := l.Pattern.(cql2.Literal)
+	pattern, ok := literal.Value.(string)
+	if !ok {
+		return nil, fmt.Errorf("like requires a string pattern")
+	}
+
+	var sb strings.Builder
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			escaped = false
+			if r == '*' || r == '?' || r == '\\' {
+				sb.WriteRune('\\')
+			}
+			sb.WriteRune(r)
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			sb.WriteRune('*')
+		case r == '_':
+			sb.WriteRune('?')
+		case r == '*' || r == '?':
+			sb.WriteRune('\\')
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return elastic.NewWildcardQuery(name, sb.String()), nil
+}
+
+// shapeQuery matches the items whose geometry has a geo_shape relation
+// with a shape.
+type shapeQuery struct {
+	shape    interface{}
+	relation string
+}
+
+func (q shapeQuery) Source() (interface{}, error) {
+	return map[string]interface{}{
+		"geo_shape": map[string]interface{}{
+			"geometry": map[string]interface{}{
+				"shape":    q.shape,
+				"relation": q.relation,
+			},
+		},
+	}, nil
+}
+
+func spatialQuery(s cql2.Spatial) (elastic.Query, error) {
+	relation, ok := esSpatialRelations[s.Op]
+	if !ok {
+		return nil, fmt.Errorf("%s is not supported on Elasticsearch, which supports s_intersects, s_disjoint, s_within and s_contains", s.Op)
+	}
+	property, shape := s.Left, s.Right
+	if _, ok := property.(cql2.Property); !ok {
+		property, shape = shape, property
+		// the geometry contains a shape that lies within it
+		switch relation {
+		case "within":
+			relation = "contains"
+		case "contains":
+			relation = "within"
+		}
+	}
+
+	if p, ok := property.(cql2.Property); !ok || cql2.PropertyName(p) != "geometry" {
+		return nil, fmt.Errorf("spatial operators on Elasticsearch compare the geometry property with a geometry or bbox")
+	}
+	switch v := shape.(type) {
+	case cql2.Geometry:
+		return shapeQuery{shape: v.GeoJSON, relation: relation}, nil
+	case cql2.BBox:
+		coords := v.Coords
+		if len(coords) == 6 {
+			coords = []float64{coords[0], coords[1], coords[3], coords[4]}
+		}
+		envelope := map[string]interface{}{
+			"type":        "envelope",
+			"coordinates": [][]float64{{coords[0], coords[3]}, {coords[2], coords[1]}},
+		}
+		return shapeQuery{shape: envelope, relation: relation}, nil
+	}
+	return nil, fmt.Errorf("spatial operators on Elasticsearch compare the geometry property with a geometry or bbox")
+}
+
+func temporalQuery(t cql2.Temporal) (elastic.Query, error) {
+	operands := map[byte]cql2.Expr{'a': t.Left, 'b': t.Right}
+	var groups []elastic.Query
+	for _, group := range esTemporalConditions[t.Op] {
+		var comparisons []elastic.Query
+		for _, condition := range group {
+			parts := strings.Fields(condition)
+			q, err := instantComparison(operands[parts[0][0]], parts[0][1] == 'E', parts[1], operands[parts[2][0]], parts[2][1] == 'E')
+			if err != nil {
+				return nil, err
+			}
+			comparisons = append(comparisons, q)
+		}
+		groups = append(groups, elastic.NewBoolQuery().Filter(comparisons...))
+	}
+	if len(groups) == 1 {
+		return groups[0], nil
+	}
+	return elastic.NewBoolQuery().Should(groups...).MinimumNumberShouldMatch(1), nil
+}
+
+// instantComparison compares the start, or the end, of a property with
+// the start, or the end, of a literal.
+func instantComparison(a cql2.Expr, aEnd bool, op string, b cql2.Expr, bEnd bool) (elastic.Query, error) {
+	if _, ok := a.(cql2.Property); !ok {
+		a, aEnd, b, bEnd, op = b, bEnd, a, aEnd, converses[op]
+	}
+	p, ok := a.(cql2.Property)
+	if !ok {
+		return nil, fmt.Errorf("temporal operators on Elasticsearch require a property")
+	}
+	if _, ok := b.(cql2.Property); ok {
+		return nil, fmt.Errorf("temporal operators on Elasticsearch compare a property with a timestamp, date or interval")
+	}
+
+	name := cql2.PropertyName(p)
+	if name == "datetime" {
+		// an item covers [start_datetime, end_datetime], which are both
+		// its datetime if it has no range
+		name = "start_datetime"
+		if aEnd {
+			name = "end_datetime"
+		}
+	}
+	f, err := propertyField(cql2.Property{Name: name}, "timestamp")
+	if err != nil {
+		return nil, err
+	}
+
+	var bound *time.Time
+	switch v := b.(type) {
+	case cql2.Timestamp:
+		bound = &v.Value
+	case cql2.Date:
+		day := v.Value
+		if bEnd {
+			day = day.Add(cql2.EndOfDay)
+		}
+		bound = &day
+	case cql2.Interval:
+		bound = v.Start
+		if bEnd {
+			bound = v.End
+		}
+	default:
+		return nil, fmt.Errorf("temporal operators require a timestamp, date, interval or property")
+	}
+
+	if bound == nil {
+		// all instants lie after the open start of an interval, and before
+		// its open end
+		holds := op == ">" || op == ">=" || op == "<>"
+		if bEnd {
+			holds = op == "<" || op == "<=" || op == "<>"
+		}
+		if !holds {
+			return elastic.NewMatchNoneQuery(), nil
+		}
+		return onField(f, func(name string) elastic.Query {
+			return elastic.NewExistsQuery(name)
+		}), nil
+	}
+	return compare(f, op, formatInstant(*bound)), nil
+}
@@ -0,0 +1,306 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// BulkConfig configures a BulkIndexer.
+type BulkConfig struct {
+	// Workers is the number of batches sent at once.
+	Workers int
+	// FlushActions and FlushBytes are the number of documents and their
+	// size a batch is sent at.
+	FlushActions int
+	FlushBytes   int
+	// FlushInterval is how often the batches that are not full are sent.
+	FlushInterval time.Duration
+	// RetryInitial and RetryMax bound the exponential backoff between the
+	// retries of documents rejected with 429 or 503. Documents are not
+	// retried once the wait would exceed RetryMax.
+	RetryInitial time.Duration
+	RetryMax     time.Duration
+}
+
+// defaultBulkConfig holds the settings a BulkConfig leaves unset.
+var defaultBulkConfig = BulkConfig{
+	Workers:       2,
+	FlushActions:  1000,
+	FlushBytes:    5 << 20,
+	FlushInterval: time.Second,
+	RetryInitial:  100 * time.Millisecond,
+	RetryMax:      30 * time.Second,
+}
+
+// withDefaults returns the config with the default of every setting that
+// is not positive: no workers would never send a document, and a flush
+// interval that is not positive cannot be timed.
+func (c BulkConfig) withDefaults() BulkConfig {
+	if c.Workers <= 0 {
+		c.Workers = defaultBulkConfig.Workers
+	}
+	if c.FlushActions <= 0 {
+		c.FlushActions = defaultBulkConfig.FlushActions
+	}
+	if c.FlushBytes <= 0 {
+		c.FlushBytes = defaultBulkConfig.FlushBytes
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = defaultBulkConfig.FlushInterval
+	}
+	if c.RetryInitial <= 0 {
+		c.RetryInitial = defaultBulkConfig.RetryInitial
+	}
+	if c.RetryMax <= 0 {
+		c.RetryMax = defaultBulkConfig.RetryMax
+	}
+	return c
+}
+
+// bulkConfigFromEnv reads the BULK_* settings.
+func bulkConfigFromEnv() BulkConfig {
+	envInt := func(key string, fallback int) int {
+		if i, err := strconv.Atoi(getEnvWithDefault(key, "")); err == nil {
+			return i
+		}
+		return fallback
+	}
+	envDuration := func(key string, fallback time.Duration) time.Duration {
+		if d, err := time.ParseDuration(getEnvWithDefault(key, "")); err == nil {
+			return d
+		}
+		return fallback
+	}
+	return BulkConfig{
+		Workers:       envInt("BULK_WORKERS", defaultBulkConfig.Workers),
+		FlushActions:  envInt("BULK_FLUSH_ACTIONS", defaultBulkConfig.FlushActions),
+		FlushBytes:    envInt("BULK_FLUSH_BYTES", defaultBulkConfig.FlushBytes),
+		FlushInterval: envDuration("BULK_FLUSH_INTERVAL", defaultBulkConfig.FlushInterval),
+		RetryInitial:  envDuration("BULK_RETRY_INITIAL", defaultBulkConfig.RetryInitial),
+		RetryMax:      envDuration("BULK_RETRY_MAX", defaultBulkConfig.RetryMax),
+	}
+}
+
+// BulkResult is the outcome of a document sent through a BulkIndexer.
+type BulkResult struct {
+	Index string
+	Id    string
+	// Status is the status code Elasticsearch answered the document with.
+	Status int
+	// Result is created, updated or deleted if the document was written.
+	Result string
+	Error  string
+	// Blocked is set if the document was not written as writes to its
+	// index are blocked, see WritesBlocked.
+	Blocked bool
+}
+
+// Failed tells whether the document was not written.
+func (r BulkResult) Failed() bool {
+	return r.Status < 200 || r.Status > 299
+}
+
+// bulkDocument is a document queued in a BulkIndexer.
+type bulkDocument struct {
+	request elastic.BulkableRequest
+	size    int
+	refresh string
+	result  *BulkResult
+	done    *sync.WaitGroup
+}
+
+// BulkIndexer writes documents through the _bulk API. Documents are queued
+// and sent by a fixed number of workers in batches, each sent when it holds
+// enough documents or bytes, or at the flush interval. Adding documents
+// blocks while the queue is full.
+type BulkIndexer struct {
+	client  *elastic.Client
+	config  BulkConfig
+	backoff elastic.Backoff
+	queue   chan *bulkDocument
+	workers sync.WaitGroup
+}
+
+// NewBulkIndexer starts a BulkIndexer. The settings of the config that are
+// not positive take their defaults.
+func NewBulkIndexer(client *elastic.Client, config BulkConfig) *BulkIndexer {
+	config = config.withDefaults()
+	b := &BulkIndexer{
+		client:  client,
+		config:  config,
+		backoff: elastic.NewExponentialBackoff(config.RetryInitial, config.RetryMax),
+		queue:   make(chan *bulkDocument, config.Workers*config.FlushActions),
+	}
+	for i := 0; i < config.Workers; i++ {
+		b.workers.Add(1)
+		go b.work()
+	}
+	return b
+}
+
+// Close sends the documents queued, and stops the workers.
+func (b *BulkIndexer) Close() {
+	close(b.queue)
+	b.workers.Wait()
+}
+
+// Write sends documents and waits for their outcomes, in the order of the
+// requests. Refresh is the refresh parameter of the _bulk API, whose
+// strongest value among the documents of a batch applies to all of them.
+func (b *BulkIndexer) Write(ctx context.Context, requests []elastic.BulkableRequest, refresh string) ([]BulkResult, error) {
+	results := make([]BulkResult, len(requests))
+	var done sync.WaitGroup
+	for i, request := range requests {
+		lines, err := request.Source()
+		if err != nil {
+			return nil, err
+		}
+		size := 0
+		for _, line := range lines {
+			size += len(line) + 1
+		}
+
+		done.Add(1)
+		document := &bulkDocument{request, size, refresh, &results[i], &done}
+		select {
+		case b.queue <- document:
+		case <-ctx.Done():
+			// the documents queued already are written all the same
+			done.Done()
+			done.Wait()
+			return nil, ctx.Err()
+		}
+	}
+	done.Wait()
+	return results, nil
+}
+
+func (b *BulkIndexer) work() {
+	defer b.workers.Done()
+
+	ticker := time.NewTicker(b.config.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []*bulkDocument
+	size := 0
+	for {
+		select {
+		case document, open := <-b.queue:
+			if !open {
+				b.flush(batch)
+				return
+			}
+			batch = append(batch, document)
+			size += document.size
+			if len(batch) < b.config.FlushActions && size < b.config.FlushBytes {
+				continue
+			}
+		case <-ticker.C:
+		}
+		b.flush(batch)
+		batch, size = nil, 0
+	}
+}
+
+// flush sends a batch, and the documents rejected as the cluster is too
+// busy again until the backoff gives up.
+func (b *BulkIndexer) flush(batch []*bulkDocument) {
+	for retry := 0; len(batch) > 0; retry++ {
+		service := b.client.Bulk().Refresh(bulkRefresh(batch))
+		for _, document := range batch {
+			service.Add(document.request)
+		}
+		response, err := service.Do(context.Background())
+
+		var rejected []*bulkDocument
+		var reason string
+		switch {
+		case err != nil && retryable(errorStatus(err)):
+			rejected, reason = batch, err.Error()
+		case err != nil:
+			for _, document := range batch {
+				document.finish(BulkResult{Status: errorStatus(err), Error: err.Error(), Blocked: WritesBlocked(err)})
+			}
+		default:
+			// the items of the response are in the order of the documents
+			for i, item := range response.Items {
+				for _, result := range item {
+					if retryable(result.Status) {
+						rejected = append(rejected, batch[i])
+						reason = errorReason(result)
+						continue
+					}
+					batch[i].finish(BulkResult{
+						Index:   result.Index,
+						Id:      result.Id,
+						Status:  result.Status,
+						Result:  result.Result,
+						Error:   errorReason(result),
+						Blocked: result.Error != nil && result.Error.Type == blockedType,
+					})
+				}
+			}
+		}
+
+		wait, ok := b.backoff.Next(retry)
+		if !ok {
+			for _, document := range rejected {
+				document.finish(BulkResult{Status: http.StatusServiceUnavailable, Error: reason})
+			}
+			return
+		}
+		if len(rejected) > 0 {
+			time.Sleep(wait)
+		}
+		batch = rejected
+	}
+}
+
+func (d *bulkDocument) finish(result BulkResult) {
+	*d.result = result
+	d.done.Done()
+}
+
+// bulkRefresh returns the strongest refresh asked for by the documents of
+// a batch.
+func bulkRefresh(batch []*bulkDocument) string {
+	refresh := "false"
+	for _, document := range batch {
+		switch {
+		case document.refresh == "true":
+			return "true"
+		case document.refresh == "wait_for":
+			refresh = "wait_for"
+		}
+	}
+	return refresh
+}
+
+// retryable tells whether a status code means the cluster is too busy for
+// now.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// errorStatus returns the status code of an error of the whole request,
+// 503 if the cluster could not be reached.
+func errorStatus(err error) int {
+	var elasticErr *elastic.Error
+	if errors.As(err, &elasticErr) {
+		return elasticErr.Status
+	}
+	return http.StatusServiceUnavailable
+}
+
+func errorReason(result *elastic.BulkResponseItem) string {
+	if result.Error == nil {
+		return ""
+	}
+	return result.Error.Reason
+}
@@ -13,6 +13,8 @@ import (
 
 type ESInstance struct {
 	Client *elastic.Client
+	// Bulk writes documents in batches.
+	Bulk *BulkIndexer
 }
 
 var ES ESInstance
@@ -64,92 +66,34 @@ func ConnectES() {
 	}
 
 	log.Println("connected to elastic search")
+	if ES.Bulk != nil {
+		ES.Bulk.Close()
+	}
 	ES = ESInstance{
 		Client: es,
+		Bulk:   NewBulkIndexer(es, bulkConfigFromEnv()),
 	}
 	createCollectionsIndex(ES)
-	createItemsIndex(ES)
+	migrateItemsIndex(ES)
 }
 
 func createCollectionsIndex(database ESInstance) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Create the collections index
-	indexName := "collections"
-	exists, err := database.Client.IndexExists(indexName).Do(ctx)
-	if err != nil {
+	if err := putIndexTemplates(ctx, database); err != nil {
 		log.Fatalf("Could not contact Elasticsearch: %v", err)
 	}
-	if !exists {
-		// Define the mapping for the index
-		mapping := `{
-			"mappings": {
-				"properties": {
-				"data": {
-					"properties": {
-					"extent": {
-						"properties": {
-						"temporal": {
-							"properties": {
-							"interval": {
-								"type": "text"
-							}
-							}
-						}
-						}
-					}
-					}
-				}
-				}
-			}
-		}`
-
-		_, err := database.Client.CreateIndex(indexName).BodyString(mapping).Do(ctx)
-		if err != nil {
-			log.Fatalf("Could not create Elasticsearch index: %v", err)
-		}
-	}
-
-	// Create other indices as needed
-}
 
-func createItemsIndex(database ESInstance) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	// Create the items index
-	indexName := "items"
-	exists, err := database.Client.IndexExists(indexName).Do(ctx)
+	// Create the collections index
+	err := createVersionedIndex(ctx, database, collectionsAlias, collectionsMappingVersion)
 	if err != nil {
-		log.Fatalf("Could not contact Elasticsearch: %v", err)
+		log.Fatalf("Could not create Elasticsearch index: %v", err)
 	}
-	if !exists {
-		// Define the mapping for the index
-		mapping := `{
-            "mappings": {
-                "properties": {
-                    "geometry": {
-                        "type": "geo_shape"
-                	},
-					"collection": {
-						"type": "keyword"
-					},
-					"properties": {
-						"properties": {
-							"datetime": {
-								"type": "date"
-							}
-						}
-					}
-            	}
-			}
-        }`
-
-		_, err := database.Client.CreateIndex(indexName).BodyString(mapping).Do(ctx)
-		if err != nil {
-			log.Fatalf("Could not create Elasticsearch index: %v", err)
-		}
+	warnOutdatedIndices(ctx, database, collectionsAlias, collectionsMappingVersion)
+
+	if err := createHistoryIndices(ctx, database); err != nil {
+		log.Fatalf("Could not create the history indices: %v", err)
 	}
 
 	// Create other indices as needed
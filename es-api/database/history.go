@@ -0,0 +1,237 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// The aliases of the history indices, which keep every revision of the
+// items and of the collections.
+const (
+	ItemHistoryAlias       = "item_history"
+	CollectionHistoryAlias = "collection_history"
+)
+
+// revisionMapping is the mapping of the revision field the history indices
+// add to the documents they keep. A revision is valid from the time it was
+// written until the next one was, and the last one has no valid_to.
+const revisionMapping = `{
+	"properties": {
+		"number": {
+			"type": "long"
+		},
+		"operation": {
+			"type": "keyword"
+		},
+		"client_label": {
+			"type": "keyword"
+		},
+		"client_address": {
+			"type": "keyword"
+		},
+		"valid_from": {
+			"type": "date"
+		},
+		"valid_to": {
+			"type": "date"
+		}
+	}
+}`
+
+// RevisionTimeFormat is the format of the validity of revisions, with the
+// millisecond precision of dates in Elasticsearch.
+const RevisionTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// revisionAttempts is how many times the revisions of documents written at
+// the same time by other requests are recorded again.
+const revisionAttempts = 3
+
+// Revision is a write of an item or a collection to record in its history.
+type Revision struct {
+	Id string
+	// Collection is the collection of an item, empty for a collection.
+	Collection string
+	// Operation is insert, update or delete.
+	Operation string
+	// Source is the document written, nil for a delete.
+	Source json.RawMessage
+}
+
+// Client is the client of a write, as recorded in the history. Label is
+// given by the client and not verified.
+type Client struct {
+	Label   string
+	Address string
+}
+
+// openId returns the id of the last revision of a document in a history
+// index, the one still valid.
+func (r Revision) openId() string {
+	if r.Collection == "" {
+		return url.PathEscape(r.Id)
+	}
+	return url.PathEscape(r.Collection) + "/" + url.PathEscape(r.Id)
+}
+
+// createHistoryIndices creates the history indices if they do not exist yet.
+func createHistoryIndices(ctx context.Context, database ESInstance) error {
+	if err := createVersionedIndex(ctx, database, ItemHistoryAlias, itemHistoryMappingVersion); err != nil {
+		return err
+	}
+	warnOutdatedIndices(ctx, database, ItemHistoryAlias, itemHistoryMappingVersion)
+	if err := createVersionedIndex(ctx, database, CollectionHistoryAlias, collectionHistoryMappingVersion); err != nil {
+		return err
+	}
+	warnOutdatedIndices(ctx, database, CollectionHistoryAlias, collectionHistoryMappingVersion)
+	return nil
+}
+
+// RecordRevisions records writes in a history index. The last revision of
+// each document is kept under an id of its own and closed by copying it
+// under its number with the time it stopped being valid, and the new
+// revision takes its place. A delete is recorded as a revision without
+// the fields of the document.
+//
+// Revisions of a document another request recorded at the same time are
+// recorded again after it.
+func RecordRevisions(ctx context.Context, alias string, client Client, revisions []Revision) error {
+	for attempt := 1; len(revisions) > 0; attempt++ {
+		conflicts, err := recordRevisions(ctx, alias, client, revisions)
+		if err != nil {
+			return err
+		}
+		if len(conflicts) > 0 && attempt == revisionAttempts {
+			return fmt.Errorf("the history of %s changed while it was recorded", conflicts[0].openId())
+		}
+		revisions = conflicts
+	}
+	return nil
+}
+
+// recordRevisions records writes in a history index, and returns those
+// whose last revision changed since it was read.
+func recordRevisions(ctx context.Context, alias string, client Client, revisions []Revision) ([]Revision, error) {
+	mget := ES.Client.Mget().Realtime(true)
+	for _, revision := range revisions {
+		mget.Add(elastic.NewMultiGetItem().Index(alias).Id(revision.openId()))
+	}
+	current, err := mget.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Format(RevisionTimeFormat)
+	var requests []elastic.BulkableRequest
+	// the index of the request writing the last revision of each document
+	opens := make([]int, len(revisions))
+	for i, revision := range revisions {
+		doc := map[string]interface{}{}
+		if revision.Source != nil {
+			if err := json.Unmarshal(revision.Source, &doc); err != nil {
+				return nil, err
+			}
+		}
+		doc["id"] = revision.Id
+		if revision.Collection != "" {
+			doc["collection"] = revision.Collection
+		}
+		meta := map[string]interface{}{
+			"number":         1,
+			"operation":      revision.Operation,
+			"client_address": client.Address,
+			"valid_from":     now,
+		}
+		if client.Label != "" {
+			meta["client_label"] = client.Label
+		}
+		doc["revision"] = meta
+
+		open := elastic.NewBulkIndexRequest().Index(alias).Id(revision.openId()).Doc(doc)
+		if last := current.Docs[i]; last.Found {
+			var closed map[string]interface{}
+			if err := json.Unmarshal(last.Source, &closed); err != nil {
+				return nil, err
+			}
+			lastMeta, _ := closed["revision"].(map[string]interface{})
+			number, _ := lastMeta["number"].(float64)
+			if lastMeta == nil {
+				lastMeta = map[string]interface{}{}
+				closed["revision"] = lastMeta
+			}
+			lastMeta["valid_to"] = now
+			meta["number"] = int64(number) + 1
+
+			requests = append(requests, elastic.NewBulkIndexRequest().
+				Index(alias).
+				Id(fmt.Sprintf("%s@%d", revision.openId(), int64(number))).
+				Doc(closed))
+			open.IfSeqNo(*last.SeqNo).IfPrimaryTerm(*last.PrimaryTerm)
+		} else {
+			open.OpType("create")
+		}
+		opens[i] = len(requests)
+		requests = append(requests, open)
+	}
+
+	results, err := ES.Bulk.Write(ctx, requests, "false")
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		if result.Failed() && result.Status != http.StatusConflict {
+			return nil, fmt.Errorf("could not record the revision %s: %s", result.Id, result.Error)
+		}
+	}
+	var conflicts []Revision
+	for i, revision := range revisions {
+		if results[opens[i]].Status == http.StatusConflict {
+			conflicts = append(conflicts, revision)
+		}
+	}
+	return conflicts, nil
+}
+
+// RecordItemsDeleted records the deletion of the items of a collection
+// whose last revision is not a delete, as deleting the collection deletes
+// its items.
+func RecordItemsDeleted(ctx context.Context, collectionId string, client Client) error {
+	query := elastic.NewBoolQuery().
+		Filter(elastic.NewTermQuery("collection", collectionId)).
+		MustNot(elastic.NewExistsQuery("revision.valid_to")).
+		MustNot(elastic.NewTermQuery("revision.operation", "delete"))
+	scroll := ES.Client.Scroll(ItemHistoryAlias).
+		Query(query).
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Include("id")).
+		Size(1000)
+	defer scroll.Clear(context.Background())
+
+	for {
+		page, err := scroll.Do(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var revisions []Revision
+		for _, hit := range page.Hits.Hits {
+			var item struct {
+				Id string `json:"id"`
+			}
+			if err := json.Unmarshal(hit.Source, &item); err != nil {
+				return err
+			}
+			revisions = append(revisions, Revision{Id: item.Id, Collection: collectionId, Operation: "delete"})
+		}
+		if err := RecordRevisions(ctx, ItemHistoryAlias, client, revisions); err != nil {
+			return err
+		}
+	}
+}
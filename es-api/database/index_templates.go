@@ -0,0 +1,310 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// collectionsAlias is the alias the collections are read and written
+// through.
+const collectionsAlias = "collections"
+
+// The versions of the mappings below. Bump one when its mapping changes:
+// the index templates are updated at startup, and the reindex command
+// moves the documents of older indices into indices with the new mapping.
+// Indices created before mappings were versioned are version 0.
+const (
+	collectionsMappingVersion       = 1
+	itemsMappingVersion             = 2
+	itemHistoryMappingVersion       = 3
+	collectionHistoryMappingVersion = 2
+)
+
+// collectionsMapping is the mapping of the collection indices, with the
+// extents collection search matches.
+const collectionsMapping = `{
+	"properties": {
+		"id": {
+			"type": "keyword"
+		},
+		"data": {
+			"properties": {
+				"title": {
+					"type": "text",
+					"analyzer": "english",
+					"fields": {
+						"keyword": {
+							"type": "keyword",
+							"ignore_above": 256
+						}
+					}
+				},
+				"description": {
+					"type": "text",
+					"analyzer": "english",
+					"fields": {
+						"keyword": {
+							"type": "keyword",
+							"ignore_above": 8191
+						}
+					}
+				},
+				"keywords": {
+					"type": "text",
+					"analyzer": "english",
+					"fields": {
+						"keyword": {
+							"type": "keyword",
+							"ignore_above": 8191
+						}
+					}
+				},
+				"extent": {
+					"properties": {
+						"temporal": {
+							"properties": {
+								"interval": {
+									"type": "text"
+								}
+							}
+						}
+					}
+				}
+			}
+		},
+		"extent_geometry": {
+			"type": "geo_shape"
+		},
+		"extent_start": {
+			"type": "date"
+		},
+		"extent_end": {
+			"type": "date"
+		}
+	}
+}`
+
+// itemsMapping is the mapping of the item indices. Properties are indexed
+// with the types filters compare them in: numbers as doubles whichever the
+// first one indexed is, timestamps as dates, and other strings as text with
+// a keyword subfield for exact matches.
+const itemsMapping = `{
+	"dynamic_templates": [
+		{
+			"property_integers": {
+				"path_match": "properties.*",
+				"match_mapping_type": "long",
+				"mapping": {
+					"type": "double"
+				}
+			}
+		},
+		{
+			"property_numbers": {
+				"path_match": "properties.*",
+				"match_mapping_type": "double",
+				"mapping": {
+					"type": "double"
+				}
+			}
+		},
+		{
+			"property_dates": {
+				"path_match": "properties.*",
+				"match_mapping_type": "date",
+				"mapping": {
+					"type": "date"
+				}
+			}
+		},
+		{
+			"property_strings": {
+				"path_match": "properties.*",
+				"match_mapping_type": "string",
+				"mapping": {
+					"type": "text",
+					"fields": {
+						"keyword": {
+							"type": "keyword",
+							"ignore_above": 256
+						}
+					}
+				}
+			}
+		}
+	],
+	"properties": {
+		"geometry": {
+			"type": "geo_shape"
+		},
+		"id": {
+			"type": "keyword"
+		},
+		"collection": {
+			"type": "keyword"
+		},
+		"properties": {
+			"properties": {
+				"datetime": {
+					"type": "date"
+				},
+				"start_datetime": {
+					"type": "date"
+				},
+				"end_datetime": {
+					"type": "date"
+				},
+				"title": {
+					"type": "text",
+					"analyzer": "english",
+					"fields": {
+						"keyword": {
+							"type": "keyword",
+							"ignore_above": 8191
+						}
+					}
+				},
+				"description": {
+					"type": "text",
+					"analyzer": "english",
+					"fields": {
+						"keyword": {
+							"type": "keyword",
+							"ignore_above": 8191
+						}
+					}
+				},
+				"keywords": {
+					"type": "text",
+					"analyzer": "english",
+					"fields": {
+						"keyword": {
+							"type": "keyword",
+							"ignore_above": 8191
+						}
+					}
+				}
+			}
+		}
+	}
+}`
+
+// indexTemplate is a versioned index template, which gives the indices
+// matching its pattern their mapping. The mapping of a history index adds
+// the revision of each document.
+type indexTemplate struct {
+	name    string
+	pattern string
+	mapping string
+	version int
+	history bool
+}
+
+var indexTemplates = []indexTemplate{
+	{"stac-collections", collectionsAlias + "_v*", collectionsMapping, collectionsMappingVersion, false},
+	{"stac-items", itemsIndexPrefix + "*", itemsMapping, itemsMappingVersion, false},
+	{"stac-item-history", ItemHistoryAlias + "_v*", itemsMapping, itemHistoryMappingVersion, true},
+	{"stac-collection-history", CollectionHistoryAlias + "_v*", collectionsMapping, collectionHistoryMappingVersion, true},
+}
+
+// putIndexTemplates creates or updates the index templates. Existing
+// indices keep the mapping they were created with.
+func putIndexTemplates(ctx context.Context, database ESInstance) error {
+	for _, template := range indexTemplates {
+		var mapping map[string]interface{}
+		if err := json.Unmarshal([]byte(template.mapping), &mapping); err != nil {
+			return err
+		}
+		if template.history {
+			var revision interface{}
+			if err := json.Unmarshal([]byte(revisionMapping), &revision); err != nil {
+				return err
+			}
+			mapping["properties"].(map[string]interface{})["revision"] = revision
+		}
+		// the version is kept in the mapping for the indices to tell it
+		mapping["_meta"] = map[string]interface{}{"version": template.version}
+
+		body := map[string]interface{}{
+			"index_patterns": []string{template.pattern},
+			"version":        template.version,
+			"template": map[string]interface{}{
+				"mappings": mapping,
+			},
+		}
+		_, err := database.Client.IndexPutIndexTemplate(template.name).BodyJson(body).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("could not put the index template %s: %v", template.name, err)
+		}
+	}
+	return nil
+}
+
+// versionedIndex returns the name of the index with a version of the
+// mapping behind an alias.
+func versionedIndex(alias string, version int) string {
+	return fmt.Sprintf("%s_v%d", alias, version)
+}
+
+// createVersionedIndex creates the index behind an alias, and the other
+// aliases given, with the current version of its mapping if the alias
+// does not exist yet.
+func createVersionedIndex(ctx context.Context, database ESInstance, alias string, version int, aliases ...string) error {
+	exists, err := database.Client.IndexExists(alias).Do(ctx)
+	if err != nil || exists {
+		return err
+	}
+
+	body := map[string]interface{}{}
+	for _, name := range append([]string{alias}, aliases...) {
+		body[name] = map[string]interface{}{}
+	}
+	_, err = database.Client.CreateIndex(versionedIndex(alias, version)).
+		BodyJson(map[string]interface{}{"aliases": body}).
+		Do(ctx)
+	return err
+}
+
+// mappingVersion returns the version of the mapping of an index.
+func mappingVersion(index *elastic.IndicesGetResponse) int {
+	meta, _ := index.Mappings["_meta"].(map[string]interface{})
+	version, _ := meta["version"].(float64)
+	return int(version)
+}
+
+// outdatedIndices returns the indices behind a name whose mapping is older
+// than a version.
+func outdatedIndices(ctx context.Context, database ESInstance, name string, version int) ([]string, error) {
+	indices, err := database.Client.IndexGet(name).Do(ctx)
+	if elastic.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var outdated []string
+	for index, response := range indices {
+		if mappingVersion(response) < version {
+			outdated = append(outdated, index)
+		}
+	}
+	return outdated, nil
+}
+
+// warnOutdatedIndices logs the indices behind a name that a reindex would
+// give the current mapping.
+func warnOutdatedIndices(ctx context.Context, database ESInstance, name string, version int) {
+	outdated, err := outdatedIndices(ctx, database, name, version)
+	if err != nil {
+		log.Printf("Could not check the mapping of %s: %v", name, err)
+		return
+	}
+	if len(outdated) > 0 {
+		log.Printf("the mapping of %s is outdated, run the reindex command to update it", strings.Join(outdated, ", "))
+	}
+}
@@ -0,0 +1,200 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// ItemsAlias is the alias of the item indices of all collections, which
+// searches across collections read through.
+const ItemsAlias = "items"
+
+// itemsIndexPrefix starts the names of the item indices.
+const itemsIndexPrefix = "items_"
+
+// ItemsIndex returns the alias of the index holding the items of a
+// collection, which is written and read through it. Index names must be
+// lowercase and cannot hold some characters, so any other character than
+// a lowercase letter, a digit, a dash or a dot is written as _ and its hex
+// code, which keeps the indices of different collections apart.
+func ItemsIndex(collectionId string) string {
+	var sb strings.Builder
+	sb.WriteString(itemsIndexPrefix)
+	for _, b := range []byte(collectionId) {
+		if b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || b == '-' || b == '.' {
+			sb.WriteByte(b)
+		} else {
+			fmt.Fprintf(&sb, "_%02x", b)
+		}
+	}
+	return sb.String()
+}
+
+// ItemsIndices returns the indices searched for the items of collections,
+// the alias of all of them if none is given.
+func ItemsIndices(collectionIds []string) []string {
+	if len(collectionIds) == 0 {
+		return []string{ItemsAlias}
+	}
+	var indices []string
+	for _, collectionId := range collectionIds {
+		indices = append(indices, ItemsIndex(collectionId))
+	}
+	return indices
+}
+
+// CreateItemsIndex creates the index of the items of a collection, behind
+// its alias and the items alias, if it does not exist yet.
+func CreateItemsIndex(ctx context.Context, collectionId string) error {
+	return createVersionedIndex(ctx, ES, ItemsIndex(collectionId), itemsMappingVersion, ItemsAlias)
+}
+
+// DeleteItemsIndex deletes the indices of the items of a collection, and
+// so all of its items: the one behind its alias, and the versions of it a
+// reindex is filling or left behind, which the alias does not point at.
+// Another reindex would otherwise move the alias back to a version of the
+// index, bringing the items back.
+func DeleteItemsIndex(ctx context.Context, collectionId string) error {
+	alias := ItemsIndex(collectionId)
+	// no index of another collection matches the pattern, as an _ of a
+	// collection id is written as its hex code. Indices are deleted by
+	// name, as deleting them through an alias or a wildcard can be
+	// forbidden.
+	indices, err := ES.Client.IndexGet(alias, alias+"_v*").
+		IgnoreUnavailable(true).
+		AllowNoIndices(true).
+		ExpandWildcards("open,closed").
+		Do(ctx)
+	if elastic.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name := range indices {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	_, err = ES.Client.DeleteIndex(names...).Do(ctx)
+	return err
+}
+
+// listCollections returns the ids of the collections, scrolling through
+// them as there can be more than a search returns at once.
+func listCollections(ctx context.Context, database ESInstance) ([]string, error) {
+	scroll := database.Client.Scroll(collectionsAlias).
+		FetchSource(false).
+		Size(1000)
+	defer scroll.Clear(context.Background())
+
+	var collectionIds []string
+	for {
+		page, err := scroll.Do(ctx)
+		if err == io.EOF {
+			return collectionIds, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range page.Hits.Hits {
+			collectionIds = append(collectionIds, hit.Id)
+		}
+	}
+}
+
+// itemsIndexCollections returns the ids of the collections of the items in
+// the single items index earlier versions kept, a page of them at a time.
+func itemsIndexCollections(ctx context.Context, database ESInstance) ([]string, error) {
+	var collectionIds []string
+	var after map[string]interface{}
+	for {
+		collections := elastic.NewCompositeAggregation().
+			Sources(elastic.NewCompositeAggregationTermsValuesSource("collection").Field("collection")).
+			Size(1000)
+		if after != nil {
+			collections = collections.AggregateAfter(after)
+		}
+		searchResult, err := database.Client.Search(ItemsAlias).
+			Size(0).
+			Aggregation("collections", collections).
+			Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+		page, ok := searchResult.Aggregations.Composite("collections")
+		if !ok || len(page.Buckets) == 0 {
+			return collectionIds, nil
+		}
+		for _, bucket := range page.Buckets {
+			collectionIds = append(collectionIds, fmt.Sprint(bucket.Key["collection"]))
+		}
+		after = page.AfterKey
+	}
+}
+
+// migrateItemsIndex moves the items of the single items index earlier
+// versions kept into the indices of their collections, and puts the alias
+// in its place. Collections without items get an empty index.
+func migrateItemsIndex(database ESInstance) {
+	ctx := context.Background()
+
+	indices, err := database.Client.IndexGet(ItemsAlias).Do(ctx)
+	if err != nil && !elastic.IsNotFound(err) {
+		log.Fatalf("Could not contact Elasticsearch: %v", err)
+	}
+	if _, ok := indices[ItemsAlias]; ok {
+		log.Println("moving the items index into one index per collection")
+
+		collectionIds, err := itemsIndexCollections(ctx, database)
+		if err != nil {
+			log.Fatalf("Could not list the collections of the items index: %v", err)
+		}
+		for _, collectionId := range collectionIds {
+			// the items alias cannot be added while the index has its name
+			err := createVersionedIndex(ctx, database, ItemsIndex(collectionId), itemsMappingVersion)
+			if err != nil {
+				log.Fatalf("Could not create the items index of %s: %v", collectionId, err)
+			}
+			_, err = database.Client.Reindex().
+				Source(elastic.NewReindexSource().Index(ItemsAlias).Query(elastic.NewTermQuery("collection", collectionId))).
+				DestinationIndex(ItemsIndex(collectionId)).
+				Refresh("true").
+				Do(ctx)
+			if err != nil {
+				log.Fatalf("Could not move the items of %s: %v", collectionId, err)
+			}
+		}
+
+		// the items index is deleted in the same request as its name
+		// becomes the alias
+		aliases := database.Client.Alias().Action(elastic.NewAliasRemoveIndexAction(ItemsAlias))
+		for _, collectionId := range collectionIds {
+			aliases = aliases.Add(versionedIndex(ItemsIndex(collectionId), itemsMappingVersion), ItemsAlias)
+		}
+		if _, err := aliases.Do(ctx); err != nil {
+			log.Fatalf("Could not alias the item indices: %v", err)
+		}
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	collectionIds, err := listCollections(timeout, database)
+	if err != nil {
+		log.Fatalf("Could not list the collections: %v", err)
+	}
+	for _, collectionId := range collectionIds {
+		if err := createVersionedIndex(timeout, database, ItemsIndex(collectionId), itemsMappingVersion, ItemsAlias); err != nil {
+			log.Fatalf("Could not create the items index of %s: %v", collectionId, err)
+		}
+	}
+	warnOutdatedIndices(timeout, database, ItemsAlias, itemsMappingVersion)
+}
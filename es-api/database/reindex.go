@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// Reindex moves the documents of the indices whose mapping is outdated into
+// new indices with the current mapping: the collections, the history
+// indices and the items of all collections, or the items of the
+// collections given only. With force every index is reindexed.
+//
+// Reads go on through the aliases while an index is copied, and the new
+// index takes the place of the old one at once. Writes are blocked until
+// then, so that none is lost: they fail with an error WritesBlocked tells
+// apart, and must be made again once the reindex is done.
+func Reindex(ctx context.Context, collectionIds []string, force bool) error {
+	if len(collectionIds) == 0 {
+		if err := reindex(ctx, ES, collectionsAlias, collectionsMappingVersion, nil, force); err != nil {
+			return err
+		}
+		if err := reindex(ctx, ES, ItemHistoryAlias, itemHistoryMappingVersion, nil, force); err != nil {
+			return err
+		}
+		if err := reindex(ctx, ES, CollectionHistoryAlias, collectionHistoryMappingVersion, nil, force); err != nil {
+			return err
+		}
+		var err error
+		collectionIds, err = listCollections(ctx, ES)
+		if err != nil {
+			return fmt.Errorf("could not list the collections: %v", err)
+		}
+	}
+	for _, collectionId := range collectionIds {
+		err := reindex(ctx, ES, ItemsIndex(collectionId), itemsMappingVersion, []string{ItemsAlias}, force)
+		if err != nil {
+			return fmt.Errorf("could not reindex the items of %s: %v", collectionId, err)
+		}
+	}
+	return nil
+}
+
+// reindex copies the documents behind an alias into a new index with a
+// version of the mapping, and swaps the alias, and the other aliases
+// given, to it.
+func reindex(ctx context.Context, database ESInstance, alias string, version int, aliases []string, force bool) error {
+	indices, err := database.Client.IndexGet(alias).Do(ctx)
+	if err != nil {
+		return err
+	}
+	var old []string
+	current := true
+	for index, response := range indices {
+		old = append(old, index)
+		current = current && mappingVersion(response) >= version
+	}
+	if current && !force {
+		log.Printf("the mapping of %s is up to date", alias)
+		return nil
+	}
+
+	target := versionedIndex(alias, version)
+	if _, ok := indices[target]; ok {
+		target = fmt.Sprintf("%s-%d", target, time.Now().Unix())
+	}
+	log.Printf("reindexing %s into %s", alias, target)
+
+	// an index left by a reindex that failed is started over
+	if _, err := database.Client.DeleteIndex(target).Do(ctx); err != nil && !elastic.IsNotFound(err) {
+		return err
+	}
+	if _, err := database.Client.CreateIndex(target).Do(ctx); err != nil {
+		return err
+	}
+
+	if err := blockWrites(ctx, database, old, true); err != nil {
+		return err
+	}
+	response, err := database.Client.Reindex().
+		SourceIndex(alias).
+		DestinationIndex(target).
+		Refresh("true").
+		WaitForCompletion(true).
+		Do(ctx)
+	if err == nil && len(response.Failures) > 0 {
+		err = fmt.Errorf("%d documents could not be copied", len(response.Failures))
+	}
+	if err != nil {
+		blockWrites(ctx, database, old, false)
+		return err
+	}
+
+	// the old indices are deleted in the same request as the aliases move,
+	// which works for indices named as the alias too
+	actions := []elastic.AliasAction{elastic.NewAliasAddAction(alias).Index(target)}
+	for _, name := range aliases {
+		actions = append(actions, elastic.NewAliasAddAction(name).Index(target))
+	}
+	for _, index := range old {
+		actions = append(actions, elastic.NewAliasRemoveIndexAction(index))
+	}
+	if _, err := database.Client.Alias().Action(actions...).Do(ctx); err != nil {
+		blockWrites(ctx, database, old, false)
+		return err
+	}
+	log.Printf("reindexed %d documents of %s", response.Created, alias)
+	return nil
+}
+
+// blockedType is the type of the errors of writes to an index whose
+// writes are blocked.
+const blockedType = "cluster_block_exception"
+
+// WritesBlocked tells whether a write failed as writes to its index are
+// blocked, as they are while a reindex copies it.
+func WritesBlocked(err error) bool {
+	var elasticErr *elastic.Error
+	return errors.As(err, &elasticErr) && elasticErr.Details != nil && elasticErr.Details.Type == blockedType
+}
+
+// blockWrites blocks or unblocks the writes to indices.
+func blockWrites(ctx context.Context, database ESInstance, indices []string, block bool) error {
+	_, err := database.Client.IndexPutSettings(indices...).
+		BodyJson(map[string]interface{}{"index.blocks.write": block}).
+		Do(ctx)
+	return err
+}
@@ -6,8 +6,8 @@ require (
 	github.com/go-playground/validator v9.31.0+incompatible
 	github.com/gofiber/fiber/v2 v2.43.0
 	github.com/joho/godotenv v1.5.1
+	github.com/jonhealy1/goapi-stac/shared v0.0.0
 	github.com/lib/pq v1.10.7
-	github.com/olivere/elastic v6.2.37+incompatible
 	github.com/olivere/elastic/v7 v7.0.32
 	github.com/stretchr/testify v1.8.2
 	gorm.io/gorm v1.24.7-0.20230306060331-85eaf9eeda11
@@ -42,3 +42,5 @@ require (
 	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace github.com/jonhealy1/goapi-stac/shared => ../shared
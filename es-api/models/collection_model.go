@@ -26,10 +26,13 @@ func (a *JSONB) Scan(value interface{}) error {
 }
 
 type Link struct {
-	Rel   string `json:"rel,omitempty"`
-	Href  string `json:"href,omitempty"`
-	Type  string `json:"type,omitempty"`
-	Title string `json:"title,omitempty"`
+	Rel    string                 `json:"rel,omitempty"`
+	Href   string                 `json:"href,omitempty"`
+	Type   string                 `json:"type,omitempty"`
+	Title  string                 `json:"title,omitempty"`
+	Method string                 `json:"method,omitempty"`
+	Body   map[string]interface{} `json:"body,omitempty"`
+	Merge  bool                   `json:"merge,omitempty"`
 }
 
 type Spatial struct {
@@ -75,6 +78,12 @@ type Collection struct {
 	Data      JSONB      `gorm:"type:jsonb" json:"data,omitempty"`
 	CreatedAt *time.Time `json:"CreatedAt,omitempty"`
 	UpdatedAt *time.Time `json:"UpdatedAt,omitempty"`
+
+	// The overall extent of the collection, indexed for collection search.
+	// An open interval has no start or end.
+	ExtentGeometry map[string]interface{} `json:"extent_geometry,omitempty"`
+	ExtentStart    *time.Time             `json:"extent_start,omitempty"`
+	ExtentEnd      *time.Time             `json:"extent_end,omitempty"`
 }
 
 type Root struct {
@@ -2,57 +2,78 @@ package models
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jonhealy1/goapi-stac/shared/params"
 )
 
 type Search struct {
-	Ids                []string                  `json:"ids,omitempty"`
-	Collections        []string                  `json:"collections,omitempty"`
-	Limit              int                       `json:"limit,omitempty"`
-	Bbox               []float64                 `json:"bbox,omitempty"`
-	Geometry           GeoJSONGenericGeometry    `json:"geometry,omitempty"`
-	GeometryCollection GeoJSONGeometryCollection `json:"geometrycollection,omitempty"`
-	Sortby             []Sort                    `json:"sortby,omitempty"`
-}
-
-type SearchMap struct {
-	Collections int
-	Ids         int
-	Geometry    int
+	Ids         []string        `json:"ids,omitempty"`
+	Collections []string        `json:"collections,omitempty"`
+	Limit       int             `json:"limit,omitempty"`
+	Datetime    string          `json:"datetime,omitempty"`
+	Token       string          `json:"token,omitempty"`
+	Bbox        []float64       `json:"bbox,omitempty"`
+	Intersects  json.RawMessage `json:"intersects,omitempty"`
+	// Geometry is the name intersects had in earlier versions of the API.
+	Geometry json.RawMessage `json:"geometry,omitempty"`
+	Sortby   []Sort          `json:"sortby,omitempty"`
+	Fields   Fields          `json:"fields,omitempty"`
+	Q        FreeText        `json:"q,omitempty"`
+	// Query holds the comparisons of the legacy query extension, keyed by
+	// property and then by operator.
+	Query      map[string]map[string]interface{} `json:"query,omitempty"`
+	Filter     json.RawMessage                   `json:"filter,omitempty"`
+	FilterLang string                            `json:"filter-lang,omitempty"`
+	FilterCrs  string                            `json:"filter-crs,omitempty"`
+	// AsOf is the RFC 3339 timestamp of the past state of the items a
+	// search is answered from, the current state if it is empty.
+	AsOf string `json:"asof,omitempty"`
 }
 
-type Sort struct {
-	Field     string `json:"field"`
-	Direction string `json:"direction"`
+// AggregationSearch is the body of a POST aggregation request, a search
+// and the aggregations to compute over the items it matches.
+type AggregationSearch struct {
+	Search
+	Aggregations []string `json:"aggregations,omitempty"`
+	// DatetimeFrequencyInterval is the width of the buckets of the
+	// datetime_frequency aggregation.
+	DatetimeFrequencyInterval string `json:"datetime_frequency_interval,omitempty"`
 }
 
-type GeoJSONGeometryCollection struct {
-	Type       string            `json:"type"` // will always be "GeometryCollection"
-	Geometries []json.RawMessage `json:"geometries"`
+// Aggregation describes an aggregation items can be aggregated by.
+type Aggregation struct {
+	Name     string `json:"name"`
+	DataType string `json:"data_type"`
 }
 
-type GeoJSONGenericGeometry struct {
-	Type        string          `json:"type"`
-	Coordinates json.RawMessage `json:"coordinates"`
-}
+// FreeText is the q of the free-text extension. It is given as a string or
+// as a list of strings, which is read as their comma separated list.
+type FreeText string
 
-type GeoJSONPoint struct {
-	Type        string     `json:"type"`
-	Coordinates [2]float64 `json:"coordinates"`
+func (q *FreeText) UnmarshalJSON(data []byte) error {
+	var terms []string
+	if err := json.Unmarshal(data, &terms); err == nil {
+		*q = FreeText(strings.Join(terms, ","))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("q must be a string or a list of strings")
+	}
+	*q = FreeText(s)
+	return nil
 }
 
-// line or multipoint
-type GeoJSONLine struct {
-	Type        string       `json:"type"`
-	Coordinates [][2]float64 `json:"coordinates"`
-}
+// Fields lists the paths of the item JSON a search includes in or excludes
+// from its results.
+type Fields = params.Fields
 
-// polygon or multiline
-type GeoJSONPolygon struct {
-	Type        string         `json:"type"`
-	Coordinates [][][2]float64 `json:"coordinates"`
+type SearchMap struct {
+	Collections int
+	Ids         int
+	Geometry    int
 }
 
-type GeoJSONMultiPolygon struct {
-	Type        string           `json:"type"`
-	Coordinates [][][][2]float64 `json:"coordinates"`
-}
+type Sort = params.Sort
@@ -7,16 +7,21 @@ import (
 )
 
 type SearchResponse struct {
-	Status   int        `json:"status"`
-	Message  string     `json:"message"`
-	Type     string     `json:"type"`
-	Context  Context    `json:"context"`
-	Features []StacItem `json:"features"`
+	Status         int           `json:"status"`
+	Message        string        `json:"message"`
+	Type           string        `json:"type"`
+	Context        Context       `json:"context"`
+	NumberMatched  int           `json:"numberMatched"`
+	NumberReturned int           `json:"numberReturned"`
+	Features       []StacItem    `json:"features"`
+	Links          []models.Link `json:"links"`
 }
 
 type Context struct {
-	Returned int `json:"returned"`
-	Limit    int `json:"limit"`
+	Returned         int  `json:"returned"`
+	Limit            int  `json:"limit"`
+	Matched          int  `json:"matched"`
+	MatchedEstimated bool `json:"matchedEstimated"`
 }
 
 type StacItem struct {
@@ -8,9 +8,12 @@ import (
 
 func ESCollectionRoute(app *fiber.App) {
 	app.Get("/", controllers.Root)
+	app.Get("/conformance", controllers.Conformance)
 	app.Post("/collections", controllers.CreateESCollection)
 	app.Get("/collections/:collectionId", controllers.GetESCollection)
 	app.Put("/collections/:collectionId", controllers.EditESCollection)
+	app.Patch("/collections/:collectionId", controllers.PatchESCollection)
 	app.Delete("/collections/:collectionId", controllers.DeleteESCollection)
+	app.Get("/collections/:collectionId/queryables", controllers.ESCollectionQueryables)
 	app.Get("/collections", controllers.GetESCollections)
 }
@@ -9,7 +9,9 @@ import (
 func ESItemRoute(app *fiber.App) {
 	app.Post("/collections/:collectionId/items", controllers.ESCreateItem)
 	app.Get("/collections/:collectionId/items/:itemId", controllers.ESGetItem)
+	app.Get("/collections/:collectionId/items/:itemId/versions", controllers.ESGetItemVersions)
 	app.Get("/collections/:collectionId/items", controllers.ESGetItemCollection)
 	app.Put("/collections/:collectionId/items/:itemId", controllers.ESUpdateItem)
+	app.Patch("/collections/:collectionId/items/:itemId", controllers.ESPatchItem)
 	app.Delete("/collections/:collectionId/items/:itemId", controllers.ESDeleteItem)
 }
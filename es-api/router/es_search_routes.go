@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"github.com/jonhealy1/goapi-stac/es-api/controllers"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func ESSearchRoute(app *fiber.App) {
+	app.Post("/search", controllers.ESPostSearch)
+	app.Get("/search", controllers.ESGetSearch)
+	app.Get("/sortables", controllers.ESSortables)
+	app.Get("/queryables", controllers.ESQueryables)
+	app.Get("/aggregate", controllers.ESGetAggregate)
+	app.Post("/aggregate", controllers.ESPostAggregate)
+	app.Get("/aggregations", controllers.ESAggregations)
+}
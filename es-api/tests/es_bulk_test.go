@@ -0,0 +1,214 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jonhealy1/goapi-stac/es-api/database"
+	"github.com/olivere/elastic/v7"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEsBulkIndexerRetry writes documents to a cluster that rejects the
+// second one with 429 the first time, which should be retried alone.
+func TestEsBulkIndexerRetry(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := atomic.AddInt32(&calls, 1)
+		var items []string
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]struct {
+				Id string `json:"_id"`
+			}
+			json.Unmarshal(scanner.Bytes(), &action)
+			create, ok := action["create"]
+			if !ok {
+				// the document line
+				continue
+			}
+			status, result := 201, `"result": "created"`
+			if create.Id == "b" && call == 1 {
+				status, result = 429, `"error": {"type": "es_rejected_execution_exception", "reason": "rejected"}`
+			} else if create.Id == "c" {
+				status, result = 409, `"error": {"type": "version_conflict_engine_exception", "reason": "exists"}`
+			}
+			items = append(items, fmt.Sprintf(`{"create": {"_index": "i", "_id": %q, "status": %d, %s}}`, create.Id, status, result))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"took": 1, "errors": true, "items": [%s]}`, strings.Join(items, ","))
+	}))
+	defer server.Close()
+
+	client, err := elastic.NewClient(elastic.SetURL(server.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	indexer := database.NewBulkIndexer(client, database.BulkConfig{
+		Workers:       1,
+		FlushActions:  10,
+		FlushBytes:    1 << 20,
+		FlushInterval: 10 * time.Millisecond,
+		RetryInitial:  time.Millisecond,
+		RetryMax:      time.Second,
+	})
+	defer indexer.Close()
+
+	var requests []elastic.BulkableRequest
+	for _, id := range []string{"a", "b", "c"} {
+		requests = append(requests, elastic.NewBulkIndexRequest().Index("i").Id(id).OpType("create").Doc(map[string]string{"id": id}))
+	}
+	results, err := indexer.Write(context.Background(), requests, "wait_for")
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "bulk requests")
+	assert.Equal(t, []string{"a", "b", "c"}, []string{results[0].Id, results[1].Id, results[2].Id}, "order of the results")
+	assert.Equal(t, []int{201, 201, 409}, []int{results[0].Status, results[1].Status, results[2].Status}, "status of the documents")
+	assert.Equal(t, "exists", results[2].Error, "error of the conflict")
+}
+
+// TestEsBulkIndexerDefaults writes a document through an indexer whose
+// settings are not positive, which should take their defaults rather than
+// block or panic.
+func TestEsBulkIndexerDefaults(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"took": 1, "errors": false, "items": [{"index": {"_index": "i", "_id": "a", "status": 201, "result": "created"}}]}`)
+	}))
+	defer server.Close()
+
+	client, err := elastic.NewClient(elastic.SetURL(server.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	indexer := database.NewBulkIndexer(client, database.BulkConfig{Workers: 0, FlushInterval: -time.Second})
+	defer indexer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	requests := []elastic.BulkableRequest{elastic.NewBulkIndexRequest().Index("i").Id("a").Doc(map[string]string{"id": "a"})}
+	results, err := indexer.Write(ctx, requests, "false")
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	assert.Equal(t, 201, results[0].Status, "status of the document")
+}
+
+// TestEsCreateItems posts items 50 to 52 of the test data, which the other
+// tests do not load, in bulk and deletes them afterwards.
+func TestEsCreateItems(t *testing.T) {
+	b, _ := ioutil.ReadFile("setup_data/sentinel-s2-l2a-cogs_0_100.json")
+	var fc struct {
+		Features []json.RawMessage `json:"features"`
+	}
+	json.Unmarshal(b, &fc)
+	features := fc.Features[50:53:53]
+	invalid := json.RawMessage(`{"type": "Feature", "id": "no-geometry", "properties": {"datetime": "2020-01-01T00:00:00Z"}}`)
+	featureCollection, _ := json.Marshal(map[string]interface{}{"type": "FeatureCollection", "features": append(features, invalid)})
+	ndjson := bytes.Join([][]byte{features[0], features[1], features[2]}, []byte("\n"))
+	point := json.RawMessage(`{"type": "Feature", "id": "bulk-point", "geometry": {"type": "Point", "coordinates": [10, 20]}, "properties": {"datetime": "2020-01-01T00:00:00Z"}}`)
+	multiPolygon := json.RawMessage(`{"type": "Feature", "id": "bulk-multipolygon", "geometry": {"type": "MultiPolygon", "coordinates": [[[[0, 0], [1, 0], [1, 1], [0, 0]]], [[[2, 2], [3, 2], [3, 3], [2, 2]]]]}, "properties": {"datetime": "2020-01-01T00:00:00Z"}}`)
+	geometries, _ := json.Marshal(map[string]interface{}{"type": "FeatureCollection", "features": []json.RawMessage{point, multiPolygon}})
+
+	tests := []struct {
+		description      string
+		query            string
+		contentType      string
+		body             []byte
+		expectedCode     int
+		expectedStatuses []string
+	}{
+		{
+			"feature collection with an invalid item",
+			"refresh=wait_for",
+			"application/json",
+			featureCollection,
+			207,
+			[]string{"created", "created", "created", "failed"},
+		},
+		{
+			"items that exist",
+			"refresh=wait_for",
+			"application/x-ndjson",
+			ndjson,
+			207,
+			[]string{"failed", "failed", "failed"},
+		},
+		{
+			"items that exist, skipped",
+			"on_conflict=skip",
+			"application/x-ndjson",
+			ndjson,
+			201,
+			[]string{"skipped", "skipped", "skipped"},
+		},
+		{
+			"items that exist, replaced",
+			"on_conflict=upsert&refresh=true",
+			"application/x-ndjson",
+			ndjson,
+			201,
+			[]string{"updated", "updated", "updated"},
+		},
+		{
+			"items of other geometry types",
+			"refresh=wait_for",
+			"application/json",
+			geometries,
+			201,
+			[]string{"created", "created"},
+		},
+		{
+			"unknown refresh",
+			"refresh=later",
+			"application/x-ndjson",
+			ndjson,
+			400,
+			nil,
+		},
+	}
+
+	app := EsSetup()
+	LoadEsCollection()
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/collections/sentinel-s2-l2a-cogs-test/items?"+test.query, bytes.NewReader(test.body))
+		req.Header.Set("Content-Type", test.contentType)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+
+		var response struct {
+			Results []struct {
+				Status string `json:"status"`
+			} `json:"results"`
+		}
+		json.NewDecoder(resp.Body).Decode(&response)
+		var statuses []string
+		for _, result := range response.Results {
+			statuses = append(statuses, result.Status)
+		}
+		assert.Equalf(t, test.expectedStatuses, statuses, test.description)
+	}
+
+	for _, id := range []string{"S2B_1CCV_20200923_0_L2A", "S2B_1CCV_20210101_0_L2A", "S2B_1CCV_20210111_0_L2A", "bulk-point", "bulk-multipolygon"} {
+		req, _ := http.NewRequest("DELETE", "/collections/sentinel-s2-l2a-cogs-test/items/"+id, nil)
+		if _, err := app.Test(req, -1); err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+	}
+	database.ES.Client.Refresh(database.ItemsAlias).Do(context.Background())
+}
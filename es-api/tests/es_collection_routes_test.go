@@ -38,7 +38,7 @@ func EsSetup() *fiber.App {
 
 	routes.ESCollectionRoute(app)
 	routes.ESItemRoute(app)
-	//routes.SearchRoute(app)
+	routes.ESSearchRoute(app)
 
 	return app
 }
@@ -200,9 +200,12 @@ func TestEsGetAllCollections(t *testing.T) {
 		body, err := ioutil.ReadAll(res.Body)
 		assert.Nilf(t, err, test.description)
 
-		var stac_collection []models.Collection
-
-		json.Unmarshal(body, &stac_collection)
+		var collections struct {
+			Collections []models.StacCollection `json:"collections"`
+			Links       []models.Link           `json:"links"`
+		}
+		err = json.Unmarshal(body, &collections)
+		assert.Nilf(t, err, test.description)
 	}
 }
 
@@ -271,6 +274,101 @@ func TestEsEditCollection(t *testing.T) {
 	assert.Equalf(t, "1.0.0", updated_collection_response.StacVersion, "check updated stac_version")
 }
 
+func TestEsPatchCollection(t *testing.T) {
+	tests := []struct {
+		description   string
+		contentType   string
+		patch         string
+		expectedCode  int
+		expectedTitle string
+	}{
+		{
+			description:   "merge patch of the title",
+			contentType:   "application/merge-patch+json",
+			patch:         `{"title": "Patched title"}`,
+			expectedCode:  200,
+			expectedTitle: "Patched title",
+		},
+		{
+			description:   "json patch of the title",
+			contentType:   "application/json-patch+json",
+			patch:         `[{"op": "test", "path": "/title", "value": "Patched title"}, {"op": "replace", "path": "/title", "value": "Patched again"}]`,
+			expectedCode:  200,
+			expectedTitle: "Patched again",
+		},
+		{
+			description:  "patch changing the id",
+			contentType:  "application/merge-patch+json",
+			patch:        `{"id": "another-id"}`,
+			expectedCode: 422,
+		},
+		{
+			description:  "malformed merge patch",
+			contentType:  "application/merge-patch+json",
+			patch:        `{"title": `,
+			expectedCode: 400,
+		},
+	}
+
+	app := EsSetup()
+	for _, test := range tests {
+		req, _ := http.NewRequest(http.MethodPatch, "/collections/sentinel-s2-l2a-cogs-test-2", bytes.NewBufferString(test.patch))
+		req.Header.Set("Content-Type", test.contentType)
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+
+		if test.expectedTitle != "" {
+			body, _ := ioutil.ReadAll(resp.Body)
+			var patched struct {
+				StacCollection models.StacCollection `json:"stac_collection"`
+			}
+			json.Unmarshal(body, &patched)
+			assert.Equalf(t, test.expectedTitle, patched.StacCollection.Title, test.description)
+		}
+	}
+}
+
+func TestEsCollectionETag(t *testing.T) {
+	route := "/collections/sentinel-s2-l2a-cogs-test-2"
+	app := EsSetup()
+
+	req, _ := http.NewRequest(http.MethodGet, route, nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	assert.Equalf(t, 200, resp.StatusCode, "get collection")
+	etag := resp.Header.Get("ETag")
+	assert.NotEmptyf(t, etag, "get collection")
+
+	req, _ = http.NewRequest(http.MethodGet, route, nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, _ = app.Test(req, -1)
+	assert.Equalf(t, 304, resp.StatusCode, "get collection of a known version")
+
+	req, _ = http.NewRequest(http.MethodPatch, route, bytes.NewBufferString(`{"title": "Conditional title"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"0-0"`)
+	resp, _ = app.Test(req, -1)
+	assert.Equalf(t, 412, resp.StatusCode, "patch of another version")
+
+	req, _ = http.NewRequest(http.MethodPatch, route, bytes.NewBufferString(`{"title": "Conditional title"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", etag)
+	resp, _ = app.Test(req, -1)
+	assert.Equalf(t, 200, resp.StatusCode, "patch of the current version")
+	assert.NotEqualf(t, etag, resp.Header.Get("ETag"), "patch of the current version")
+
+	req, _ = http.NewRequest(http.MethodDelete, route, nil)
+	req.Header.Set("If-Match", etag)
+	resp, _ = app.Test(req, -1)
+	assert.Equalf(t, 412, resp.StatusCode, "delete of a version patched since")
+}
+
 func TestEsDeleteCollection(t *testing.T) {
 	app := EsSetup()
 
@@ -302,3 +400,19 @@ func TestEsDeleteCollection(t *testing.T) {
 
 	assert.Equalf(t, "success", collection_response.Message, "delete collection")
 }
+
+func TestEsConformance(t *testing.T) {
+	app := EsSetup()
+
+	req, _ := http.NewRequest("GET", "/conformance", nil)
+	res, err := app.Test(req, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+
+	body, _ := ioutil.ReadAll(res.Body)
+	var conformance struct {
+		ConformsTo []string `json:"conformsTo"`
+	}
+	json.Unmarshal(body, &conformance)
+	assert.Contains(t, conformance.ConformsTo, "http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/core")
+}
@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jonhealy1/goapi-stac/es-api/cql2es"
+	"github.com/jonhealy1/goapi-stac/es-api/responses"
+	"github.com/jonhealy1/goapi-stac/shared/cql2"
+)
+
+func TestCql2JSONToES(t *testing.T) {
+	tests := []struct {
+		filter   string
+		expected string
+	}{
+		{
+			`{"op": "=", "args": [{"property": "platform"}, "sentinel-2b"]}`,
+			`{"term":{"properties.platform.keyword":"sentinel-2b"}}`,
+		},
+		{
+			`{"op": "<", "args": [{"property": "properties.eo:cloud_cover"}, 10]}`,
+			`{"range":{"properties.eo:cloud_cover":{"from":null,"include_lower":true,"include_upper":false,"to":10}}}`,
+		},
+		{
+			`{"op": ">", "args": [10, {"property": "eo:cloud_cover"}]}`,
+			`{"range":{"properties.eo:cloud_cover":{"from":null,"include_lower":true,"include_upper":false,"to":10}}}`,
+		},
+		{
+			`{"op": "and", "args": [
+				{"op": "in", "args": [{"property": "collection"}, ["a", "b"]]},
+				{"op": "not", "args": [{"op": "like", "args": [{"property": "id"}, "S2A%"]}]}
+			]}`,
+			`{"bool":{"filter":[{"terms":{"collection":["a","b"]}},{"bool":{"must_not":{"wildcard":{"id":{"value":"S2A*"}}}}}]}}`,
+		},
+		{
+			`{"op": "=", "args": [{"property": "title"}, "Flooded fields"]}`,
+			`{"term":{"properties.title.keyword":"Flooded fields"}}`,
+		},
+		{
+			`{"op": "between", "args": [{"property": "gsd"}, 5, 20]}`,
+			`{"range":{"properties.gsd":{"from":5,"include_lower":true,"include_upper":true,"to":20}}}`,
+		},
+		{
+			`{"op": "s_intersects", "args": [{"property": "geometry"}, {"bbox": [1, 2, 3, 4]}]}`,
+			`{"geo_shape":{"geometry":{"relation":"intersects","shape":{"coordinates":[[1,4],[3,2]],"type":"envelope"}}}}`,
+		},
+		{
+			`{"op": "s_within", "args": [{"type": "Point", "coordinates": [1, 2]}, {"property": "geometry"}]}`,
+			`{"geo_shape":{"geometry":{"relation":"contains","shape":{"type":"Point","coordinates":[1,2]}}}}`,
+		},
+		{
+			`{"op": "t_before", "args": [{"property": "updated"}, {"timestamp": "2020-01-01T00:00:00Z"}]}`,
+			`{"bool":{"filter":{"range":{"properties.updated":{"from":null,"include_lower":true,"include_upper":false,"to":"2020-01-01T00:00:00Z"}}}}}`,
+		},
+		{
+			`{"op": "t_after", "args": [{"property": "datetime"}, {"interval": ["2020-01-01", ".."]}]}`,
+			`{"bool":{"filter":{"match_none":{}}}}`,
+		},
+		{
+			`{"op": "isNull", "args": [{"property": "sentinel:sequence"}]}`,
+			`{"bool":{"must_not":{"exists":{"field":"properties.sentinel:sequence"}}}}`,
+		},
+	}
+	for _, test := range tests {
+		expr, err := cql2.ParseJSON([]byte(test.filter))
+		if err != nil {
+			t.Fatalf("Unexpected error parsing %s: %v", test.filter, err)
+		}
+		query, err := cql2es.ToES(expr)
+		if err != nil {
+			t.Fatalf("Unexpected error translating %s: %v", test.filter, err)
+		}
+		source, _ := query.Source()
+		result, _ := json.Marshal(source)
+		if string(result) != test.expected {
+			t.Errorf("Expected %s but got %s", test.expected, result)
+		}
+	}
+}
+
+func TestCql2ToESUnsupported(t *testing.T) {
+	filters := []string{
+		`{"op": "s_touches", "args": [{"property": "geometry"}, {"bbox": [1, 2, 3, 4]}]}`,
+		`{"op": "t_before", "args": [{"property": "datetime"}, {"property": "updated"}]}`,
+		`{"op": "=", "args": [{"property": "datetime"}, 10]}`,
+	}
+	for _, filter := range filters {
+		expr, err := cql2.ParseJSON([]byte(filter))
+		if err != nil {
+			t.Fatalf("Unexpected error parsing %s: %v", filter, err)
+		}
+		if _, err := cql2es.ToES(expr); err == nil {
+			t.Errorf("Expected an error translating %s", filter)
+		}
+	}
+}
+
+// TestEsCql2FilterOnTitle filters on the title and description of an item,
+// which are analyzed text and compared by their keyword subfield.
+func TestEsCql2FilterOnTitle(t *testing.T) {
+	app := EsSetup()
+	LoadEsCollection()
+
+	item := `{"type": "Feature", "id": "titled-item", "geometry": {"type": "Point", "coordinates": [10, 20]},
+		"properties": {"datetime": "2020-01-01T00:00:00Z", "title": "Flooded fields", "description": "Fields flooded in spring", "keywords": ["flood"]}}`
+	req, _ := http.NewRequest("POST", "/collections/sentinel-s2-l2a-cogs-test/items?refresh=wait_for", bytes.NewReader([]byte(item)))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected status code 201 creating the item, but got %d", resp.StatusCode)
+	}
+
+	// the sample the queryables are found in may miss the item
+	overrides := filepath.Join(t.TempDir(), "overrides.json")
+	ioutil.WriteFile(overrides, []byte(`{"pin": {"title": {"type": "string"}, "description": {"type": "string"}}}`), 0644)
+	t.Setenv("QUERYABLES_OVERRIDES", overrides)
+
+	filters := []string{
+		"title = 'Flooded fields'",
+		"title LIKE 'Flooded%'",
+		"title IN ('Flooded fields', 'Dry fields')",
+		"description = 'Fields flooded in spring'",
+	}
+	for _, filter := range filters {
+		req, _ := http.NewRequest("GET", "/search?ids=titled-item&filter="+url.QueryEscape(filter), nil)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+		var searchResponse responses.SearchResponse
+		json.NewDecoder(resp.Body).Decode(&searchResponse)
+		if resp.StatusCode != 200 || len(searchResponse.Features) != 1 {
+			t.Errorf("%s: expected the item, but got status %d and %d items", filter, resp.StatusCode, len(searchResponse.Features))
+		}
+	}
+
+	req, _ = http.NewRequest("DELETE", "/collections/sentinel-s2-l2a-cogs-test/items/titled-item", nil)
+	if _, err := app.Test(req, -1); err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+}
+
+func TestEsQueryables(t *testing.T) {
+	app := EsSetup()
+	LoadEsCollection()
+
+	getQueryables := func(path string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest("GET", path, nil)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+		defer resp.Body.Close()
+		var body struct {
+			Properties map[string]interface{} `json:"properties"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body.Properties
+	}
+
+	for _, path := range []string{"/queryables", "/collections/sentinel-s2-l2a-cogs-test/queryables"} {
+		status, properties := getQueryables(path)
+		if status != 200 {
+			t.Fatalf("%s: expected status code 200, but got %d", path, status)
+		}
+		if _, ok := properties["datetime"]; !ok {
+			t.Errorf("%s: expected queryable datetime in %v", path, properties)
+		}
+	}
+
+	if status, _ := getQueryables("/collections/does-not-exist/queryables"); status != 404 {
+		t.Errorf("Expected status code 404, but got %d", status)
+	}
+}
+
+func TestEsSearchFilterUnknownQueryable(t *testing.T) {
+	app := EsSetup()
+
+	search := func(filter string) (int, string) {
+		req, _ := http.NewRequest("GET", "/search?filter="+url.QueryEscape(filter), nil)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if status, body := search("not_a_property = 'foo'"); status != 400 || !strings.Contains(body, "not_a_property") {
+		t.Errorf("Expected status code 400 naming the unknown queryable, but got %d: %s", status, body)
+	}
+
+	// lenient overrides let filters use properties no item of the sample
+	// has, but not those hidden
+	overrides := filepath.Join(t.TempDir(), "lenient.json")
+	ioutil.WriteFile(overrides, []byte(`{"lenient": true, "hide": ["platform"]}`), 0644)
+	t.Setenv("QUERYABLES_OVERRIDES", overrides)
+	if status, body := search("not_a_property = 'foo'"); status != 200 {
+		t.Errorf("Expected status code 200, but got %d: %s", status, body)
+	}
+	if status, body := search("platform = 'sentinel-2b'"); status != 400 || !strings.Contains(body, "platform") {
+		t.Errorf("Expected status code 400 naming the hidden queryable, but got %d: %s", status, body)
+	}
+}
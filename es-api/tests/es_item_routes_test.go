@@ -0,0 +1,272 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jonhealy1/goapi-stac/es-api/database"
+	"github.com/jonhealy1/goapi-stac/es-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEsItemsIndex(t *testing.T) {
+	tests := map[string]string{
+		"sentinel-s2-l2a-cogs-test": "items_sentinel-s2-l2a-cogs-test",
+		"Landsat_C2":                "items__4candsat_5f_432",
+		"a/b":                       "items_a_2fb",
+	}
+	for collectionId, expected := range tests {
+		assert.Equalf(t, expected, database.ItemsIndex(collectionId), "items index of %s", collectionId)
+	}
+}
+
+// TestEsItemInTwoCollections stores an item of the same id in two
+// collections, and deletes it with one of them.
+func TestEsItemInTwoCollections(t *testing.T) {
+	app := EsSetup()
+	LoadEsCollection()
+	LoadEsItems()
+
+	get := func(collectionId string) int {
+		req, _ := http.NewRequest("GET", "/collections/"+collectionId+"/items/S2B_1CCV_20181004_0_L2A", nil)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	var collection map[string]interface{}
+	b, _ := os.ReadFile("setup_data/collection.json")
+	json.Unmarshal(b, &collection)
+	collection["id"] = "sentinel-s2-l2a-cogs-test-3"
+	body, _ := json.Marshal(collection)
+	req, _ := http.NewRequest("POST", "/collections", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if _, err := app.Test(req, -1); err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+
+	item, _ := os.ReadFile("setup_data/S2B_1CCV_20181004_0_L2A-test.json")
+	req, _ = http.NewRequest("POST", "/collections/sentinel-s2-l2a-cogs-test-3/items", bytes.NewReader(item))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	assert.Equalf(t, 201, resp.StatusCode, "create item in the second collection")
+
+	assert.Equalf(t, 200, get("sentinel-s2-l2a-cogs-test"), "get item of the first collection")
+	assert.Equalf(t, 200, get("sentinel-s2-l2a-cogs-test-3"), "get item of the second collection")
+
+	req, _ = http.NewRequest("DELETE", "/collections/sentinel-s2-l2a-cogs-test-3", nil)
+	resp, err = app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	assert.Equalf(t, 200, resp.StatusCode, "delete collection")
+
+	assert.Equalf(t, 200, get("sentinel-s2-l2a-cogs-test"), "get item of the remaining collection")
+	assert.Equalf(t, 404, get("sentinel-s2-l2a-cogs-test-3"), "get item of the deleted collection")
+}
+
+func TestEsPatchItem(t *testing.T) {
+	route := "/collections/sentinel-s2-l2a-cogs-test/items/S2B_1CCV_20181004_0_L2A"
+	tests := []struct {
+		description  string
+		route        string
+		contentType  string
+		patch        string
+		expectedCode int
+		// expectedBbox is the bbox of the patched item, if set
+		expectedBbox []float64
+	}{
+		{
+			description:  "merge patch of a property",
+			route:        route,
+			contentType:  "application/merge-patch+json",
+			patch:        `{"properties": {"eo:cloud_cover": 12.5}}`,
+			expectedCode: 200,
+		},
+		{
+			description:  "json patch of the geometry",
+			route:        route,
+			contentType:  "application/json-patch+json",
+			patch:        `[{"op": "replace", "path": "/geometry/coordinates", "value": [[[-177, -89], [-170, -89], [-170, -80], [-177, -80], [-177, -89]]]}]`,
+			expectedCode: 200,
+			expectedBbox: []float64{-177, -89, -170, -80},
+		},
+		{
+			description:  "patch moving the item to another collection",
+			route:        route,
+			contentType:  "application/merge-patch+json",
+			patch:        `{"collection": "sentinel-s2-l2a-cogs-test-3"}`,
+			expectedCode: 422,
+		},
+		{
+			description:  "json patch failing its test",
+			route:        route,
+			contentType:  "application/json-patch+json",
+			patch:        `[{"op": "test", "path": "/properties/eo:cloud_cover", "value": 99}]`,
+			expectedCode: 409,
+		},
+		{
+			description:  "patch of another media type",
+			route:        route,
+			contentType:  "text/plain",
+			patch:        `{}`,
+			expectedCode: 415,
+		},
+		{
+			description:  "patch of an item that does not exist",
+			route:        route + "-x",
+			contentType:  "application/merge-patch+json",
+			patch:        `{}`,
+			expectedCode: 404,
+		},
+	}
+
+	app := EsSetup()
+	LoadEsCollection()
+	LoadEsItems()
+
+	for _, test := range tests {
+		req, _ := http.NewRequest(http.MethodPatch, test.route, bytes.NewBufferString(test.patch))
+		req.Header.Set("Content-Type", test.contentType)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+
+		if test.expectedBbox != nil {
+			var patched struct {
+				StacItem models.StacItem `json:"stac_item"`
+			}
+			json.NewDecoder(resp.Body).Decode(&patched)
+			assert.Equalf(t, test.expectedBbox, []float64(patched.StacItem.Bbox), test.description)
+		}
+	}
+
+	// the items the other tests expect
+	LoadEsItems()
+}
+
+func TestEsItemETag(t *testing.T) {
+	route := "/collections/sentinel-s2-l2a-cogs-test/items/S2B_1CCV_20181004_0_L2A"
+	app := EsSetup()
+	LoadEsCollection()
+	LoadEsItems()
+
+	request := func(method string, header string, etag string, body string) *http.Response {
+		req, _ := http.NewRequest(method, route, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		if header != "" {
+			req.Header.Set(header, etag)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+		return resp
+	}
+
+	resp := request(http.MethodGet, "", "", "")
+	assert.Equalf(t, 200, resp.StatusCode, "get item")
+	etag := resp.Header.Get("ETag")
+	assert.NotEmptyf(t, etag, "get item")
+	assert.NotContainsf(t, etag, "W/", "get item")
+
+	resp = request(http.MethodGet, "If-None-Match", etag, "")
+	assert.Equalf(t, 304, resp.StatusCode, "get item of a known version")
+
+	resp = request(http.MethodPatch, "If-Match", `"0-0"`, `{"properties": {"eo:cloud_cover": 3}}`)
+	assert.Equalf(t, 412, resp.StatusCode, "patch of another version")
+
+	resp = request(http.MethodPatch, "If-Match", etag, `{"properties": {"eo:cloud_cover": 3}}`)
+	assert.Equalf(t, 200, resp.StatusCode, "patch of the current version")
+	patched := resp.Header.Get("ETag")
+	assert.NotEqualf(t, etag, patched, "patch of the current version")
+
+	// the version read first is lost to the patch
+	resp = request(http.MethodPatch, "If-Match", etag, `{"properties": {"eo:cloud_cover": 4}}`)
+	assert.Equalf(t, 412, resp.StatusCode, "patch of a version patched since")
+	resp = request(http.MethodDelete, "If-Match", etag, "")
+	assert.Equalf(t, 412, resp.StatusCode, "delete of a version patched since")
+
+	resp = request(http.MethodGet, "If-None-Match", etag, "")
+	assert.Equalf(t, 200, resp.StatusCode, "get item of an old version")
+	assert.Equalf(t, patched, resp.Header.Get("ETag"), "get item of an old version")
+
+	// the items the other tests expect
+	LoadEsItems()
+}
+
+// TestEsItemVersions patches an item, and reads its versions and the item
+// as it was before and after.
+func TestEsItemVersions(t *testing.T) {
+	route := "/collections/sentinel-s2-l2a-cogs-test/items/S2B_1CCV_20181004_0_L2A"
+	app := EsSetup()
+	LoadEsCollection()
+	LoadEsItems()
+
+	request := func(method string, route string, body string) *http.Response {
+		req, _ := http.NewRequest(method, route, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set("X-Client-Label", "reprocessing")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+		return resp
+	}
+
+	before := time.Now().UTC()
+	resp := request(http.MethodPatch, route, `{"properties": {"eo:cloud_cover": 7}}`)
+	assert.Equalf(t, 200, resp.StatusCode, "patch item")
+	database.ES.Client.Refresh(database.ItemHistoryAlias).Do(context.Background())
+
+	resp = request(http.MethodGet, route+"/versions", "")
+	assert.Equalf(t, 200, resp.StatusCode, "get the versions of the item")
+	var versions struct {
+		Versions []struct {
+			Revision    int64  `json:"revision"`
+			Operation   string `json:"operation"`
+			ClientLabel string `json:"client_label"`
+		} `json:"versions"`
+	}
+	json.NewDecoder(resp.Body).Decode(&versions)
+	if assert.NotEmptyf(t, versions.Versions, "get the versions of the item") {
+		last := versions.Versions[len(versions.Versions)-1]
+		assert.Equalf(t, "update", last.Operation, "last version of the item")
+		assert.Equalf(t, "reprocessing", last.ClientLabel, "last version of the item")
+	}
+
+	resp = request(http.MethodGet, route+"?asof="+time.Now().UTC().Format(time.RFC3339Nano), "")
+	assert.Equalf(t, 200, resp.StatusCode, "get the item as it is")
+	var item models.StacItem
+	json.NewDecoder(resp.Body).Decode(&item)
+	properties, _ := item.Properties.(map[string]interface{})
+	assert.Equalf(t, 7.0, properties["eo:cloud_cover"], "get the item as it is")
+
+	resp = request(http.MethodGet, route+"?asof="+before.AddDate(-20, 0, 0).Format(time.RFC3339), "")
+	assert.Equalf(t, 404, resp.StatusCode, "get the item before it existed")
+	resp = request(http.MethodGet, route+"?asof=yesterday", "")
+	assert.Equalf(t, 400, resp.StatusCode, "get the item at an invalid time")
+
+	resp = request(http.MethodGet, "/search?ids=S2B_1CCV_20181004_0_L2A&asof="+time.Now().UTC().Format(time.RFC3339Nano), "")
+	assert.Equalf(t, 200, resp.StatusCode, "search the items as they are")
+	var search struct {
+		Features []json.RawMessage `json:"features"`
+	}
+	json.NewDecoder(resp.Body).Decode(&search)
+	assert.Lenf(t, search.Features, 1, "search the items as they are")
+
+	// the items the other tests expect
+	LoadEsItems()
+}
@@ -0,0 +1,58 @@
+package tests
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jonhealy1/goapi-stac/es-api/responses"
+)
+
+// TestEsPageThroughCollection follows the next links of an item listing
+// until the last page, which should visit every item exactly once. With
+// PIT_KEEP_ALIVE set the pages are read from a point in time.
+func TestEsPageThroughCollection(t *testing.T) {
+	app := EsSetup()
+	LoadEsCollection()
+	LoadEsItems()
+
+	seen := map[string]bool{}
+	path := "/collections/sentinel-s2-l2a-cogs-test/items?limit=7"
+	for pages := 0; path != ""; pages++ {
+		if pages > 10 {
+			t.Fatalf("expected to reach the last page")
+		}
+		req, _ := http.NewRequest("GET", path, nil)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+		if resp.StatusCode != 200 {
+			t.Fatalf("expected status code 200, but got %d", resp.StatusCode)
+		}
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		var page responses.SearchResponse
+		json.Unmarshal(body, &page)
+
+		for _, feature := range page.Features {
+			if seen[feature.Id] {
+				t.Errorf("item %s was returned twice", feature.Id)
+			}
+			seen[feature.Id] = true
+		}
+
+		path = ""
+		for _, link := range page.Links {
+			if link.Rel == "next" {
+				path = link.Href[strings.Index(link.Href, "/collections/"):]
+			}
+		}
+	}
+
+	if len(seen) != 50 {
+		t.Errorf("expected 50 items, but got %d", len(seen))
+	}
+}
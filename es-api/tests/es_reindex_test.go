@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/jonhealy1/goapi-stac/es-api/database"
+)
+
+// TestEsReindex forces a reindex of the items of the test collection, which
+// should move them into a new index behind the same alias.
+func TestEsReindex(t *testing.T) {
+	EsSetup()
+	LoadEsCollection()
+	LoadEsItems()
+
+	ctx := context.Background()
+	alias := database.ItemsIndex("sentinel-s2-l2a-cogs-test")
+	before, err := database.ES.Client.IndexGet(alias).Do(ctx)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	count, err := database.ES.Client.Count(alias).Do(ctx)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+
+	if err := database.Reindex(ctx, []string{"sentinel-s2-l2a-cogs-test"}, true); err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+
+	after, err := database.ES.Client.IndexGet(alias).Do(ctx)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	if len(after) != 1 {
+		t.Fatalf("expected one index behind %s, but got %d", alias, len(after))
+	}
+	for index := range after {
+		if _, ok := before[index]; ok {
+			t.Errorf("expected a new index behind %s, but got %s", alias, index)
+		}
+	}
+
+	reindexed, err := database.ES.Client.Count(alias).Do(ctx)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	if reindexed != count {
+		t.Errorf("expected %d items after the reindex, but got %d", count, reindexed)
+	}
+	all, err := database.ES.Client.Count(database.ItemsAlias).Do(ctx)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	if all < count {
+		t.Errorf("expected the items alias to hold the reindexed items")
+	}
+}
+
+// TestEsWritesBlocked blocks the writes to the items of the test collection,
+// as a reindex does while it copies them, which should answer writes with
+// 503 and a Retry-After.
+func TestEsWritesBlocked(t *testing.T) {
+	app := EsSetup()
+	LoadEsCollection()
+
+	ctx := context.Background()
+	alias := database.ItemsIndex("sentinel-s2-l2a-cogs-test")
+	block := func(blocked bool) {
+		_, err := database.ES.Client.IndexPutSettings(alias).
+			BodyJson(map[string]interface{}{"index.blocks.write": blocked}).
+			Do(ctx)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+	}
+	block(true)
+	defer block(false)
+
+	item := `{"type": "Feature", "id": "blocked-item", "geometry": {"type": "Point", "coordinates": [10, 20]},
+		"properties": {"datetime": "2020-01-01T00:00:00Z"}}`
+	for _, contentType := range []string{"application/json", "application/x-ndjson"} {
+		req, _ := http.NewRequest("POST", "/collections/sentinel-s2-l2a-cogs-test/items", bytes.NewReader([]byte(item)))
+		req.Header.Set("Content-Type", contentType)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+		expected := http.StatusServiceUnavailable
+		if contentType == "application/x-ndjson" {
+			// the items of a bulk request are reported one by one
+			expected = http.StatusMultiStatus
+		}
+		if resp.StatusCode != expected {
+			t.Errorf("%s: expected status code %d, but got %d", contentType, expected, resp.StatusCode)
+		}
+		if resp.Header.Get("Retry-After") == "" {
+			t.Errorf("%s: expected a Retry-After header", contentType)
+		}
+	}
+}
@@ -41,6 +41,10 @@ func LoadEsCollection() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	if err := database.CreateItemsIndex(ctx, collection.Id); err != nil {
+		return fmt.Errorf("Could not create the items index: %v", err)
+	}
+
 	_, err = database.ES.Client.Get().
 		Index(indexName).
 		Id(collection.Id).
@@ -79,9 +83,10 @@ func LoadEsItems() {
 	var itemCollection models.ItemCollection
 	json.Unmarshal(byteValue, &itemCollection)
 
-	for _, item := range itemCollection.Features {
+	// the first 50 items, which pg-api loads too
+	for _, item := range itemCollection.Features[:50] {
 		put, err := database.ES.Client.Index().
-			Index("items").
+			Index(database.ItemsIndex(item.Collection)).
 			Id(item.Id).
 			BodyJson(item).
 			Do(context.Background())
@@ -90,4 +95,9 @@ func LoadEsItems() {
 		}
 		fmt.Printf("Indexed item %s to index %s, type %s\n", put.Id, put.Index, put.Type)
 	}
+
+	// make the items visible to searches
+	if _, err := database.ES.Client.Refresh(database.ItemsAlias).Do(context.Background()); err != nil {
+		panic(err)
+	}
 }
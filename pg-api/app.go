@@ -36,8 +36,17 @@ func Setup() *fiber.App {
 	// connect to database: postgres
 	database.ConnectDb()
 
-	// create new fiber app
-	app := fiber.New()
+	// create new fiber app, whose body limit fits bulk item requests
+	bodyLimit := 64 * 1024 * 1024
+	if value, exists := os.LookupEnv("BODY_LIMIT"); exists {
+		// fiber takes a limit that is not positive for its default of 4MB
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			log.Fatalf("BODY_LIMIT must be a positive number of bytes, not %q", value)
+		}
+		bodyLimit = limit
+	}
+	app := fiber.New(fiber.Config{BodyLimit: bodyLimit})
 
 	// register middleware
 	app.Use(cors.New())
@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jonhealy1/goapi-stac/pg-api/database"
+	"github.com/jonhealy1/goapi-stac/pg-api/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxBuckets is the number of buckets a frequency distribution returns at
+// most. The items of the buckets left out are counted in its overflow.
+const maxBuckets = 1000
+
+// aggregation is an aggregation of the items matching a search. Without a
+// key it counts them, otherwise it counts them per value of key.
+type aggregation struct {
+	dataType string
+	// key is the SQL expression of the bucket an item falls in. Items for
+	// which it is NULL fall in none.
+	key string
+	// keyType is the data type of bucket keys.
+	keyType string
+	// byKey orders buckets by their key instead of most frequent first.
+	byKey bool
+	// width makes the buckets numeric ranges of that width, keyed by their
+	// lower bound.
+	width float64
+}
+
+// datetimeIntervals are the datetime_frequency_interval values, the fields
+// datetimes are truncated to.
+var datetimeIntervals = map[string]bool{"year": true, "month": true, "day": true, "hour": true}
+
+// aggregations lists the aggregations /aggregate computes.
+var aggregations = map[string]aggregation{
+	"total_count": {dataType: "integer"},
+	"collection_frequency": {
+		dataType: "frequency_distribution",
+		key:      "items.collection",
+		keyType:  "string",
+	},
+	"platform_frequency": {
+		dataType: "frequency_distribution",
+		key:      sortables["properties.platform"].key.Expr,
+		keyType:  "string",
+	},
+	"constellation_frequency": {
+		dataType: "frequency_distribution",
+		key:      sortables["properties.constellation"].key.Expr,
+		keyType:  "string",
+	},
+	"datetime_frequency": {
+		dataType: "frequency_distribution",
+		// the interval is one of datetimeIntervals
+		key:     "date_trunc('%s', items.datetime AT TIME ZONE 'UTC')",
+		keyType: "datetime",
+		byKey:   true,
+	},
+	"cloud_cover_frequency": {
+		dataType: "frequency_distribution",
+		// 100% cloud cover falls in the last bucket
+		key:     fmt.Sprintf("LEAST(floor(%s / 10), 9) * 10", numberProperty("eo:cloud_cover")),
+		keyType: "numeric",
+		byKey:   true,
+		width:   10,
+	},
+}
+
+// aggregationRow is a bucket of a frequency distribution.
+type aggregationRow struct {
+	Key       string
+	Frequency int
+}
+
+// keySQL returns the expression of the bucket keys as text.
+func (a aggregation) keySQL(interval string) string {
+	switch a.keyType {
+	case "datetime":
+		return fmt.Sprintf(`to_char(%s, 'YYYY-MM-DD"T"HH24:MI:SS"Z"')`, fmt.Sprintf(a.key, interval))
+	case "numeric":
+		return fmt.Sprintf("(%s)::float8::text", a.key)
+	}
+	return a.key
+}
+
+// aggregate computes an aggregation of the items matching query.
+func (a aggregation) aggregate(name string, query *SearchQuery, interval string) (fiber.Map, error) {
+	result := fiber.Map{"name": name, "data_type": a.dataType}
+
+	if a.key == "" {
+		from, args := query.fromSQL(false)
+		var count int
+		if err := database.DB.Db.Raw("SELECT count(*)"+from, args...).Row().Scan(&count); err != nil {
+			return nil, err
+		}
+		result["value"] = count
+		return result, nil
+	}
+
+	key := a.key
+	if a.keyType == "datetime" {
+		key = fmt.Sprintf(a.key, interval)
+	}
+	order := "count(*) DESC, " + key
+	if a.byKey {
+		order = key
+	}
+	keyed := *query
+	keyed.conditions = append(append([]string{}, query.conditions...), key+" IS NOT NULL")
+	from, args := keyed.fromSQL(false)
+	sql := fmt.Sprintf("SELECT %s AS key, count(*) AS frequency%s GROUP BY %s ORDER BY %s",
+		a.keySQL(interval), from, key, order)
+
+	var rows []aggregationRow
+	if err := database.DB.Db.Raw(sql, args...).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	overflow := 0
+	buckets := []fiber.Map{}
+	for i, row := range rows {
+		if i >= maxBuckets {
+			overflow += row.Frequency
+			continue
+		}
+		bucket := fiber.Map{"key": row.Key, "data_type": a.keyType, "frequency": row.Frequency}
+		if a.width > 0 {
+			lower, _ := strconv.ParseFloat(row.Key, 64)
+			bucket["from"], bucket["to"] = lower, lower+a.width
+		}
+		buckets = append(buckets, bucket)
+	}
+	result["overflow"] = overflow
+	result["buckets"] = buckets
+	return result, nil
+}
+
+// Aggregations godoc
+// @Summary Get the aggregations
+// @Description Get the aggregations /aggregate can compute
+// @Tags Search
+// @ID get-aggregations
+// @Produce  json
+// @Router /aggregations [get]
+func Aggregations(c *fiber.Ctx) error {
+	var names []string
+	for name := range aggregations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	available := []models.Aggregation{}
+	for _, name := range names {
+		available = append(available, models.Aggregation{Name: name, DataType: aggregations[name].dataType})
+	}
+
+	return c.Status(http.StatusOK).JSON(&fiber.Map{
+		"aggregations": available,
+		"links": []models.Link{
+			{Rel: "root", Type: "application/json", Href: c.BaseURL() + "/"},
+			{Rel: "self", Type: "application/json", Href: c.BaseURL() + "/aggregations"},
+		},
+	})
+}
+
+// GetAggregate godoc
+// @Summary GET Aggregate request
+// @Description Aggregate the STAC items matching a search
+// @Tags Search
+// @ID get-aggregate
+// @Produce  json
+// @Param aggregations query string false "Comma separated aggregations, see /aggregations"
+// @Param datetime_frequency_interval query string false "year, month (default), day or hour"
+// @Router /aggregate [get]
+func GetAggregate(c *fiber.Ctx) error {
+	search, err := getSearchParams(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	request := models.AggregationSearch{
+		Search:                    search,
+		DatetimeFrequencyInterval: c.Query("datetime_frequency_interval"),
+	}
+	for _, name := range strings.Split(c.Query("aggregations"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			request.Aggregations = append(request.Aggregations, name)
+		}
+	}
+	return runAggregate(c, request)
+}
+
+// PostAggregate godoc
+// @Summary POST Aggregate request
+// @Description Aggregate the STAC items matching a search
+// @Tags Search
+// @ID post-aggregate
+// @Accept  json
+// @Produce  json
+// @Param search body models.AggregationSearch true "Search and aggregations json"
+// @Router /aggregate [post]
+func PostAggregate(c *fiber.Ctx) error {
+	var request models.AggregationSearch
+
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	return runAggregate(c, request)
+}
+
+// runAggregate computes the aggregations of a GET or POST request. The
+// total count is computed when none are requested.
+func runAggregate(c *fiber.Ctx, request models.AggregationSearch) error {
+	names := request.Aggregations
+	if len(names) == 0 {
+		names = []string{"total_count"}
+	}
+	for _, name := range names {
+		if _, ok := aggregations[name]; !ok {
+			return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+				"message": fmt.Sprintf("unknown aggregation %q, see /aggregations for the aggregations available", name),
+			})
+		}
+	}
+	interval := request.DatetimeFrequencyInterval
+	if interval == "" {
+		interval = "month"
+	}
+	if !datetimeIntervals[interval] {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"message": "datetime_frequency_interval must be one of year, month, day or hour",
+		})
+	}
+
+	// aggregations cover every match, not a page of them
+	search := request.Search
+	search.Token, search.Sortby = "", nil
+	query, err := BuildSearchQuery(search)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err := checkQueryables(search); err != nil {
+		return queryablesError(c, err)
+	}
+
+	results := []fiber.Map{}
+	for _, name := range names {
+		result, err := aggregations[name].aggregate(name, query, interval)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+				"message": "could not aggregate items",
+			})
+		}
+		results = append(results, result)
+	}
+
+	return c.Status(http.StatusOK).JSON(&fiber.Map{
+		"type":         "AggregationCollection",
+		"aggregations": results,
+		"links": []models.Link{
+			{Rel: "root", Type: "application/json", Href: c.BaseURL() + "/"},
+			{Rel: "self", Type: "application/json", Href: c.BaseURL() + c.OriginalURL()},
+		},
+	})
+}
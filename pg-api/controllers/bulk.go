@@ -0,0 +1,357 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/jonhealy1/goapi-stac/pg-api/database"
+	"github.com/jonhealy1/goapi-stac/shared/cql2"
+	"github.com/jonhealy1/goapi-stac/shared/stac"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// The outcomes of the items of a bulk request.
+const (
+	bulkCreated    = "created"
+	bulkUpdated    = "updated"
+	bulkSkipped    = "skipped"
+	bulkFailed     = "failed"
+	bulkRolledBack = "rolled_back"
+)
+
+// bulkModes are the modes of a bulk request: in a transaction, every item
+// is written or none is, while best-effort writes the items it can.
+var bulkModes = map[string]bool{"transaction": true, "best-effort": true}
+
+// bulkConflicts are the clauses of the statement writing the items, by
+// how an item whose id exists already is handled: as an error, by skipping
+// it, or by replacing it. The statement returns the items it wrote and
+// whether it created them, and no row for an item it did not write.
+var bulkConflicts = map[string]string{
+	"error": `ON CONFLICT (id) DO NOTHING`,
+	"skip":  `ON CONFLICT (id) DO NOTHING`,
+	// an item of another collection is not replaced
+	"upsert": `ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data, geometry = EXCLUDED.geometry
+		WHERE items.collection = EXCLUDED.collection`,
+}
+
+// bulkBatchSize is how many items of a bulk request a statement writes.
+const bulkBatchSize = 500
+
+// bulkResult is the outcome of an item of a bulk request.
+type bulkResult struct {
+	// Index is the position of the item in the request.
+	Index   int    `json:"index"`
+	Id      string `json:"id,omitempty"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+
+	// data is the item to write, with its geometry, unless it failed or
+	// is skipped.
+	data     []byte
+	geometry cql2.Geometry
+	// code is the status code of a failure.
+	code int
+}
+
+func (r *bulkResult) fail(code int, message string) {
+	r.Status, r.code, r.Message, r.data = bulkFailed, code, message, nil
+}
+
+// isItemSequence tells whether the body of a request is a sequence of
+// newline delimited items.
+func isItemSequence(c *fiber.Ctx) bool {
+	mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	return mediaType == "application/x-ndjson" || mediaType == "application/geo+json-seq"
+}
+
+// isBulkRequest tells whether a request to create items holds a
+// FeatureCollection or newline delimited items rather than one item.
+func isBulkRequest(c *fiber.Ctx) bool {
+	if isItemSequence(c) {
+		return true
+	}
+	var body struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(c.Body(), &body)
+	return body.Type == "FeatureCollection"
+}
+
+// readBulkItems reads the items of a bulk request, one per line of an
+// NDJSON body or the features of a FeatureCollection, and checks them.
+func readBulkItems(c *fiber.Ctx, collectionId string) ([]*bulkResult, error) {
+	var features []json.RawMessage
+	if isItemSequence(c) {
+		for _, line := range bytes.Split(c.Body(), []byte("\n")) {
+			// GeoJSON text sequences start each item with a record separator
+			line = bytes.TrimSpace(bytes.TrimPrefix(line, []byte("\x1e")))
+			if len(line) > 0 {
+				features = append(features, line)
+			}
+		}
+	} else {
+		var itemCollection struct {
+			Features []json.RawMessage `json:"features"`
+		}
+		if err := json.Unmarshal(c.Body(), &itemCollection); err != nil {
+			return nil, err
+		}
+		features = itemCollection.Features
+	}
+
+	results := make([]*bulkResult, len(features))
+	for i, feature := range features {
+		result := &bulkResult{Index: i, Status: bulkCreated}
+		results[i] = result
+
+		var item struct {
+			Id string `json:"id"`
+		}
+		json.Unmarshal(feature, &item)
+		result.Id = item.Id
+		data, geometry, err := stac.CheckItem(feature, collectionId)
+		if err != nil {
+			result.fail(http.StatusUnprocessableEntity, err.Error())
+			continue
+		}
+		result.data, result.geometry = data, geometry
+	}
+	return results, nil
+}
+
+// checkBulkConflicts fails or skips the items whose id another item of the
+// request or an item in the database has already, as the conflict handling
+// asks. Only items of the same collection are replaced.
+func checkBulkConflicts(results []*bulkResult, collectionId string, conflict string) error {
+	var ids []string
+	for _, result := range results {
+		if result.data != nil {
+			ids = append(ids, result.Id)
+		}
+	}
+	var existing []struct {
+		Id         string
+		Collection string
+	}
+	err := database.DB.Db.Raw(
+		`SELECT id, collection FROM items WHERE id = ANY(?)`, pq.Array(ids),
+	).Scan(&existing).Error
+	if err != nil {
+		return err
+	}
+	collections := map[string]string{}
+	for _, item := range existing {
+		collections[item.Id] = item.Collection
+	}
+
+	for _, result := range results {
+		if result.data == nil {
+			continue
+		}
+		owner, exists := collections[result.Id]
+		switch {
+		case !exists:
+			// later items of the same id conflict with this one
+			collections[result.Id] = collectionId
+		case conflict == "skip":
+			result.Status, result.data = bulkSkipped, nil
+		case conflict == "upsert" && owner == collectionId:
+		case owner == collectionId:
+			result.fail(http.StatusConflict, "item already exists")
+		default:
+			result.fail(http.StatusConflict, fmt.Sprintf("item exists in collection %s", owner))
+		}
+	}
+	return nil
+}
+
+// bulkBatches splits the items of a bulk request left to write into the
+// batches written by a statement each. An item whose id is in the current
+// batch already, which replaces it, starts the next one.
+func bulkBatches(results []*bulkResult) [][]*bulkResult {
+	var batches [][]*bulkResult
+	var batch []*bulkResult
+	ids := map[string]bool{}
+	for _, result := range results {
+		if result.data == nil {
+			continue
+		}
+		if len(batch) == bulkBatchSize || ids[result.Id] {
+			batches = append(batches, batch)
+			batch, ids = nil, map[string]bool{}
+		}
+		batch = append(batch, result)
+		ids[result.Id] = true
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// insertBulkItems writes a batch of items of a bulk request in a single
+// statement, and returns whether it created each item it wrote, by id.
+func insertBulkItems(db *gorm.DB, batch []*bulkResult, collectionId string, conflict string) (map[string]bool, error) {
+	values := make([]string, len(batch))
+	var args []interface{}
+	for i, result := range batch {
+		values[i] = "(?, ?, ?, ST_GeomFromGeoJSON(?))"
+		args = append(args, result.Id, collectionId, string(result.data), string(result.geometry.GeoJSON))
+	}
+	var written []struct {
+		Id      string
+		Created bool
+	}
+	err := db.Raw(
+		`INSERT INTO items (id, collection, data, geometry) VALUES `+strings.Join(values, ", ")+
+			` `+bulkConflicts[conflict]+` RETURNING id, xmax = 0 AS created`,
+		args...,
+	).Scan(&written).Error
+	if err != nil {
+		return nil, err
+	}
+	created := make(map[string]bool, len(written))
+	for _, item := range written {
+		created[item.Id] = item.Created
+	}
+	return created, nil
+}
+
+// writeBulkBatch writes a batch of items of a bulk request and records the
+// outcome of each. A batch whose statement fails is undone and written
+// again item by item, to tell the items that failed from the others.
+func writeBulkBatch(db *gorm.DB, batch []*bulkResult, collectionId string, conflict string) error {
+	var created map[string]bool
+	err := db.Transaction(func(tx *gorm.DB) (err error) {
+		created, err = insertBulkItems(tx, batch, collectionId, conflict)
+		return err
+	})
+	if err != nil && len(batch) == 1 {
+		batch[0].fail(http.StatusBadRequest, "could not create item: "+err.Error())
+		return err
+	}
+	if err != nil {
+		err = nil
+		for i := range batch {
+			if itemErr := writeBulkBatch(db, batch[i:i+1], collectionId, conflict); itemErr != nil && err == nil {
+				err = itemErr
+			}
+		}
+		return err
+	}
+
+	for _, result := range batch {
+		itemCreated, written := created[result.Id]
+		switch {
+		case written && itemCreated:
+			result.Status = bulkCreated
+		case written:
+			result.Status = bulkUpdated
+		case conflict == "skip":
+			result.Status = bulkSkipped
+		// written by another request since the conflicts were checked
+		case conflict == "upsert":
+			result.fail(http.StatusConflict, "item exists in another collection")
+		default:
+			result.fail(http.StatusConflict, "item already exists")
+		}
+		if result.Status == bulkFailed && err == nil {
+			err = errors.New(result.Message)
+		}
+	}
+	return err
+}
+
+// createItems writes the items of a bulk request, in a single transaction
+// or one by one as the mode parameter asks, and answers with the outcome of
+// every item.
+func createItems(c *fiber.Ctx, collectionId string) error {
+	mode := c.Query("mode", "transaction")
+	if !bulkModes[mode] {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{"message": "mode must be transaction or best-effort"})
+	}
+	conflict := c.Query("on_conflict", "error")
+	if _, ok := bulkConflicts[conflict]; !ok {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{"message": "on_conflict must be error, skip or upsert"})
+	}
+
+	results, err := readBulkItems(c, collectionId)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{"message": "invalid FeatureCollection: " + err.Error()})
+	}
+	if err := checkBulkConflicts(results, collectionId, conflict); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{"message": "could not check the items for conflicts"})
+	}
+
+	failed := func() *bulkResult {
+		for _, result := range results {
+			if result.Status == bulkFailed {
+				return result
+			}
+		}
+		return nil
+	}
+
+	if mode == "transaction" {
+		if failed() == nil {
+			err = audited(c, func(tx *gorm.DB) error {
+				for _, batch := range bulkBatches(results) {
+					if err := writeBulkBatch(tx, batch, collectionId, conflict); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+		// nothing was written if an item failed
+		if failure := failed(); failure != nil {
+			for _, result := range results {
+				if result.Status != bulkFailed && result.Status != bulkSkipped {
+					result.Status = bulkRolledBack
+				}
+			}
+			return c.Status(failure.code).JSON(&fiber.Map{
+				"message":    "no items were created",
+				"collection": collectionId,
+				"results":    results,
+			})
+		}
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{"message": "could not create the items"})
+		}
+	} else {
+		// the items that failed are left out of the batches they were in
+		for _, batch := range bulkBatches(results) {
+			err := audited(c, func(tx *gorm.DB) error {
+				writeBulkBatch(tx, batch, collectionId, conflict)
+				return nil
+			})
+			if err != nil {
+				for _, result := range batch {
+					if result.Status != bulkFailed {
+						result.fail(http.StatusInternalServerError, "could not create item")
+					}
+				}
+			}
+		}
+	}
+
+	status := http.StatusCreated
+	if failed() != nil {
+		status = http.StatusMultiStatus
+	}
+	return c.Status(status).JSON(&fiber.Map{
+		"message":    "success",
+		"collection": collectionId,
+		"results":    results,
+	})
+}
@@ -2,12 +2,14 @@ package controllers
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/jonhealy1/goapi-stac/pg-api/database"
 	"github.com/jonhealy1/goapi-stac/pg-api/models"
 
 	"github.com/go-playground/validator"
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 func Root(c *fiber.Ctx) error {
@@ -69,7 +71,9 @@ func CreateCollection(c *fiber.Ctx) error {
 		return err
 	}
 
-	err = database.DB.Db.Create(&collection).Error
+	err = audited(c, func(tx *gorm.DB) error {
+		return tx.Create(&collection).Error
+	})
 
 	if err != nil {
 		c.Status(http.StatusBadRequest).JSON(
@@ -77,6 +81,10 @@ func CreateCollection(c *fiber.Ctx) error {
 		return err
 	}
 
+	if updatedAt, err := collectionVersion(database.DB.Db, collection.Id); err == nil && updatedAt != nil {
+		c.Set(fiber.HeaderETag, versionETag(*updatedAt))
+	}
+
 	c.Status(http.StatusCreated).JSON(&fiber.Map{
 		"message":         "success",
 		"id":              collection.Id,
@@ -93,6 +101,7 @@ func CreateCollection(c *fiber.Ctx) error {
 // @Accept  json
 // @Produce  json
 // @Param collectionId path string true "Collection ID"
+// @Param If-None-Match header string false "ETags of versions of the collection already known"
 // @Router /collections/{collectionId} [get]
 // @Success 200 {object} models.Collection
 func GetCollection(c *fiber.Ctx) error {
@@ -112,37 +121,74 @@ func GetCollection(c *fiber.Ctx) error {
 		return err
 	}
 
+	var updatedAt []time.Time
+	database.DB.Db.Raw("SELECT updated_at FROM collections WHERE id = ?", id).Scan(&updatedAt)
+	if len(updatedAt) > 0 && notModified(c, updatedAt[0]) {
+		return nil
+	}
+
 	c.Status(http.StatusOK).JSON(collection.Data[0])
 	return nil
 }
 
 // GetCollections godoc
-// @Summary Get all Collections
-// @Description Get all Collections
+// @Summary Search Collections
+// @Description Get the Collections matching a search, a page at a time
 // @Tags Collections
 // @ID get-all-collections
 // @Accept  json
 // @Produce  json
+// @Param q query string false "Free-text query, matched most relevant first"
+// @Param bbox query string false "Comma separated bbox of 4 or 6 numbers the spatial extent intersects"
+// @Param bbox-crs query string false "CRS of the bbox, CRS84 (default) or EPSG:4326"
+// @Param datetime query string false "Datetime or interval the temporal extent overlaps"
+// @Param limit query int false "Page size, at most MAX_LIMIT"
+// @Param sortby query string false "Comma separated id, title, start_datetime or end_datetime, prefixed with - to sort descending"
+// @Param token query string false "Pagination token from a next or prev link"
 // @Router /collections [get]
 // @Success 200 {object} []models.Collection
 func GetCollections(c *fiber.Ctx) error {
-	collections := []models.Collection{}
-	results := database.DB.Db.Find(&collections)
-	if results.Error != nil {
-		c.Status(http.StatusBadRequest).JSON(
-			&fiber.Map{"message": "could not get collections"})
-		return results.Error
+	search, err := getCollectionSearchParams(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"message": err.Error(),
+		})
 	}
-	var data []interface{}
-	for _, collection := range collections {
-		data = append(data, collection.Data[0])
+	query, err := BuildCollectionSearchQuery(search)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"message": err.Error(),
+		})
 	}
 
-	c.Status(http.StatusOK).JSON(&fiber.Map{
-		"count":   results.RowsAffected,
-		"results": data,
+	collections, next, prev, err := query.fetchPage()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"message": "could not get collections",
+		})
+	}
+	matched, estimated, err := query.numberMatched(len(collections), next)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"message": "could not count collections",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(&fiber.Map{
+		"collections": collections,
+		"context": models.Context{
+			Returned:         len(collections),
+			Limit:            query.limit,
+			Matched:          matched,
+			MatchedEstimated: estimated,
+		},
+		"numberMatched":  matched,
+		"numberReturned": len(collections),
+		"links": append([]models.Link{
+			{Rel: "self", Type: "application/json", Href: c.BaseURL() + c.OriginalURL()},
+			{Rel: "root", Type: "application/json", Href: c.BaseURL() + "/"},
+		}, getPageLinks(c, next, prev)...),
 	})
-	return nil
 }
 
 // DeleteCollection godoc
@@ -153,6 +199,7 @@ func GetCollections(c *fiber.Ctx) error {
 // @Accept  json
 // @Produce  json
 // @Param collectionId path string true "Collection ID"
+// @Param If-Match header string false "ETag the collection must still have"
 // @Router /collections/{collectionId} [delete]
 func DeleteCollection(c *fiber.Ctx) error {
 	collection := &models.Collection{}
@@ -165,13 +212,18 @@ func DeleteCollection(c *fiber.Ctx) error {
 		return nil
 	}
 
-	err := database.DB.Db.Unscoped().Where("id = ?", id).Delete(&collection).Error
-
+	err := audited(c, func(tx *gorm.DB) error {
+		updatedAt, err := collectionVersion(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(c, updatedAt); err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("id = ?", id).Delete(&collection).Error
+	})
 	if err != nil {
-		c.Status(http.StatusBadRequest).JSON(&fiber.Map{
-			"message": "could not delete collection",
-		})
-		return err
+		return writeError(c, err, "could not delete collection")
 	}
 
 	c.Status(http.StatusOK).JSON(&fiber.Map{
@@ -189,6 +241,7 @@ func DeleteCollection(c *fiber.Ctx) error {
 // @Produce  json
 // @Param collectionId path string true "Collection ID"
 // @Param collection body models.Collection true "STAC Collection json"
+// @Param If-Match header string false "ETag the collection must still have"
 // @Router /collections/{collectionId} [put]
 // @Success 200 {object} models.Collection
 func EditCollection(c *fiber.Ctx) error {
@@ -216,12 +269,26 @@ func EditCollection(c *fiber.Ctx) error {
 		Data: models.JSONB{(&collection)},
 	}
 
-	err = database.DB.Db.Model(collectionModel).Where("id = ?", id).Updates(updated).Error
-	if err != nil {
-		c.Status(http.StatusBadRequest).JSON(&fiber.Map{
-			"message": "could not update collection",
-		})
+	var updatedAt *time.Time
+	err = audited(c, func(tx *gorm.DB) error {
+		if updatedAt, err = collectionVersion(tx, id); err != nil {
+			return err
+		}
+		if err := checkIfMatch(c, updatedAt); err != nil {
+			return err
+		}
+		if err := tx.Model(collectionModel).Where("id = ?", id).Updates(updated).Error; err != nil {
+			return err
+		}
+		updatedAt, err = collectionVersion(tx, id)
 		return err
+	})
+	if err != nil {
+		return writeError(c, err, "could not update collection")
+	}
+
+	if updatedAt != nil {
+		c.Set(fiber.HeaderETag, versionETag(*updatedAt))
 	}
 
 	c.Status(http.StatusOK).JSON(&fiber.Map{
@@ -232,9 +299,34 @@ func EditCollection(c *fiber.Ctx) error {
 
 func Conformance(c *fiber.Ctx) error {
 	conformsTo := []string{
+		"https://api.stacspec.org/v1.0.0/core",
+		"https://api.stacspec.org/v1.0.0/collections",
+		"https://api.stacspec.org/v1.0.0/ogcapi-features",
+		"https://api.stacspec.org/v1.0.0/ogcapi-features#fields",
+		"https://api.stacspec.org/v1.0.0/item-search",
+		"https://api.stacspec.org/v1.0.0/item-search#fields",
+		"https://api.stacspec.org/v1.0.0/item-search#query",
+		"https://api.stacspec.org/v1.0.0/item-search#sort",
+		"https://api.stacspec.org/v1.0.0/item-search#filter",
+		"https://api.stacspec.org/v1.0.0/item-search#free-text",
+		"https://api.stacspec.org/v1.0.0/item-search#advanced-free-text",
+		"https://api.stacspec.org/v1.0.0/collection-search",
+		"https://api.stacspec.org/v1.0.0/collection-search#free-text",
+		"https://api.stacspec.org/v1.0.0/collection-search#advanced-free-text",
+		"https://api.stacspec.org/v1.0.0/collection-search#sort",
+		// the aggregation extension is versioned apart from the STAC API
+		"https://api.stacspec.org/v0.3.0/aggregation",
 		"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/core",
-		"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/oas30",
 		"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/geojson",
+		"http://www.opengis.net/spec/ogcapi-features-2/1.0/conf/crs",
+		"http://www.opengis.net/spec/ogcapi-features-3/1.0/conf/queryables",
+		"http://www.opengis.net/spec/cql2/1.0/conf/cql2-json",
+		"http://www.opengis.net/spec/cql2/1.0/conf/cql2-text",
+		"http://www.opengis.net/spec/cql2/1.0/conf/basic-cql2",
+		"http://www.opengis.net/spec/cql2/1.0/conf/advanced-comparison-operators",
+		"http://www.opengis.net/spec/cql2/1.0/conf/basic-spatial-functions",
+		"http://www.opengis.net/spec/cql2/1.0/conf/spatial-functions",
+		"http://www.opengis.net/spec/cql2/1.0/conf/temporal-functions",
 	}
 
 	return c.Status(http.StatusOK).JSON(&fiber.Map{
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jonhealy1/goapi-stac/pg-api/models"
+	"github.com/jonhealy1/goapi-stac/shared/freetext"
+	"github.com/jonhealy1/goapi-stac/shared/params"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// collectionSortables lists the fields collection searches can be sorted
+// by. The datetimes are the bounds of the temporal extent.
+var collectionSortables = map[string]sortKey{
+	"id":             {Expr: "collections.id", Type: "text"},
+	"title":          {Expr: "(collections.data->0->>'title')", Type: "text"},
+	"start_datetime": {Expr: "collections.extent_start", Type: "timestamptz"},
+	"end_datetime":   {Expr: "collections.extent_end", Type: "timestamptz"},
+}
+
+// collectionRelevanceSortKey orders the matches of a free-text collection
+// search most relevant first.
+var collectionRelevanceSortKey = sortKey{Expr: "ts_rank(collections.search, text_query.query)", Type: "float4", Desc: true}
+
+// collectionSortKeys returns the keys a collection search is ordered by,
+// ending with the id. Collections are listed by id by default.
+func collectionSortKeys(sortby []models.Sort) ([]sortKey, error) {
+	var keys []sortKey
+	hasID := false
+	for _, s := range sortby {
+		key, ok := collectionSortables[s.Field]
+		if !ok {
+			return nil, fmt.Errorf("cannot sort collections by %q, they can be sorted by id, title, start_datetime and end_datetime", s.Field)
+		}
+		switch strings.ToLower(s.Direction) {
+		case "", "asc":
+		case "desc":
+			key.Desc = true
+		default:
+			return nil, fmt.Errorf("sort direction must be asc or desc, got %q", s.Direction)
+		}
+		hasID = hasID || s.Field == "id"
+		keys = append(keys, key)
+	}
+	if !hasID {
+		keys = append(keys, collectionSortables["id"])
+	}
+	return keys, nil
+}
+
+// BuildCollectionSearchQuery plans the query for a collection search,
+// combining whichever of its free-text query, bbox and datetime are set
+// with the sort order and the position of the requested page. The bbox
+// and datetime are matched against the extents of the collections.
+// Free-text searches are sorted by relevance unless they set a sortby.
+func BuildCollectionSearchQuery(search models.Search) (*SearchQuery, error) {
+	sortKeys, err := collectionSortKeys(search.Sortby)
+	if err != nil {
+		return nil, err
+	}
+	q := &SearchQuery{
+		from:     "collections",
+		data:     "collections.data->0",
+		limit:    pageLimit(search.Limit),
+		sortKeys: sortKeys,
+	}
+	q.where("collections.deleted_at IS NULL")
+
+	if search.Q != "" {
+		text, err := freetext.Parse(string(search.Q))
+		if err != nil {
+			return nil, err
+		}
+		tsquery, args := tsquerySQL(text)
+		q.join("(SELECT "+tsquery+" AS query) AS text_query", args...)
+		q.where("collections.search @@ text_query.query")
+		if len(search.Sortby) == 0 {
+			q.sortKeys = []sortKey{collectionRelevanceSortKey, collectionSortables["id"]}
+		}
+	}
+
+	if len(search.Bbox) > 0 {
+		condition, args, err := bboxSQL("collections.extent_geometry", search.Bbox)
+		if err != nil {
+			return nil, err
+		}
+		q.where(condition, args...)
+	}
+
+	if search.Datetime != "" {
+		interval, err := params.ParseDatetime(search.Datetime)
+		if err != nil {
+			return nil, err
+		}
+		if interval.Start != nil {
+			q.where("collections.extent_end >= ?::timestamptz", *interval.Start)
+		}
+		if interval.End != nil {
+			q.where("collections.extent_start <= ?::timestamptz", *interval.End)
+		}
+	}
+
+	if q.token, err = decodeToken(search.Token); err != nil {
+		return nil, err
+	}
+	if q.keyset, q.keysetArgs, err = keysetSQL(q.sortKeys, q.token); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// getCollectionSearchParams reads the query parameters of a collection
+// search.
+func getCollectionSearchParams(c *fiber.Ctx) (models.Search, error) {
+	search := models.Search{
+		Q:        models.FreeText(c.Query("q")),
+		Datetime: c.Query("datetime"),
+		Token:    c.Query("token"),
+		Sortby:   params.ParseSortby(c.Query("sortby")),
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		var err error
+		if search.Limit, err = strconv.Atoi(limit); err != nil || search.Limit < 1 {
+			return search, fmt.Errorf("limit must be a positive integer")
+		}
+	}
+
+	bbox, err := params.ParseBbox(c.Query("bbox"))
+	if err != nil {
+		return search, err
+	}
+	if search.Bbox, err = params.BboxToCRS84(bbox, c.Query("bbox-crs")); err != nil {
+		return search, err
+	}
+	return search, nil
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jonhealy1/goapi-stac/pg-api/database"
+	"github.com/jonhealy1/goapi-stac/shared/params"
+)
+
+// defaultExactCountThreshold is the exactCountThreshold used when
+// EXACT_COUNT_THRESHOLD is not set.
+const defaultExactCountThreshold = 10000
+
+// exactCountThreshold is the number of matches up to which searches are
+// counted exactly. Above it the planner's estimate is returned instead, as
+// counting every match of a large search is as slow as reading them all.
+var exactCountThreshold = params.EnvInt("EXACT_COUNT_THRESHOLD", defaultExactCountThreshold)
+
+// queryPlan is the part of the JSON output of EXPLAIN holding the number
+// of rows the planner expects the query to return.
+type queryPlan []struct {
+	Plan struct {
+		Rows float64 `json:"Plan Rows"`
+	} `json:"Plan"`
+}
+
+// numberMatched returns the number of items matching the search, across
+// all of its pages, and whether that number is an estimate. returned and
+// next are the size of the page fetched and its next token: a first page
+// without a next page holds every match, so it needs no count.
+func (q *SearchQuery) numberMatched(returned int, next string) (int, bool, error) {
+	if q.token == nil && next == "" {
+		return returned, false, nil
+	}
+
+	from, args := q.fromSQL(false)
+
+	var explain string
+	if err := database.DB.Db.Raw("EXPLAIN (FORMAT JSON) SELECT 1"+from, args...).Row().Scan(&explain); err != nil {
+		return 0, false, err
+	}
+	var plan queryPlan
+	if err := json.Unmarshal([]byte(explain), &plan); err != nil || len(plan) == 0 {
+		return 0, false, fmt.Errorf("could not read the query plan: %v", err)
+	}
+	if estimate := int(plan[0].Plan.Rows); estimate > exactCountThreshold {
+		return estimate, true, nil
+	}
+
+	var count int
+	if err := database.DB.Db.Raw("SELECT count(*)"+from, args...).Row().Scan(&count); err != nil {
+		return 0, false, err
+	}
+	return count, false, nil
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// versionETag returns the strong entity tag of a version of an item or a
+// collection, which is the time it was written.
+func versionETag(updatedAt time.Time) string {
+	return fmt.Sprintf(`"%x"`, updatedAt.UnixMicro())
+}
+
+// etagMatches tells whether an If-Match or If-None-Match header lists an
+// entity tag. If-None-Match compares the tags weakly, If-Match strongly so
+// that a weak tag never matches.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch checks the If-Match header of a write against the version of
+// the item or collection written, nil if there is none.
+func checkIfMatch(c *fiber.Ctx, updatedAt *time.Time) error {
+	header := c.Get(fiber.HeaderIfMatch)
+	if header != "" && (updatedAt == nil || !etagMatches(header, versionETag(*updatedAt), false)) {
+		return &statusError{http.StatusPreconditionFailed, "precondition failed: the resource has changed"}
+	}
+	return nil
+}
+
+// notModified tells whether the If-None-Match header of a read lists the
+// version of the item or collection read, and answers it if so. The
+// version is given as an ETag in any case.
+func notModified(c *fiber.Ctx, updatedAt time.Time) bool {
+	etag := versionETag(updatedAt)
+	c.Set(fiber.HeaderETag, etag)
+	if header := c.Get(fiber.HeaderIfNoneMatch); header != "" && etagMatches(header, etag, true) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// itemVersion returns when an item was last written, nil if there is no
+// such item. Within a transaction, the item stays locked until it ends.
+func itemVersion(db *gorm.DB, id, collectionId string) (*time.Time, error) {
+	return rowVersion(db, `SELECT updated_at FROM items WHERE id = ? AND collection = ? FOR UPDATE`, id, collectionId)
+}
+
+// collectionVersion returns when a collection was last written, nil if
+// there is no such collection. Within a transaction, the collection stays
+// locked until it ends.
+func collectionVersion(db *gorm.DB, id string) (*time.Time, error) {
+	return rowVersion(db, `SELECT updated_at FROM collections WHERE id = ? FOR UPDATE`, id)
+}
+
+func rowVersion(db *gorm.DB, query string, args ...interface{}) (*time.Time, error) {
+	var updatedAt []time.Time
+	if err := db.Raw(query, args...).Scan(&updatedAt).Error; err != nil || len(updatedAt) == 0 {
+		return nil, err
+	}
+	return &updatedAt[0], nil
+}
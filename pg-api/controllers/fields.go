@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/jonhealy1/goapi-stac/pg-api/models"
+)
+
+// fieldNode is a node of the tree of included paths. A leaf includes the
+// whole value at its path.
+type fieldNode struct {
+	leaf     bool
+	children map[string]*fieldNode
+}
+
+// fieldsSQL builds the expression selecting the fields of an item's JSON.
+// Only the included paths are kept, or the whole item if there are none,
+// and the excluded paths are then removed from it. A path that is both
+// included and excluded, or excluded above an included path, stays.
+func fieldsSQL(fields models.Fields) (string, []interface{}) {
+	var includes [][]string
+	for _, field := range fields.Include {
+		includes = append(includes, strings.Split(field, "."))
+	}
+
+	w := &fieldsWriter{}
+	if len(includes) == 0 {
+		w.write("items.data")
+	} else {
+		root := &fieldNode{}
+		for _, path := range includes {
+			root.add(path)
+		}
+		w.object(root, nil)
+	}
+
+	for _, field := range fields.Exclude {
+		path := strings.Split(field, ".")
+		if coversAny(path, includes) {
+			continue
+		}
+		w.write(" #- ARRAY[")
+		for i, key := range path {
+			if i > 0 {
+				w.write(", ")
+			}
+			w.bind(key)
+		}
+		w.write("]")
+	}
+	return w.sb.String(), w.args
+}
+
+func (n *fieldNode) add(path []string) {
+	if n.leaf {
+		return
+	}
+	if len(path) == 0 {
+		n.leaf, n.children = true, nil
+		return
+	}
+	if n.children == nil {
+		n.children = map[string]*fieldNode{}
+	}
+	child, ok := n.children[path[0]]
+	if !ok {
+		child = &fieldNode{}
+		n.children[path[0]] = child
+	}
+	child.add(path[1:])
+}
+
+// coversAny reports whether path is, or is a parent of, one of paths.
+func coversAny(path []string, paths [][]string) bool {
+	for _, other := range paths {
+		if len(other) >= len(path) && strings.Join(other[:len(path)], ".") == strings.Join(path, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+type fieldsWriter struct {
+	sb   strings.Builder
+	args []interface{}
+}
+
+func (w *fieldsWriter) write(parts ...string) {
+	for _, part := range parts {
+		w.sb.WriteString(part)
+	}
+}
+
+func (w *fieldsWriter) bind(key string) {
+	w.sb.WriteString("?::text")
+	w.args = append(w.args, key)
+}
+
+// value writes the JSON value at path in the item.
+func (w *fieldsWriter) value(path []string) {
+	w.write("items.data")
+	for _, key := range path {
+		w.write(" -> ")
+		w.bind(key)
+	}
+}
+
+// object writes the object at path with only the fields of node. Values
+// that are missing from the item are left out rather than set to null.
+func (w *fieldsWriter) object(node *fieldNode, path []string) {
+	var leaves, branches []string
+	for key, child := range node.children {
+		if child.leaf {
+			leaves = append(leaves, key)
+		} else {
+			branches = append(branches, key)
+		}
+	}
+	sort.Strings(leaves)
+	sort.Strings(branches)
+
+	w.write("(")
+	if len(leaves) > 0 {
+		w.write("COALESCE((SELECT jsonb_object_agg(key, value) FROM jsonb_each(CASE WHEN jsonb_typeof(")
+		w.value(path)
+		w.write(") = 'object' THEN ")
+		w.value(path)
+		w.write(" END) WHERE key IN ?), '{}'::jsonb)")
+		w.args = append(w.args, leaves)
+	} else {
+		w.write("'{}'::jsonb")
+	}
+
+	for _, key := range branches {
+		childPath := append(append([]string{}, path...), key)
+		w.write(" || CASE WHEN jsonb_typeof(")
+		w.value(childPath)
+		w.write(") = 'object' THEN jsonb_build_object(")
+		w.bind(key)
+		w.write(", ")
+		w.object(node.children[key], childPath)
+		w.write(") ELSE '{}'::jsonb END")
+	}
+	w.write(")")
+}
@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jonhealy1/goapi-stac/shared/freetext"
+)
+
+// textSearchConfig is the text search configuration search vectors are
+// built with, and free-text queries parsed with.
+const textSearchConfig = "english"
+
+// relevanceSortKey orders the matches of a free-text search most relevant
+// first. It reads the tsquery a free-text search joins as text_query.
+var relevanceSortKey = sortKey{Expr: "ts_rank(items.search, text_query.query)", Type: "float4", Desc: true}
+
+// tsquerySQL returns the expression building the tsquery of a free-text
+// query. Terms are bound as placeholders and normalized by Postgres, so
+// stop words and punctuation in them are handled as in search vectors.
+func tsquerySQL(n *freetext.Node) (string, []interface{}) {
+	switch n.Op {
+	case "":
+		function := "plainto_tsquery"
+		if n.Phrase {
+			function = "phraseto_tsquery"
+		}
+		return fmt.Sprintf("%s('%s', ?::text)", function, textSearchConfig), []interface{}{n.Term}
+	case "not":
+		sql, args := tsquerySQL(n.Children[0])
+		return "!!" + sql, args
+	}
+
+	operator := " && "
+	if n.Op == "or" {
+		operator = " || "
+	}
+	var terms []string
+	var args []interface{}
+	for _, child := range n.Children {
+		sql, childArgs := tsquerySQL(child)
+		terms = append(terms, sql)
+		args = append(args, childArgs...)
+	}
+	return "(" + strings.Join(terms, operator) + ")", args
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jonhealy1/goapi-stac/pg-api/database"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// clientLabelHeader carries a label a client may give its writes, such as
+// the name of the job making them, which the history of the items and
+// collections records with the client address. The api does not
+// authenticate clients, so the label is not verified: any client can send
+// any label, and it does not identify who made a write.
+const clientLabelHeader = "X-Client-Label"
+
+// itemsAsOfSQL is the relation of the items as they were at a point in
+// time, the last revision of each before it unless that deleted the item.
+// It stands in for the items table, whose columns it has.
+const itemsAsOfSQL = "(SELECT * FROM (SELECT DISTINCT ON (id)" +
+	" id, collection, data, geometry, datetime, start_datetime, end_datetime, search, changed_at AS updated_at, operation" +
+	" FROM items_history WHERE changed_at <= ? ORDER BY id, changed_at DESC, revision DESC" +
+	") AS revisions WHERE operation <> 'delete') AS items"
+
+// audited runs writes in a transaction, recording the label and the
+// address of their client in the history of the items and collections
+// they change.
+func audited(c *fiber.Ctx, write func(tx *gorm.DB) error) error {
+	return database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`SELECT set_config('stac.client_label', ?, true), set_config('stac.client_address', ?, true)`,
+			c.Get(clientLabelHeader), c.IP()).Error
+		if err != nil {
+			return err
+		}
+		return write(tx)
+	})
+}
+
+// parseAsOf reads an asof parameter, the RFC 3339 timestamp of the past
+// state a read is answered from.
+func parseAsOf(asOf string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, asOf)
+	if err != nil {
+		return t, fmt.Errorf("asof must be an RFC 3339 timestamp")
+	}
+	return t, nil
+}
+
+// itemRevision is a revision of an item in its history.
+type itemRevision struct {
+	Revision      int64     `json:"revision"`
+	Operation     string    `json:"operation"`
+	ClientLabel   string    `json:"client_label,omitempty"`
+	ClientAddress string    `json:"client_address,omitempty"`
+	ChangedAt     time.Time `json:"changed_at"`
+	Collection    string    `json:"-"`
+	Data          string    `json:"-"`
+	Geometry      string    `json:"-"`
+
+	StacItem map[string]interface{} `json:"stac_item,omitempty" gorm:"-"`
+}
+
+// getItemAsOf answers a request for an item as it was at a point in time.
+func getItemAsOf(c *fiber.Ctx, id string, collectionId string, asOf string) error {
+	t, err := parseAsOf(asOf)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{"message": err.Error()})
+	}
+
+	var revisions []itemRevision
+	err = database.DB.Db.Raw(
+		`SELECT revision, operation, client_label, client_address, changed_at, collection, data, ST_AsGeoJSON(geometry) AS geometry
+		FROM items_history WHERE id = ? AND collection = ? AND changed_at <= ?
+		ORDER BY changed_at DESC, revision DESC LIMIT 1`,
+		id, collectionId, t,
+	).Scan(&revisions).Error
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{"message": "could not get item"})
+	}
+	if len(revisions) == 0 || revisions[0].Operation == "delete" {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{"message": "item did not exist at " + asOf})
+	}
+
+	var geomMap, itemMap map[string]interface{}
+	json.Unmarshal([]byte(revisions[0].Geometry), &geomMap)
+	json.Unmarshal([]byte(revisions[0].Data), &itemMap)
+	return c.Status(http.StatusOK).JSON(&fiber.Map{
+		"message":    "item retrieved successfully",
+		"id":         id,
+		"collection": revisions[0].Collection,
+		"geometry":   geomMap,
+		"stac_item":  itemMap,
+		"asof":       asOf,
+		"revision":   revisions[0].Revision,
+	})
+}
+
+// GetItemVersions godoc
+// @Summary Get the versions of an Item
+// @Description Get every revision of an item, oldest first, with the client label and address that wrote it and when
+// @Tags Items
+// @ID get-item-versions
+// @Accept  json
+// @Produce  json
+// @Param collectionId path string true "Collection ID"
+// @Param itemId path string true "Item ID"
+// @Router /collections/{collectionId}/items/{itemId}/versions [get]
+func GetItemVersions(c *fiber.Ctx) error {
+	id := c.Params("itemId")
+	collection_id := c.Params("collectionId")
+
+	var revisions []itemRevision
+	err := database.DB.Db.Raw(
+		`SELECT revision, operation, client_label, client_address, changed_at, collection, data
+		FROM items_history WHERE id = ? AND collection = ?
+		ORDER BY changed_at, revision`,
+		id, collection_id,
+	).Scan(&revisions).Error
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{"message": "could not get the versions of the item"})
+	}
+	if len(revisions) == 0 {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{"message": "item does not exist"})
+	}
+
+	for i := range revisions {
+		// a deleted item is recorded as it was before
+		if revisions[i].Operation != "delete" {
+			json.Unmarshal([]byte(revisions[i].Data), &revisions[i].StacItem)
+		}
+	}
+	return c.Status(http.StatusOK).JSON(&fiber.Map{
+		"message":    "success",
+		"id":         id,
+		"collection": collection_id,
+		"versions":   revisions,
+	})
+}
@@ -4,23 +4,30 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/jonhealy1/goapi-stac/pg-api/database"
 	"github.com/jonhealy1/goapi-stac/pg-api/models"
+	"github.com/jonhealy1/goapi-stac/shared/params"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 // CreateItem godoc
-// @Summary Create a STAC item
-// @Description Create an item with an ID
+// @Summary Create a STAC item, or many
+// @Description Create an item with an ID, or the items of a FeatureCollection or NDJSON body with a status for each
 // @Tags Items
 // @ID post-item
-// @Accept  json
+// @Accept  json,application/x-ndjson
 // @Produce  json
 // @Param collectionId path string true "Collection ID"
 // @Param item body models.Item true "STAC Item json"
+// @Param mode query string false "Items of a FeatureCollection or NDJSON are created in a transaction (default) or best-effort"
+// @Param on_conflict query string false "error (default), skip or upsert items whose id exists"
 // @Router /collections/{collectionId}/items [post]
 func CreateItem(c *fiber.Ctx) error {
 	stac_item := new(models.StacItem)
@@ -44,6 +51,10 @@ func CreateItem(c *fiber.Ctx) error {
 			&fiber.Map{"message": "Collection does not exist"})
 	}
 
+	if isBulkRequest(c) {
+		return createItems(c, collection_id)
+	}
+
 	err = c.BodyParser(&stac_item)
 	if err != nil {
 		c.Status(http.StatusUnprocessableEntity).JSON(
@@ -56,18 +67,22 @@ func CreateItem(c *fiber.Ctx) error {
 	}
 	coordinatesString = coordinatesString + "]]"
 	rawGeometryJSON := fmt.Sprintf("{'type':'Polygon', 'coordinates':%s}", coordinatesString)
-	err = database.DB.Db.Exec(
-		`INSERT INTO items (id, collection, data, geometry) 
-		VALUES (
-			@id, 
-			@collection, 
-			@data, 
-			ST_GeomFromEWKB(ST_GeomFromGeoJSON(@geometry)))`,
-		sql.Named("id", stac_item.Id),
-		sql.Named("collection", collection_id),
-		sql.Named("data", stac_item),
-		sql.Named("geometry", rawGeometryJSON),
-	).Error
+	var updatedAt time.Time
+	err = audited(c, func(tx *gorm.DB) error {
+		return tx.Raw(
+			`INSERT INTO items (id, collection, data, geometry) 
+			VALUES (
+				@id, 
+				@collection, 
+				@data, 
+				ST_GeomFromEWKB(ST_GeomFromGeoJSON(@geometry)))
+			RETURNING updated_at`,
+			sql.Named("id", stac_item.Id),
+			sql.Named("collection", collection_id),
+			sql.Named("data", stac_item),
+			sql.Named("geometry", rawGeometryJSON),
+		).Scan(&updatedAt).Error
+	})
 
 	if err != nil {
 		c.Status(http.StatusBadRequest).JSON(
@@ -75,6 +90,7 @@ func CreateItem(c *fiber.Ctx) error {
 		return err
 	}
 
+	c.Set(fiber.HeaderETag, versionETag(updatedAt))
 	c.Status(http.StatusCreated).JSON(&fiber.Map{
 		"message":    "success",
 		"id":         stac_item.Id,
@@ -92,6 +108,7 @@ func CreateItem(c *fiber.Ctx) error {
 // @Produce  json
 // @Param itemId path string true "Item ID"
 // @Param collectionId path string true "Collection ID"
+// @Param If-Match header string false "ETag the item must still have"
 // @Router /collections/{collectionId}/items/{itemId} [delete]
 func DeleteItem(c *fiber.Ctx) error {
 	id := c.Params("itemId")
@@ -110,17 +127,22 @@ func DeleteItem(c *fiber.Ctx) error {
 		return nil
 	}
 
-	err := database.DB.Db.Exec(
-		`DELETE FROM items WHERE id=@id AND collection=@collection`,
-		sql.Named("id", id),
-		sql.Named("collection", collection_id),
-	).Error
-
+	err := audited(c, func(tx *gorm.DB) error {
+		updatedAt, err := itemVersion(tx, id, collection_id)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(c, updatedAt); err != nil {
+			return err
+		}
+		return tx.Exec(
+			`DELETE FROM items WHERE id=@id AND collection=@collection`,
+			sql.Named("id", id),
+			sql.Named("collection", collection_id),
+		).Error
+	})
 	if err != nil {
-		c.Status(http.StatusBadRequest).JSON(&fiber.Map{
-			"message": "could not delete item",
-		})
-		return err
+		return writeError(c, err, "could not delete item")
 	}
 
 	c.Status(http.StatusOK).JSON(&fiber.Map{
@@ -139,6 +161,7 @@ func DeleteItem(c *fiber.Ctx) error {
 // @Param collectionId path string true "Collection ID"
 // @Param itemId path string true "Item ID"
 // @Param item body models.Item true "STAC Collection json"
+// @Param If-Match header string false "ETag the item must still have"
 // @Router /collections/{collectionId}/items/{itemId} [put]
 // @Success 200 {object} models.Item
 func EditItem(c *fiber.Ctx) error {
@@ -167,21 +190,47 @@ func EditItem(c *fiber.Ctx) error {
 		return err
 	}
 
-	err = database.DB.Db.Exec(
-		`UPDATE items SET data=@data
-		WHERE id=@id AND collection=@collection`,
-		sql.Named("data", stac_item),
-		sql.Named("id", stac_item.Id),
-		sql.Named("collection", stac_item.Collection),
-	).Error
-
-	if err != nil {
-		c.Status(http.StatusBadRequest).JSON(&fiber.Map{
-			"message": "could not update item",
+	// the item written is the one of the path, which the body cannot name
+	// another
+	if stac_item.Id == "" {
+		stac_item.Id = id
+	}
+	if stac_item.Collection == "" {
+		stac_item.Collection = collection_id
+	}
+	if stac_item.Id != id || stac_item.Collection != collection_id {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"message": "the id and collection of the item must be those of the path",
 		})
+	}
+
+	var updated []time.Time
+	err = audited(c, func(tx *gorm.DB) error {
+		updatedAt, err := itemVersion(tx, id, collection_id)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(c, updatedAt); err != nil {
+			return err
+		}
+		err = tx.Raw(
+			`UPDATE items SET data=@data
+			WHERE id=@id AND collection=@collection
+			RETURNING updated_at`,
+			sql.Named("data", stac_item),
+			sql.Named("id", id),
+			sql.Named("collection", collection_id),
+		).Scan(&updated).Error
+		if err == nil && len(updated) == 0 {
+			return &statusError{http.StatusNotFound, "item does not exist"}
+		}
 		return err
+	})
+	if err != nil {
+		return writeError(c, err, "could not update item")
 	}
 
+	c.Set(fiber.HeaderETag, versionETag(updated[0]))
 	c.Status(http.StatusOK).JSON(&fiber.Map{
 		"message": "success",
 	})
@@ -197,6 +246,8 @@ func EditItem(c *fiber.Ctx) error {
 // @Produce  json
 // @Param itemId path string true "Item ID"
 // @Param collectionId path string true "Collection ID"
+// @Param If-None-Match header string false "ETags of versions of the item already known"
+// @Param asof query string false "RFC 3339 timestamp to get the item as it was then"
 // @Router /collections/{collectionId}/items/{itemId} [get]
 // @Success 200 {object} models.Item
 func GetItem(c *fiber.Ctx) error {
@@ -216,6 +267,10 @@ func GetItem(c *fiber.Ctx) error {
 		return nil
 	}
 
+	if asOf := c.Query("asof"); asOf != "" {
+		return getItemAsOf(c, item_id, collection_id, asOf)
+	}
+
 	result := &models.Item{}
 	database.DB.Db.Table("items").Where("id = ? AND collection = ?", item_id, collection_id).Find(&result)
 
@@ -232,7 +287,7 @@ func GetItem(c *fiber.Ctx) error {
 		c.Status(http.StatusNotFound).JSON(&fiber.Map{
 			"message": "item does not exist",
 		})
-	} else {
+	} else if result.UpdatedAt == nil || !notModified(c, *result.UpdatedAt) {
 		c.Status(http.StatusOK).JSON(&fiber.Map{
 			"message":    "item retrieved successfully",
 			"id":         result.Id,
@@ -252,6 +307,13 @@ func GetItem(c *fiber.Ctx) error {
 // @Accept  json
 // @Produce  json
 // @Param collectionId path string true "Collection ID"
+// @Param limit query int false "Page size, at most MAX_LIMIT"
+// @Param bbox query string false "Comma separated bbox of 4 or 6 numbers"
+// @Param bbox-crs query string false "CRS of the bbox, CRS84 (default) or EPSG:4326"
+// @Param datetime query string false "Datetime or interval, open ends as .."
+// @Param token query string false "Pagination token from a next or prev link"
+// @Param fields query string false "Comma separated fields to include, or exclude if prefixed with -"
+// @Param asof query string false "RFC 3339 timestamp to get the items as they were then"
 // @Router /collections/{collectionId}/items [get]
 // @Success 200 {object} models.ItemCollection
 func GetItemCollection(c *fiber.Ctx) error {
@@ -262,37 +324,71 @@ func GetItemCollection(c *fiber.Ctx) error {
 		})
 	}
 
-	limit := 100
+	search := models.Search{
+		Collections: []string{collectionID},
+		Datetime:    c.Query("datetime"),
+		Token:       c.Query("token"),
+		Fields:      params.ParseFields(c.Query("fields")),
+		AsOf:        c.Query("asof"),
+	}
+	if limitString := c.Query("limit"); limitString != "" {
+		var err error
+		if search.Limit, err = strconv.Atoi(limitString); err != nil || search.Limit < 1 {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"message": "limit must be a positive integer",
+			})
+		}
+	}
+	bbox, err := params.ParseBbox(c.Query("bbox"))
+	if err == nil {
+		search.Bbox, err = params.BboxToCRS84(bbox, c.Query("bbox-crs"))
+	}
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
 
-	var items []models.Item
-	err := database.DB.Db.Where("collection = ?", collectionID).Limit(limit).Find(&items).Error
+	query, err := BuildSearchQuery(search)
 	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	stacItems, next, prev, err := query.fetchPage()
+	if err != nil {
+		log.Printf("could not retrieve items of collection %s: %v", collectionID, err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 			"message": "failed to retrieve items from collection",
-			"error":   err.Error(),
 		})
 	}
 
-	var stacItems []interface{}
-	for _, item := range items {
-		var itemMap map[string]interface{}
-		if err := json.Unmarshal([]byte(item.Data), &itemMap); err != nil {
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-				"message": "failed to unmarshal item data",
-				"error":   err.Error(),
-			})
-		}
-		stacItems = append(stacItems, itemMap)
+	matched, estimated, err := query.numberMatched(len(stacItems), next)
+	if err != nil {
+		log.Printf("could not count items of collection %s: %v", collectionID, err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"message": "failed to count items in collection",
+		})
 	}
 
 	return c.Status(http.StatusOK).JSON(fiber.Map{
 		"message":    "item collection retrieved successfully",
 		"collection": collectionID,
 		"context": models.Context{
-			Returned: len(items),
-			Limit:    limit,
+			Returned:         len(stacItems),
+			Limit:            query.limit,
+			Matched:          matched,
+			MatchedEstimated: estimated,
 		},
-		"type":     "FeatureCollection",
-		"features": stacItems,
+		"numberMatched":  matched,
+		"numberReturned": len(stacItems),
+		"type":           "FeatureCollection",
+		"features":       stacItems,
+		"links": append([]models.Link{
+			{Rel: "self", Type: "application/geo+json", Href: c.BaseURL() + c.OriginalURL()},
+			{Rel: "root", Type: "application/json", Href: c.BaseURL() + "/"},
+			{Rel: "collection", Type: "application/json", Href: c.BaseURL() + "/collections/" + collectionID},
+		}, getPageLinks(c, next, prev)...),
 	})
 }
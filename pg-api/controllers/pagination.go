@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jonhealy1/goapi-stac/pg-api/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// paginationToken is the opaque state handed to clients in next and prev
+// links. It holds the sort key values of the row a page ends (or, going
+// backwards, starts) at, so the following page can be found with a keyset
+// condition instead of an offset.
+type paginationToken struct {
+	Keys []json.RawMessage `json:"k"`
+	Prev bool              `json:"p,omitempty"`
+}
+
+func encodeToken(token paginationToken) string {
+	b, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeToken(s string) (*paginationToken, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pagination token")
+	}
+	token := &paginationToken{}
+	if err := json.Unmarshal(b, token); err != nil {
+		return nil, fmt.Errorf("invalid pagination token")
+	}
+	return token, nil
+}
+
+// sortKey is one key of the ordering a listing is paginated in.
+type sortKey struct {
+	// Expr is the SQL expression sorted on.
+	Expr string
+	// Type is the SQL type token values are bound as when compared to Expr.
+	Type string
+	Desc bool
+}
+
+// idSortKey is appended to every ordering so that it is total, which the
+// keyset conditions rely on.
+var idSortKey = sortables["id"].key
+
+// defaultSortKeys orders items newest first.
+var defaultSortKeys = []sortKey{
+	{Expr: sortables["properties.datetime"].key.Expr, Type: "timestamptz", Desc: true},
+	idSortKey,
+}
+
+// orderBySQL builds the ORDER BY clause for keys. Reversed, it lists the
+// rows in the opposite order, which is used to fetch a previous page.
+func orderBySQL(keys []sortKey, reverse bool) string {
+	var terms []string
+	for _, key := range keys {
+		desc := key.Desc != reverse
+		term := key.Expr + " ASC"
+		if desc {
+			term = key.Expr + " DESC"
+		}
+		// nulls always sort last going forwards
+		if reverse {
+			term += " NULLS FIRST"
+		} else {
+			term += " NULLS LAST"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, ", ")
+}
+
+// keysetSQL builds the condition selecting the rows that come after the
+// position stored in token, or before it for a token to a previous page.
+func keysetSQL(keys []sortKey, token *paginationToken) (string, []interface{}, error) {
+	if token == nil {
+		return "", nil, nil
+	}
+	if len(token.Keys) != len(keys) {
+		return "", nil, fmt.Errorf("pagination token does not match the sort order")
+	}
+
+	values := make([]interface{}, len(keys))
+	for i, raw := range token.Keys {
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return "", nil, fmt.Errorf("invalid pagination token")
+		}
+		values[i] = value
+	}
+
+	var clauses []string
+	var args []interface{}
+	for i, key := range keys {
+		var terms []string
+		var termArgs []interface{}
+		for j := 0; j < i; j++ {
+			if values[j] == nil {
+				terms = append(terms, keys[j].Expr+" IS NULL")
+			} else {
+				terms = append(terms, fmt.Sprintf("%s = ?::%s", keys[j].Expr, keys[j].Type))
+				termArgs = append(termArgs, values[j])
+			}
+		}
+
+		if values[i] == nil {
+			// nulls sort last, so nothing comes after a null key
+			if !token.Prev {
+				continue
+			}
+			terms = append(terms, key.Expr+" IS NOT NULL")
+		} else {
+			op := ">"
+			if key.Desc != token.Prev {
+				op = "<"
+			}
+			term := fmt.Sprintf("%s %s ?::%s", key.Expr, op, key.Type)
+			if !token.Prev {
+				term = fmt.Sprintf("(%s OR %s IS NULL)", term, key.Expr)
+			}
+			terms = append(terms, term)
+			termArgs = append(termArgs, values[i])
+		}
+
+		clauses = append(clauses, "("+strings.Join(terms, " AND ")+")")
+		args = append(args, termArgs...)
+	}
+
+	if len(clauses) == 0 {
+		return "FALSE", nil, nil
+	}
+	return "(" + strings.Join(clauses, " OR ") + ")", args, nil
+}
+
+// pageKeysSQL builds the expression selecting the sort key values of a row
+// as a JSON array, from which the tokens of the following pages are made.
+func pageKeysSQL(keys []sortKey) string {
+	exprs := make([]string, len(keys))
+	for i, key := range keys {
+		exprs[i] = key.Expr
+	}
+	return "jsonb_build_array(" + strings.Join(exprs, ", ") + ")"
+}
+
+// paginate trims the limit+1 rows fetched for a page to the page itself,
+// restoring their order if they were fetched backwards, and returns the
+// tokens for the next and previous pages, which are empty if there is none.
+func paginate(rows []searchRow, limit int, token *paginationToken) ([]searchRow, string, string) {
+	more := len(rows) > limit
+	if more {
+		rows = rows[:limit]
+	}
+
+	backwards := token != nil && token.Prev
+	if backwards {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	if len(rows) == 0 {
+		return rows, "", ""
+	}
+
+	hasNext, hasPrev := more, token != nil
+	if backwards {
+		hasNext, hasPrev = true, more
+	}
+
+	next, prev := "", ""
+	if hasNext {
+		next = encodeToken(paginationToken{Keys: rowKeys(rows[len(rows)-1])})
+	}
+	if hasPrev {
+		prev = encodeToken(paginationToken{Keys: rowKeys(rows[0]), Prev: true})
+	}
+	return rows, next, prev
+}
+
+func rowKeys(row searchRow) []json.RawMessage {
+	var keys []json.RawMessage
+	json.Unmarshal([]byte(row.PageKeys), &keys)
+	return keys
+}
+
+// getPageLinks builds the next and prev links of a GET listing, which repeat
+// the request's query parameters with a different token.
+func getPageLinks(c *fiber.Ctx, next string, prev string) []models.Link {
+	links := []models.Link{}
+	for _, page := range []struct{ rel, token string }{{"next", next}, {"prev", prev}} {
+		if page.token == "" {
+			continue
+		}
+		query, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+		query.Set("token", page.token)
+		links = append(links, models.Link{
+			Rel:  page.rel,
+			Type: "application/geo+json",
+			Href: c.BaseURL() + c.Path() + "?" + query.Encode(),
+		})
+	}
+	return links
+}
+
+// postPageLinks builds the next and prev links of a POST search. Their body
+// only holds the token and is merged into the original request body.
+func postPageLinks(c *fiber.Ctx, next string, prev string) []models.Link {
+	links := []models.Link{}
+	for _, page := range []struct{ rel, token string }{{"next", next}, {"prev", prev}} {
+		if page.token == "" {
+			continue
+		}
+		links = append(links, models.Link{
+			Rel:    page.rel,
+			Type:   "application/geo+json",
+			Href:   c.BaseURL() + c.Path(),
+			Method: http.MethodPost,
+			Body:   map[string]interface{}{"token": page.token},
+			Merge:  true,
+		})
+	}
+	return links
+}
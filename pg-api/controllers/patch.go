@@ -0,0 +1,242 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jonhealy1/goapi-stac/pg-api/models"
+	"github.com/jonhealy1/goapi-stac/shared/cql2"
+	"github.com/jonhealy1/goapi-stac/shared/patch"
+	"github.com/jonhealy1/goapi-stac/shared/stac"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// statusError is an error answered with a status code.
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// applyPatch applies the patch in the body of a request to a document. Its
+// errors are statusErrors.
+func applyPatch(c *fiber.Ctx, doc []byte) ([]byte, error) {
+	patched, err := patch.Apply(doc, c.Get(fiber.HeaderContentType), c.Body())
+	switch {
+	case err == patch.ErrUnsupportedType:
+		return nil, &statusError{http.StatusUnsupportedMediaType, err.Error()}
+	case errors.Is(err, patch.ErrInvalidPatch):
+		return nil, &statusError{http.StatusBadRequest, err.Error()}
+	case errors.Is(err, patch.ErrConflict):
+		return nil, &statusError{http.StatusConflict, err.Error()}
+	case err != nil:
+		return nil, err
+	}
+	return patched, nil
+}
+
+// writeError answers a request whose write failed.
+func writeError(c *fiber.Ctx, err error, message string) error {
+	var se *statusError
+	if errors.As(err, &se) {
+		return c.Status(se.status).JSON(&fiber.Map{"message": se.message})
+	}
+	return c.Status(http.StatusBadRequest).JSON(&fiber.Map{"message": message})
+}
+
+// validateItem checks a patched item and returns it with its geometry, as
+// stac.ValidateItem does, with the errors of an invalid item answered with
+// 422.
+func validateItem(doc, patched []byte, id, collectionId string) ([]byte, cql2.Geometry, error) {
+	patched, geometry, err := stac.ValidateItem(doc, patched, id, collectionId)
+	return patched, geometry, itemError(err)
+}
+
+// itemError is a statusError answering the errors of an invalid item with
+// 422, and any other error as it is.
+func itemError(err error) error {
+	if errors.Is(err, stac.ErrInvalidItem) {
+		return &statusError{http.StatusUnprocessableEntity, err.Error()}
+	}
+	return err
+}
+
+// validateCollection checks a patched collection, which must keep its id
+// and have a valid extent, and returns it decoded.
+func validateCollection(patched []byte, id string) (*models.StacCollection, error) {
+	invalid := func(format string, args ...interface{}) error {
+		return &statusError{http.StatusUnprocessableEntity, "invalid collection: " + fmt.Sprintf(format, args...)}
+	}
+
+	var collection models.StacCollection
+	if err := json.Unmarshal(patched, &collection); err != nil {
+		return nil, invalid("%v", err)
+	}
+	if collection.Id != id {
+		return nil, invalid("the id cannot change")
+	}
+	for _, bbox := range collection.Extent.Spatial.Bbox {
+		if len(bbox) != 4 && len(bbox) != 6 {
+			return nil, invalid("a bbox of the extent must have 4 or 6 numbers")
+		}
+	}
+	for _, interval := range collection.Extent.Temporal.Interval {
+		if len(interval) != 2 {
+			return nil, invalid("an interval of the extent must have a start and an end")
+		}
+		for _, instant := range interval {
+			if _, err := time.Parse(time.RFC3339, instant); instant != "" && err != nil {
+				return nil, invalid("the interval %v of the extent is not RFC 3339", interval)
+			}
+		}
+	}
+	return &collection, nil
+}
+
+// PatchItem godoc
+// @Summary Patch an Item
+// @Description Change part of a stac item with a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902)
+// @Tags Items
+// @ID patch-item
+// @Accept  application/merge-patch+json,application/json-patch+json
+// @Produce  json
+// @Param collectionId path string true "Collection ID"
+// @Param itemId path string true "Item ID"
+// @Param patch body object true "Merge Patch or JSON Patch"
+// @Param If-Match header string false "ETag the item must still have"
+// @Router /collections/{collectionId}/items/{itemId} [patch]
+// @Success 200 {object} models.StacItem
+func PatchItem(c *fiber.Ctx) error {
+	id := c.Params("itemId")
+	collection_id := c.Params("collectionId")
+
+	var patched []byte
+	var updatedAt time.Time
+	// the item is locked until the patched item is written
+	err := audited(c, func(tx *gorm.DB) error {
+		var row struct {
+			Data      string
+			UpdatedAt time.Time
+		}
+		err := tx.Raw(
+			`SELECT data, updated_at FROM items WHERE id = ? AND collection = ? FOR UPDATE`,
+			id, collection_id,
+		).Scan(&row).Error
+		if err != nil {
+			return err
+		}
+		if row.Data == "" {
+			return &statusError{http.StatusNotFound, "item does not exist"}
+		}
+		if err := checkIfMatch(c, &row.UpdatedAt); err != nil {
+			return err
+		}
+
+		patched, err = applyPatch(c, []byte(row.Data))
+		if err != nil {
+			return err
+		}
+		var geometry cql2.Geometry
+		patched, geometry, err = validateItem([]byte(row.Data), patched, id, collection_id)
+		if err != nil {
+			return err
+		}
+
+		return tx.Raw(
+			`UPDATE items SET data=@data, geometry=ST_GeomFromGeoJSON(@geometry)
+			WHERE id=@id AND collection=@collection
+			RETURNING updated_at`,
+			sql.Named("data", string(patched)),
+			sql.Named("geometry", string(geometry.GeoJSON)),
+			sql.Named("id", id),
+			sql.Named("collection", collection_id),
+		).Scan(&updatedAt).Error
+	})
+	if err != nil {
+		return writeError(c, err, "could not update item")
+	}
+
+	c.Set(fiber.HeaderETag, versionETag(updatedAt))
+	return c.Status(http.StatusOK).JSON(&fiber.Map{
+		"message":    "success",
+		"id":         id,
+		"collection": collection_id,
+		"stac_item":  json.RawMessage(patched),
+	})
+}
+
+// PatchCollection godoc
+// @Summary Patch a Collection
+// @Description Change part of a collection with a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902)
+// @Tags Collections
+// @ID patch-collection
+// @Accept  application/merge-patch+json,application/json-patch+json
+// @Produce  json
+// @Param collectionId path string true "Collection ID"
+// @Param patch body object true "Merge Patch or JSON Patch"
+// @Param If-Match header string false "ETag the collection must still have"
+// @Router /collections/{collectionId} [patch]
+// @Success 200 {object} models.StacCollection
+func PatchCollection(c *fiber.Ctx) error {
+	id := c.Params("collectionId")
+
+	var patched []byte
+	var updatedAt *time.Time
+	err := audited(c, func(tx *gorm.DB) error {
+		// collections are stored as a one element array
+		var row struct {
+			Data      string
+			UpdatedAt time.Time
+		}
+		err := tx.Raw(
+			`SELECT data->0 AS data, updated_at FROM collections WHERE id = ? AND deleted_at IS NULL FOR UPDATE`,
+			id,
+		).Scan(&row).Error
+		if err != nil {
+			return err
+		}
+		if row.Data == "" {
+			return &statusError{http.StatusNotFound, "collection does not exist"}
+		}
+		if err := checkIfMatch(c, &row.UpdatedAt); err != nil {
+			return err
+		}
+
+		patched, err = applyPatch(c, []byte(row.Data))
+		if err != nil {
+			return err
+		}
+		if _, err := validateCollection(patched, id); err != nil {
+			return err
+		}
+
+		err = tx.Exec(`UPDATE collections SET data = jsonb_build_array(CAST(? AS jsonb)) WHERE id = ?`, string(patched), id).Error
+		if err != nil {
+			return err
+		}
+		updatedAt, err = collectionVersion(tx, id)
+		return err
+	})
+	if err != nil {
+		return writeError(c, err, "could not update collection")
+	}
+
+	if updatedAt != nil {
+		c.Set(fiber.HeaderETag, versionETag(*updatedAt))
+	}
+
+	return c.Status(http.StatusOK).JSON(&fiber.Map{
+		"message":         "success",
+		"id":              id,
+		"stac_collection": json.RawMessage(patched),
+	})
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/jonhealy1/goapi-stac/pg-api/database"
+	"github.com/jonhealy1/goapi-stac/pg-api/models"
+	"github.com/jonhealy1/goapi-stac/shared/params"
+	"github.com/jonhealy1/goapi-stac/shared/queryables"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// pgQueryables reads the queryables from the items and collections tables.
+type pgQueryables struct{}
+
+// Sample types the properties of a sample of the items by the JSON types
+// of their values.
+func (pgQueryables) Sample(collections []string) (map[string][]string, error) {
+	where, args := "", []interface{}{}
+	if len(collections) > 0 {
+		where, args = " WHERE items.collection IN ?", append(args, collections)
+	}
+	args = append(args, queryables.SampleSize)
+
+	var rows []struct {
+		Key  string
+		Type string
+	}
+	sql := "SELECT property.key AS key, jsonb_typeof(property.value) AS type" +
+		" FROM (SELECT items.data FROM items" + where + " LIMIT ?) AS sample," +
+		" jsonb_each(CASE WHEN jsonb_typeof(sample.data->'properties') = 'object' THEN sample.data->'properties' END) AS property" +
+		" GROUP BY 1, 2"
+	if err := database.DB.Db.Raw(sql, args...).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	types := map[string][]string{}
+	for _, row := range rows {
+		types[row.Key] = append(types[row.Key], row.Type)
+	}
+	return types, nil
+}
+
+// Summaries returns the summaries of the collections, or of every
+// collection.
+func (pgQueryables) Summaries(collections []string) ([]map[string]interface{}, error) {
+	query := database.DB.Db.Model(&models.Collection{})
+	if len(collections) > 0 {
+		query = query.Where("id IN ?", collections)
+	}
+	var rows []string
+	if err := query.Where("data->0->'summaries' IS NOT NULL").Pluck("data->0->'summaries'", &rows).Error; err != nil {
+		return nil, err
+	}
+
+	var summaries []map[string]interface{}
+	for _, s := range rows {
+		var summary map[string]interface{}
+		if err := json.Unmarshal([]byte(s), &summary); err == nil {
+			summaries = append(summaries, summary)
+		}
+	}
+	return summaries, nil
+}
+
+// checkQueryables rejects a search whose filter references properties that
+// are not queryables of the collections searched, or, if the queryables
+// are lenient, properties the overrides hide.
+func checkQueryables(search models.Search) error {
+	filter, err := params.Filter(search.Filter, search.FilterLang, search.FilterCrs)
+	if err != nil || filter == nil {
+		return err
+	}
+
+	available, err := queryables.Get(pgQueryables{}, search.Collections)
+	if err != nil {
+		return err
+	}
+	return available.Check(filter)
+}
+
+// queryablesError responds to a search checkQueryables rejected: with a
+// 400 if its filter references an unknown queryable, and with a 500 if the
+// queryables could not be computed. The filter is parsed by BuildSearchQuery
+// first, so it is known to be valid.
+func queryablesError(c *fiber.Ctx, err error) error {
+	if _, ok := err.(*queryables.UnknownError); ok {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+		"message": "could not get queryables",
+	})
+}
+
+func queryablesResponse(c *fiber.Ctx, collections []string) error {
+	q, err := queryables.Get(pgQueryables{}, collections)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"message": "could not get queryables",
+		})
+	}
+	return c.Status(http.StatusOK).JSON(q.Schema(c.BaseURL() + c.Path()))
+}
+
+// Queryables godoc
+// @Summary Get the queryables
+// @Description Get the properties filters can use across all collections
+// @Tags Search
+// @ID get-queryables
+// @Produce  json
+// @Router /queryables [get]
+func Queryables(c *fiber.Ctx) error {
+	return queryablesResponse(c, nil)
+}
+
+// CollectionQueryables godoc
+// @Summary Get the queryables of a collection
+// @Description Get the properties filters on the items of a collection can use
+// @Tags Collections
+// @ID get-collection-queryables
+// @Produce  json
+// @Param collectionId path string true "Collection ID"
+// @Router /collections/{collectionId}/queryables [get]
+func CollectionQueryables(c *fiber.Ctx) error {
+	id := c.Params("collectionId")
+
+	var count int64
+	if err := database.DB.Db.Model(&models.Collection{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"message": "could not get collection",
+		})
+	}
+	if count == 0 {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"message": fmt.Sprintf("collection %s does not exist", id),
+		})
+	}
+	return queryablesResponse(c, []string{id})
+}
@@ -7,8 +7,8 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/jonhealy1/goapi-stac/pg-api/database"
 	"github.com/jonhealy1/goapi-stac/pg-api/models"
+	"github.com/jonhealy1/goapi-stac/shared/params"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -20,116 +20,29 @@ import (
 // @ID get-search
 // @Accept  json
 // @Produce  json
-// @Param bbox1, bbox2, bbox3, bbox4 path float true "Bbox"
+// @Param bbox query string false "Comma separated bbox"
+// @Param collections query string false "Comma separated collection IDs"
+// @Param ids query string false "Comma separated item IDs"
+// @Param intersects query string false "GeoJSON geometry the items intersect"
+// @Param limit query int false "Page size"
+// @Param filter query string false "CQL2 filter"
+// @Param filter-lang query string false "cql2-text (default) or cql2-json"
+// @Param datetime query string false "RFC 3339 instant or interval"
+// @Param token query string false "Pagination token from a next or prev link"
+// @Param fields query string false "Comma separated fields to include, or exclude if prefixed with -"
+// @Param query query string false "JSON query extension object"
+// @Param sortby query string false "Comma separated fields to sort by, descending if prefixed with -"
+// @Param q query string false "Free-text query, matched most relevant first"
+// @Param asof query string false "RFC 3339 timestamp to search the items as they were then"
 // @Router /search [get]
 func GetSearch(c *fiber.Ctx) error {
-	var items []models.Item
-	var search models.Search
-	var searchMap models.SearchMap
-	geoString := ""
-
-	bboxString := c.Query("bbox")
-	collectionsString := c.Query("collections")
-	limitString := c.Query("limit")
-	geometryString := c.Query("geometry")
-
-	geomType := ""
-	line := [][2]float64{}
-	point := models.GeoJSONPoint{}.Coordinates
-	if geometryString != "" {
-		geomSlice := strings.FieldsFunc(geometryString, split)
-		fmt.Println(geomSlice)
-		geomType = geomSlice[1]
-		if geomType == "Point" {
-			point[0], _ = strconv.ParseFloat(geomSlice[3], 32)
-			point[1], _ = strconv.ParseFloat(geomSlice[4], 32)
-			searchMap.Geometry = 1
-		}
-		if geomType == "LineString" {
-			val1, _ := strconv.ParseFloat(geomSlice[3], 32)
-			val2, _ := strconv.ParseFloat(geomSlice[4], 32)
-			val3, _ := strconv.ParseFloat(geomSlice[5], 32)
-			val4, _ := strconv.ParseFloat(geomSlice[6], 32)
-
-			line = [][2]float64{
-				{val1, val2},
-				{val3, val4},
-			}
-			searchMap.Geometry = 1
-		}
-	}
-
-	limit := 100
-	if limitString != "" {
-		limit, _ = strconv.Atoi(limitString)
-	}
-	fmt.Println("limit: ", limit)
-
-	if bboxString != "" {
-		searchMap.Geometry = 1
-	}
-
-	if collectionsString != "" {
-		searchMap.Collections = 1
-		collections := strings.Split(collectionsString, ",")
-		for i := 0; i < len(collections); i++ {
-			search.Collections = append(search.Collections, collections[i])
-		}
-	}
-
-	searchString := sQLString(searchMap)
-
-	searchString += fmt.Sprintf("LIMIT %d", limit)
-
-	if searchMap.Geometry == 1 {
-		if bboxString != "" {
-			bbox := strings.Split(bboxString, ",")
-
-			b1, _ := strconv.ParseFloat(bbox[0], 32)
-			b2, _ := strconv.ParseFloat(bbox[1], 32)
-			b3, _ := strconv.ParseFloat(bbox[2], 32)
-			b4, _ := strconv.ParseFloat(bbox[3], 32)
-
-			search.Bbox = append(search.Bbox, b1, b2, b3, b4)
-			geoString = bbox2polygon(search.Bbox)
-		} else if geometryString != "" {
-			if geomType == "Point" {
-				geoString = pointString(point)
-			} else if geomType == "LineString" {
-				geoString = lineString(line)
-			}
-		}
-
-		encodedString := toWKT(geoString)
-
-		if len(search.Collections) > 0 {
-			database.DB.Db.Raw(searchString, encodedString, search.Collections).Scan(&items)
-		} else {
-			database.DB.Db.Raw(searchString, encodedString).Scan(&items)
-		}
-	} else if len(search.Collections) > 0 {
-		database.DB.Db.Raw(searchString, search.Collections, limit).Scan(&items)
-	}
-
-	context := models.Context{
-		Returned: len(items),
-		Limit:    limit,
-	}
-
-	var stac_items []interface{}
-	for _, a_item := range items {
-		var itemMap map[string]interface{}
-		json.Unmarshal([]byte(a_item.Data), &itemMap)
-		stac_items = append(stac_items, itemMap)
+	search, err := getSearchParams(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"message": err.Error(),
+		})
 	}
-
-	c.Status(http.StatusOK).JSON(&fiber.Map{
-		"context":  context,
-		"type":     "FeatureCollection",
-		"features": stac_items,
-	})
-
-	return nil
+	return runSearch(c, search, getPageLinks)
 }
 
 // PostSearch godoc
@@ -143,8 +56,6 @@ func GetSearch(c *fiber.Ctx) error {
 // @Router /search [post]
 func PostSearch(c *fiber.Ctx) error {
 	var search models.Search
-	var items []models.Item
-	var searchMap models.SearchMap
 
 	if err := c.BodyParser(&search); err != nil {
 		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
@@ -153,100 +64,104 @@ func PostSearch(c *fiber.Ctx) error {
 			"Data":    err.Error(),
 		})
 	}
-	limit := 100
-	if search.Limit > 0 {
-		limit = search.Limit
-	}
+	return runSearch(c, search, postPageLinks)
+}
 
-	bbox := fix3dBbox(search)
+// getSearchParams reads the query parameters of a GET search into the
+// same form a POST search body takes.
+func getSearchParams(c *fiber.Ctx) (models.Search, error) {
+	var search models.Search
 
-	if len(bbox) == 4 || search.Geometry.Type == "Point" ||
-		search.Geometry.Type == "Polygon" || search.Geometry.Type == "LineString" {
-		searchMap.Geometry = 1
+	if collections := c.Query("collections"); collections != "" {
+		search.Collections = strings.Split(collections, ",")
 	}
-	if len(search.Collections) > 0 {
-		searchMap.Collections = 1
+	if ids := c.Query("ids"); ids != "" {
+		search.Ids = strings.Split(ids, ",")
 	}
-	if len(search.Ids) > 0 {
-		searchMap.Ids = 1
+
+	if limit := c.Query("limit"); limit != "" {
+		var err error
+		if search.Limit, err = strconv.Atoi(limit); err != nil || search.Limit < 1 {
+			return search, fmt.Errorf("limit must be a positive integer")
+		}
 	}
-	searchString := sQLString(searchMap)
 
-	if len(search.Sortby) > 0 {
-		searchString = BuildSortString(searchString, search)
+	var err error
+	if search.Bbox, err = params.ParseBbox(c.Query("bbox")); err != nil {
+		return search, err
 	}
 
-	searchString += fmt.Sprintf(" LIMIT %d", limit)
-
-	if searchMap.Geometry == 1 {
-		geoString := ""
-		if len(bbox) == 4 {
-			geoString = bbox2polygon(bbox)
-		} else if search.Geometry.Type == "Point" {
-			geom := models.GeoJSONPoint{}.Coordinates
-			json.Unmarshal(search.Geometry.Coordinates, &geom)
-			geoString = pointString(geom)
-		} else if search.Geometry.Type == "Polygon" {
-			geom := models.GeoJSONPolygon{}.Coordinates
-			json.Unmarshal(search.Geometry.Coordinates, &geom)
-			geoString = polygonString(geom)
-		} else if search.Geometry.Type == "LineString" {
-			geom := models.GeoJSONLine{}.Coordinates
-			json.Unmarshal(search.Geometry.Coordinates, &geom)
-			geoString = lineString(geom)
-		}
+	if intersects := c.Query("intersects", c.Query("geometry")); intersects != "" {
+		search.Intersects = json.RawMessage(intersects)
+	}
 
-		encodedString := toWKT(geoString)
+	search.Q = models.FreeText(c.Query("q"))
+	search.Datetime = c.Query("datetime")
+	search.Token = c.Query("token")
+	search.Fields = params.ParseFields(c.Query("fields"))
+	search.Sortby = params.ParseSortby(c.Query("sortby"))
+	search.AsOf = c.Query("asof")
 
-		if searchMap.Collections == 1 && searchMap.Ids == 1 {
-			database.DB.Db.Raw(searchString, encodedString, search.Collections, search.Ids).Scan(&items)
-		} else if searchMap.Ids == 1 {
-			database.DB.Db.Raw(searchString, encodedString, search.Ids).Scan(&items)
-		} else if searchMap.Collections == 1 {
-			database.DB.Db.Raw(searchString, encodedString, search.Collections).Scan(&items)
-		} else {
-			database.DB.Db.Raw(searchString, encodedString, limit).Scan(&items)
-		}
-	} else if searchMap.Collections == 1 || searchMap.Ids == 1 {
-		tx1 := database.DB.Db.Limit(limit)
-		tx2 := database.DB.Db.Limit(limit)
-		if len(search.Collections) > 0 {
-			tx1 = database.DB.Db.Limit(limit).Where("collection IN ?", search.Collections)
-			tx2 = tx1.Limit(limit)
-			fmt.Println(tx1)
+	if query := c.Query("query"); query != "" {
+		if err := json.Unmarshal([]byte(query), &search.Query); err != nil {
+			return search, fmt.Errorf("query must be a JSON object of property comparisons")
 		}
+	}
 
-		if len(search.Ids) > 0 {
-			tx2 = tx1.Limit(limit).Where("id IN ?", search.Ids)
-			fmt.Println(tx1)
+	if filter := c.Query("filter"); filter != "" {
+		search.FilterLang = c.Query("filter-lang", "cql2-text")
+		search.FilterCrs = c.Query("filter-crs")
+		if search.FilterLang == "cql2-text" {
+			// a POST body holds cql2-text as a JSON string
+			search.Filter, _ = json.Marshal(filter)
+		} else {
+			search.Filter = json.RawMessage(filter)
 		}
+	}
 
-		err := tx2.Find(&items).Error
+	return search, nil
+}
 
-		if err != nil {
-			c.Status(http.StatusBadRequest).JSON(
-				&fiber.Map{"message": "could not get items"})
-			return err
-		}
+// runSearch runs a GET or POST search and responds with the requested page
+// of items. links builds the next and prev links for the request's method.
+func runSearch(c *fiber.Ctx, search models.Search, links func(*fiber.Ctx, string, string) []models.Link) error {
+	query, err := BuildSearchQuery(search)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"message": err.Error(),
+		})
+	}
+	if err := checkQueryables(search); err != nil {
+		return queryablesError(c, err)
 	}
 
-	context := models.Context{
-		Returned: len(items),
-		Limit:    limit,
+	stac_items, next, prev, err := query.fetchPage()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"message": "could not get items",
+		})
 	}
 
-	var stac_items []interface{}
-	for _, a_item := range items {
-		var itemMap map[string]interface{}
-		json.Unmarshal([]byte(a_item.Data), &itemMap)
-		stac_items = append(stac_items, itemMap)
+	matched, estimated, err := query.numberMatched(len(stac_items), next)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"message": "could not count items",
+		})
 	}
 
-	c.Status(http.StatusOK).JSON(&fiber.Map{
-		"context":  context,
-		"type":     "FeatureCollection",
-		"features": stac_items,
+	context := models.Context{
+		Returned:         len(stac_items),
+		Limit:            query.limit,
+		Matched:          matched,
+		MatchedEstimated: estimated,
+	}
+
+	return c.Status(http.StatusOK).JSON(&fiber.Map{
+		"context":        context,
+		"numberMatched":  matched,
+		"numberReturned": len(stac_items),
+		"type":           "FeatureCollection",
+		"features":       stac_items,
+		"links":          links(c, next, prev),
 	})
-
-	return nil
 }
@@ -0,0 +1,276 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jonhealy1/goapi-stac/pg-api/cql2sql"
+	"github.com/jonhealy1/goapi-stac/pg-api/database"
+	"github.com/jonhealy1/goapi-stac/pg-api/models"
+	"github.com/jonhealy1/goapi-stac/shared/cql2"
+	"github.com/jonhealy1/goapi-stac/shared/freetext"
+	"github.com/jonhealy1/goapi-stac/shared/params"
+)
+
+// defaultLimit is the page size of a search that does not set one, the
+// same as in the elasticsearch api.
+const defaultLimit = 100
+
+// defaultMaxLimit is the maxLimit used when MAX_LIMIT is not set.
+const defaultMaxLimit = 10000
+
+// maxLimit is the largest page size. Searches asking for more get a page
+// of maxLimit items, as OGC API Features requires.
+var maxLimit = params.EnvInt("MAX_LIMIT", defaultMaxLimit)
+
+// pageLimit returns the page size of a search asking for limit items, 0
+// meaning it sets none.
+func pageLimit(limit int) int {
+	if limit <= 0 {
+		return defaultLimit
+	}
+	if limit > maxLimit {
+		return maxLimit
+	}
+	return limit
+}
+
+// SearchQuery is the SQL query planned for a search of items, or of
+// collections. Its predicates are ANDed together, and every value, including
+// the limit, is bound as a ? placeholder.
+type SearchQuery struct {
+	// from is the table searched, items or collections, or a relation
+	// standing in for it with the arguments of its placeholders.
+	from     string
+	fromArgs []interface{}
+	// data is the expression of the JSON returned for a row. If it is empty
+	// the item JSON is returned, projected to fields.
+	data       string
+	joins      []string
+	joinArgs   []interface{}
+	conditions []string
+	args       []interface{}
+	keyset     string
+	keysetArgs []interface{}
+	sortKeys   []sortKey
+	token      *paginationToken
+	limit      int
+	fields     models.Fields
+}
+
+// where adds a predicate and the arguments of its placeholders.
+func (q *SearchQuery) where(condition string, args ...interface{}) {
+	if condition == "" {
+		return
+	}
+	q.conditions = append(q.conditions, condition)
+	q.args = append(q.args, args...)
+}
+
+// join adds a relation to the FROM clause, and the arguments of its
+// placeholders.
+func (q *SearchQuery) join(relation string, args ...interface{}) {
+	q.joins = append(q.joins, relation)
+	q.joinArgs = append(q.joinArgs, args...)
+}
+
+// SQL returns the query and its arguments in placeholder order. Each row
+// holds the item JSON, projected to the requested fields, and the values of
+// its sort keys. It fetches one item more than the page holds, which tells
+// whether there is a next page.
+func (q *SearchQuery) SQL() (string, []interface{}) {
+	data, args := q.data, []interface{}(nil)
+	if data == "" {
+		data, args = fieldsSQL(q.fields)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("SELECT ")
+	sb.WriteString(data)
+	sb.WriteString(" AS data, ")
+	sb.WriteString(pageKeysSQL(q.sortKeys))
+	sb.WriteString(" AS page_keys")
+	from, fromArgs := q.fromSQL(true)
+	sb.WriteString(from)
+	sb.WriteString(" ORDER BY ")
+	sb.WriteString(orderBySQL(q.sortKeys, q.token != nil && q.token.Prev))
+	sb.WriteString(" LIMIT ?")
+
+	args = append(args, fromArgs...)
+	return sb.String(), append(args, q.limit+1)
+}
+
+// fromSQL returns the FROM and WHERE clauses of the query, restricted to
+// the requested page by its keyset condition or not.
+func (q *SearchQuery) fromSQL(page bool) (string, []interface{}) {
+	conditions := q.conditions
+	args := append(append(append([]interface{}{}, q.fromArgs...), q.joinArgs...), q.args...)
+	if page && q.keyset != "" {
+		conditions = append(append([]string{}, conditions...), q.keyset)
+		args = append(args, q.keysetArgs...)
+	}
+
+	from := " FROM " + strings.Join(append([]string{q.from}, q.joins...), ", ")
+	if len(conditions) == 0 {
+		return from, args
+	}
+	return from + " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// searchRow is a row of a search query.
+type searchRow struct {
+	Data     string
+	PageKeys string
+}
+
+// fetchPage runs the query and returns the items of the page together with
+// the tokens for the next and previous pages.
+func (q *SearchQuery) fetchPage() ([]map[string]interface{}, string, string, error) {
+	var rows []searchRow
+	sql, args := q.SQL()
+	if err := database.DB.Db.Raw(sql, args...).Scan(&rows).Error; err != nil {
+		return nil, "", "", err
+	}
+
+	rows, next, prev := paginate(rows, q.limit, q.token)
+
+	items := []map[string]interface{}{}
+	for _, row := range rows {
+		var item map[string]interface{}
+		if err := json.Unmarshal([]byte(row.Data), &item); err != nil {
+			return nil, "", "", err
+		}
+		items = append(items, item)
+	}
+	return items, next, prev, nil
+}
+
+// BuildSearchQuery plans the query for a search, combining whichever of
+// its free-text query, ids, collections, bbox or intersects, datetime and
+// filter are set with the sort order, the position of the requested page and
+// the fields to return. Free-text searches are sorted by relevance unless
+// they set a sortby. A search with an asof timestamp runs against the items
+// as they were then.
+func BuildSearchQuery(search models.Search) (*SearchQuery, error) {
+	sortKeys, err := searchSortKeys(search)
+	if err != nil {
+		return nil, err
+	}
+	q := &SearchQuery{from: "items", limit: pageLimit(search.Limit), sortKeys: sortKeys, fields: search.Fields}
+
+	if search.AsOf != "" {
+		asOf, err := parseAsOf(search.AsOf)
+		if err != nil {
+			return nil, err
+		}
+		q.from, q.fromArgs = itemsAsOfSQL, []interface{}{asOf}
+	}
+
+	if search.Q != "" {
+		text, err := freetext.Parse(string(search.Q))
+		if err != nil {
+			return nil, err
+		}
+		tsquery, args := tsquerySQL(text)
+		q.join("(SELECT "+tsquery+" AS query) AS text_query", args...)
+		q.where("items.search @@ text_query.query")
+		if len(search.Sortby) == 0 {
+			q.sortKeys = []sortKey{relevanceSortKey, idSortKey}
+		}
+	}
+
+	if len(search.Ids) > 0 {
+		q.where("items.id IN ?", search.Ids)
+	}
+	if len(search.Collections) > 0 {
+		q.where("items.collection IN ?", search.Collections)
+	}
+
+	if err := q.whereIntersects(search); err != nil {
+		return nil, err
+	}
+
+	filter, filterArgs, err := searchFilter(search)
+	if err != nil {
+		return nil, err
+	}
+	q.where(filter, filterArgs...)
+
+	if q.token, err = decodeToken(search.Token); err != nil {
+		return nil, err
+	}
+	if q.keyset, q.keysetArgs, err = keysetSQL(q.sortKeys, q.token); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// whereIntersects adds the predicate selecting the items that intersect
+// the bbox or the intersects geometry of a search.
+func (q *SearchQuery) whereIntersects(search models.Search) error {
+	intersects := search.Intersects
+	if len(intersects) == 0 {
+		intersects = search.Geometry
+	}
+	if string(intersects) == "null" {
+		intersects = nil
+	}
+
+	if len(search.Bbox) > 0 {
+		if len(intersects) > 0 {
+			return fmt.Errorf("only one of bbox and intersects can be given")
+		}
+		return q.whereBbox(search.Bbox)
+	}
+
+	if len(intersects) > 0 {
+		geometry, err := cql2.ParseGeoJSON(intersects)
+		if err != nil {
+			return fmt.Errorf("invalid intersects: %v", err)
+		}
+		q.where("ST_Intersects(items.geometry, ST_SetSRID(ST_GeomFromGeoJSON(?::text), 4326))", string(geometry.GeoJSON))
+	}
+	return nil
+}
+
+// whereBbox adds the predicate selecting the items that intersect a bbox.
+func (q *SearchQuery) whereBbox(bbox []float64) error {
+	condition, args, err := bboxSQL("items.geometry", bbox)
+	if err != nil {
+		return err
+	}
+	q.where(condition, args...)
+	return nil
+}
+
+// bboxSQL builds the condition selecting the rows whose geometry column
+// intersects a bbox of 4 or 6 numbers, split as cql2sql.Envelopes splits
+// it, as a filter on a BBOX is.
+func bboxSQL(column string, bbox []float64) (string, []interface{}, error) {
+	if len(bbox) == 6 {
+		bbox = []float64{bbox[0], bbox[1], bbox[3], bbox[4]}
+	}
+	if len(bbox) != 4 {
+		return "", nil, fmt.Errorf("bbox must have 4 or 6 numbers")
+	}
+	west, south, east, north := bbox[0], bbox[1], bbox[2], bbox[3]
+	if south > north {
+		return "", nil, fmt.Errorf("the south edge of a bbox cannot lie north of its north edge")
+	}
+	if west < -180 || east > 180 || south < -90 || north > 90 || east < -180 || west > 180 {
+		return "", nil, fmt.Errorf("bbox %v is outside of CRS84 bounds", bbox)
+	}
+
+	var conditions []string
+	var args []interface{}
+	for _, envelope := range cql2sql.Envelopes(bbox) {
+		conditions = append(conditions, "ST_Intersects("+column+", ST_MakeEnvelope(?::float8, ?::float8, ?::float8, ?::float8, 4326))")
+		args = append(args, envelope[0], envelope[1], envelope[2], envelope[3])
+	}
+	if len(conditions) == 1 {
+		return conditions[0], args, nil
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", args, nil
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jonhealy1/goapi-stac/pg-api/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// sortable is a field searches can be sorted by. Only these fields are
+// accepted in sortby, so the SQL of a sort key never comes from a request.
+type sortable struct {
+	key    sortKey
+	schema map[string]interface{}
+}
+
+func textProperty(name string) string {
+	return fmt.Sprintf("(items.data->'properties'->>'%s')", name)
+}
+
+// numberProperty reads a number property, treating values of another type
+// as NULL so they sort last instead of failing the cast.
+func numberProperty(name string) string {
+	return fmt.Sprintf("(CASE WHEN jsonb_typeof(items.data->'properties'->'%[1]s') = 'number' THEN (items.data->'properties'->'%[1]s')::float8 END)", name)
+}
+
+// timestampProperty reads a property through safe_timestamptz, as filters
+// do, so that a value that is no timestamp sorts last instead of failing
+// the cast.
+func timestampProperty(name string) string {
+	return fmt.Sprintf("safe_timestamptz(items.data->'properties'->>'%s')", name)
+}
+
+var (
+	stringSchema   = map[string]interface{}{"type": "string"}
+	numberSchema   = map[string]interface{}{"type": "number"}
+	datetimeSchema = map[string]interface{}{"type": "string", "format": "date-time"}
+)
+
+// sortables lists the fields searches can be sorted by, each compared as
+// its own type.
+var sortables = map[string]sortable{
+	"id":                        {sortKey{Expr: "items.id", Type: "text"}, stringSchema},
+	"collection":                {sortKey{Expr: "items.collection", Type: "text"}, stringSchema},
+	"properties.datetime":       {sortKey{Expr: "items.datetime", Type: "timestamptz"}, datetimeSchema},
+	"properties.start_datetime": {sortKey{Expr: "items.start_datetime", Type: "timestamptz"}, datetimeSchema},
+	"properties.end_datetime":   {sortKey{Expr: "items.end_datetime", Type: "timestamptz"}, datetimeSchema},
+	"properties.created":        {sortKey{Expr: timestampProperty("created"), Type: "timestamptz"}, datetimeSchema},
+	"properties.updated":        {sortKey{Expr: timestampProperty("updated"), Type: "timestamptz"}, datetimeSchema},
+	"properties.platform":       {sortKey{Expr: textProperty("platform"), Type: "text"}, stringSchema},
+	"properties.constellation":  {sortKey{Expr: textProperty("constellation"), Type: "text"}, stringSchema},
+	"properties.gsd":            {sortKey{Expr: numberProperty("gsd"), Type: "float8"}, numberSchema},
+	"properties.eo:cloud_cover": {sortKey{Expr: numberProperty("eo:cloud_cover"), Type: "float8"}, numberSchema},
+}
+
+// sortableName returns the name of the sortable a sortby field refers to.
+// Properties may be given without their "properties." prefix.
+func sortableName(field string) string {
+	if _, ok := sortables[field]; ok {
+		return field
+	}
+	return "properties." + field
+}
+
+// searchSortKeys returns the keys a search is ordered by. The id is always
+// the last key, which makes the order total.
+func searchSortKeys(search models.Search) ([]sortKey, error) {
+	if len(search.Sortby) == 0 {
+		return defaultSortKeys, nil
+	}
+
+	var keys []sortKey
+	hasID := false
+	for _, sortby := range search.Sortby {
+		name := sortableName(sortby.Field)
+		s, ok := sortables[name]
+		if !ok {
+			return nil, fmt.Errorf("cannot sort by %q, see /sortables for the fields that can be sorted by", sortby.Field)
+		}
+
+		key := s.key
+		switch strings.ToLower(sortby.Direction) {
+		case "", "asc":
+		case "desc":
+			key.Desc = true
+		default:
+			return nil, fmt.Errorf("sort direction must be asc or desc, got %q", sortby.Direction)
+		}
+
+		hasID = hasID || name == "id"
+		keys = append(keys, key)
+	}
+	if !hasID {
+		keys = append(keys, idSortKey)
+	}
+	return keys, nil
+}
+
+// Sortables godoc
+// @Summary Get the sortables
+// @Description Get the fields searches can be sorted by
+// @Tags Search
+// @ID get-sortables
+// @Produce  json
+// @Router /sortables [get]
+func Sortables(c *fiber.Ctx) error {
+	properties := map[string]interface{}{}
+	for name, s := range sortables {
+		properties[name] = s.schema
+	}
+
+	return c.Status(http.StatusOK).JSON(&fiber.Map{
+		"$schema":    "https://json-schema.org/draft/2019-09/schema",
+		"$id":        c.BaseURL() + "/sortables",
+		"type":       "object",
+		"title":      "Sortables",
+		"properties": properties,
+	})
+}
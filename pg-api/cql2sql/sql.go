@@ -0,0 +1,416 @@
+package cql2sql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jonhealy1/goapi-stac/shared/cql2"
+)
+
+type column struct {
+	name    string
+	sqlType string
+}
+
+// columns maps the queryables that have their own column in the items
+// table. All other properties are read from the item's JSONB properties.
+var columns = map[string]column{
+	"id":             {"items.id", "text"},
+	"collection":     {"items.collection", "text"},
+	"datetime":       {"items.datetime", "timestamptz"},
+	"start_datetime": {"items.start_datetime", "timestamptz"},
+	"end_datetime":   {"items.end_datetime", "timestamptz"},
+}
+
+var sqlSpatialFuncs = map[string]string{
+	"s_intersects": "ST_Intersects",
+	"s_equals":     "ST_Equals",
+	"s_disjoint":   "ST_Disjoint",
+	"s_touches":    "ST_Touches",
+	"s_within":     "ST_Within",
+	"s_overlaps":   "ST_Overlaps",
+	"s_crosses":    "ST_Crosses",
+	"s_contains":   "ST_Contains",
+}
+
+// sqlTemporalConditions expresses each temporal operator as a condition on
+// the start (S) and end (E) instants of its operands a and b, following the
+// definitions in the CQL2 standard.
+var sqlTemporalConditions = map[string]string{
+	"t_after":        "{aS} > {bE}",
+	"t_before":       "{aE} < {bS}",
+	"t_contains":     "{aS} < {bS} AND {aE} > {bE}",
+	"t_disjoint":     "({aS} > {bE} OR {aE} < {bS})",
+	"t_during":       "{aS} > {bS} AND {aE} < {bE}",
+	"t_equals":       "{aS} = {bS} AND {aE} = {bE}",
+	"t_finishedBy":   "{aS} < {bS} AND {aE} = {bE}",
+	"t_finishes":     "{aS} > {bS} AND {aE} = {bE}",
+	"t_intersects":   "{aS} <= {bE} AND {aE} >= {bS}",
+	"t_meets":        "{aE} = {bS}",
+	"t_metBy":        "{aS} = {bE}",
+	"t_overlappedBy": "{aS} > {bS} AND {aS} < {bE} AND {aE} > {bE}",
+	"t_overlaps":     "{aS} < {bS} AND {aE} > {bS} AND {aE} < {bE}",
+	"t_startedBy":    "{aS} = {bS} AND {aE} > {bE}",
+	"t_starts":       "{aS} = {bS} AND {aE} < {bE}",
+}
+
+// ToSQL translates a filter expression into a boolean SQL condition on the
+// items table. Every value, including property names, is bound as a ?
+// placeholder, and the returned arguments are in placeholder order.
+func ToSQL(e cql2.Expr) (string, []interface{}, error) {
+	w := &sqlWriter{}
+	if err := w.predicate(e); err != nil {
+		return "", nil, err
+	}
+	return w.sb.String(), w.args, nil
+}
+
+type sqlWriter struct {
+	sb   strings.Builder
+	args []interface{}
+}
+
+func (w *sqlWriter) write(parts ...string) {
+	for _, part := range parts {
+		w.sb.WriteString(part)
+	}
+}
+
+// bind writes a placeholder for value, cast to the given SQL type.
+func (w *sqlWriter) bind(value interface{}, sqlType string) {
+	w.sb.WriteString("?")
+	if sqlType != "" {
+		w.sb.WriteString("::" + sqlType)
+	}
+	w.args = append(w.args, value)
+}
+
+func (w *sqlWriter) predicate(e cql2.Expr) error {
+	switch v := e.(type) {
+	case cql2.Logical:
+		w.write("(")
+		for i, arg := range v.Args {
+			if i > 0 {
+				w.write(" ", strings.ToUpper(v.Op), " ")
+			}
+			if err := w.predicate(arg); err != nil {
+				return err
+			}
+		}
+		w.write(")")
+	case cql2.Not:
+		w.write("NOT (")
+		if err := w.predicate(v.Arg); err != nil {
+			return err
+		}
+		w.write(")")
+	case cql2.Literal:
+		if v.Value == true {
+			w.write("TRUE")
+		} else {
+			w.write("FALSE")
+		}
+	case cql2.Comparison:
+		sqlType, err := valueType(v.Left, v.Right)
+		if err != nil {
+			return err
+		}
+		if err := w.operand(v.Left, sqlType); err != nil {
+			return err
+		}
+		w.write(" ", v.Op, " ")
+		return w.operand(v.Right, sqlType)
+	case cql2.Like:
+		if err := w.operand(v.Value, "text"); err != nil {
+			return err
+		}
+		w.write(" LIKE ")
+		return w.operand(v.Pattern, "text")
+	case cql2.In:
+		sqlType, err := valueType(append([]cql2.Expr{v.Value}, v.List...)...)
+		if err != nil {
+			return err
+		}
+		if err := w.operand(v.Value, sqlType); err != nil {
+			return err
+		}
+		w.write(" IN (")
+		for i, item := range v.List {
+			if i > 0 {
+				w.write(", ")
+			}
+			if err := w.operand(item, sqlType); err != nil {
+				return err
+			}
+		}
+		w.write(")")
+	case cql2.Between:
+		sqlType, err := valueType(v.Value, v.Low, v.High)
+		if err != nil {
+			return err
+		}
+		if err := w.operand(v.Value, sqlType); err != nil {
+			return err
+		}
+		w.write(" BETWEEN ")
+		if err := w.operand(v.Low, sqlType); err != nil {
+			return err
+		}
+		w.write(" AND ")
+		return w.operand(v.High, sqlType)
+	case cql2.IsNull:
+		p, ok := v.Value.(cql2.Property)
+		if !ok {
+			return fmt.Errorf("isNull requires a property")
+		}
+		name := cql2.PropertyName(p)
+		if column, ok := columns[name]; ok {
+			w.write(column.name, " IS NULL")
+			return nil
+		}
+		w.write("COALESCE(jsonb_typeof(")
+		w.jsonProperty(name)
+		w.write("), 'null') = 'null'")
+	case cql2.Spatial:
+		w.write(sqlSpatialFuncs[v.Op], "(")
+		if err := w.geometry(v.Left); err != nil {
+			return err
+		}
+		w.write(", ")
+		if err := w.geometry(v.Right); err != nil {
+			return err
+		}
+		w.write(")")
+	case cql2.Temporal:
+		return w.temporal(v)
+	default:
+		return fmt.Errorf("expected a boolean expression")
+	}
+	return nil
+}
+
+// valueType picks the SQL type a comparison is made in. A property stored
+// in a typed column decides the type, otherwise the literals among the
+// operands do. Comparisons between JSON properties only are made as text.
+func valueType(operands ...cql2.Expr) (string, error) {
+	sqlType := ""
+	for _, operand := range operands {
+		if p, ok := operand.(cql2.Property); ok {
+			if column, ok := columns[cql2.PropertyName(p)]; ok && column.sqlType != "text" {
+				sqlType = column.sqlType
+			}
+		}
+	}
+	for _, operand := range operands {
+		t := literalType(operand)
+		// timestamps may be written as plain strings
+		if t == "" || (t == "text" && sqlType == "timestamptz") {
+			continue
+		}
+		if sqlType != "" && t != sqlType {
+			return "", fmt.Errorf("cannot compare a %s value with a %s value", typeNames[sqlType], typeNames[t])
+		}
+		sqlType = t
+	}
+	if sqlType == "" {
+		sqlType = "text"
+	}
+	return sqlType, nil
+}
+
+var typeNames = map[string]string{
+	"text":        "string",
+	"float8":      "number",
+	"boolean":     "boolean",
+	"timestamptz": "timestamp",
+}
+
+func literalType(e cql2.Expr) string {
+	switch v := e.(type) {
+	case cql2.Literal:
+		switch v.Value.(type) {
+		case string:
+			return "text"
+		case float64:
+			return "float8"
+		case bool:
+			return "boolean"
+		}
+	case cql2.Timestamp, cql2.Date:
+		return "timestamptz"
+	}
+	return ""
+}
+
+func (w *sqlWriter) operand(e cql2.Expr, sqlType string) error {
+	switch v := e.(type) {
+	case cql2.Property:
+		return w.property(cql2.PropertyName(v), sqlType)
+	case cql2.Literal:
+		w.bind(v.Value, sqlType)
+	case cql2.Timestamp:
+		w.bind(v.Value, sqlType)
+	case cql2.Date:
+		w.bind(v.Value, sqlType)
+	default:
+		return fmt.Errorf("unsupported operand in comparison")
+	}
+	return nil
+}
+
+// property writes the value of a queryable, cast to sqlType. JSON values
+// of another type than the one being compared, and strings that are no
+// timestamp, are treated as NULL rather than failing the cast.
+func (w *sqlWriter) property(name string, sqlType string) error {
+	if name == "geometry" {
+		return fmt.Errorf("geometry can only be used with spatial operators")
+	}
+	if column, ok := columns[name]; ok {
+		if column.sqlType != sqlType {
+			return fmt.Errorf("%s cannot be compared with a %s value", name, typeNames[sqlType])
+		}
+		w.write(column.name)
+		return nil
+	}
+
+	switch sqlType {
+	case "float8", "boolean":
+		jsonType := "number"
+		if sqlType == "boolean" {
+			jsonType = "boolean"
+		}
+		w.write("(CASE WHEN jsonb_typeof(")
+		w.jsonProperty(name)
+		w.write(") = '", jsonType, "' THEN (")
+		w.jsonProperty(name)
+		w.write(")::", sqlType, " END)")
+	case "timestamptz":
+		// safe_timestamptz is NULL for a value that is no timestamp
+		w.write("safe_timestamptz(")
+		w.textProperty(name)
+		w.write(")")
+	default:
+		w.textProperty(name)
+	}
+	return nil
+}
+
+func (w *sqlWriter) jsonProperty(name string) {
+	w.write("data->'properties'->")
+	w.bind(name, "text")
+}
+
+func (w *sqlWriter) textProperty(name string) {
+	w.write("data->'properties'->>")
+	w.bind(name, "text")
+}
+
+func (w *sqlWriter) geometry(e cql2.Expr) error {
+	switch v := e.(type) {
+	case cql2.Property:
+		if cql2.PropertyName(v) != "geometry" {
+			return fmt.Errorf("spatial operators require the geometry property, got %q", v.Name)
+		}
+		w.write("items.geometry")
+	case cql2.Geometry:
+		w.write("ST_SetSRID(ST_GeomFromGeoJSON(")
+		w.bind(string(v.GeoJSON), "text")
+		w.write("), 4326)")
+	case cql2.BBox:
+		envelopes := Envelopes(v.Coords)
+		if len(envelopes) > 1 {
+			w.write("ST_Collect(")
+		}
+		for i, envelope := range envelopes {
+			if i > 0 {
+				w.write(", ")
+			}
+			w.write("ST_MakeEnvelope(")
+			for _, coord := range envelope {
+				w.bind(coord, "float8")
+				w.write(", ")
+			}
+			w.write("4326)")
+		}
+		if len(envelopes) > 1 {
+			w.write(")")
+		}
+	default:
+		return fmt.Errorf("spatial operators require a geometry, bbox or the geometry property")
+	}
+	return nil
+}
+
+// Envelopes returns the envelopes, as their west, south, east and north
+// edges, covering a bbox of 4 or 6 numbers. A bbox whose west edge lies
+// east of its east edge crosses the antimeridian, and is split in two.
+func Envelopes(bbox []float64) [][]float64 {
+	if len(bbox) == 6 {
+		bbox = []float64{bbox[0], bbox[1], bbox[3], bbox[4]}
+	}
+	west, south, east, north := bbox[0], bbox[1], bbox[2], bbox[3]
+	if west <= east {
+		return [][]float64{{west, south, east, north}}
+	}
+	return [][]float64{{west, south, 180, north}, {-180, south, east, north}}
+}
+
+func (w *sqlWriter) temporal(t cql2.Temporal) error {
+	operands := map[byte]cql2.Expr{'a': t.Left, 'b': t.Right}
+	condition := sqlTemporalConditions[t.Op]
+	for {
+		open := strings.IndexByte(condition, '{')
+		if open < 0 {
+			w.write(condition)
+			return nil
+		}
+		w.write(condition[:open])
+		ref := condition[open+1 : open+3]
+		if err := w.instant(operands[ref[0]], ref[1] == 'E'); err != nil {
+			return err
+		}
+		condition = condition[open+4:]
+	}
+}
+
+// instant writes the start, or the end, of a temporal operand.
+func (w *sqlWriter) instant(e cql2.Expr, end bool) error {
+	switch v := e.(type) {
+	case cql2.Property:
+		name := cql2.PropertyName(v)
+		if name == "datetime" {
+			// an item covers [start_datetime, end_datetime], which are both
+			// its datetime if it has no range
+			if end {
+				w.write("items.end_datetime")
+			} else {
+				w.write("items.start_datetime")
+			}
+			return nil
+		}
+		return w.property(name, "timestamptz")
+	case cql2.Timestamp:
+		w.bind(v.Value, "timestamptz")
+	case cql2.Date:
+		if end {
+			w.bind(v.Value.Add(cql2.EndOfDay), "timestamptz")
+		} else {
+			w.bind(v.Value, "timestamptz")
+		}
+	case cql2.Interval:
+		bound := v.Start
+		if end {
+			bound = v.End
+		}
+		if bound != nil {
+			w.bind(*bound, "timestamptz")
+		} else if end {
+			w.write("'infinity'::timestamptz")
+		} else {
+			w.write("'-infinity'::timestamptz")
+		}
+	default:
+		return fmt.Errorf("temporal operators require a timestamp, date, interval or property")
+	}
+	return nil
+}
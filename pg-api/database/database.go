@@ -64,9 +64,261 @@ func ConnectDb() {
 		id TEXT PRIMARY KEY NOT NULL,
 		collection TEXT,
 		data JSONB,
-		geometry geometry(POLYGON, 4326) NOT NULL
+		geometry geometry(GEOMETRY, 4326) NOT NULL
 	);`)
 
+	// datetime columns are derived from the item properties by a trigger, so
+	// that every write keeps them in step with data
+	db.Exec(`ALTER TABLE items
+		ADD COLUMN IF NOT EXISTS datetime TIMESTAMPTZ,
+		ADD COLUMN IF NOT EXISTS start_datetime TIMESTAMPTZ,
+		ADD COLUMN IF NOT EXISTS end_datetime TIMESTAMPTZ;`)
+
+	db.Exec(`CREATE OR REPLACE FUNCTION items_set_datetime() RETURNS trigger AS $$
+	BEGIN
+		NEW.datetime := (NEW.data->'properties'->>'datetime')::timestamptz;
+		NEW.start_datetime := COALESCE((NEW.data->'properties'->>'start_datetime')::timestamptz, NEW.datetime);
+		NEW.end_datetime := COALESCE((NEW.data->'properties'->>'end_datetime')::timestamptz, NEW.datetime);
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;`)
+
+	db.Exec(`DROP TRIGGER IF EXISTS items_datetime ON items;`)
+	db.Exec(`CREATE TRIGGER items_datetime
+		BEFORE INSERT OR UPDATE OF data ON items
+		FOR EACH ROW EXECUTE FUNCTION items_set_datetime();`)
+
+	db.Exec(`CREATE INDEX IF NOT EXISTS items_datetime_idx ON items (start_datetime, end_datetime);`)
+
+	// filters and sorts read timestamps from the properties of items with
+	// this, so that a property that is no timestamp is NULL rather than
+	// failing the query
+	db.Exec(`CREATE OR REPLACE FUNCTION safe_timestamptz(value TEXT) RETURNS timestamptz AS $$
+	BEGIN
+		RETURN value::timestamptz;
+	EXCEPTION WHEN data_exception THEN
+		RETURN NULL;
+	END;
+	$$ LANGUAGE plpgsql STABLE PARALLEL SAFE;`)
+
+	// free-text search matches the words of the title, keywords, description
+	// and a few descriptive properties, weighted in that order
+	db.Exec(`CREATE OR REPLACE FUNCTION text_search_vector(fields JSONB) RETURNS tsvector AS $$
+		SELECT setweight(to_tsvector('english', COALESCE(fields->>'title', '')), 'A') ||
+			setweight(to_tsvector('english', COALESCE((
+				SELECT string_agg(keyword, ' ')
+				FROM jsonb_array_elements_text(CASE WHEN jsonb_typeof(fields->'keywords') = 'array' THEN fields->'keywords' END) AS keyword
+			), '')), 'B') ||
+			setweight(to_tsvector('english', COALESCE(fields->>'description', '')), 'C') ||
+			setweight(to_tsvector('english', concat_ws(' ',
+				fields->>'platform',
+				fields->>'constellation',
+				fields->>'mission',
+				CASE WHEN jsonb_typeof(fields->'instruments') = 'array' THEN (
+					SELECT string_agg(instrument, ' ') FROM jsonb_array_elements_text(fields->'instruments') AS instrument
+				) END
+			)), 'D');
+	$$ LANGUAGE sql IMMUTABLE;`)
+
+	db.Exec(`ALTER TABLE items ADD COLUMN IF NOT EXISTS search tsvector;`)
+	db.Exec(`CREATE OR REPLACE FUNCTION items_set_search() RETURNS trigger AS $$
+	BEGIN
+		NEW.search := text_search_vector(NEW.data->'properties');
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;`)
+	db.Exec(`DROP TRIGGER IF EXISTS items_search ON items;`)
+	db.Exec(`CREATE TRIGGER items_search
+		BEFORE INSERT OR UPDATE OF data ON items
+		FOR EACH ROW EXECUTE FUNCTION items_set_search();`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS items_search_idx ON items USING GIN (search);`)
+
+	// collections are stored as a one element array
+	db.Exec(`ALTER TABLE collections ADD COLUMN IF NOT EXISTS search tsvector;`)
+	db.Exec(`CREATE OR REPLACE FUNCTION collections_set_search() RETURNS trigger AS $$
+	BEGIN
+		NEW.search := text_search_vector(NEW.data->0);
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;`)
+	db.Exec(`DROP TRIGGER IF EXISTS collections_search ON collections;`)
+	db.Exec(`CREATE TRIGGER collections_search
+		BEFORE INSERT OR UPDATE OF data ON collections
+		FOR EACH ROW EXECUTE FUNCTION collections_set_search();`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS collections_search_idx ON collections USING GIN (search);`)
+
+	// collection searches match the overall spatial and temporal extents of
+	// collections, the first bbox and interval. Open intervals end at
+	// infinity, and a bbox crossing the antimeridian is split in two.
+	db.Exec(`ALTER TABLE collections
+		ADD COLUMN IF NOT EXISTS extent_geometry geometry(GEOMETRY, 4326),
+		ADD COLUMN IF NOT EXISTS extent_start TIMESTAMPTZ,
+		ADD COLUMN IF NOT EXISTS extent_end TIMESTAMPTZ;`)
+	db.Exec(`CREATE OR REPLACE FUNCTION collections_set_extent() RETURNS trigger AS $$
+	DECLARE
+		bbox JSONB := NEW.data->0->'extent'->'spatial'->'bbox'->0;
+		temporal JSONB := NEW.data->0->'extent'->'temporal'->'interval'->0;
+		half INT;
+		west FLOAT8;
+		south FLOAT8;
+		east FLOAT8;
+		north FLOAT8;
+	BEGIN
+		NEW.extent_geometry := NULL;
+		IF jsonb_typeof(bbox) = 'array' AND jsonb_array_length(bbox) IN (4, 6) THEN
+			half := jsonb_array_length(bbox) / 2;
+			west := (bbox->>0)::float8;
+			south := (bbox->>1)::float8;
+			east := (bbox->>half)::float8;
+			north := (bbox->>(half + 1))::float8;
+			IF west <= east THEN
+				NEW.extent_geometry := ST_MakeEnvelope(west, south, east, north, 4326);
+			ELSE
+				NEW.extent_geometry := ST_Collect(
+					ST_MakeEnvelope(west, south, 180, north, 4326),
+					ST_MakeEnvelope(-180, south, east, north, 4326));
+			END IF;
+		END IF;
+		-- an open end is written as null, or as an empty string
+		NEW.extent_start := COALESCE(NULLIF(temporal->>0, '')::timestamptz, '-infinity');
+		NEW.extent_end := COALESCE(NULLIF(temporal->>1, '')::timestamptz, 'infinity');
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;`)
+	db.Exec(`DROP TRIGGER IF EXISTS collections_extent ON collections;`)
+	db.Exec(`CREATE TRIGGER collections_extent
+		BEFORE INSERT OR UPDATE OF data ON collections
+		FOR EACH ROW EXECUTE FUNCTION collections_set_extent();`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS collections_extent_geometry_idx ON collections USING GIST (extent_geometry);`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS collections_extent_idx ON collections (extent_start, extent_end);`)
+
+	// updated_at is the version of an item or a collection its ETag is
+	// derived from, so every write moves it forward, even within the same
+	// microsecond
+	db.Exec(`ALTER TABLE items ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();`)
+	db.Exec(`CREATE OR REPLACE FUNCTION set_updated_at() RETURNS trigger AS $$
+	BEGIN
+		NEW.updated_at := clock_timestamp();
+		IF TG_OP = 'UPDATE' AND NEW.updated_at <= OLD.updated_at THEN
+			NEW.updated_at := OLD.updated_at + interval '1 microsecond';
+		END IF;
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;`)
+	db.Exec(`DROP TRIGGER IF EXISTS items_updated_at ON items;`)
+	db.Exec(`CREATE TRIGGER items_updated_at
+		BEFORE INSERT OR UPDATE ON items
+		FOR EACH ROW EXECUTE FUNCTION set_updated_at();`)
+	db.Exec(`DROP TRIGGER IF EXISTS collections_updated_at ON collections;`)
+	db.Exec(`CREATE TRIGGER collections_updated_at
+		BEFORE INSERT OR UPDATE ON collections
+		FOR EACH ROW EXECUTE FUNCTION set_updated_at();`)
+
+	// the history tables keep every revision of the items and collections,
+	// each holding a row as it was from changed_at until the next revision
+	// of the same id, and the client that wrote it. The last revision of a
+	// deleted row has the delete operation. Revisions are never changed.
+	db.Exec(`CREATE TABLE IF NOT EXISTS items_history (
+		revision BIGSERIAL PRIMARY KEY,
+		operation TEXT NOT NULL,
+		client_label TEXT,
+		client_address TEXT,
+		changed_at TIMESTAMPTZ NOT NULL,
+		id TEXT NOT NULL,
+		collection TEXT,
+		data JSONB,
+		geometry geometry(GEOMETRY, 4326),
+		datetime TIMESTAMPTZ,
+		start_datetime TIMESTAMPTZ,
+		end_datetime TIMESTAMPTZ,
+		search tsvector
+	);`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS items_history_id_idx ON items_history (id, changed_at);`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS items_history_changed_at_idx ON items_history (changed_at);`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS collections_history (
+		revision BIGSERIAL PRIMARY KEY,
+		operation TEXT NOT NULL,
+		client_label TEXT,
+		client_address TEXT,
+		changed_at TIMESTAMPTZ NOT NULL,
+		id TEXT NOT NULL,
+		data JSONB
+	);`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS collections_history_id_idx ON collections_history (id, changed_at);`)
+
+	// the client label was first recorded as changed_by, falling back on
+	// the client address
+	for _, table := range []string{"items_history", "collections_history"} {
+		db.Exec(`DO $$
+		BEGIN
+			IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = '` + table + `' AND column_name = 'changed_by') THEN
+				ALTER TABLE ` + table + ` RENAME COLUMN changed_by TO client_label;
+				ALTER TABLE ` + table + ` ADD COLUMN client_address TEXT;
+			END IF;
+		END;
+		$$;`)
+	}
+
+	// writes of the api pass the label and the address of their client in
+	// the stac.client_label and stac.client_address settings of their
+	// transaction. Other writes have no label, and the address of the
+	// database client.
+	db.Exec(`CREATE OR REPLACE FUNCTION client_label() RETURNS TEXT AS $$
+		SELECT NULLIF(current_setting('stac.client_label', true), '');
+	$$ LANGUAGE sql STABLE;`)
+	db.Exec(`CREATE OR REPLACE FUNCTION client_address() RETURNS TEXT AS $$
+		SELECT COALESCE(NULLIF(current_setting('stac.client_address', true), ''), host(inet_client_addr()));
+	$$ LANGUAGE sql STABLE;`)
+	db.Exec(`CREATE OR REPLACE FUNCTION items_record_history() RETURNS trigger AS $$
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			INSERT INTO items_history (operation, client_label, client_address, changed_at, id, collection, data, geometry, datetime, start_datetime, end_datetime, search)
+			VALUES ('delete', client_label(), client_address(), GREATEST(clock_timestamp(), OLD.updated_at + interval '1 microsecond'),
+				OLD.id, OLD.collection, OLD.data, OLD.geometry, OLD.datetime, OLD.start_datetime, OLD.end_datetime, OLD.search);
+			RETURN OLD;
+		END IF;
+		INSERT INTO items_history (operation, client_label, client_address, changed_at, id, collection, data, geometry, datetime, start_datetime, end_datetime, search)
+		VALUES (lower(TG_OP), client_label(), client_address(), NEW.updated_at,
+			NEW.id, NEW.collection, NEW.data, NEW.geometry, NEW.datetime, NEW.start_datetime, NEW.end_datetime, NEW.search);
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;`)
+	db.Exec(`DROP TRIGGER IF EXISTS items_history ON items;`)
+	db.Exec(`CREATE TRIGGER items_history
+		AFTER INSERT OR UPDATE OR DELETE ON items
+		FOR EACH ROW EXECUTE FUNCTION items_record_history();`)
+	db.Exec(`CREATE OR REPLACE FUNCTION collections_record_history() RETURNS trigger AS $$
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			INSERT INTO collections_history (operation, client_label, client_address, changed_at, id, data)
+			VALUES ('delete', client_label(), client_address(), GREATEST(clock_timestamp(), OLD.updated_at + interval '1 microsecond'), OLD.id, OLD.data);
+			RETURN OLD;
+		END IF;
+		INSERT INTO collections_history (operation, client_label, client_address, changed_at, id, data)
+		VALUES (lower(TG_OP), client_label(), client_address(), NEW.updated_at, NEW.id, NEW.data);
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;`)
+	db.Exec(`DROP TRIGGER IF EXISTS collections_history ON collections;`)
+	db.Exec(`CREATE TRIGGER collections_history
+		AFTER INSERT OR UPDATE OR DELETE ON collections
+		FOR EACH ROW EXECUTE FUNCTION collections_record_history();`)
+	db.Exec(`DROP FUNCTION IF EXISTS changed_by();`)
+
+	db.Exec(`CREATE OR REPLACE FUNCTION history_append_only() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+	END;
+	$$ LANGUAGE plpgsql;`)
+	for _, table := range []string{"items_history", "collections_history"} {
+		db.Exec(`DROP TRIGGER IF EXISTS ` + table + `_append_only ON ` + table + `;`)
+		db.Exec(`CREATE TRIGGER ` + table + `_append_only
+			BEFORE UPDATE OR DELETE ON ` + table + `
+			FOR EACH STATEMENT EXECUTE FUNCTION history_append_only();`)
+	}
+
+	migrate(db)
+
 	DB = Dbinstance{
 		Db: db,
 	}
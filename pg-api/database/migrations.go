@@ -0,0 +1,99 @@
+package database
+
+import (
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// migration is a change of the stored rows, such as filling a column added
+// to the schema for the rows written before it existed, or of a table
+// created by an earlier version. Unlike the schema, which ConnectDb brings
+// up to date on every start, a migration runs once: the versions applied
+// are kept in schema_migrations.
+type migration struct {
+	version    int
+	statements []string
+}
+
+// withoutRowTriggers runs statements rewriting the rows of a table without
+// the triggers that version them, so that filling derived columns neither
+// records revisions in the history nor changes the ETags.
+func withoutRowTriggers(table string, statements ...string) []string {
+	disable := `ALTER TABLE ` + table + ` DISABLE TRIGGER ` + table + `_history, DISABLE TRIGGER ` + table + `_updated_at;`
+	enable := `ALTER TABLE ` + table + ` ENABLE TRIGGER ` + table + `_history, ENABLE TRIGGER ` + table + `_updated_at;`
+	return append(append([]string{disable}, statements...), enable)
+}
+
+// migrations are applied in the order of their versions, which are never
+// reused.
+var migrations = []migration{
+	{
+		// items written before the datetime columns existed
+		version:    1,
+		statements: withoutRowTriggers("items", `UPDATE items SET data = data WHERE start_datetime IS NULL;`),
+	},
+	{
+		// items and collections written before free-text search existed
+		version: 2,
+		statements: append(
+			withoutRowTriggers("items", `UPDATE items SET data = data WHERE search IS NULL;`),
+			withoutRowTriggers("collections", `UPDATE collections SET data = data WHERE search IS NULL;`)...,
+		),
+	},
+	{
+		// collections written before their extents were searchable
+		version:    3,
+		statements: withoutRowTriggers("collections", `UPDATE collections SET data = data WHERE extent_start IS NULL;`),
+	},
+	{
+		// rows written before the history tables existed start their
+		// history as they are now
+		version: 4,
+		statements: []string{
+			`INSERT INTO items_history (operation, client_label, client_address, changed_at, id, collection, data, geometry, datetime, start_datetime, end_datetime, search)
+			SELECT 'insert', NULL, NULL, updated_at, id, collection, data, geometry, datetime, start_datetime, end_datetime, search FROM items
+			WHERE NOT EXISTS (SELECT 1 FROM items_history WHERE items_history.id = items.id);`,
+			`INSERT INTO collections_history (operation, client_label, client_address, changed_at, id, data)
+			SELECT 'insert', NULL, NULL, updated_at, id, data FROM collections
+			WHERE NOT EXISTS (SELECT 1 FROM collections_history WHERE collections_history.id = collections.id);`,
+		},
+	},
+	{
+		// items were limited to polygons, which patches can change into
+		// geometries of any type
+		version: 5,
+		statements: []string{
+			`ALTER TABLE items ALTER COLUMN geometry TYPE geometry(GEOMETRY, 4326);`,
+			`ALTER TABLE items_history ALTER COLUMN geometry TYPE geometry(GEOMETRY, 4326);`,
+		},
+	},
+}
+
+// migrate applies the migrations not applied yet, each in a transaction
+// that records its version. Instances starting at the same time wait on
+// each other, and skip the migrations another one applied.
+func migrate(db *gorm.DB) {
+	db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);`)
+
+	for _, m := range migrations {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?) ON CONFLICT DO NOTHING`, m.version)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			for _, statement := range m.statements {
+				if err := tx.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("Failed to apply migration %d. \n%v", m.version, err)
+		}
+	}
+}
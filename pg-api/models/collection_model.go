@@ -26,10 +26,13 @@ func (a *JSONB) Scan(value interface{}) error {
 }
 
 type Link struct {
-	Rel   string `json:"rel,omitempty"`
-	Href  string `json:"href,omitempty"`
-	Type  string `json:"type,omitempty"`
-	Title string `json:"title,omitempty"`
+	Rel    string                 `json:"rel,omitempty"`
+	Href   string                 `json:"href,omitempty"`
+	Type   string                 `json:"type,omitempty"`
+	Title  string                 `json:"title,omitempty"`
+	Method string                 `json:"method,omitempty"`
+	Body   map[string]interface{} `json:"body,omitempty"`
+	Merge  bool                   `json:"merge,omitempty"`
 }
 
 type Spatial struct {
@@ -18,7 +18,7 @@ type StacItem struct {
 	Collection     string          `json:"collection,omitempty"`
 	StacVersion    string          `json:"stac_version,omitempty"`
 	StacExtensions []string        `json:"stac_extensions,omitempty"`
-	Bbox           pq.Float64Array `gorm:"type:float[]"`
+	Bbox           pq.Float64Array `gorm:"type:float[]" json:"bbox,omitempty"`
 	Geometry       GeoJSONPoly     `json:"geometry,omitempty"`
 	Properties     interface{}     `json:"properties,omitempty"`
 	Assets         interface{}     `json:"assets,omitempty"`
@@ -37,10 +37,15 @@ type Item struct {
 type Context struct {
 	Returned int `json:"returned,omitempty"`
 	Limit    int `json:"limit,omitempty"`
+	Matched  int `json:"matched"`
+	// MatchedEstimated tells whether Matched was estimated rather than
+	// counted.
+	MatchedEstimated bool `json:"matchedEstimated"`
 }
 
 type ItemCollection struct {
 	Type     string  `json:"type,omitempty"`
 	Context  Context `json:"context,omitempty"`
 	Features []Item  `json:"features,omitempty"`
+	Links    []Link  `json:"links,omitempty"`
 }
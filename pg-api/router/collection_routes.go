@@ -12,6 +12,8 @@ func CollectionRoute(app *fiber.App) {
 	app.Post("/collections", controllers.CreateCollection)
 	app.Get("/collections/:collectionId", controllers.GetCollection)
 	app.Put("/collections/:collectionId", controllers.EditCollection)
+	app.Patch("/collections/:collectionId", controllers.PatchCollection)
 	app.Delete("/collections/:collectionId", controllers.DeleteCollection)
+	app.Get("/collections/:collectionId/queryables", controllers.CollectionQueryables)
 	app.Get("/collections", controllers.GetCollections)
 }
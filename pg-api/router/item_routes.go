@@ -9,7 +9,9 @@ import (
 func ItemRoute(app *fiber.App) {
 	app.Post("/collections/:collectionId/items", controllers.CreateItem)
 	app.Get("/collections/:collectionId/items/:itemId", controllers.GetItem)
+	app.Get("/collections/:collectionId/items/:itemId/versions", controllers.GetItemVersions)
 	app.Get("/collections/:collectionId/items", controllers.GetItemCollection)
 	app.Put("/collections/:collectionId/items/:itemId", controllers.EditItem)
+	app.Patch("/collections/:collectionId/items/:itemId", controllers.PatchItem)
 	app.Delete("/collections/:collectionId/items/:itemId", controllers.DeleteItem)
 }
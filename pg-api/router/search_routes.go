@@ -9,4 +9,9 @@ import (
 func SearchRoute(app *fiber.App) {
 	app.Post("/search", controllers.PostSearch)
 	app.Get("/search", controllers.GetSearch)
+	app.Get("/sortables", controllers.Sortables)
+	app.Get("/aggregate", controllers.GetAggregate)
+	app.Post("/aggregate", controllers.PostAggregate)
+	app.Get("/aggregations", controllers.Aggregations)
+	app.Get("/queryables", controllers.Queryables)
 }
@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCreateItems posts items 50 to 52 of the test data, which the other
+// tests do not load, in bulk and deletes them afterwards.
+func TestCreateItems(t *testing.T) {
+	b, _ := ioutil.ReadFile("setup_data/sentinel-s2-l2a-cogs_0_100.json")
+	var fc struct {
+		Features []json.RawMessage `json:"features"`
+	}
+	json.Unmarshal(b, &fc)
+	features := fc.Features[50:53:53]
+	invalid := json.RawMessage(`{"type": "Feature", "id": "no-geometry", "properties": {"datetime": "2020-01-01T00:00:00Z"}}`)
+
+	featureCollection := func(features ...json.RawMessage) []byte {
+		body, _ := json.Marshal(map[string]interface{}{"type": "FeatureCollection", "features": features})
+		return body
+	}
+	ndjson := bytes.Join([][]byte{features[0], features[1], features[2]}, []byte("\n"))
+	point := json.RawMessage(`{"type": "Feature", "id": "bulk-point", "geometry": {"type": "Point", "coordinates": [10, 20]}, "properties": {"datetime": "2020-01-01T00:00:00Z"}}`)
+	multiPolygon := json.RawMessage(`{"type": "Feature", "id": "bulk-multipolygon", "geometry": {"type": "MultiPolygon", "coordinates": [[[[0, 0], [1, 0], [1, 1], [0, 0]]], [[[2, 2], [3, 2], [3, 3], [2, 2]]]]}, "properties": {"datetime": "2020-01-01T00:00:00Z"}}`)
+
+	tests := []struct {
+		description      string
+		query            string
+		contentType      string
+		body             []byte
+		expectedCode     int
+		expectedStatuses []string
+	}{
+		{
+			"transaction with an invalid item",
+			"",
+			"application/json",
+			featureCollection(append(features, invalid)...),
+			422,
+			[]string{"rolled_back", "rolled_back", "rolled_back", "failed"},
+		},
+		{
+			"best effort with an invalid item",
+			"mode=best-effort",
+			"application/json",
+			featureCollection(append(features, invalid)...),
+			207,
+			[]string{"created", "created", "created", "failed"},
+		},
+		{
+			"items that exist",
+			"",
+			"application/x-ndjson",
+			ndjson,
+			409,
+			[]string{"failed", "failed", "failed"},
+		},
+		{
+			"items that exist, skipped",
+			"on_conflict=skip",
+			"application/x-ndjson",
+			ndjson,
+			201,
+			[]string{"skipped", "skipped", "skipped"},
+		},
+		{
+			"items that exist, replaced",
+			"on_conflict=upsert",
+			"application/x-ndjson",
+			ndjson,
+			201,
+			[]string{"updated", "updated", "updated"},
+		},
+		{
+			"items of other geometry types",
+			"",
+			"application/json",
+			featureCollection(point, multiPolygon),
+			201,
+			[]string{"created", "created"},
+		},
+		{
+			"unknown conflict handling",
+			"on_conflict=merge",
+			"application/x-ndjson",
+			ndjson,
+			400,
+			nil,
+		},
+	}
+
+	app := Setup()
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/collections/sentinel-s2-l2a-cogs-test/items?"+test.query, bytes.NewReader(test.body))
+		req.Header.Set("Content-Type", test.contentType)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+
+		var response struct {
+			Results []struct {
+				Status string `json:"status"`
+			} `json:"results"`
+		}
+		json.NewDecoder(resp.Body).Decode(&response)
+		var statuses []string
+		for _, result := range response.Results {
+			statuses = append(statuses, result.Status)
+		}
+		assert.Equalf(t, test.expectedStatuses, statuses, test.description)
+	}
+
+	for _, id := range []string{"S2B_1CCV_20200923_0_L2A", "S2B_1CCV_20210101_0_L2A", "S2B_1CCV_20210111_0_L2A", "bulk-point", "bulk-multipolygon"} {
+		req, _ := http.NewRequest("DELETE", "/collections/sentinel-s2-l2a-cogs-test/items/"+id, nil)
+		if _, err := app.Test(req, -1); err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+	}
+}
@@ -257,6 +257,153 @@ func TestPgEditCollection(t *testing.T) {
 	assert.Equalf(t, "success", collection_response.Message, "update collection")
 }
 
+func TestPgPatchCollection(t *testing.T) {
+	tests := []struct {
+		description   string
+		contentType   string
+		patch         string
+		expectedCode  int
+		expectedTitle string
+	}{
+		{
+			description:   "merge patch of the title",
+			contentType:   "application/merge-patch+json",
+			patch:         `{"title": "Patched title"}`,
+			expectedCode:  200,
+			expectedTitle: "Patched title",
+		},
+		{
+			description:   "json patch of the title",
+			contentType:   "application/json-patch+json",
+			patch:         `[{"op": "test", "path": "/title", "value": "Patched title"}, {"op": "replace", "path": "/title", "value": "Patched again"}]`,
+			expectedCode:  200,
+			expectedTitle: "Patched again",
+		},
+		{
+			description:  "patch with an invalid extent",
+			contentType:  "application/merge-patch+json",
+			patch:        `{"extent": {"spatial": {"bbox": [[1, 2, 3]]}}}`,
+			expectedCode: 422,
+		},
+		{
+			description:  "malformed json patch",
+			contentType:  "application/json-patch+json",
+			patch:        `[{"op": "replace", "path": "title", "value": "x"}]`,
+			expectedCode: 400,
+		},
+	}
+
+	app := Setup()
+	for _, test := range tests {
+		req, _ := http.NewRequest(http.MethodPatch, "/collections/sentinel-s2-l2a-cogs-test-2", bytes.NewBufferString(test.patch))
+		req.Header.Set("Content-Type", test.contentType)
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			log.Fatalf("An Error Occured %v", err)
+		}
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+
+		if test.expectedTitle != "" {
+			body, _ := ioutil.ReadAll(resp.Body)
+			var patched struct {
+				StacCollection models.StacCollection `json:"stac_collection"`
+			}
+			json.Unmarshal(body, &patched)
+			assert.Equalf(t, test.expectedTitle, patched.StacCollection.Title, test.description)
+		}
+	}
+}
+
+func TestPgCollectionETag(t *testing.T) {
+	route := "/collections/sentinel-s2-l2a-cogs-test-2"
+	app := Setup()
+
+	req, _ := http.NewRequest(http.MethodGet, route, nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		log.Fatalf("An Error Occured %v", err)
+	}
+	assert.Equalf(t, 200, resp.StatusCode, "get collection")
+	etag := resp.Header.Get("ETag")
+	assert.NotEmptyf(t, etag, "get collection")
+
+	req, _ = http.NewRequest(http.MethodGet, route, nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, _ = app.Test(req, -1)
+	assert.Equalf(t, 304, resp.StatusCode, "get collection of a known version")
+
+	req, _ = http.NewRequest(http.MethodPatch, route, bytes.NewBufferString(`{"title": "Conditional title"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"0"`)
+	resp, _ = app.Test(req, -1)
+	assert.Equalf(t, 412, resp.StatusCode, "patch of another version")
+
+	req, _ = http.NewRequest(http.MethodPatch, route, bytes.NewBufferString(`{"title": "Conditional title"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", etag)
+	resp, _ = app.Test(req, -1)
+	assert.Equalf(t, 200, resp.StatusCode, "patch of the current version")
+	assert.NotEqualf(t, etag, resp.Header.Get("ETag"), "patch of the current version")
+
+	req, _ = http.NewRequest(http.MethodDelete, route, nil)
+	req.Header.Set("If-Match", etag)
+	resp, _ = app.Test(req, -1)
+	assert.Equalf(t, 412, resp.StatusCode, "delete of a version patched since")
+}
+
+// TestPgCollectionOpenInterval writes a collection whose temporal extent
+// is open at one end, and finds it by a datetime past its start.
+func TestPgCollectionOpenInterval(t *testing.T) {
+	route := "/collections/open-interval-test"
+	app := Setup()
+
+	var collection map[string]interface{}
+	b, _ := os.ReadFile("setup_data/collection.json")
+	json.Unmarshal(b, &collection)
+	collection["id"] = "open-interval-test"
+	collection["extent"] = map[string]interface{}{
+		"spatial":  map[string]interface{}{"bbox": [][]float64{{-180, -90, 180, 90}}},
+		"temporal": map[string]interface{}{"interval": [][]interface{}{{"2015-06-27T10:25:31.456000Z", nil}}},
+	}
+	body, _ := json.Marshal(collection)
+
+	request := func(method string, route string, contentType string, body []byte) *http.Response {
+		req, _ := http.NewRequest(method, route, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			log.Fatalf("An Error Occured %v", err)
+		}
+		return resp
+	}
+
+	resp := request(http.MethodPost, "/collections", "application/json", body)
+	assert.Equalf(t, 201, resp.StatusCode, "create collection with an open end")
+
+	resp = request(http.MethodPut, route, "application/json", body)
+	assert.Equalf(t, 200, resp.StatusCode, "edit collection with an open end")
+
+	resp = request(http.MethodPatch, route, "application/merge-patch+json",
+		[]byte(`{"extent": {"temporal": {"interval": [[null, "2030-01-01T00:00:00Z"]]}}}`))
+	assert.Equalf(t, 200, resp.StatusCode, "patch collection to an open start")
+
+	resp = request(http.MethodGet, "/collections?datetime=2000-01-01T00:00:00Z&limit=100", "", nil)
+	assert.Equalf(t, 200, resp.StatusCode, "search collections by datetime")
+	var search struct {
+		Collections []models.StacCollection `json:"collections"`
+	}
+	json.NewDecoder(resp.Body).Decode(&search)
+	found := false
+	for _, collection := range search.Collections {
+		found = found || collection.Id == "open-interval-test"
+	}
+	assert.Truef(t, found, "search collections by a datetime before the end")
+
+	resp = request(http.MethodDelete, route, "", nil)
+	assert.Equalf(t, 200, resp.StatusCode, "delete collection")
+}
+
 func TestPgDeleteCollection(t *testing.T) {
 	app := Setup()
 
@@ -1,48 +1,307 @@
 package tests
 
 import (
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jonhealy1/goapi-stac/pg-api/controllers"
 	"github.com/jonhealy1/goapi-stac/pg-api/models"
 )
 
-func TestBuildSearchString(t *testing.T) {
+func TestBuildSearchQuerySort(t *testing.T) {
 	tests := []struct {
-		search   *models.Search
+		sortby   []models.Sort
 		expected string
 	}{
 		{
-			&models.Search{
-				Sortby: []models.Sort{
-					{Field: "field1", Direction: "ASC"},
-					{Field: "field2", Direction: "DESC"},
-				},
+			[]models.Sort{{Field: "id", Direction: "DESC"}},
+			" ORDER BY items.id DESC NULLS LAST LIMIT ?",
+		},
+		{
+			[]models.Sort{{Field: "properties.datetime", Direction: "asc"}},
+			" ORDER BY items.datetime ASC NULLS LAST, items.id ASC NULLS LAST LIMIT ?",
+		},
+		{
+			[]models.Sort{{Field: "eo:cloud_cover", Direction: "desc"}, {Field: "collection"}},
+			" ORDER BY (CASE WHEN jsonb_typeof(items.data->'properties'->'eo:cloud_cover') = 'number' THEN (items.data->'properties'->'eo:cloud_cover')::float8 END) DESC NULLS LAST," +
+				" items.collection ASC NULLS LAST, items.id ASC NULLS LAST LIMIT ?",
+		},
+		{
+			[]models.Sort{{Field: "properties.updated", Direction: "desc"}},
+			" ORDER BY safe_timestamptz(items.data->'properties'->>'updated') DESC NULLS LAST, items.id ASC NULLS LAST LIMIT ?",
+		},
+	}
+	for _, test := range tests {
+		query, err := controllers.BuildSearchQuery(models.Search{Sortby: test.sortby})
+		if err != nil {
+			t.Fatalf("Unexpected error sorting by %v: %v", test.sortby, err)
+		}
+		result, _ := query.SQL()
+		if !strings.HasSuffix(result, test.expected) {
+			t.Errorf("Expected %q to end with %q", result, test.expected)
+		}
+	}
+
+	invalid := [][]models.Sort{
+		{{Field: "properties.foo'; DROP TABLE items; --", Direction: "asc"}},
+		{{Field: "id", Direction: "asc; DROP TABLE items"}},
+	}
+	for _, sortby := range invalid {
+		if _, err := controllers.BuildSearchQuery(models.Search{Sortby: sortby}); err == nil {
+			t.Errorf("Expected an error sorting by %v", sortby)
+		}
+	}
+}
+
+func TestBuildSearchQuery(t *testing.T) {
+	selectAll := "SELECT items.data AS data, jsonb_build_array(items.datetime, items.id) AS page_keys FROM items"
+	order := " ORDER BY items.datetime DESC NULLS LAST, items.id ASC NULLS LAST LIMIT ?"
+	tests := []struct {
+		search   models.Search
+		expected string
+		args     []interface{}
+	}{
+		{
+			models.Search{},
+			selectAll + order,
+			[]interface{}{101},
+		},
+		{
+			models.Search{Ids: []string{"a", "b"}, Limit: 10},
+			selectAll + " WHERE items.id IN ?" + order,
+			[]interface{}{[]string{"a", "b"}, 11},
+		},
+		{
+			models.Search{Ids: []string{"a"}, Collections: []string{"c"}},
+			selectAll + " WHERE items.id IN ? AND items.collection IN ?" + order,
+			[]interface{}{[]string{"a"}, []string{"c"}, 101},
+		},
+		{
+			models.Search{
+				Collections: []string{"c"},
+				FilterLang:  "cql2-text",
+				Filter:      []byte(`"platform = 'sentinel-2b'"`),
 			},
-			" ORDER BY data -> 'field1' ASC, data -> 'field2' DESC",
+			selectAll + " WHERE items.collection IN ? AND data->'properties'->>?::text = ?::text" + order,
+			[]interface{}{[]string{"c"}, "platform", "sentinel-2b", 101},
+		},
+		{
+			models.Search{Query: map[string]map[string]interface{}{
+				"platform":       {"in": []interface{}{"landsat-8", "sentinel-2b"}, "startsWith": "sentinel_2"},
+				"eo:cloud_cover": {"lt": 20.0},
+				"datetime":       {"gte": "2020-01-01T00:00:00Z"},
+			}},
+			selectAll + " WHERE (items.datetime >= ?::timestamptz" +
+				" AND (CASE WHEN jsonb_typeof(data->'properties'->?::text) = 'number' THEN (data->'properties'->?::text)::float8 END) < ?::float8" +
+				" AND data->'properties'->>?::text IN (?::text, ?::text)" +
+				" AND data->'properties'->>?::text LIKE ?::text)" + order,
+			[]interface{}{
+				time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+				"eo:cloud_cover", "eo:cloud_cover", 20.0,
+				"platform", "landsat-8", "sentinel-2b",
+				"platform", `sentinel\_2%`,
+				101,
+			},
+		},
+		{
+			models.Search{Q: "flood,wildfire"},
+			"SELECT items.data AS data, jsonb_build_array(ts_rank(items.search, text_query.query), items.id) AS page_keys" +
+				" FROM items, (SELECT (plainto_tsquery('english', ?::text) || plainto_tsquery('english', ?::text)) AS query) AS text_query" +
+				" WHERE items.search @@ text_query.query" +
+				" ORDER BY ts_rank(items.search, text_query.query) DESC NULLS LAST, items.id ASC NULLS LAST LIMIT ?",
+			[]interface{}{"flood", "wildfire", 101},
+		},
+		{
+			models.Search{
+				Q:           `"climate model" +forest -(fire OR smoke)`,
+				Collections: []string{"c"},
+				Sortby:      []models.Sort{{Field: "id", Direction: "asc"}},
+			},
+			"SELECT items.data AS data, jsonb_build_array(items.id) AS page_keys" +
+				" FROM items, (SELECT (plainto_tsquery('english', ?::text) && !!(plainto_tsquery('english', ?::text) || plainto_tsquery('english', ?::text))) AS query) AS text_query" +
+				" WHERE items.search @@ text_query.query AND items.collection IN ?" +
+				" ORDER BY items.id ASC NULLS LAST LIMIT ?",
+			[]interface{}{"forest", "fire", "smoke", []string{"c"}, 101},
+		},
+		{
+			models.Search{Q: `"sea ice" AND NOT arctic`},
+			"SELECT items.data AS data, jsonb_build_array(ts_rank(items.search, text_query.query), items.id) AS page_keys" +
+				" FROM items, (SELECT (phraseto_tsquery('english', ?::text) && !!plainto_tsquery('english', ?::text)) AS query) AS text_query" +
+				" WHERE items.search @@ text_query.query" +
+				" ORDER BY ts_rank(items.search, text_query.query) DESC NULLS LAST, items.id ASC NULLS LAST LIMIT ?",
+			[]interface{}{"sea ice", "arctic", 101},
+		},
+		{
+			models.Search{Bbox: []float64{-10.5, 40, 0, -5, 50.25, 100}},
+			selectAll + " WHERE ST_Intersects(items.geometry, ST_MakeEnvelope(?::float8, ?::float8, ?::float8, ?::float8, 4326))" + order,
+			[]interface{}{-10.5, 40.0, -5.0, 50.25, 101},
+		},
+		{
+			models.Search{Bbox: []float64{170, -10, -170, 10}},
+			selectAll + " WHERE (ST_Intersects(items.geometry, ST_MakeEnvelope(?::float8, ?::float8, ?::float8, ?::float8, 4326))" +
+				" OR ST_Intersects(items.geometry, ST_MakeEnvelope(?::float8, ?::float8, ?::float8, ?::float8, 4326)))" + order,
+			[]interface{}{170.0, -10.0, 180.0, 10.0, -180.0, -10.0, -170.0, 10.0, 101},
 		},
 		{
-			&models.Search{
-				Sortby: []models.Sort{
-					{Field: "field1.subfield1", Direction: "ASC"},
-					{Field: "field2", Direction: "DESC"},
-				},
+			models.Search{Intersects: []byte(`{"type": "MultiPolygon", "coordinates": [
+				[[[0, 0], [10, 0], [10, 10], [0, 10], [0, 0]], [[2, 2], [2, 4], [4, 4], [4, 2], [2, 2]]],
+				[[[20.123456789012, 20], [30, 20], [30, 30], [20.123456789012, 20]]]
+			], "bbox": [0, 0, 30, 30]}`)},
+			selectAll + " WHERE ST_Intersects(items.geometry, ST_SetSRID(ST_GeomFromGeoJSON(?::text), 4326))" + order,
+			[]interface{}{
+				`{"type":"MultiPolygon","coordinates":[[[[0,0],[10,0],[10,10],[0,10],[0,0]],[[2,2],[2,4],[4,4],[4,2],[2,2]]],[[[20.123456789012,20],[30,20],[30,30],[20.123456789012,20]]]]}`,
+				101,
 			},
-			" ORDER BY data -> 'field1' -> 'subfield1' ASC, data -> 'field2' DESC",
 		},
 		{
-			&models.Search{
-				Sortby: []models.Sort{
-					{Field: "field1", Direction: "ASC"},
-				},
+			models.Search{Geometry: []byte(`{"type": "GeometryCollection", "geometries": [
+				{"type": "Point", "coordinates": [1, 2]},
+				{"type": "MultiLineString", "coordinates": [[[0, 0], [1, 1]], [[2, 2], [3, 3, 4]]]}
+			]}`)},
+			selectAll + " WHERE ST_Intersects(items.geometry, ST_SetSRID(ST_GeomFromGeoJSON(?::text), 4326))" + order,
+			[]interface{}{
+				`{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[1,2]},{"type":"MultiLineString","coordinates":[[[0,0],[1,1]],[[2,2],[3,3,4]]]}]}`,
+				101,
 			},
-			" ORDER BY data -> 'field1' ASC",
+		},
+		{
+			models.Search{Fields: models.Fields{Exclude: []string{"assets", "properties.eo:cloud_cover"}}},
+			"SELECT items.data #- ARRAY[?::text] #- ARRAY[?::text, ?::text] AS data, jsonb_build_array(items.datetime, items.id) AS page_keys FROM items" + order,
+			[]interface{}{"assets", "properties", "eo:cloud_cover", 101},
+		},
+		{
+			models.Search{Fields: models.Fields{
+				Include: []string{"id", "properties.datetime"},
+				Exclude: []string{"properties", "id"},
+			}},
+			"SELECT (COALESCE((SELECT jsonb_object_agg(key, value) FROM jsonb_each(CASE WHEN jsonb_typeof(items.data) = 'object' THEN items.data END) WHERE key IN ?), '{}'::jsonb)" +
+				" || CASE WHEN jsonb_typeof(items.data -> ?::text) = 'object' THEN jsonb_build_object(?::text, " +
+				"(COALESCE((SELECT jsonb_object_agg(key, value) FROM jsonb_each(CASE WHEN jsonb_typeof(items.data -> ?::text) = 'object' THEN items.data -> ?::text END) WHERE key IN ?), '{}'::jsonb)))" +
+				" ELSE '{}'::jsonb END) AS data, jsonb_build_array(items.datetime, items.id) AS page_keys FROM items" + order,
+			[]interface{}{[]string{"id"}, "properties", "properties", "properties", "properties", []string{"datetime"}, 101},
+		},
+		{
+			models.Search{Ids: []string{"a"}, AsOf: "2023-05-01T12:00:00Z"},
+			"SELECT items.data AS data, jsonb_build_array(items.datetime, items.id) AS page_keys" +
+				" FROM (SELECT * FROM (SELECT DISTINCT ON (id)" +
+				" id, collection, data, geometry, datetime, start_datetime, end_datetime, search, changed_at AS updated_at, operation" +
+				" FROM items_history WHERE changed_at <= ? ORDER BY id, changed_at DESC, revision DESC" +
+				") AS revisions WHERE operation <> 'delete') AS items" +
+				" WHERE items.id IN ?" + order,
+			[]interface{}{time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), []string{"a"}, 101},
 		},
 	}
 	for _, test := range tests {
-		result := controllers.BuildSortString("", *test.search)
+		query, err := controllers.BuildSearchQuery(test.search)
+		if err != nil {
+			t.Fatalf("Unexpected error planning %+v: %v", test.search, err)
+		}
+		result, args := query.SQL()
 		if result != test.expected {
 			t.Errorf("Expected %q but got %q", test.expected, result)
 		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("Expected args %v but got %v", test.args, args)
+		}
+	}
+}
+
+func TestBuildSearchQueryInvalid(t *testing.T) {
+	searches := []models.Search{
+		{Bbox: []float64{1, 2, 3}},
+		{Bbox: []float64{0, 10, 1, 5}},
+		{Bbox: []float64{0, 0, 1, 1}, Intersects: []byte(`{"type": "Point", "coordinates": [0, 0]}`)},
+		{Geometry: []byte(`{"type": "Point", "coordinates": "a"}`)},
+		{Intersects: []byte(`{"type": "Point", "coordinates": [200, 0]}`)},
+		{Intersects: []byte(`{"type": "Circle", "coordinates": [0, 0]}`)},
+		{Intersects: []byte(`{"type": "LineString", "coordinates": [[0, 0]]}`)},
+		{Intersects: []byte(`{"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 1]]]}`)},
+		{Intersects: []byte(`{"type": "MultiPolygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}`)},
+		{Intersects: []byte(`{"type": "GeometryCollection", "geometries": [{"type": "Point"}]}`)},
+		{Intersects: []byte(`{"type": "Polygon", "coordinates": []}`)},
+		{Intersects: []byte(`{"type": "MultiPolygon", "coordinates": [[]]}`)},
+		{Intersects: []byte(`{"type": "MultiPoint", "coordinates": []}`)},
+		{Intersects: []byte(`{"type": "LineString", "coordinates": []}`)},
+		{Q: "(flood"},
+		{Q: "flood AND"},
+		{Q: `"unterminated`},
+		{Q: " , "},
+		{Filter: []byte(`{"op": "foo", "args": []}`)},
+		{Datetime: "../.."},
+		{AsOf: "yesterday"},
+		{Token: "not-a-token"},
+		{Query: map[string]map[string]interface{}{"platform": {"like": "a"}}},
+		{Query: map[string]map[string]interface{}{"platform": {"in": "a"}}},
+		{Query: map[string]map[string]interface{}{"gsd": {"startsWith": 10.0}}},
+	}
+	for _, search := range searches {
+		if _, err := controllers.BuildSearchQuery(search); err == nil {
+			t.Errorf("Expected an error for %+v", search)
+		}
+	}
+}
+
+func TestBuildCollectionSearchQuery(t *testing.T) {
+	selectAll := "SELECT collections.data->0 AS data, jsonb_build_array(collections.id) AS page_keys FROM collections WHERE collections.deleted_at IS NULL"
+	order := " ORDER BY collections.id ASC NULLS LAST LIMIT ?"
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		search   models.Search
+		expected string
+		args     []interface{}
+	}{
+		{
+			models.Search{},
+			selectAll + order,
+			[]interface{}{101},
+		},
+		{
+			models.Search{Bbox: []float64{170, -10, -170, 10}, Limit: 10},
+			selectAll + " AND (ST_Intersects(collections.extent_geometry, ST_MakeEnvelope(?::float8, ?::float8, ?::float8, ?::float8, 4326))" +
+				" OR ST_Intersects(collections.extent_geometry, ST_MakeEnvelope(?::float8, ?::float8, ?::float8, ?::float8, 4326)))" + order,
+			[]interface{}{170.0, -10.0, 180.0, 10.0, -180.0, -10.0, -170.0, 10.0, 11},
+		},
+		{
+			models.Search{Datetime: "2020-01-01T00:00:00Z/..", Sortby: []models.Sort{{Field: "title", Direction: "desc"}}},
+			"SELECT collections.data->0 AS data, jsonb_build_array((collections.data->0->>'title'), collections.id) AS page_keys" +
+				" FROM collections WHERE collections.deleted_at IS NULL AND collections.extent_end >= ?::timestamptz" +
+				" ORDER BY (collections.data->0->>'title') DESC NULLS LAST, collections.id ASC NULLS LAST LIMIT ?",
+			[]interface{}{start, 101},
+		},
+		{
+			models.Search{Q: "sentinel"},
+			"SELECT collections.data->0 AS data, jsonb_build_array(ts_rank(collections.search, text_query.query), collections.id) AS page_keys" +
+				" FROM collections, (SELECT plainto_tsquery('english', ?::text) AS query) AS text_query" +
+				" WHERE collections.deleted_at IS NULL AND collections.search @@ text_query.query" +
+				" ORDER BY ts_rank(collections.search, text_query.query) DESC NULLS LAST, collections.id ASC NULLS LAST LIMIT ?",
+			[]interface{}{"sentinel", 101},
+		},
+	}
+	for _, test := range tests {
+		query, err := controllers.BuildCollectionSearchQuery(test.search)
+		if err != nil {
+			t.Fatalf("Unexpected error for %+v: %v", test.search, err)
+		}
+		sql, args := query.SQL()
+		if sql != test.expected {
+			t.Errorf("Expected %q but got %q", test.expected, sql)
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("Expected args %v but got %v", test.args, args)
+		}
+	}
+
+	invalid := []models.Search{
+		{Bbox: []float64{1, 2, 3}},
+		{Datetime: "../.."},
+		{Sortby: []models.Sort{{Field: "properties.datetime"}}},
+		{Q: "(flood"},
+	}
+	for _, search := range invalid {
+		if _, err := controllers.BuildCollectionSearchQuery(search); err == nil {
+			t.Errorf("Expected an error for %+v", search)
+		}
 	}
 }
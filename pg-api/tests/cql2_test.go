@@ -0,0 +1,230 @@
+package tests
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jonhealy1/goapi-stac/pg-api/cql2sql"
+	"github.com/jonhealy1/goapi-stac/shared/cql2"
+)
+
+func TestCql2JSONToSQL(t *testing.T) {
+	ts, _ := time.Parse(time.RFC3339, "2020-01-01T00:00:00Z")
+
+	tests := []struct {
+		filter   string
+		expected string
+		args     []interface{}
+	}{
+		{
+			`{"op": "=", "args": [{"property": "platform"}, "sentinel-2b"]}`,
+			"data->'properties'->>?::text = ?::text",
+			[]interface{}{"platform", "sentinel-2b"},
+		},
+		{
+			`{"op": "<", "args": [{"property": "properties.eo:cloud_cover"}, 10]}`,
+			"(CASE WHEN jsonb_typeof(data->'properties'->?::text) = 'number' THEN (data->'properties'->?::text)::float8 END) < ?::float8",
+			[]interface{}{"eo:cloud_cover", "eo:cloud_cover", 10.0},
+		},
+		{
+			`{"op": "and", "args": [
+				{"op": "in", "args": [{"property": "collection"}, ["a", "b"]]},
+				{"op": "not", "args": [{"op": "like", "args": [{"property": "id"}, "S2A%"]}]}
+			]}`,
+			"(items.collection IN (?::text, ?::text) AND NOT (items.id LIKE ?::text))",
+			[]interface{}{"a", "b", "S2A%"},
+		},
+		{
+			`{"op": "between", "args": [{"property": "gsd"}, 5, 20]}`,
+			"(CASE WHEN jsonb_typeof(data->'properties'->?::text) = 'number' THEN (data->'properties'->?::text)::float8 END) BETWEEN ?::float8 AND ?::float8",
+			[]interface{}{"gsd", "gsd", 5.0, 20.0},
+		},
+		{
+			`{"op": "s_intersects", "args": [{"property": "geometry"}, {"bbox": [1, 2, 3, 4]}]}`,
+			"ST_Intersects(items.geometry, ST_MakeEnvelope(?::float8, ?::float8, ?::float8, ?::float8, 4326))",
+			[]interface{}{1.0, 2.0, 3.0, 4.0},
+		},
+		{
+			// a bbox across the antimeridian is split in two
+			`{"op": "s_intersects", "args": [{"property": "geometry"}, {"bbox": [170, -10, -170, 10]}]}`,
+			"ST_Intersects(items.geometry, ST_Collect(ST_MakeEnvelope(?::float8, ?::float8, ?::float8, ?::float8, 4326), ST_MakeEnvelope(?::float8, ?::float8, ?::float8, ?::float8, 4326)))",
+			[]interface{}{170.0, -10.0, 180.0, 10.0, -180.0, -10.0, -170.0, 10.0},
+		},
+		{
+			`{"op": "s_within", "args": [{"property": "geometry"}, {"type": "Point", "coordinates": [1, 2]}]}`,
+			"ST_Within(items.geometry, ST_SetSRID(ST_GeomFromGeoJSON(?::text), 4326))",
+			[]interface{}{`{"type":"Point","coordinates":[1,2]}`},
+		},
+		{
+			`{"op": "t_intersects", "args": [{"property": "updated"}, {"interval": ["2020-01-01T00:00:00Z", ".."]}]}`,
+			"safe_timestamptz(data->'properties'->>?::text) <= 'infinity'::timestamptz AND safe_timestamptz(data->'properties'->>?::text) >= ?::timestamptz",
+			[]interface{}{"updated", "updated", ts},
+		},
+		{
+			`{"op": "isNull", "args": [{"property": "sentinel:sequence"}]}`,
+			"COALESCE(jsonb_typeof(data->'properties'->?::text), 'null') = 'null'",
+			[]interface{}{"sentinel:sequence"},
+		},
+	}
+	for _, test := range tests {
+		expr, err := cql2.ParseJSON([]byte(test.filter))
+		if err != nil {
+			t.Fatalf("Unexpected error parsing %s: %v", test.filter, err)
+		}
+		result, args, err := cql2sql.ToSQL(expr)
+		if err != nil {
+			t.Fatalf("Unexpected error translating %s: %v", test.filter, err)
+		}
+		if result != test.expected {
+			t.Errorf("Expected %q but got %q", test.expected, result)
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("Expected args %v but got %v", test.args, args)
+		}
+	}
+}
+
+func TestCql2JSONInvalid(t *testing.T) {
+	filters := []string{
+		`{"op": "=", "args": [{"property": "platform"}]}`,
+		`{"op": "foo", "args": [{"property": "platform"}, 1]}`,
+		`{"op": "and", "args": [{"property": "platform"}, true]}`,
+		`{"property": "platform"}`,
+		`{"op": "in", "args": [{"property": "platform"}, "a"]}`,
+		`{"op": "=", "args": [{"property": "gsd"}, {"bbox": [1, 2]}]}`,
+		`{"op": "t_intersects", "args": [{"property": "datetime"}, {"interval": ["2020-01-01", "2019-01-01"]}]}`,
+		`{"op": "=", "args": [`,
+	}
+	for _, filter := range filters {
+		expr, err := cql2.ParseJSON([]byte(filter))
+		if err == nil {
+			_, _, err = cql2sql.ToSQL(expr)
+		}
+		if err == nil {
+			t.Errorf("Expected an error for %s", filter)
+		}
+	}
+}
+
+func TestCql2TextMatchesJSON(t *testing.T) {
+	tests := []struct {
+		text     string
+		jsonForm string
+	}{
+		{
+			"eo:cloud_cover < 10 AND platform = 'sentinel-2b'",
+			`{"op": "and", "args": [
+				{"op": "<", "args": [{"property": "eo:cloud_cover"}, 10]},
+				{"op": "=", "args": [{"property": "platform"}, "sentinel-2b"]}
+			]}`,
+		},
+		{
+			"NOT (id LIKE 'S2A%') or collection IN ('a', 'b')",
+			`{"op": "or", "args": [
+				{"op": "not", "args": [{"op": "like", "args": [{"property": "id"}, "S2A%"]}]},
+				{"op": "in", "args": [{"property": "collection"}, ["a", "b"]]}
+			]}`,
+		},
+		{
+			`"eo:cloud_cover" BETWEEN 0 AND 20.5 AND sentinel:sequence IS NULL`,
+			`{"op": "and", "args": [
+				{"op": "between", "args": [{"property": "eo:cloud_cover"}, 0, 20.5]},
+				{"op": "isNull", "args": [{"property": "sentinel:sequence"}]}
+			]}`,
+		},
+		{
+			"S_INTERSECTS(geometry, POLYGON((0 0, 1 0, 1 1, 0 0)))",
+			`{"op": "s_intersects", "args": [{"property": "geometry"},
+				{"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}]}`,
+		},
+		{
+			"s_within(geometry, BBOX(-180, -90, 180, 90))",
+			`{"op": "s_within", "args": [{"property": "geometry"}, {"bbox": [-180, -90, 180, 90]}]}`,
+		},
+		{
+			"T_INTERSECTS(datetime, INTERVAL('2020-01-01T00:00:00Z', '..')) AND updated > TIMESTAMP('2020-08-30T10:49:43Z')",
+			`{"op": "and", "args": [
+				{"op": "t_intersects", "args": [{"property": "datetime"}, {"interval": ["2020-01-01T00:00:00Z", ".."]}]},
+				{"op": ">", "args": [{"property": "updated"}, {"timestamp": "2020-08-30T10:49:43Z"}]}
+			]}`,
+		},
+	}
+	for _, test := range tests {
+		textExpr, err := cql2.ParseText(test.text)
+		if err != nil {
+			t.Fatalf("Unexpected error parsing %q: %v", test.text, err)
+		}
+		jsonExpr, err := cql2.ParseJSON([]byte(test.jsonForm))
+		if err != nil {
+			t.Fatalf("Unexpected error parsing %s: %v", test.jsonForm, err)
+		}
+		textSQL, textArgs, _ := cql2sql.ToSQL(textExpr)
+		jsonSQL, jsonArgs, _ := cql2sql.ToSQL(jsonExpr)
+		if textSQL != jsonSQL || !reflect.DeepEqual(textArgs, jsonArgs) {
+			t.Errorf("Expected %q %v but got %q %v", jsonSQL, jsonArgs, textSQL, textArgs)
+		}
+	}
+}
+
+func TestCql2TextSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		pos  int
+	}{
+		{"eo:cloud_cover < ", 18},
+		{"eo:cloud_cover 10", 16},
+		{"platform = 'sentinel-2b", 12},
+		{"(gsd > 5", 9},
+		{"gsd > 5 AND", 12},
+		{"gsd BETWEEN 1 OR 2", 15},
+		{"gsd > 5 # 2", 9},
+		{"s_intersects(geometry, POINT(1))", 31},
+	}
+	for _, test := range tests {
+		_, err := cql2.ParseText(test.text)
+		syntaxErr, ok := err.(*cql2.SyntaxError)
+		if !ok {
+			t.Errorf("Expected a syntax error for %q but got %v", test.text, err)
+			continue
+		}
+		if syntaxErr.Pos != test.pos {
+			t.Errorf("Expected error at position %d for %q but got %v", test.pos, test.text, syntaxErr)
+		}
+	}
+}
+
+func TestCql2Properties(t *testing.T) {
+	filter, err := cql2.ParseText("properties.eo:cloud_cover < 10 AND (platform = 'sentinel-2b' OR NOT gsd BETWEEN eo:cloud_cover AND 20)" +
+		" AND S_INTERSECTS(geometry, POINT(1 2)) AND T_AFTER(datetime, TIMESTAMP('2020-01-01T00:00:00Z'))")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []string{"datetime", "eo:cloud_cover", "geometry", "gsd", "platform"}
+	if properties := cql2.Properties(filter); !reflect.DeepEqual(properties, expected) {
+		t.Errorf("Expected properties %v but got %v", expected, properties)
+	}
+}
+
+func TestCql2GeoJSONEmpty(t *testing.T) {
+	for _, geometry := range []string{
+		`{"type": "Polygon", "coordinates": []}`,
+		`{"type": "Polygon", "coordinates": [[]]}`,
+		`{"type": "MultiPolygon", "coordinates": []}`,
+		`{"type": "MultiPoint", "coordinates": []}`,
+		`{"type": "LineString", "coordinates": []}`,
+	} {
+		if _, err := cql2.ParseGeoJSON([]byte(geometry)); err == nil {
+			t.Errorf("Expected an error for %s", geometry)
+		}
+	}
+
+	g, err := cql2.ParseGeoJSON([]byte(`{"type": "GeometryCollection", "geometries": []}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := `{"type":"GeometryCollection","geometries":[]}`; string(g.GeoJSON) != expected {
+		t.Errorf("Expected %s but got %s", expected, g.GeoJSON)
+	}
+}
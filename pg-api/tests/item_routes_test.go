@@ -7,8 +7,10 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/jonhealy1/goapi-stac/pg-api/models"
 	"github.com/jonhealy1/goapi-stac/pg-api/responses"
@@ -264,6 +266,272 @@ func TestEditItem(t *testing.T) {
 	assert.Equalf(t, "success", item_response.Message, "update item")
 }
 
+// TestEditItemPath checks that an edit only writes the item of its path.
+func TestEditItemPath(t *testing.T) {
+	tests := []struct {
+		description  string
+		route        string
+		id           string
+		collection   string
+		expectedCode int
+	}{
+		{
+			description:  "edit with the id of another item",
+			route:        "/collections/sentinel-s2-l2a-cogs-test/items/S2B_1CCV_20181004_0_L2A-test",
+			id:           "S2B_1CCV_20181004_0_L2A",
+			collection:   "sentinel-s2-l2a-cogs-test",
+			expectedCode: 400,
+		},
+		{
+			description:  "edit with another collection",
+			route:        "/collections/sentinel-s2-l2a-cogs-test/items/S2B_1CCV_20181004_0_L2A-test",
+			id:           "S2B_1CCV_20181004_0_L2A-test",
+			collection:   "sentinel-s2-l2a-cogs-test-2",
+			expectedCode: 400,
+		},
+		{
+			description:  "edit of an item that does not exist",
+			route:        "/collections/sentinel-s2-l2a-cogs-test/items/S2B_1CCV_20181004_0_L2A-test-x",
+			id:           "S2B_1CCV_20181004_0_L2A-test-x",
+			collection:   "sentinel-s2-l2a-cogs-test",
+			expectedCode: 404,
+		},
+	}
+
+	var item map[string]interface{}
+	b, _ := os.ReadFile("setup_data/S2B_1CCV_20181004_0_L2A-test-updated.json")
+	json.Unmarshal(b, &item)
+
+	app := Setup()
+	for _, test := range tests {
+		item["id"], item["collection"] = test.id, test.collection
+		body, _ := json.Marshal(item)
+		req, _ := http.NewRequest(http.MethodPut, test.route, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+		assert.Emptyf(t, resp.Header.Get("ETag"), test.description)
+	}
+}
+
+func TestPatchItem(t *testing.T) {
+	route := "/collections/sentinel-s2-l2a-cogs-test/items/S2B_1CCV_20181004_0_L2A-test"
+	tests := []struct {
+		description  string
+		route        string
+		contentType  string
+		patch        string
+		expectedCode int
+		// expectedBbox is the bbox of the patched item, if set
+		expectedBbox []float64
+	}{
+		{
+			description:  "merge patch of a property",
+			route:        route,
+			contentType:  "application/merge-patch+json",
+			patch:        `{"properties": {"eo:cloud_cover": 12.5, "sentinel:valid_cloud_cover": null}}`,
+			expectedCode: 200,
+		},
+		{
+			description:  "merge patch of the geometry into a point",
+			route:        route,
+			contentType:  "application/merge-patch+json",
+			patch:        `{"geometry": {"type": "Point", "coordinates": [10.5, -20]}}`,
+			expectedCode: 200,
+			expectedBbox: []float64{10.5, -20, 10.5, -20},
+		},
+		{
+			description:  "json patch of the geometry",
+			route:        route,
+			contentType:  "application/json-patch+json",
+			patch:        `[{"op": "replace", "path": "/geometry", "value": {"type": "Polygon", "coordinates": [[[-177, -89], [-170, -89], [-170, -80], [-177, -80], [-177, -89]]]}}]`,
+			expectedCode: 200,
+			expectedBbox: []float64{-177, -89, -170, -80},
+		},
+		{
+			description:  "json patch of the coordinates of the geometry",
+			route:        route,
+			contentType:  "application/json-patch+json",
+			patch:        `[{"op": "replace", "path": "/geometry/coordinates", "value": [[[-177, -89], [-170, -89], [-170, -80], [-177, -80], [-177, -89]]]}]`,
+			expectedCode: 200,
+			expectedBbox: []float64{-177, -89, -170, -80},
+		},
+		{
+			description:  "patch changing the id",
+			route:        route,
+			contentType:  "application/merge-patch+json",
+			patch:        `{"id": "another-id"}`,
+			expectedCode: 422,
+		},
+		{
+			description:  "patch with an invalid datetime",
+			route:        route,
+			contentType:  "application/merge-patch+json",
+			patch:        `{"properties": {"datetime": "yesterday"}}`,
+			expectedCode: 422,
+		},
+		{
+			description:  "json patch failing its test",
+			route:        route,
+			contentType:  "application/json-patch+json",
+			patch:        `[{"op": "test", "path": "/properties/eo:cloud_cover", "value": 99}]`,
+			expectedCode: 409,
+		},
+		{
+			description:  "patch of another media type",
+			route:        route,
+			contentType:  "application/json",
+			patch:        `{"properties": {"eo:cloud_cover": 1}}`,
+			expectedCode: 415,
+		},
+		{
+			description:  "patch of an item that does not exist",
+			route:        route + "-x",
+			contentType:  "application/merge-patch+json",
+			patch:        `{"properties": {"eo:cloud_cover": 1}}`,
+			expectedCode: 404,
+		},
+	}
+
+	app := Setup()
+	for _, test := range tests {
+		req, _ := http.NewRequest(http.MethodPatch, test.route, bytes.NewBufferString(test.patch))
+		req.Header.Set("Content-Type", test.contentType)
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+
+		if test.expectedBbox != nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			var patched struct {
+				StacItem struct {
+					Bbox []float64 `json:"bbox"`
+				} `json:"stac_item"`
+			}
+			json.Unmarshal(body, &patched)
+			assert.Equalf(t, test.expectedBbox, patched.StacItem.Bbox, test.description)
+		}
+	}
+}
+
+func TestItemETag(t *testing.T) {
+	route := "/collections/sentinel-s2-l2a-cogs-test/items/S2B_1CCV_20181004_0_L2A-test"
+	app := Setup()
+	request := func(method string, header string, etag string, body string) *http.Response {
+		req, _ := http.NewRequest(method, route, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		if header != "" {
+			req.Header.Set(header, etag)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return resp
+	}
+
+	resp := request(http.MethodGet, "", "", "")
+	assert.Equalf(t, 200, resp.StatusCode, "get item")
+	etag := resp.Header.Get("ETag")
+	assert.NotEmptyf(t, etag, "get item")
+	assert.NotContainsf(t, etag, "W/", "get item")
+
+	resp = request(http.MethodGet, "If-None-Match", etag, "")
+	assert.Equalf(t, 304, resp.StatusCode, "get item of a known version")
+
+	resp = request(http.MethodPatch, "If-Match", `"0"`, `{"properties": {"eo:cloud_cover": 3}}`)
+	assert.Equalf(t, 412, resp.StatusCode, "patch of another version")
+
+	resp = request(http.MethodPatch, "If-Match", etag, `{"properties": {"eo:cloud_cover": 3}}`)
+	assert.Equalf(t, 200, resp.StatusCode, "patch of the current version")
+	patched := resp.Header.Get("ETag")
+	assert.NotEqualf(t, etag, patched, "patch of the current version")
+
+	// the version read first is lost to the patch
+	resp = request(http.MethodPatch, "If-Match", etag, `{"properties": {"eo:cloud_cover": 4}}`)
+	assert.Equalf(t, 412, resp.StatusCode, "patch of a version patched since")
+	resp = request(http.MethodDelete, "If-Match", etag, "")
+	assert.Equalf(t, 412, resp.StatusCode, "delete of a version patched since")
+
+	resp = request(http.MethodGet, "If-None-Match", etag, "")
+	assert.Equalf(t, 200, resp.StatusCode, "get item of an old version")
+	assert.Equalf(t, patched, resp.Header.Get("ETag"), "get item of an old version")
+}
+
+func TestItemVersions(t *testing.T) {
+	route := "/collections/sentinel-s2-l2a-cogs-test/items/S2B_1CCV_20181004_0_L2A-test"
+	app := Setup()
+	get := func(route string) (*http.Response, []byte) {
+		req, _ := http.NewRequest(http.MethodGet, route, nil)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	req, _ := http.NewRequest(http.MethodPatch, route, bytes.NewBufferString(`{"properties": {"eo:cloud_cover": 5}}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("X-Client-Label", "reprocessing")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	assert.Equalf(t, 200, resp.StatusCode, "patch item")
+
+	resp, body := get(route + "/versions")
+	assert.Equalf(t, 200, resp.StatusCode, "get versions")
+	var history struct {
+		Versions []struct {
+			Revision    int64                  `json:"revision"`
+			Operation   string                 `json:"operation"`
+			ClientLabel string                 `json:"client_label"`
+			ChangedAt   time.Time              `json:"changed_at"`
+			StacItem    map[string]interface{} `json:"stac_item"`
+		} `json:"versions"`
+	}
+	json.Unmarshal(body, &history)
+	if len(history.Versions) < 2 {
+		t.Fatalf("Expected the item to have several versions, got %s", body)
+	}
+	first, last := history.Versions[0], history.Versions[len(history.Versions)-1]
+	assert.Equalf(t, "insert", first.Operation, "first version")
+	assert.Equalf(t, "reprocessing", last.ClientLabel, "last version")
+
+	resp, body = get(route + "?asof=" + url.QueryEscape(first.ChangedAt.Format(time.RFC3339Nano)))
+	assert.Equalf(t, 200, resp.StatusCode, "get item as first created")
+	var asOf struct {
+		Revision int64                  `json:"revision"`
+		StacItem map[string]interface{} `json:"stac_item"`
+	}
+	json.Unmarshal(body, &asOf)
+	assert.Equalf(t, first.Revision, asOf.Revision, "get item as first created")
+	assert.Equalf(t, first.StacItem, asOf.StacItem, "get item as first created")
+
+	resp, _ = get(route + "?asof=2000-01-01T00:00:00Z")
+	assert.Equalf(t, 404, resp.StatusCode, "get item before it was created")
+	resp, _ = get(route + "?asof=yesterday")
+	assert.Equalf(t, 400, resp.StatusCode, "get item with an invalid asof")
+
+	search := "/search?ids=S2B_1CCV_20181004_0_L2A-test&asof="
+	for asof, expected := range map[string]int{"2000-01-01T00:00:00Z": 0, time.Now().UTC().Format(time.RFC3339): 1} {
+		resp, body = get(search + url.QueryEscape(asof))
+		assert.Equalf(t, 200, resp.StatusCode, "search as of %s", asof)
+		var page struct {
+			Features []interface{} `json:"features"`
+		}
+		json.Unmarshal(body, &page)
+		assert.Equalf(t, expected, len(page.Features), "search as of %s", asof)
+	}
+}
+
 func TestDeleteItem(t *testing.T) {
 	app := Setup()
 	resp, err := http.NewRequest(
@@ -279,3 +547,71 @@ func TestDeleteItem(t *testing.T) {
 
 	assert.Equalf(t, 200, res.StatusCode, "delete item")
 }
+
+func TestGetItemCollectionPagination(t *testing.T) {
+	route := "/collections/sentinel-s2-l2a-cogs-test/items?limit=20"
+	returned := []int{}
+	for route != "" {
+		// the test app caches GET responses by path, so each page needs its own
+		app := Setup()
+		req, _ := http.NewRequest("GET", route, nil)
+		res, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+
+		body, _ := ioutil.ReadAll(res.Body)
+		var item_collection models.ItemCollection
+		json.Unmarshal(body, &item_collection)
+		returned = append(returned, item_collection.Context.Returned)
+
+		route = ""
+		for _, link := range item_collection.Links {
+			if link.Rel == "next" {
+				href, _ := url.Parse(link.Href)
+				route = href.RequestURI()
+			}
+		}
+	}
+
+	assert.Equal(t, []int{20, 20, 10}, returned)
+}
+
+func TestGetItemCollectionFilter(t *testing.T) {
+	bbox := "97.504892,-75.254738,179.321298,-65.431580"
+	tests := []struct {
+		description      string
+		query            string
+		expectedCode     int
+		expectedReturned int
+	}{
+		{"bbox", "bbox=" + bbox, 200, 50},
+		{"bbox-crs CRS84", "bbox=" + bbox + "&bbox-crs=" + url.QueryEscape("http://www.opengis.net/def/crs/OGC/1.3/CRS84"), 200, 50},
+		{"bbox-crs EPSG:4326", "bbox=-75.254738,97.504892,-65.431580,179.321298&bbox-crs=" + url.QueryEscape("http://www.opengis.net/def/crs/EPSG/0/4326"), 200, 50},
+		{"bbox with no items", "bbox=17.504892,-75.254738,19.321298,-65.431580", 200, 0},
+		{"datetime", "datetime=" + url.QueryEscape("../2019-01-01T00:00:00Z"), 200, 9},
+		{"limit above the maximum", "limit=1000000", 200, 50},
+		{"unsupported bbox-crs", "bbox=" + bbox + "&bbox-crs=" + url.QueryEscape("http://www.opengis.net/def/crs/EPSG/0/3857"), 400, 0},
+		{"invalid bbox", "bbox=1,2,3", 400, 0},
+		{"invalid limit", "limit=0", 400, 0},
+	}
+
+	for _, test := range tests {
+		// the test app caches GET responses by path, so each request needs its own
+		app := Setup()
+		req, _ := http.NewRequest("GET", "/collections/sentinel-s2-l2a-cogs-test/items?"+test.query, nil)
+		res, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		if res.StatusCode != test.expectedCode {
+			t.Errorf("%s: expected status code %d, but got %d", test.description, test.expectedCode, res.StatusCode)
+			continue
+		}
+		if test.expectedCode != 200 {
+			continue
+		}
+
+		body, _ := ioutil.ReadAll(res.Body)
+		var item_collection models.ItemCollection
+		json.Unmarshal(body, &item_collection)
+		assert.Equalf(t, test.expectedReturned, item_collection.Context.Returned, test.description)
+	}
+}
@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jonhealy1/goapi-stac/shared/patch"
+	"github.com/jonhealy1/goapi-stac/shared/stac"
+)
+
+func TestMergePatch(t *testing.T) {
+	// examples of RFC 7396
+	tests := []struct {
+		doc      string
+		patch    string
+		expected string
+	}{
+		{`{"a": "b"}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "b"}`, `{"b": "c"}`, `{"a": "b", "b": "c"}`},
+		{`{"a": "b"}`, `{"a": null}`, `{}`},
+		{`{"a": "b", "b": "c"}`, `{"a": null}`, `{"b": "c"}`},
+		{`{"a": ["b"]}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "c"}`, `{"a": ["b"]}`, `{"a": ["b"]}`},
+		{`{"a": {"b": "c"}}`, `{"a": {"b": "d", "c": null}}`, `{"a": {"b": "d"}}`},
+		{`{"a": [{"b": "c"}]}`, `{"a": [1]}`, `{"a": [1]}`},
+		{`["a", "b"]`, `["c", "d"]`, `["c", "d"]`},
+		{`{"a": "b"}`, `["c"]`, `["c"]`},
+		{`{"e": null}`, `{"a": 1}`, `{"e": null, "a": 1}`},
+		{`[1, 2]`, `{"a": "b", "c": null}`, `{"a": "b"}`},
+		{`{}`, `{"a": {"bb": {"ccc": null}}}`, `{"a": {"bb": {}}}`},
+	}
+	for _, test := range tests {
+		result, err := patch.Apply([]byte(test.doc), patch.MergePatchType, []byte(test.patch))
+		if err != nil {
+			t.Fatalf("Unexpected error patching %s with %s: %v", test.doc, test.patch, err)
+		}
+		assertSameJSON(t, result, test.expected)
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	// examples of RFC 6902
+	tests := []struct {
+		doc      string
+		patch    string
+		expected string
+	}{
+		{`{"foo": "bar"}`, `[{"op": "add", "path": "/baz", "value": "qux"}]`, `{"baz": "qux", "foo": "bar"}`},
+		{`{"foo": ["bar", "baz"]}`, `[{"op": "add", "path": "/foo/1", "value": "qux"}]`, `{"foo": ["bar", "qux", "baz"]}`},
+		{`{"baz": "qux", "foo": "bar"}`, `[{"op": "remove", "path": "/baz"}]`, `{"foo": "bar"}`},
+		{`{"foo": ["bar", "qux", "baz"]}`, `[{"op": "remove", "path": "/foo/1"}]`, `{"foo": ["bar", "baz"]}`},
+		{`{"baz": "qux", "foo": "bar"}`, `[{"op": "replace", "path": "/baz", "value": "boo"}]`, `{"baz": "boo", "foo": "bar"}`},
+		{
+			`{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+			`[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+			`{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`,
+		},
+		{`{"foo": ["all", "grass", "cows", "eat"]}`, `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`, `{"foo": ["all", "cows", "eat", "grass"]}`},
+		{
+			`{"baz": "qux", "foo": ["a", 2, "c"]}`,
+			`[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2}]`,
+			`{"baz": "qux", "foo": ["a", 2, "c"]}`,
+		},
+		{`{"foo": "bar"}`, `[{"op": "add", "path": "/child", "value": {"grandchild": {}}}]`, `{"foo": "bar", "child": {"grandchild": {}}}`},
+		{`{"foo": ["bar"]}`, `[{"op": "add", "path": "/foo/-", "value": ["abc", "def"]}]`, `{"foo": ["bar", ["abc", "def"]]}`},
+		{`{"foo": null}`, `[{"op": "test", "path": "/foo", "value": null}]`, `{"foo": null}`},
+		{`{"/": 9, "~1": 10}`, `[{"op": "test", "path": "/~01", "value": 10}, {"op": "copy", "from": "/~1", "path": "/a"}]`, `{"/": 9, "~1": 10, "a": 9}`},
+	}
+	for _, test := range tests {
+		result, err := patch.Apply([]byte(test.doc), patch.JSONPatchType, []byte(test.patch))
+		if err != nil {
+			t.Fatalf("Unexpected error patching %s with %s: %v", test.doc, test.patch, err)
+		}
+		assertSameJSON(t, result, test.expected)
+	}
+}
+
+func TestJSONPatchErrors(t *testing.T) {
+	tests := []struct {
+		patch    string
+		expected error
+	}{
+		{`[{"op": "add", "path": "/baz/bat", "value": "qux"}]`, patch.ErrConflict},
+		{`[{"op": "test", "path": "/baz", "value": "bar"}]`, patch.ErrConflict},
+		{`[{"op": "remove", "path": "/foo/2"}]`, patch.ErrConflict},
+		{`[{"op": "replace", "path": "/nothing", "value": 1}]`, patch.ErrConflict},
+		{`[{"op": "add", "path": "/foo/01", "value": 1}]`, patch.ErrConflict},
+		{`[{"op": "add", "path": "/baz"}]`, patch.ErrInvalidPatch},
+		{`[{"op": "move", "from": "/foo", "path": "/foo/0"}]`, patch.ErrInvalidPatch},
+		{`[{"op": "frobnicate", "path": "/baz"}]`, patch.ErrInvalidPatch},
+		{`[{"op": "add", "path": "baz", "value": 1}]`, patch.ErrInvalidPatch},
+		{`{"op": "add", "path": "/baz", "value": 1}`, patch.ErrInvalidPatch},
+	}
+	for _, test := range tests {
+		_, err := patch.Apply([]byte(`{"baz": "qux", "foo": ["bar", "baz"]}`), patch.JSONPatchType, []byte(test.patch))
+		if !errors.Is(err, test.expected) {
+			t.Errorf("Expected %v patching with %s, but got %v", test.expected, test.patch, err)
+		}
+	}
+
+	_, err := patch.Apply([]byte(`{}`), "application/json", []byte(`{}`))
+	if err != patch.ErrUnsupportedType {
+		t.Errorf("Expected %v, but got %v", patch.ErrUnsupportedType, err)
+	}
+}
+
+func TestPatchKeepsNumbers(t *testing.T) {
+	doc := `{"big":12345678901234567890,"precise":0.1000000000000000055511151231257827,"small":1e-7}`
+	tests := []struct {
+		contentType string
+		patch       string
+		expected    string
+	}{
+		{patch.MergePatchType, `{"name":"a"}`, `{"big":12345678901234567890,"name":"a","precise":0.1000000000000000055511151231257827,"small":1e-7}`},
+		{patch.JSONPatchType, `[{"op":"test","path":"/big","value":12345678901234567890},{"op":"copy","from":"/big","path":"/copy"}]`, `{"big":12345678901234567890,"copy":12345678901234567890,"precise":0.1000000000000000055511151231257827,"small":1e-7}`},
+		{patch.JSONPatchType, `[{"op":"test","path":"/small","value":0.0000001}]`, doc},
+	}
+	for _, test := range tests {
+		result, err := patch.Apply([]byte(doc), test.contentType, []byte(test.patch))
+		if err != nil {
+			t.Fatalf("Unexpected error patching with %s: %v", test.patch, err)
+		}
+		if string(result) != test.expected {
+			t.Errorf("Expected %s patching with %s, but got %s", test.expected, test.patch, result)
+		}
+	}
+}
+
+func assertSameJSON(t *testing.T, result []byte, expected string) {
+	t.Helper()
+	var resultValue, expectedValue interface{}
+	json.Unmarshal(result, &resultValue)
+	json.Unmarshal([]byte(expected), &expectedValue)
+	if !reflect.DeepEqual(resultValue, expectedValue) {
+		t.Errorf("Expected %s but got %s", expected, result)
+	}
+}
+
+func TestValidateItemGeometry(t *testing.T) {
+	doc := `{"type": "Feature", "id": "a", "collection": "c", "bbox": [0, 0, 1, 1],
+		"geometry": {"type": "Point", "coordinates": [0, 0]},
+		"properties": {"datetime": "2020-01-01T00:00:00Z"}}`
+	tests := []struct {
+		geometry string
+		bbox     []float64
+	}{
+		{`{"type": "Point", "coordinates": [1, 2]}`, []float64{1, 2, 1, 2}},
+		{`{"type": "MultiPolygon", "coordinates": [[[[0, 0], [3, 0], [3, 1], [0, 0]]], [[[-1, -2], [0, -2], [0, 0], [-1, -2]]]]}`, []float64{-1, -2, 3, 1}},
+		{`{"type": "GeometryCollection", "geometries": [{"type": "GeometryCollection", "geometries": []}, {"type": "Point", "coordinates": [5, 6]}]}`, []float64{5, 6, 5, 6}},
+		{`{"type": "Polygon", "coordinates": []}`, nil},
+		{`{"type": "GeometryCollection", "geometries": []}`, nil},
+	}
+	for _, test := range tests {
+		merge := []byte(`{"geometry": ` + test.geometry + `}`)
+		patched, err := patch.Apply([]byte(doc), patch.MergePatchType, merge)
+		if err != nil {
+			t.Fatalf("Unexpected error patching %s: %v", test.geometry, err)
+		}
+		patched, _, err = stac.ValidateItem([]byte(doc), patched, "a", "c")
+		if test.bbox == nil {
+			if !errors.Is(err, stac.ErrInvalidItem) {
+				t.Errorf("Expected an invalid item for %s but got %v", test.geometry, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error validating %s: %v", test.geometry, err)
+		}
+		var item struct {
+			Bbox []float64 `json:"bbox"`
+		}
+		json.Unmarshal(patched, &item)
+		if !reflect.DeepEqual(item.Bbox, test.bbox) {
+			t.Errorf("Expected bbox %v for %s but got %v", test.bbox, test.geometry, item.Bbox)
+		}
+	}
+}
@@ -6,8 +6,11 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/jonhealy1/goapi-stac/pg-api/models"
 	"github.com/jonhealy1/goapi-stac/pg-api/responses"
 )
 
@@ -1069,3 +1072,991 @@ func TestGetSearchLineNoResults(t *testing.T) {
 		t.Errorf("Expected returned %d, but got %d", expectedReturned, searchResponse.Context.Returned)
 	}
 }
+
+func TestPostSearchFilter(t *testing.T) {
+	jsonBody := []byte(`{
+		"collections": ["sentinel-s2-l2a-cogs-test"],
+		"filter-lang": "cql2-json",
+		"filter": {
+			"op": "and",
+			"args": [
+				{"op": "<", "args": [{"property": "eo:cloud_cover"}, 10]},
+				{"op": "=", "args": [{"property": "platform"}, "sentinel-2b"]}
+			]
+		}
+	}`)
+	bodyReader := bytes.NewReader(jsonBody)
+
+	app := Setup()
+	req, _ := http.NewRequest("POST", "/search", bodyReader)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status code 200, but got %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+
+	var searchResponse responses.SearchResponse
+	err = json.Unmarshal(body, &searchResponse)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+
+	expectedReturned := 2
+	if searchResponse.Context.Returned != expectedReturned {
+		t.Errorf("Expected returned %d, but got %d", expectedReturned, searchResponse.Context.Returned)
+	}
+}
+
+func TestPostSearchFilterSpatial(t *testing.T) {
+	jsonBody := []byte(`{
+		"filter": {
+			"op": "s_intersects",
+			"args": [
+				{"property": "geometry"},
+				{"bbox": [97.504892, -75.254738, 179.321298, -65.431580]}
+			]
+		}
+	}`)
+	bodyReader := bytes.NewReader(jsonBody)
+
+	app := Setup()
+	req, _ := http.NewRequest("POST", "/search", bodyReader)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status code 200, but got %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+
+	var searchResponse responses.SearchResponse
+	err = json.Unmarshal(body, &searchResponse)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+
+	expectedReturned := 50
+	if searchResponse.Context.Returned != expectedReturned {
+		t.Errorf("Expected returned %d, but got %d", expectedReturned, searchResponse.Context.Returned)
+	}
+}
+
+func TestPostSearchFilterInvalid(t *testing.T) {
+	jsonBody := []byte(`{
+		"filter": {"op": "<", "args": [{"property": "eo:cloud_cover"}]}
+	}`)
+	bodyReader := bytes.NewReader(jsonBody)
+
+	app := Setup()
+	req, _ := http.NewRequest("POST", "/search", bodyReader)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 400 {
+		t.Fatalf("Expected status code 400, but got %d", resp.StatusCode)
+	}
+}
+
+func TestGetSearchFilter(t *testing.T) {
+	app := Setup()
+
+	url := "/search?filter=" + url.QueryEscape("eo:cloud_cover < 10 AND platform = 'sentinel-2b'")
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status code 200, but got %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+
+	var searchResponse responses.SearchResponse
+	err = json.Unmarshal(body, &searchResponse)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+
+	expectedReturned := 2
+	if searchResponse.Context.Returned != expectedReturned {
+		t.Errorf("Expected returned %d, but got %d", expectedReturned, searchResponse.Context.Returned)
+	}
+}
+
+func TestGetSearchFilterSyntaxError(t *testing.T) {
+	app := Setup()
+
+	url := "/search?filter=" + url.QueryEscape("eo:cloud_cover <")
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 400 {
+		t.Fatalf("Expected status code 400, but got %d", resp.StatusCode)
+	}
+}
+
+func TestPostSearchDatetime(t *testing.T) {
+	jsonBody := []byte(`{
+		"collections": ["sentinel-s2-l2a-cogs-test"],
+		"datetime": "2019-01-01T00:00:00Z/2019-12-31T23:59:59Z"
+	}`)
+	bodyReader := bytes.NewReader(jsonBody)
+
+	app := Setup()
+	req, _ := http.NewRequest("POST", "/search", bodyReader)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status code 200, but got %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+
+	var searchResponse responses.SearchResponse
+	err = json.Unmarshal(body, &searchResponse)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+
+	expectedReturned := 21
+	if searchResponse.Context.Returned != expectedReturned {
+		t.Errorf("Expected returned %d, but got %d", expectedReturned, searchResponse.Context.Returned)
+	}
+}
+
+func TestGetSearchDatetime(t *testing.T) {
+	tests := []struct {
+		description      string
+		datetime         string
+		expectedCode     int
+		expectedReturned int
+	}{
+		{"instant", "2018-10-04T21:05:21Z", 200, 1},
+		{"open start", "../2019-01-01T00:00:00Z", 200, 9},
+		{"open end", "2020-01-01T00:00:00Z/..", 200, 20},
+		{"empty open end", "2020-01-01T00:00:00Z/", 200, 20},
+		{"closed interval", "2019-01-01T00:00:00Z/2019-12-31T23:59:59Z", 200, 21},
+		{"fully open", "../..", 400, 0},
+		{"not a timestamp", "yesterday", 400, 0},
+	}
+
+	for _, test := range tests {
+		// the test app caches GET responses by path, so each request needs its own
+		app := Setup()
+		req, _ := http.NewRequest("GET", "/search?collections=sentinel-s2-l2a-cogs-test&datetime="+url.QueryEscape(test.datetime), nil)
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+
+		if resp.StatusCode != test.expectedCode {
+			t.Errorf("%s: expected status code %d, but got %d", test.description, test.expectedCode, resp.StatusCode)
+			continue
+		}
+		if test.expectedCode != 200 {
+			continue
+		}
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		var searchResponse responses.SearchResponse
+		json.Unmarshal(body, &searchResponse)
+
+		if searchResponse.Context.Returned != test.expectedReturned {
+			t.Errorf("%s: expected returned %d, but got %d", test.description, test.expectedReturned, searchResponse.Context.Returned)
+		}
+	}
+}
+
+// searchPage requests a page of search results and returns the response.
+func searchPage(t *testing.T, method string, target string, body []byte) responses.SearchResponse {
+	app := Setup()
+	req, _ := http.NewRequest(method, target, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status code 200, but got %d", resp.StatusCode)
+	}
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	var searchResponse responses.SearchResponse
+	json.Unmarshal(respBody, &searchResponse)
+	return searchResponse
+}
+
+func pageLink(searchResponse responses.SearchResponse, rel string) *models.Link {
+	for _, link := range searchResponse.Links {
+		if link.Rel == rel {
+			return &link
+		}
+	}
+	return nil
+}
+
+func TestGetSearchPagination(t *testing.T) {
+	page := searchPage(t, "GET", "/search?collections=sentinel-s2-l2a-cogs-test&limit=20", nil)
+	if pageLink(page, "prev") != nil {
+		t.Errorf("Expected no prev link on the first page")
+	}
+
+	seen := map[string]bool{}
+	var pages [][]string
+	for {
+		var ids []string
+		for _, item := range page.Features {
+			if seen[item.Id] {
+				t.Fatalf("Item %s returned twice", item.Id)
+			}
+			seen[item.Id] = true
+			ids = append(ids, item.Id)
+		}
+		pages = append(pages, ids)
+
+		next := pageLink(page, "next")
+		if next == nil {
+			break
+		}
+		href, _ := url.Parse(next.Href)
+		page = searchPage(t, "GET", href.RequestURI(), nil)
+	}
+
+	if len(seen) != 50 || len(pages) != 3 {
+		t.Fatalf("Expected 50 items on 3 pages, but got %d items on %d pages", len(seen), len(pages))
+	}
+
+	prev := pageLink(page, "prev")
+	if prev == nil {
+		t.Fatalf("Expected a prev link on the last page")
+	}
+	href, _ := url.Parse(prev.Href)
+	page = searchPage(t, "GET", href.RequestURI(), nil)
+	for i, item := range page.Features {
+		if item.Id != pages[1][i] {
+			t.Errorf("Expected prev page item %d to be %s, but got %s", i, pages[1][i], item.Id)
+		}
+	}
+}
+
+func TestPostSearchPagination(t *testing.T) {
+	request := map[string]interface{}{
+		"collections": []string{"sentinel-s2-l2a-cogs-test"},
+		"limit":       30,
+	}
+	body, _ := json.Marshal(request)
+	page := searchPage(t, "POST", "/search", body)
+
+	next := pageLink(page, "next")
+	if next == nil {
+		t.Fatalf("Expected a next link")
+	}
+	if next.Method != "POST" || !next.Merge || next.Body["token"] == nil {
+		t.Fatalf("Expected a POST next link merging a token, but got %+v", next)
+	}
+
+	for key, value := range next.Body {
+		request[key] = value
+	}
+	body, _ = json.Marshal(request)
+	page = searchPage(t, "POST", "/search", body)
+
+	if page.Context.Returned != 20 {
+		t.Errorf("Expected returned 20, but got %d", page.Context.Returned)
+	}
+	if pageLink(page, "next") != nil {
+		t.Errorf("Expected no next link on the last page")
+	}
+}
+
+func TestGetSearchInvalidToken(t *testing.T) {
+	app := Setup()
+	req, _ := http.NewRequest("GET", "/search?collections=sentinel-s2-l2a-cogs-test&token=not-a-token", nil)
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+
+	if resp.StatusCode != 400 {
+		t.Errorf("Expected status code 400, but got %d", resp.StatusCode)
+	}
+}
+
+func TestSearchIds(t *testing.T) {
+	tests := []struct {
+		description string
+		method      string
+		target      string
+		body        string
+		expected    int
+	}{
+		{"POST ids", "POST", "/search", `{"ids": ["S2B_1CCV_20181004_0_L2A", "S2B_1CCV_20181024_0_L2A"]}`, 2},
+		{"POST ids and collections", "POST", "/search", `{"ids": ["S2B_1CCV_20181004_0_L2A"], "collections": ["sentinel-s2-l2a-cogs-test"]}`, 1},
+		{"POST ids of another collection", "POST", "/search", `{"ids": ["S2B_1CCV_20181004_0_L2A"], "collections": ["sentinel-s2-l2a-cogs-test-test"]}`, 0},
+		{"GET ids", "GET", "/search?ids=S2B_1CCV_20181004_0_L2A,S2B_1CCV_20181024_0_L2A", "", 2},
+	}
+
+	for _, test := range tests {
+		page := searchPage(t, test.method, test.target, []byte(test.body))
+		if page.Context.Returned != test.expected {
+			t.Errorf("%s: expected returned %d, but got %d", test.description, test.expected, page.Context.Returned)
+		}
+	}
+}
+
+func TestSearchFields(t *testing.T) {
+	tests := []struct {
+		description string
+		method      string
+		target      string
+		body        string
+	}{
+		{
+			"POST fields",
+			"POST",
+			"/search",
+			`{"collections": ["sentinel-s2-l2a-cogs-test"], "limit": 5,
+				"fields": {"include": ["id", "properties.eo:cloud_cover", "assets.thumbnail"], "exclude": ["properties.datetime"]}}`,
+		},
+		{
+			"GET fields",
+			"GET",
+			"/search?collections=sentinel-s2-l2a-cogs-test&limit=5&fields=id,%2Bproperties.eo:cloud_cover,assets.thumbnail,-properties.datetime",
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		app := Setup()
+		req, _ := http.NewRequest(test.method, test.target, bytes.NewReader([]byte(test.body)))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+		if resp.StatusCode != 200 {
+			t.Fatalf("%s: expected status code 200, but got %d", test.description, resp.StatusCode)
+		}
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		var searchResponse struct {
+			Features []map[string]interface{} `json:"features"`
+			Links    []models.Link            `json:"links"`
+		}
+		json.Unmarshal(body, &searchResponse)
+
+		if len(searchResponse.Features) != 5 {
+			t.Fatalf("%s: expected 5 features, but got %d", test.description, len(searchResponse.Features))
+		}
+		for _, feature := range searchResponse.Features {
+			properties, _ := feature["properties"].(map[string]interface{})
+			assets, _ := feature["assets"].(map[string]interface{})
+			if len(feature) != 3 || feature["id"] == nil {
+				t.Errorf("%s: expected only id, properties and assets, but got %v", test.description, feature)
+			}
+			if len(properties) != 1 || properties["eo:cloud_cover"] == nil {
+				t.Errorf("%s: expected only eo:cloud_cover in properties, but got %v", test.description, properties)
+			}
+			if len(assets) != 1 || assets["thumbnail"] == nil {
+				t.Errorf("%s: expected only the thumbnail asset, but got %v", test.description, assets)
+			}
+		}
+		if pageLink(responses.SearchResponse{Links: searchResponse.Links}, "next") == nil {
+			t.Errorf("%s: expected a next link", test.description)
+		}
+	}
+}
+
+func TestSearchQueryExtension(t *testing.T) {
+	tests := []struct {
+		description string
+		method      string
+		target      string
+		body        string
+		expected    int
+	}{
+		{
+			"POST number and string",
+			"POST", "/search",
+			`{"collections": ["sentinel-s2-l2a-cogs-test"], "query": {"eo:cloud_cover": {"lt": 10}, "platform": {"in": ["landsat-8", "sentinel-2b"]}}}`,
+			2,
+		},
+		{
+			"POST timestamp",
+			"POST", "/search",
+			`{"collections": ["sentinel-s2-l2a-cogs-test"], "query": {"datetime": {"gte": "2020-01-01T00:00:00Z"}}}`,
+			20,
+		},
+		{
+			"POST string prefix",
+			"POST", "/search",
+			`{"collections": ["sentinel-s2-l2a-cogs-test"], "query": {"platform": {"startsWith": "sentinel-"}}}`,
+			50,
+		},
+		{
+			"GET query",
+			"GET",
+			"/search?collections=sentinel-s2-l2a-cogs-test&query=" + url.QueryEscape(`{"platform": {"neq": "sentinel-2b"}}`),
+			"",
+			0,
+		},
+	}
+
+	for _, test := range tests {
+		page := searchPage(t, test.method, test.target, []byte(test.body))
+		if page.Context.Returned != test.expected {
+			t.Errorf("%s: expected returned %d, but got %d", test.description, test.expected, page.Context.Returned)
+		}
+	}
+}
+
+func TestGetSearchSortby(t *testing.T) {
+	page := searchPage(t, "GET", "/search?collections=sentinel-s2-l2a-cogs-test&sortby=-properties.eo:cloud_cover,%2Bid", nil)
+	if page.Context.Returned != 50 {
+		t.Fatalf("Expected returned 50, but got %d", page.Context.Returned)
+	}
+
+	previous := 101.0
+	for _, item := range page.Features {
+		cloudCover, _ := item.Properties.(map[string]interface{})["eo:cloud_cover"].(float64)
+		if cloudCover > previous {
+			t.Fatalf("Expected items sorted by descending cloud cover, but %s has %v after %v", item.Id, cloudCover, previous)
+		}
+		previous = cloudCover
+	}
+
+	page = searchPage(t, "GET", "/search?collections=sentinel-s2-l2a-cogs-test&sortby=id", nil)
+	for i := 1; i < len(page.Features); i++ {
+		if page.Features[i-1].Id > page.Features[i].Id {
+			t.Fatalf("Expected items sorted by ascending id, but %s comes before %s", page.Features[i-1].Id, page.Features[i].Id)
+		}
+	}
+}
+
+func TestGetSearchSortbyInvalid(t *testing.T) {
+	app := Setup()
+	req, _ := http.NewRequest("GET", "/search?sortby="+url.QueryEscape("properties.foo'"), nil)
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+
+	if resp.StatusCode != 400 {
+		t.Errorf("Expected status code 400, but got %d", resp.StatusCode)
+	}
+}
+
+func TestSortables(t *testing.T) {
+	app := Setup()
+	req, _ := http.NewRequest("GET", "/sortables", nil)
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status code 200, but got %d", resp.StatusCode)
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	var sortables struct {
+		Properties map[string]interface{} `json:"properties"`
+	}
+	json.Unmarshal(body, &sortables)
+
+	for _, field := range []string{"id", "properties.datetime", "properties.eo:cloud_cover"} {
+		if sortables.Properties[field] == nil {
+			t.Errorf("Expected %s to be sortable", field)
+		}
+	}
+}
+
+func TestSearchIntersects(t *testing.T) {
+	polygon := `{"type": "Polygon", "coordinates": [[[170.8515625, -74.14512718337613], [178.359375, -74.14512718337613], [178.359375, -70.15296965617042], [170.8515625, -70.15296965617042], [170.8515625, -74.14512718337613]]]}`
+	multiPolygon := `{"type": "MultiPolygon", "coordinates": [
+		[[[170.8515625, -74.14512718337613], [178.359375, -74.14512718337613], [178.359375, -70.15296965617042], [170.8515625, -70.15296965617042], [170.8515625, -74.14512718337613]]],
+		[[[10, 10], [11, 10], [11, 11], [10, 11], [10, 10]]]
+	]}`
+	collection := `{"type": "GeometryCollection", "geometries": [{"type": "Point", "coordinates": [10, 10]}, ` + polygon + `]}`
+
+	tests := []struct {
+		description string
+		method      string
+		target      string
+		body        string
+		expected    int
+	}{
+		{"POST MultiPolygon", "POST", "/search", `{"collections": ["sentinel-s2-l2a-cogs-test"], "intersects": ` + multiPolygon + `}`, 50},
+		{"POST GeometryCollection", "POST", "/search", `{"collections": ["sentinel-s2-l2a-cogs-test"], "intersects": ` + collection + `}`, 50},
+		{"GET intersects", "GET", "/search?collections=sentinel-s2-l2a-cogs-test&intersects=" + url.QueryEscape(polygon), "", 50},
+		{"POST bbox across the antimeridian", "POST", "/search", `{"collections": ["sentinel-s2-l2a-cogs-test"], "bbox": [170.8515625, -74.1451271, -179, -70.1529696]}`, 50},
+		{"GET filter on a bbox across the antimeridian", "GET", "/search?collections=sentinel-s2-l2a-cogs-test&filter=" + url.QueryEscape("S_INTERSECTS(geometry, BBOX(170.8515625, -74.1451271, -179, -70.1529696))"), "", 50},
+	}
+
+	for _, test := range tests {
+		page := searchPage(t, test.method, test.target, []byte(test.body))
+		if page.Context.Returned != test.expected {
+			t.Errorf("%s: expected returned %d, but got %d", test.description, test.expected, page.Context.Returned)
+		}
+	}
+}
+
+func TestSearchIntersectsInvalid(t *testing.T) {
+	bodies := []string{
+		`{"intersects": {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 1]]]}}`,
+		`{"intersects": {"type": "Point", "coordinates": [0, 100]}}`,
+		`{"intersects": {"type": "Point", "coordinates": [0, 0]}, "bbox": [0, 0, 1, 1]}`,
+		`{"bbox": [0, 10, 1, 5]}`,
+	}
+
+	for _, body := range bodies {
+		app := Setup()
+		req, _ := http.NewRequest("POST", "/search", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+		if resp.StatusCode != 400 {
+			t.Errorf("Expected status code 400 for %s, but got %d", body, resp.StatusCode)
+		}
+	}
+}
+
+func TestSearchNumberMatched(t *testing.T) {
+	tests := []struct {
+		description string
+		method      string
+		target      string
+		body        string
+		matched     int
+		returned    int
+	}{
+		{"first page of many", "GET", "/search?collections=sentinel-s2-l2a-cogs-test&limit=20", "", 50, 20},
+		{"single page", "POST", "/search", `{"collections": ["sentinel-s2-l2a-cogs-test"]}`, 50, 50},
+		{"ids", "POST", "/search", `{"ids": ["S2B_1CCV_20181004_0_L2A", "S2B_1CCV_20181024_0_L2A"], "limit": 1}`, 2, 1},
+		{"no matches", "POST", "/search", `{"collections": ["sentinel-s2-l2a-cogs-test"], "bbox": [17.504892, -75.254738, 19.321298, -65.431580]}`, 0, 0},
+	}
+
+	for _, test := range tests {
+		page := searchPage(t, test.method, test.target, []byte(test.body))
+		if page.NumberMatched != test.matched || page.Context.Matched != test.matched {
+			t.Errorf("%s: expected %d matched, but got %d and %d in the context", test.description, test.matched, page.NumberMatched, page.Context.Matched)
+		}
+		if page.NumberReturned != test.returned {
+			t.Errorf("%s: expected %d returned, but got %d", test.description, test.returned, page.NumberReturned)
+		}
+		if page.Context.MatchedEstimated {
+			t.Errorf("%s: expected an exact count", test.description)
+		}
+	}
+
+	// later pages are counted too
+	first := searchPage(t, "GET", "/search?collections=sentinel-s2-l2a-cogs-test&limit=20", nil)
+	href, _ := url.Parse(pageLink(first, "next").Href)
+	second := searchPage(t, "GET", href.RequestURI(), nil)
+	if second.NumberMatched != 50 {
+		t.Errorf("Expected 50 matched on the second page, but got %d", second.NumberMatched)
+	}
+}
+
+func TestSearchFreeText(t *testing.T) {
+	tests := []struct {
+		description string
+		method      string
+		target      string
+		body        string
+		expected    int
+	}{
+		{"GET any term", "GET", "/search?collections=sentinel-s2-l2a-cogs-test&q=landsat,sentinel", "", 50},
+		{"GET no match", "GET", "/search?collections=sentinel-s2-l2a-cogs-test&q=landsat", "", 0},
+		{"POST list of terms", "POST", "/search", `{"collections": ["sentinel-s2-l2a-cogs-test"], "q": ["landsat", "msi"]}`, 50},
+		{"POST excluded term", "POST", "/search", `{"collections": ["sentinel-s2-l2a-cogs-test"], "q": "msi -sentinel"}`, 0},
+		{"POST boolean operators", "POST", "/search", `{"collections": ["sentinel-s2-l2a-cogs-test"], "q": "(landsat OR msi) AND NOT modis"}`, 50},
+	}
+
+	for _, test := range tests {
+		page := searchPage(t, test.method, test.target, []byte(test.body))
+		if page.Context.Returned != test.expected {
+			t.Errorf("%s: expected returned %d, but got %d", test.description, test.expected, page.Context.Returned)
+		}
+	}
+}
+
+type collectionSearchResponse struct {
+	Collections []struct {
+		Id string `json:"id"`
+	} `json:"collections"`
+	NumberMatched int           `json:"numberMatched"`
+	Links         []models.Link `json:"links"`
+}
+
+func TestGetCollectionsFreeText(t *testing.T) {
+	tests := []struct {
+		q        string
+		expected bool
+	}{
+		{"reflectance", true},
+		{`"earth observation"`, true},
+		{`"observation earth"`, false},
+		{"landsat", false},
+	}
+
+	for _, test := range tests {
+		app := Setup()
+		req, _ := http.NewRequest("GET", "/collections?q="+url.QueryEscape(test.q), nil)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+		if resp.StatusCode != 200 {
+			t.Fatalf("Expected status code 200 for %s, but got %d", test.q, resp.StatusCode)
+		}
+
+		var collections collectionSearchResponse
+		body, _ := ioutil.ReadAll(resp.Body)
+		if err := json.Unmarshal(body, &collections); err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+
+		found := false
+		for _, collection := range collections.Collections {
+			found = found || collection.Id == "sentinel-s2-l2a-cogs-test"
+		}
+		if found != test.expected {
+			t.Errorf("q=%s: expected the test collection to match %v, but got %v", test.q, test.expected, found)
+		}
+	}
+}
+
+type aggregateResponse struct {
+	Type         string `json:"type"`
+	Aggregations []struct {
+		Name     string `json:"name"`
+		DataType string `json:"data_type"`
+		Value    int    `json:"value"`
+		Overflow int    `json:"overflow"`
+		Buckets  []struct {
+			Key       string   `json:"key"`
+			Frequency int      `json:"frequency"`
+			From      *float64 `json:"from"`
+			To        *float64 `json:"to"`
+		} `json:"buckets"`
+	} `json:"aggregations"`
+}
+
+func aggregate(t *testing.T, method string, target string, body string) (int, aggregateResponse) {
+	app := Setup()
+	req, _ := http.NewRequest(method, target, bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var response aggregateResponse
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	json.Unmarshal(respBody, &response)
+	return resp.StatusCode, response
+}
+
+func TestAggregate(t *testing.T) {
+	status, response := aggregate(t, "GET",
+		"/aggregate?collections=sentinel-s2-l2a-cogs-test&aggregations=total_count,platform_frequency,datetime_frequency,cloud_cover_frequency", "")
+	if status != 200 {
+		t.Fatalf("Expected status code 200, but got %d", status)
+	}
+	if response.Type != "AggregationCollection" || len(response.Aggregations) != 4 {
+		t.Fatalf("Expected 4 aggregations, but got %+v", response)
+	}
+
+	total, platforms, datetimes, cloudCover := response.Aggregations[0], response.Aggregations[1], response.Aggregations[2], response.Aggregations[3]
+	if total.Name != "total_count" || total.Value != 50 {
+		t.Errorf("Expected a total count of 50, but got %+v", total)
+	}
+	if len(platforms.Buckets) != 1 || platforms.Buckets[0].Key != "sentinel-2b" || platforms.Buckets[0].Frequency != 50 {
+		t.Errorf("Expected 50 sentinel-2b items, but got %+v", platforms.Buckets)
+	}
+	if len(datetimes.Buckets) != 18 || datetimes.Buckets[0].Key != "2018-09-01T00:00:00Z" || datetimes.Buckets[0].Frequency != 2 {
+		t.Errorf("Expected 18 months starting in September 2018, but got %+v", datetimes.Buckets)
+	}
+	last := cloudCover.Buckets[len(cloudCover.Buckets)-1]
+	if last.Key != "90" || *last.From != 90 || *last.To != 100 || last.Frequency != 23 {
+		t.Errorf("Expected 23 items with 90 to 100%% cloud cover, but got %+v", last)
+	}
+
+	status, response = aggregate(t, "POST", "/aggregate", `{
+		"collections": ["sentinel-s2-l2a-cogs-test"],
+		"query": {"eo:cloud_cover": {"lt": 10}},
+		"aggregations": ["collection_frequency", "total_count"],
+		"datetime_frequency_interval": "day"
+	}`)
+	if status != 200 {
+		t.Fatalf("Expected status code 200, but got %d", status)
+	}
+	collections := response.Aggregations[0]
+	if len(collections.Buckets) != 1 || collections.Buckets[0].Frequency != 2 || response.Aggregations[1].Value != 2 {
+		t.Errorf("Expected 2 items with less than 10%% cloud cover, but got %+v", response.Aggregations)
+	}
+}
+
+func TestAggregateInvalid(t *testing.T) {
+	targets := []string{
+		"/aggregate?aggregations=foo",
+		"/aggregate?aggregations=datetime_frequency&datetime_frequency_interval=week",
+		"/aggregate?aggregations=total_count&bbox=1,2,3",
+	}
+	for _, target := range targets {
+		if status, _ := aggregate(t, "GET", target, ""); status != 400 {
+			t.Errorf("Expected status code 400 for %s, but got %d", target, status)
+		}
+	}
+}
+
+func TestAggregations(t *testing.T) {
+	app := Setup()
+	req, _ := http.NewRequest("GET", "/aggregations", nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+
+	var response struct {
+		Aggregations []models.Aggregation `json:"aggregations"`
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	json.Unmarshal(body, &response)
+
+	found := false
+	for _, aggregation := range response.Aggregations {
+		found = found || (aggregation.Name == "datetime_frequency" && aggregation.DataType == "frequency_distribution")
+	}
+	if !found {
+		t.Errorf("Expected datetime_frequency in %+v", response.Aggregations)
+	}
+}
+
+func getQueryables(t *testing.T, target string) (int, map[string]map[string]interface{}) {
+	app := Setup()
+	req, _ := http.NewRequest("GET", target, nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Properties map[string]map[string]interface{} `json:"properties"`
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	json.Unmarshal(body, &response)
+	return resp.StatusCode, response.Properties
+}
+
+func TestQueryables(t *testing.T) {
+	status, properties := getQueryables(t, "/queryables")
+	if status != 200 {
+		t.Fatalf("Expected status code 200, but got %d", status)
+	}
+	for _, name := range []string{"id", "collection", "geometry", "datetime", "eo:cloud_cover", "platform"} {
+		if _, ok := properties[name]; !ok {
+			t.Errorf("Expected queryable %s in %v", name, properties)
+		}
+	}
+	if properties["eo:cloud_cover"]["type"] != "number" {
+		t.Errorf("Expected eo:cloud_cover to be a number, got %v", properties["eo:cloud_cover"])
+	}
+}
+
+func TestCollectionQueryables(t *testing.T) {
+	status, properties := getQueryables(t, "/collections/sentinel-s2-l2a-cogs-test/queryables")
+	if status != 200 {
+		t.Fatalf("Expected status code 200, but got %d", status)
+	}
+	if _, ok := properties["platform"]; !ok {
+		t.Errorf("Expected queryable platform in %v", properties)
+	}
+
+	if status, _ := getQueryables(t, "/collections/does-not-exist/queryables"); status != 404 {
+		t.Errorf("Expected status code 404, but got %d", status)
+	}
+}
+
+func TestSearchFilterUnknownQueryable(t *testing.T) {
+	search := func(filter string) (int, string) {
+		// the test app caches GET responses by path, so each request needs its own
+		app := Setup()
+		req, _ := http.NewRequest("GET", "/search?filter="+url.QueryEscape(filter), nil)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if status, body := search("not_a_property = 'foo'"); status != 400 || !strings.Contains(body, "not_a_property") {
+		t.Errorf("Expected status code 400 naming the unknown queryable, but got %d: %s", status, body)
+	}
+
+	overrides := filepath.Join(t.TempDir(), "overrides.json")
+	ioutil.WriteFile(overrides, []byte(`{"hide": ["platform"]}`), 0644)
+	t.Setenv("QUERYABLES_OVERRIDES", overrides)
+	if status, body := search("platform = 'sentinel-2b'"); status != 400 || !strings.Contains(body, "platform") {
+		t.Errorf("Expected status code 400 naming the hidden queryable, but got %d: %s", status, body)
+	}
+
+	// lenient overrides let filters use properties no item of the sample
+	// has, but not those hidden
+	overrides = filepath.Join(t.TempDir(), "lenient.json")
+	ioutil.WriteFile(overrides, []byte(`{"lenient": true, "hide": ["platform"]}`), 0644)
+	t.Setenv("QUERYABLES_OVERRIDES", overrides)
+	if status, body := search("not_a_property = 'foo'"); status != 200 {
+		t.Errorf("Expected status code 200, but got %d: %s", status, body)
+	}
+	if status, body := search("platform = 'sentinel-2b'"); status != 400 || !strings.Contains(body, "platform") {
+		t.Errorf("Expected status code 400 naming the hidden queryable, but got %d: %s", status, body)
+	}
+}
+
+func TestCollectionSearch(t *testing.T) {
+	tests := []struct {
+		description  string
+		query        string
+		expectedCode int
+		expected     bool
+	}{
+		{"bbox", "bbox=100,-70,110,-60", 200, true},
+		{"datetime", "datetime=" + url.QueryEscape("2020-01-01T00:00:00Z/.."), 200, true},
+		{"datetime before the extent", "datetime=" + url.QueryEscape("../2010-01-01T00:00:00Z"), 200, false},
+		{"sortby", "sortby=-title", 200, true},
+		{"invalid bbox", "bbox=1,2,3", 400, false},
+		{"invalid sortby", "sortby=properties.datetime", 400, false},
+		{"invalid limit", "limit=0", 400, false},
+	}
+
+	for _, test := range tests {
+		// the test app caches GET responses by path, so each request needs its own
+		app := Setup()
+		req, _ := http.NewRequest("GET", "/collections?"+test.query, nil)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+		if resp.StatusCode != test.expectedCode {
+			t.Errorf("%s: expected status code %d, but got %d", test.description, test.expectedCode, resp.StatusCode)
+			continue
+		}
+		if test.expectedCode != 200 {
+			continue
+		}
+
+		var collections collectionSearchResponse
+		body, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(body, &collections)
+
+		found := false
+		for _, collection := range collections.Collections {
+			found = found || collection.Id == "sentinel-s2-l2a-cogs-test"
+		}
+		if found != test.expected {
+			t.Errorf("%s: expected the test collection to match %v, but got %v", test.description, test.expected, found)
+		}
+	}
+}
+
+func TestCollectionSearchPagination(t *testing.T) {
+	route := "/collections?limit=1"
+	seen := map[string]bool{}
+	matched := 0
+	for route != "" {
+		app := Setup()
+		req, _ := http.NewRequest("GET", route, nil)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+
+		var page collectionSearchResponse
+		body, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(body, &page)
+		matched = page.NumberMatched
+		for _, collection := range page.Collections {
+			if seen[collection.Id] {
+				t.Fatalf("Collection %s listed twice", collection.Id)
+			}
+			seen[collection.Id] = true
+		}
+
+		route = ""
+		for _, link := range page.Links {
+			if link.Rel == "next" {
+				href, _ := url.Parse(link.Href)
+				route = href.RequestURI()
+			}
+		}
+	}
+
+	if len(seen) != matched {
+		t.Errorf("Expected to page through %d collections, but got %d", matched, len(seen))
+	}
+}
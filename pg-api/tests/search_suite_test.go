@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/jonhealy1/goapi-stac/pg-api/responses"
+)
+
+// searchCase is a case of the search test suite es-api runs too, which is
+// kept in the testdata directory at the root of the repository.
+type searchCase struct {
+	Description string          `json:"description"`
+	Method      string          `json:"method"`
+	Query       string          `json:"query"`
+	Body        json.RawMessage `json:"body"`
+	Status      int             `json:"status"`
+	Returned    int             `json:"returned"`
+	// Ids are the ids of the items returned, in order, if set.
+	Ids []string `json:"ids"`
+}
+
+func TestSearchSuite(t *testing.T) {
+	b, err := os.ReadFile("../../testdata/search_cases.json")
+	if err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+	var cases []searchCase
+	if err := json.Unmarshal(b, &cases); err != nil {
+		t.Fatalf("An error occurred: %v", err)
+	}
+
+	for _, test := range cases {
+		// the test app caches GET responses by path, so each request needs its own
+		app := Setup()
+		req, _ := http.NewRequest(test.Method, "/search?"+test.Query, bytes.NewReader(test.Body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("An error occurred: %v", err)
+		}
+
+		if resp.StatusCode != test.Status {
+			t.Errorf("%s: expected status code %d, but got %d", test.Description, test.Status, resp.StatusCode)
+			continue
+		}
+		if test.Status != 200 {
+			continue
+		}
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		var searchResponse responses.SearchResponse
+		json.Unmarshal(body, &searchResponse)
+
+		if searchResponse.Context.Returned != test.Returned {
+			t.Errorf("%s: expected returned %d, but got %d", test.Description, test.Returned, searchResponse.Context.Returned)
+		}
+		if test.Ids != nil {
+			var ids []string
+			for _, feature := range searchResponse.Features {
+				ids = append(ids, feature.Id)
+			}
+			if !reflect.DeepEqual(ids, test.Ids) {
+				t.Errorf("%s: expected ids %v, but got %v", test.Description, test.Ids, ids)
+			}
+		}
+	}
+}
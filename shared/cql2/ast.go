@@ -0,0 +1,149 @@
+package cql2
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Expr is a node of a parsed CQL2 filter expression. Both the JSON and the
+// text encodings of CQL2 are parsed into this representation.
+type Expr interface {
+	expr()
+}
+
+// Logical combines two or more boolean expressions with "and" or "or".
+type Logical struct {
+	Op   string
+	Args []Expr
+}
+
+// Not negates a boolean expression.
+type Not struct {
+	Arg Expr
+}
+
+// Comparison is a binary comparison: =, <>, <, <=, > or >=.
+type Comparison struct {
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+// Like matches a string against a pattern using % and _ wildcards.
+type Like struct {
+	Value   Expr
+	Pattern Expr
+}
+
+// In tests a value for membership in a list of literals.
+type In struct {
+	Value Expr
+	List  []Expr
+}
+
+// Between tests whether a value lies in the closed range [Low, High].
+type Between struct {
+	Value Expr
+	Low   Expr
+	High  Expr
+}
+
+// IsNull tests whether a property is missing or null.
+type IsNull struct {
+	Value Expr
+}
+
+// Spatial is a binary spatial predicate such as s_intersects.
+type Spatial struct {
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+// Temporal is a binary temporal predicate such as t_intersects.
+type Temporal struct {
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+// Property references a queryable by name, e.g. "eo:cloud_cover" or "id".
+type Property struct {
+	Name string
+}
+
+// PropertyName strips the optional "properties." prefix from a property
+// reference, so that "properties.eo:cloud_cover" and "eo:cloud_cover" are
+// the same queryable.
+func PropertyName(p Property) string {
+	return strings.TrimPrefix(p.Name, "properties.")
+}
+
+// Literal is a scalar string, number (float64) or boolean value.
+type Literal struct {
+	Value interface{}
+}
+
+// Timestamp is an instant in time.
+type Timestamp struct {
+	Value time.Time
+}
+
+// Date is a calendar day, treated as the interval covering that whole day.
+type Date struct {
+	Value time.Time
+}
+
+// Interval is a time interval. A nil Start or End means the interval is
+// open ("..") on that side.
+type Interval struct {
+	Start *time.Time
+	End   *time.Time
+}
+
+// Geometry is a GeoJSON geometry literal, kept in its original encoding.
+type Geometry struct {
+	GeoJSON json.RawMessage
+}
+
+// BBox is a bounding box literal of 4 or 6 coordinates.
+type BBox struct {
+	Coords []float64
+}
+
+func (Logical) expr()    {}
+func (Not) expr()        {}
+func (Comparison) expr() {}
+func (Like) expr()       {}
+func (In) expr()         {}
+func (Between) expr()    {}
+func (IsNull) expr()     {}
+func (Spatial) expr()    {}
+func (Temporal) expr()   {}
+func (Property) expr()   {}
+func (Literal) expr()    {}
+func (Timestamp) expr()  {}
+func (Date) expr()       {}
+func (Interval) expr()   {}
+func (Geometry) expr()   {}
+func (BBox) expr()       {}
+
+// comparisonOps lists the supported CQL2 binary comparison operators.
+var comparisonOps = map[string]bool{
+	"=": true, "<>": true, "<": true, "<=": true, ">": true, ">=": true,
+}
+
+// spatialOps lists the supported CQL2 spatial operators.
+var spatialOps = map[string]bool{
+	"s_intersects": true, "s_equals": true, "s_disjoint": true, "s_touches": true,
+	"s_within": true, "s_overlaps": true, "s_crosses": true, "s_contains": true,
+}
+
+// temporalOps lists the supported CQL2 temporal operators.
+var temporalOps = map[string]bool{
+	"t_after": true, "t_before": true, "t_contains": true, "t_disjoint": true,
+	"t_during": true, "t_equals": true, "t_finishedBy": true, "t_finishes": true,
+	"t_intersects": true, "t_meets": true, "t_metBy": true, "t_overlappedBy": true,
+	"t_overlaps": true, "t_startedBy": true, "t_starts": true,
+}
@@ -0,0 +1,178 @@
+package cql2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// coordinateDepths is the number of array levels above the positions in
+// the coordinates of each geometry type.
+var coordinateDepths = map[string]int{
+	"Point":           0,
+	"MultiPoint":      1,
+	"LineString":      1,
+	"MultiLineString": 2,
+	"Polygon":         2,
+	"MultiPolygon":    3,
+}
+
+type geoJSONGeometry struct {
+	Type        string            `json:"type"`
+	Coordinates json.RawMessage   `json:"coordinates,omitempty"`
+	Geometries  []json.RawMessage `json:"geometries"`
+}
+
+// MarshalJSON writes the coordinates of a geometry, or the geometries of a
+// GeometryCollection, which are written even if there are none.
+func (g geoJSONGeometry) MarshalJSON() ([]byte, error) {
+	if g.Type == "GeometryCollection" {
+		return json.Marshal(struct {
+			Type       string            `json:"type"`
+			Geometries []json.RawMessage `json:"geometries"`
+		}{g.Type, g.Geometries})
+	}
+	return json.Marshal(struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	}{g.Type, g.Coordinates})
+}
+
+// ParseGeoJSON validates a GeoJSON geometry of any type, including
+// GeometryCollection. Members other than the type, coordinates and
+// geometries are dropped, while coordinates keep their original encoding so
+// that no precision is lost.
+func ParseGeoJSON(data []byte) (Geometry, error) {
+	normalized, err := normalizeGeoJSON(data)
+	if err != nil {
+		return Geometry{}, err
+	}
+	geojson, err := json.Marshal(normalized)
+	if err != nil {
+		return Geometry{}, err
+	}
+	return Geometry{GeoJSON: geojson}, nil
+}
+
+func normalizeGeoJSON(data []byte) (*geoJSONGeometry, error) {
+	var g geoJSONGeometry
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("a geometry must be a GeoJSON object")
+	}
+
+	if g.Type == "GeometryCollection" {
+		if g.Geometries == nil {
+			return nil, fmt.Errorf("a GeometryCollection requires geometries")
+		}
+		normalized := &geoJSONGeometry{Type: g.Type, Geometries: []json.RawMessage{}}
+		for i, member := range g.Geometries {
+			child, err := normalizeGeoJSON(member)
+			if err != nil {
+				return nil, fmt.Errorf("geometry %d of GeometryCollection: %v", i, err)
+			}
+			encoded, _ := json.Marshal(child)
+			normalized.Geometries = append(normalized.Geometries, encoded)
+		}
+		return normalized, nil
+	}
+
+	depth, ok := coordinateDepths[g.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported geometry type %q", g.Type)
+	}
+	if len(g.Coordinates) == 0 {
+		return nil, fmt.Errorf("a %s requires coordinates", g.Type)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(g.Coordinates))
+	decoder.UseNumber()
+	var coordinates interface{}
+	if err := decoder.Decode(&coordinates); err != nil {
+		return nil, fmt.Errorf("invalid %s coordinates", g.Type)
+	}
+	if err := checkCoordinates(g.Type, coordinates, depth); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", g.Type, err)
+	}
+
+	var compact bytes.Buffer
+	json.Compact(&compact, g.Coordinates)
+	return &geoJSONGeometry{Type: g.Type, Coordinates: compact.Bytes()}, nil
+}
+
+// checkCoordinates checks the nesting of coordinates, and the sizes of
+// their positions, lines and rings.
+func checkCoordinates(geomType string, coordinates interface{}, depth int) error {
+	if depth == 0 {
+		return checkPosition(coordinates)
+	}
+
+	list, ok := coordinates.([]interface{})
+	if !ok {
+		return fmt.Errorf("expected an array of coordinates")
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("expected a non-empty array of coordinates")
+	}
+	for _, element := range list {
+		if err := checkCoordinates(geomType, element, depth-1); err != nil {
+			return err
+		}
+	}
+
+	// depth 1 is a list of positions, which is a line or a ring in all
+	// types but MultiPoint
+	if depth != 1 || geomType == "MultiPoint" {
+		return nil
+	}
+	if geomType == "LineString" || geomType == "MultiLineString" {
+		if len(list) < 2 {
+			return fmt.Errorf("a line needs at least 2 positions")
+		}
+		return nil
+	}
+	if len(list) < 4 {
+		return fmt.Errorf("a polygon ring needs at least 4 positions")
+	}
+	if !samePosition(list[0], list[len(list)-1]) {
+		return fmt.Errorf("a polygon ring must end at its first position")
+	}
+	return nil
+}
+
+func samePosition(a interface{}, b interface{}) bool {
+	as, bs := a.([]interface{}), b.([]interface{})
+	if len(as) != len(bs) {
+		return false
+	}
+	for i := range as {
+		af, _ := as[i].(json.Number).Float64()
+		bf, _ := bs[i].(json.Number).Float64()
+		if af != bf {
+			return false
+		}
+	}
+	return true
+}
+
+func checkPosition(position interface{}) error {
+	values, ok := position.([]interface{})
+	if !ok || len(values) < 2 || len(values) > 3 {
+		return fmt.Errorf("a position must have 2 or 3 numbers")
+	}
+	var coords []float64
+	for _, value := range values {
+		n, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("a position must have 2 or 3 numbers")
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return fmt.Errorf("invalid coordinate %s", n)
+		}
+		coords = append(coords, f)
+	}
+	if coords[0] < -180 || coords[0] > 180 || coords[1] < -90 || coords[1] > 90 {
+		return fmt.Errorf("position %v is outside of CRS84 bounds", coords)
+	}
+	return nil
+}
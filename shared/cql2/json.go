@@ -0,0 +1,310 @@
+package cql2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	dateLayout = "2006-01-02"
+
+	// EndOfDay is added to the start of a day to get its last representable
+	// instant, so that a date covers the whole day it names.
+	EndOfDay = 24*time.Hour - time.Microsecond
+)
+
+// ParseJSON parses a CQL2-JSON filter into an expression tree.
+func ParseJSON(data []byte) (Expr, error) {
+	var raw interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid CQL2-JSON: %v", err)
+	}
+
+	e, err := parseJSONNode(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !isPredicate(e) {
+		return nil, fmt.Errorf("filter must be a boolean expression")
+	}
+	return e, nil
+}
+
+func parseJSONNode(node interface{}) (Expr, error) {
+	switch v := node.(type) {
+	case string:
+		return Literal{Value: v}, nil
+	case bool:
+		return Literal{Value: v}, nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", v)
+		}
+		return Literal{Value: f}, nil
+	case map[string]interface{}:
+		return parseJSONObject(v)
+	case nil:
+		return nil, fmt.Errorf("null is not a valid CQL2 value")
+	}
+	return nil, fmt.Errorf("unexpected value %v", node)
+}
+
+func parseJSONObject(obj map[string]interface{}) (Expr, error) {
+	if op, ok := obj["op"]; ok {
+		opName, ok := op.(string)
+		if !ok {
+			return nil, fmt.Errorf("op must be a string")
+		}
+		args, ok := obj["args"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("op %q requires an args array", opName)
+		}
+		return parseJSONOp(opName, args)
+	}
+
+	if name, ok := obj["property"]; ok {
+		s, ok := name.(string)
+		if !ok || s == "" {
+			return nil, fmt.Errorf("property must be a non-empty string")
+		}
+		return Property{Name: s}, nil
+	}
+
+	if ts, ok := obj["timestamp"]; ok {
+		s, _ := ts.(string)
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %v", ts)
+		}
+		return Timestamp{Value: t}, nil
+	}
+
+	if d, ok := obj["date"]; ok {
+		s, _ := d.(string)
+		t, err := time.Parse(dateLayout, s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %v", d)
+		}
+		return Date{Value: t}, nil
+	}
+
+	if iv, ok := obj["interval"]; ok {
+		bounds, ok := iv.([]interface{})
+		if !ok || len(bounds) != 2 {
+			return nil, fmt.Errorf("interval must be an array of two instants")
+		}
+		start, ok := bounds[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid interval start %v", bounds[0])
+		}
+		end, ok := bounds[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid interval end %v", bounds[1])
+		}
+		return ParseInterval(start, end)
+	}
+
+	if b, ok := obj["bbox"]; ok {
+		values, ok := b.([]interface{})
+		if !ok || (len(values) != 4 && len(values) != 6) {
+			return nil, fmt.Errorf("bbox must be an array of 4 or 6 numbers")
+		}
+		coords := make([]float64, len(values))
+		for i, value := range values {
+			n, ok := value.(json.Number)
+			if !ok {
+				return nil, fmt.Errorf("bbox must be an array of 4 or 6 numbers")
+			}
+			coords[i], _ = n.Float64()
+		}
+		return BBox{Coords: coords}, nil
+	}
+
+	if _, ok := obj["type"]; ok {
+		geojson, err := json.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		return ParseGeoJSON(geojson)
+	}
+
+	return nil, fmt.Errorf("unrecognised CQL2-JSON object")
+}
+
+func parseJSONOp(op string, rawArgs []interface{}) (Expr, error) {
+	// the second argument of "in" is a bare array of literals
+	if op == "in" {
+		if len(rawArgs) != 2 {
+			return nil, fmt.Errorf("op %q requires 2 arguments", op)
+		}
+		value, err := parseJSONNode(rawArgs[0])
+		if err != nil {
+			return nil, err
+		}
+		rawList, ok := rawArgs[1].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("op %q requires a list as its second argument", op)
+		}
+		list := make([]Expr, 0, len(rawList))
+		for _, item := range rawList {
+			e, err := parseJSONNode(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, e)
+		}
+		return NewOp(op, []Expr{value, List(list)})
+	}
+
+	args := make([]Expr, 0, len(rawArgs))
+	for _, rawArg := range rawArgs {
+		arg, err := parseJSONNode(rawArg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return NewOp(op, args)
+}
+
+// List is the list operand of an "in" predicate. It only exists while an
+// expression is being built and is unpacked into In.List by NewOp.
+type List []Expr
+
+func (List) expr() {}
+
+// NewOp builds the expression node for the operator op applied to args,
+// checking the operator's arity and the kind of its arguments.
+func NewOp(op string, args []Expr) (Expr, error) {
+	arity := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("op %q requires %d arguments, got %d", op, n, len(args))
+		}
+		return nil
+	}
+
+	switch {
+	case op == "and" || op == "or":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("op %q requires at least 2 arguments", op)
+		}
+		for _, arg := range args {
+			if !isPredicate(arg) {
+				return nil, fmt.Errorf("arguments of %q must be boolean expressions", op)
+			}
+		}
+		return Logical{Op: op, Args: args}, nil
+	case op == "not":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		if !isPredicate(args[0]) {
+			return nil, fmt.Errorf("argument of %q must be a boolean expression", op)
+		}
+		return Not{Arg: args[0]}, nil
+	case comparisonOps[op]:
+		if err := arity(2); err != nil {
+			return nil, err
+		}
+		return Comparison{Op: op, Left: args[0], Right: args[1]}, nil
+	case op == "like":
+		if err := arity(2); err != nil {
+			return nil, err
+		}
+		if lit, ok := args[1].(Literal); !ok || !isString(lit) {
+			return nil, fmt.Errorf("pattern of %q must be a string", op)
+		}
+		return Like{Value: args[0], Pattern: args[1]}, nil
+	case op == "between":
+		if err := arity(3); err != nil {
+			return nil, err
+		}
+		return Between{Value: args[0], Low: args[1], High: args[2]}, nil
+	case op == "in":
+		if err := arity(2); err != nil {
+			return nil, err
+		}
+		list, ok := args[1].(List)
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("op %q requires a non-empty list", op)
+		}
+		return In{Value: args[0], List: list}, nil
+	case op == "isNull":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		return IsNull{Value: args[0]}, nil
+	case spatialOps[op]:
+		if err := arity(2); err != nil {
+			return nil, err
+		}
+		return Spatial{Op: op, Left: args[0], Right: args[1]}, nil
+	case temporalOps[op]:
+		if err := arity(2); err != nil {
+			return nil, err
+		}
+		return Temporal{Op: op, Left: args[0], Right: args[1]}, nil
+	}
+	return nil, fmt.Errorf("unsupported operator %q", op)
+}
+
+// ParseInterval builds an Interval from two RFC 3339 timestamps or dates,
+// either of which may be ".." (or empty) for an open end.
+func ParseInterval(start, end string) (Interval, error) {
+	var iv Interval
+	if start != ".." && start != "" {
+		t, err := parseInstant(start, false)
+		if err != nil {
+			return iv, err
+		}
+		iv.Start = &t
+	}
+	if end != ".." && end != "" {
+		t, err := parseInstant(end, true)
+		if err != nil {
+			return iv, err
+		}
+		iv.End = &t
+	}
+	if iv.Start != nil && iv.End != nil && iv.End.Before(*iv.Start) {
+		return iv, fmt.Errorf("interval end %s is before its start %s", end, start)
+	}
+	return iv, nil
+}
+
+// parseInstant parses an RFC 3339 timestamp or a date. A date used as the
+// end of an interval stands for the last instant of that day.
+func parseInstant(s string, isEnd bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return t, fmt.Errorf("invalid timestamp %q, expected RFC 3339", s)
+	}
+	if isEnd {
+		t = t.Add(EndOfDay)
+	}
+	return t, nil
+}
+
+func isPredicate(e Expr) bool {
+	switch v := e.(type) {
+	case Logical, Not, Comparison, Like, In, Between, IsNull, Spatial, Temporal:
+		return true
+	case Literal:
+		_, ok := v.Value.(bool)
+		return ok
+	}
+	return false
+}
+
+func isString(lit Literal) bool {
+	_, ok := lit.Value.(string)
+	return ok
+}
@@ -0,0 +1,47 @@
+package cql2
+
+import "sort"
+
+// Properties returns the names of the queryables a filter expression
+// references, without their optional "properties." prefix, sorted and
+// without duplicates.
+func Properties(e Expr) []string {
+	seen := map[string]bool{}
+	collectProperties(e, seen)
+
+	names := []string{}
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func collectProperties(e Expr, seen map[string]bool) {
+	var children []Expr
+	switch v := e.(type) {
+	case Property:
+		seen[PropertyName(v)] = true
+	case Logical:
+		children = v.Args
+	case Not:
+		children = []Expr{v.Arg}
+	case Comparison:
+		children = []Expr{v.Left, v.Right}
+	case Like:
+		children = []Expr{v.Value, v.Pattern}
+	case In:
+		children = append([]Expr{v.Value}, v.List...)
+	case Between:
+		children = []Expr{v.Value, v.Low, v.High}
+	case IsNull:
+		children = []Expr{v.Value}
+	case Spatial:
+		children = []Expr{v.Left, v.Right}
+	case Temporal:
+		children = []Expr{v.Left, v.Right}
+	}
+	for _, child := range children {
+		collectProperties(child, seen)
+	}
+}
@@ -0,0 +1,508 @@
+package cql2
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SyntaxError reports a malformed CQL2-Text filter. Pos is the 1-based
+// character position in the filter at which the error was found.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+// ParseText parses a CQL2-Text filter into the same expression tree that
+// ParseJSON produces for the equivalent CQL2-JSON filter.
+func ParseText(text string) (Expr, error) {
+	tokens, err := lex(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &textParser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %s", tok)
+	}
+	return e, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokQuotedIdent
+	tokString
+	tokNumber
+	tokOperator
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of filter"
+	case tokString:
+		return fmt.Sprintf("string '%s'", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func lex(text string) ([]token, error) {
+	runes := []rune(text)
+	var tokens []token
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", start + 1})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", start + 1})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokComma, ",", start + 1})
+			i++
+		case r == '=':
+			tokens = append(tokens, token{tokOperator, "=", start + 1})
+			i++
+		case r == '<' || r == '>':
+			i++
+			if i < len(runes) && (runes[i] == '=' || (r == '<' && runes[i] == '>')) {
+				i++
+			}
+			tokens = append(tokens, token{tokOperator, string(runes[start:i]), start + 1})
+		case r == '\'':
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, &SyntaxError{start + 1, "unterminated string"}
+				}
+				if runes[i] == '\'' {
+					// a doubled quote is an escaped quote
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						b.WriteRune('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, token{tokString, b.String(), start + 1})
+		case r == '"':
+			i++
+			for i < len(runes) && runes[i] != '"' {
+				i++
+			}
+			if i >= len(runes) {
+				return nil, &SyntaxError{start + 1, "unterminated quoted identifier"}
+			}
+			tokens = append(tokens, token{tokQuotedIdent, string(runes[start+1 : i]), start + 1})
+			i++
+		case r == '-' || r == '+' || r == '.' || unicode.IsDigit(r):
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' ||
+				runes[i] == 'e' || runes[i] == 'E' ||
+				((runes[i] == '-' || runes[i] == '+') && (runes[i-1] == 'e' || runes[i-1] == 'E'))) {
+				i++
+			}
+			number := string(runes[start:i])
+			if _, err := strconv.ParseFloat(number, 64); err != nil {
+				return nil, &SyntaxError{start + 1, fmt.Sprintf("invalid number %q", number)}
+			}
+			tokens = append(tokens, token{tokNumber, number, start + 1})
+		case unicode.IsLetter(r) || r == '_':
+			i++
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) ||
+				runes[i] == '_' || runes[i] == ':' || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, string(runes[start:i]), start + 1})
+		default:
+			return nil, &SyntaxError{start + 1, fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+	tokens = append(tokens, token{tokEOF, "", len(runes) + 1})
+	return tokens, nil
+}
+
+type textParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *textParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *textParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *textParser) errorf(tok token, format string, args ...interface{}) error {
+	return &SyntaxError{tok.pos, fmt.Sprintf(format, args...)}
+}
+
+// keyword reports whether the next token is the (case-insensitive) keyword
+// kw, consuming it if it is.
+func (p *textParser) keyword(kw string) bool {
+	tok := p.peek()
+	if tok.kind == tokIdent && strings.EqualFold(tok.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *textParser) expect(kind tokenKind, what string) (token, error) {
+	tok := p.next()
+	if tok.kind != kind {
+		return tok, p.errorf(tok, "expected %s, got %s", what, tok)
+	}
+	return tok, nil
+}
+
+func (p *textParser) op(tok token, op string, args ...Expr) (Expr, error) {
+	e, err := NewOp(op, args)
+	if err != nil {
+		return nil, p.errorf(tok, "%v", err)
+	}
+	return e, nil
+}
+
+func (p *textParser) parseOr() (Expr, error) {
+	return p.parseLogical("or", p.parseAnd)
+}
+
+func (p *textParser) parseAnd() (Expr, error) {
+	return p.parseLogical("and", p.parseNot)
+}
+
+func (p *textParser) parseLogical(op string, operand func() (Expr, error)) (Expr, error) {
+	tok := p.peek()
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	args := []Expr{first}
+	for p.keyword(op) {
+		arg, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if len(args) == 1 {
+		return first, nil
+	}
+	return p.op(tok, op, args...)
+}
+
+func (p *textParser) parseNot() (Expr, error) {
+	tok := p.peek()
+	if p.keyword("not") {
+		arg, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return p.op(tok, "not", arg)
+	}
+	return p.parsePredicate()
+}
+
+func (p *textParser) parsePredicate() (Expr, error) {
+	tok := p.peek()
+
+	if tok.kind == tokLParen {
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+
+	if tok.kind == tokIdent && p.tokens[p.pos+1].kind == tokLParen {
+		if op, ok := lookupOp(tok.text); ok {
+			p.next()
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			return p.op(tok, op, args...)
+		}
+	}
+
+	left, err := p.parseScalar()
+	if err != nil {
+		return nil, err
+	}
+
+	opTok := p.peek()
+	if opTok.kind == tokOperator {
+		p.next()
+		right, err := p.parseScalar()
+		if err != nil {
+			return nil, err
+		}
+		return p.op(opTok, opTok.text, left, right)
+	}
+
+	if p.keyword("is") {
+		negate := p.keyword("not")
+		if !p.keyword("null") {
+			return nil, p.errorf(p.peek(), "expected NULL, got %s", p.peek())
+		}
+		e, err := p.op(opTok, "isNull", left)
+		if err != nil || !negate {
+			return e, err
+		}
+		return Not{Arg: e}, nil
+	}
+
+	negate := p.keyword("not")
+	var e Expr
+	switch {
+	case p.keyword("like"):
+		pattern, err := p.parseScalar()
+		if err != nil {
+			return nil, err
+		}
+		e, err = p.op(opTok, "like", left, pattern)
+		if err != nil {
+			return nil, err
+		}
+	case p.keyword("between"):
+		low, err := p.parseScalar()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword("and") {
+			return nil, p.errorf(p.peek(), "expected AND, got %s", p.peek())
+		}
+		high, err := p.parseScalar()
+		if err != nil {
+			return nil, err
+		}
+		e, err = p.op(opTok, "between", left, low, high)
+		if err != nil {
+			return nil, err
+		}
+	case p.keyword("in"):
+		if p.peek().kind != tokLParen {
+			return nil, p.errorf(p.peek(), "expected '(', got %s", p.peek())
+		}
+		list, err := p.parseArgs()
+		if err != nil {
+			return nil, err
+		}
+		e, err = p.op(opTok, "in", left, List(list))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		if negate {
+			return nil, p.errorf(p.peek(), "expected LIKE, BETWEEN or IN, got %s", p.peek())
+		}
+		if lit, ok := left.(Literal); ok && isPredicate(lit) {
+			return lit, nil
+		}
+		return nil, p.errorf(opTok, "expected a comparison operator, LIKE, BETWEEN, IN or IS, got %s", opTok)
+	}
+	if negate {
+		return Not{Arg: e}, nil
+	}
+	return e, nil
+}
+
+// parseArgs parses a parenthesised, comma separated list of arguments.
+func (p *textParser) parseArgs() ([]Expr, error) {
+	if _, err := p.expect(tokLParen, "'('"); err != nil {
+		return nil, err
+	}
+	var args []Expr
+	for {
+		arg, err := p.parseArg()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		tok := p.next()
+		if tok.kind == tokRParen {
+			return args, nil
+		}
+		if tok.kind != tokComma {
+			return nil, p.errorf(tok, "expected ',' or ')', got %s", tok)
+		}
+	}
+}
+
+// parseArg parses a function argument, which may itself be a predicate.
+func (p *textParser) parseArg() (Expr, error) {
+	tok := p.peek()
+	if tok.kind == tokIdent && p.tokens[p.pos+1].kind == tokLParen {
+		if _, ok := lookupOp(tok.text); ok {
+			return p.parsePredicate()
+		}
+	}
+	return p.parseScalar()
+}
+
+func (p *textParser) parseScalar() (Expr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokString:
+		return Literal{Value: tok.text}, nil
+	case tokNumber:
+		f, _ := strconv.ParseFloat(tok.text, 64)
+		return Literal{Value: f}, nil
+	case tokQuotedIdent:
+		return Property{Name: tok.text}, nil
+	case tokIdent:
+		name := strings.ToUpper(tok.text)
+		switch name {
+		case "TRUE":
+			return Literal{Value: true}, nil
+		case "FALSE":
+			return Literal{Value: false}, nil
+		}
+		if p.peek().kind != tokLParen && !(isGeometryKeyword(name) && p.peek().kind == tokIdent) {
+			return Property{Name: tok.text}, nil
+		}
+		switch name {
+		case "TIMESTAMP", "DATE":
+			return p.parseTimeLiteral(tok, name)
+		case "INTERVAL":
+			return p.parseInterval(tok)
+		case "BBOX":
+			return p.parseBBox(tok)
+		}
+		if isGeometryKeyword(name) {
+			p.pos--
+			return p.parseGeometry()
+		}
+		return nil, p.errorf(tok, "unsupported function %s", tok.text)
+	}
+	return nil, p.errorf(tok, "expected a value, got %s", tok)
+}
+
+func (p *textParser) parseTimeLiteral(tok token, name string) (Expr, error) {
+	p.next()
+	s, err := p.expect(tokString, "a quoted "+strings.ToLower(name))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokRParen, "')'"); err != nil {
+		return nil, err
+	}
+	if name == "DATE" {
+		t, err := time.Parse(dateLayout, s.text)
+		if err != nil {
+			return nil, p.errorf(s, "invalid date '%s'", s.text)
+		}
+		return Date{Value: t}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s.text)
+	if err != nil {
+		return nil, p.errorf(s, "invalid timestamp '%s'", s.text)
+	}
+	return Timestamp{Value: t}, nil
+}
+
+func (p *textParser) parseInterval(tok token) (Expr, error) {
+	p.next()
+	var bounds [2]string
+	for i := range bounds {
+		if i > 0 {
+			if _, err := p.expect(tokComma, "','"); err != nil {
+				return nil, err
+			}
+		}
+		s, err := p.expect(tokString, "a quoted instant or '..'")
+		if err != nil {
+			return nil, err
+		}
+		bounds[i] = s.text
+	}
+	if _, err := p.expect(tokRParen, "')'"); err != nil {
+		return nil, err
+	}
+	iv, err := ParseInterval(bounds[0], bounds[1])
+	if err != nil {
+		return nil, p.errorf(tok, "%v", err)
+	}
+	return iv, nil
+}
+
+func (p *textParser) parseBBox(tok token) (Expr, error) {
+	p.next()
+	var coords []float64
+	for {
+		n, err := p.expect(tokNumber, "a number")
+		if err != nil {
+			return nil, err
+		}
+		f, _ := strconv.ParseFloat(n.text, 64)
+		coords = append(coords, f)
+		sep := p.next()
+		if sep.kind == tokRParen {
+			break
+		}
+		if sep.kind != tokComma {
+			return nil, p.errorf(sep, "expected ',' or ')', got %s", sep)
+		}
+	}
+	if len(coords) != 4 && len(coords) != 6 {
+		return nil, p.errorf(tok, "BBOX requires 4 or 6 numbers, got %d", len(coords))
+	}
+	return BBox{Coords: coords}, nil
+}
+
+// lookupOp finds the spatial or temporal operator a function name refers
+// to, ignoring case.
+func lookupOp(name string) (string, bool) {
+	lower := strings.ToLower(name)
+	if spatialOps[lower] {
+		return lower, true
+	}
+	for op := range temporalOps {
+		if strings.ToLower(op) == lower {
+			return op, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,161 @@
+package cql2
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// wktTypes maps the WKT geometry keywords to their GeoJSON type names.
+var wktTypes = map[string]string{
+	"POINT":              "Point",
+	"LINESTRING":         "LineString",
+	"POLYGON":            "Polygon",
+	"MULTIPOINT":         "MultiPoint",
+	"MULTILINESTRING":    "MultiLineString",
+	"MULTIPOLYGON":       "MultiPolygon",
+	"GEOMETRYCOLLECTION": "GeometryCollection",
+}
+
+func isGeometryKeyword(name string) bool {
+	_, ok := wktTypes[strings.ToUpper(name)]
+	return ok
+}
+
+// parseGeometry parses a WKT geometry literal into a Geometry holding the
+// equivalent GeoJSON.
+func (p *textParser) parseGeometry() (Expr, error) {
+	start := p.peek()
+	geometry, err := p.parseWKT()
+	if err != nil {
+		return nil, err
+	}
+	geojson, err := json.Marshal(geometry)
+	if err != nil {
+		return nil, err
+	}
+	g, err := ParseGeoJSON(geojson)
+	if err != nil {
+		return nil, p.errorf(start, "%v", err)
+	}
+	return g, nil
+}
+
+func (p *textParser) parseWKT() (map[string]interface{}, error) {
+	tok := p.next()
+	geomType, ok := wktTypes[strings.ToUpper(tok.text)]
+	if tok.kind != tokIdent || !ok {
+		return nil, p.errorf(tok, "expected a WKT geometry, got %s", tok)
+	}
+	// the dimension marker carries no information GeoJSON needs
+	if next := p.peek(); next.kind == tokIdent && strings.EqualFold(next.text, "Z") {
+		p.next()
+	}
+
+	if geomType == "GeometryCollection" {
+		if _, err := p.expect(tokLParen, "'('"); err != nil {
+			return nil, err
+		}
+		var geometries []interface{}
+		for {
+			g, err := p.parseWKT()
+			if err != nil {
+				return nil, err
+			}
+			geometries = append(geometries, g)
+			sep := p.next()
+			if sep.kind == tokRParen {
+				break
+			}
+			if sep.kind != tokComma {
+				return nil, p.errorf(sep, "expected ',' or ')', got %s", sep)
+			}
+		}
+		return map[string]interface{}{"type": geomType, "geometries": geometries}, nil
+	}
+
+	var coordinates interface{}
+	var err error
+	switch geomType {
+	case "Point":
+		if _, err := p.expect(tokLParen, "'('"); err != nil {
+			return nil, err
+		}
+		coordinates, err = p.parsePosition()
+		if err == nil {
+			_, err = p.expect(tokRParen, "')'")
+		}
+	case "LineString":
+		coordinates, err = p.parsePositions()
+	case "MultiPoint":
+		coordinates, err = p.parseWKTList(func() (interface{}, error) {
+			// both MULTIPOINT(1 2, 3 4) and MULTIPOINT((1 2), (3 4)) are valid
+			if p.peek().kind != tokLParen {
+				return p.parsePosition()
+			}
+			p.next()
+			position, err := p.parsePosition()
+			if err != nil {
+				return nil, err
+			}
+			_, err = p.expect(tokRParen, "')'")
+			return position, err
+		})
+	case "Polygon", "MultiLineString":
+		coordinates, err = p.parseWKTList(func() (interface{}, error) {
+			return p.parsePositions()
+		})
+	case "MultiPolygon":
+		coordinates, err = p.parseWKTList(func() (interface{}, error) {
+			return p.parseWKTList(func() (interface{}, error) {
+				return p.parsePositions()
+			})
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"type": geomType, "coordinates": coordinates}, nil
+}
+
+// parseWKTList parses a parenthesised, comma separated list of elements.
+func (p *textParser) parseWKTList(element func() (interface{}, error)) ([]interface{}, error) {
+	if _, err := p.expect(tokLParen, "'('"); err != nil {
+		return nil, err
+	}
+	var list []interface{}
+	for {
+		e, err := element()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+		sep := p.next()
+		if sep.kind == tokRParen {
+			return list, nil
+		}
+		if sep.kind != tokComma {
+			return nil, p.errorf(sep, "expected ',' or ')', got %s", sep)
+		}
+	}
+}
+
+// parsePositions parses a parenthesised list of positions.
+func (p *textParser) parsePositions() (interface{}, error) {
+	return p.parseWKTList(func() (interface{}, error) {
+		return p.parsePosition()
+	})
+}
+
+// parsePosition parses the 2 or 3 space separated numbers of a position.
+func (p *textParser) parsePosition() ([]float64, error) {
+	var position []float64
+	for p.peek().kind == tokNumber {
+		f, _ := strconv.ParseFloat(p.next().text, 64)
+		position = append(position, f)
+	}
+	if len(position) != 2 && len(position) != 3 {
+		return nil, p.errorf(p.peek(), "expected a position of 2 or 3 numbers")
+	}
+	return position, nil
+}
@@ -0,0 +1,220 @@
+// Package freetext parses the q parameter of the free-text extension, which
+// each api turns into a query of its own.
+package freetext
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Node is a node of a parsed free-text query: a word or phrase, or the
+// and, or or not of its children.
+type Node struct {
+	Op       string
+	Term     string
+	Phrase   bool
+	Children []*Node
+}
+
+// Parse parses the q parameter of the free-text extension. Terms
+// separated by commas or spaces match if any of them does, and a quoted
+// phrase matches its words in order. AND, OR, NOT and parentheses combine
+// terms, and a term prefixed with "+" is required while one prefixed with
+// "-" is excluded.
+func Parse(q string) (*Node, error) {
+	p := &parser{tokens: tokenize(q)}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid q: %v", err)
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid q: unexpected %q", p.tokens[p.pos])
+	}
+	if node == nil {
+		return nil, fmt.Errorf("invalid q: no search terms")
+	}
+	return node, nil
+}
+
+// tokenize splits q into parentheses, commas, quoted phrases and
+// words. A "+" or "-" prefix stays on the token it precedes.
+func tokenize(q string) []string {
+	var tokens []string
+	runes := []rune(q)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == ',':
+			tokens = append(tokens, string(r))
+			i++
+		default:
+			start := i
+			if (r == '+' || r == '-') && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '(') {
+				i++
+				if runes[i] == '(' {
+					tokens = append(tokens, string(runes[start:i]), "(")
+					i++
+					continue
+				}
+			}
+			if runes[i] == '"' {
+				end := i + 1
+				for end < len(runes) && runes[end] != '"' {
+					end++
+				}
+				if end < len(runes) {
+					end++
+				}
+				tokens = append(tokens, string(runes[start:end]))
+				i = end
+				continue
+			}
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune("(),\"", runes[i]) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		}
+	}
+	return tokens
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// parseOr parses a list of clauses. Without required or excluded clauses
+// the list matches if any clause does. Otherwise every required clause must
+// match, no excluded one may, and the other clauses only count when nothing
+// is required.
+func (p *parser) parseOr() (*Node, error) {
+	var optional, required, excluded []*Node
+	for {
+		switch p.peek() {
+		case "", ")":
+			return combineClauses(optional, required, excluded), nil
+		case ",", "OR":
+			p.pos++
+			continue
+		}
+
+		modifier := ""
+		if token := p.peek(); token == "+" || token == "-" {
+			modifier = token
+			p.pos++
+		} else if len(token) > 1 && (token[0] == '+' || token[0] == '-') {
+			modifier = token[:1]
+			p.tokens[p.pos] = token[1:]
+		}
+
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		switch modifier {
+		case "+":
+			required = append(required, node)
+		case "-":
+			excluded = append(excluded, node)
+		default:
+			optional = append(optional, node)
+		}
+	}
+}
+
+func combineClauses(optional []*Node, required []*Node, excluded []*Node) *Node {
+	var clauses []*Node
+	if len(required) > 0 {
+		clauses = append(clauses, required...)
+	} else if len(optional) == 1 {
+		clauses = append(clauses, optional[0])
+	} else if len(optional) > 1 {
+		clauses = append(clauses, &Node{Op: "or", Children: optional})
+	}
+	for _, node := range excluded {
+		clauses = append(clauses, &Node{Op: "not", Children: []*Node{node}})
+	}
+
+	switch len(clauses) {
+	case 0:
+		return nil
+	case 1:
+		return clauses[0]
+	}
+	return &Node{Op: "and", Children: clauses}
+}
+
+func (p *parser) parseAnd() (*Node, error) {
+	node, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "AND" {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		node = &Node{Op: "and", Children: []*Node{node, right}}
+	}
+	return node, nil
+}
+
+func (p *parser) parseNot() (*Node, error) {
+	if p.peek() != "NOT" {
+		return p.parsePrimary()
+	}
+	p.pos++
+	node, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return &Node{Op: "not", Children: []*Node{node}}, nil
+}
+
+func (p *parser) parsePrimary() (*Node, error) {
+	token := p.peek()
+	switch token {
+	case "":
+		return nil, fmt.Errorf("expected a term")
+	case "(":
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		if node == nil {
+			return nil, fmt.Errorf("empty parentheses")
+		}
+		return node, nil
+	case ")", ",", "AND", "OR", "NOT":
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+
+	p.pos++
+	if strings.HasPrefix(token, `"`) {
+		if len(token) < 2 || !strings.HasSuffix(token, `"`) {
+			return nil, fmt.Errorf("unterminated phrase %s", token)
+		}
+		phrase := strings.TrimSpace(token[1 : len(token)-1])
+		if phrase == "" {
+			return nil, fmt.Errorf("empty phrase")
+		}
+		return &Node{Term: phrase, Phrase: true}, nil
+	}
+	return &Node{Term: token}, nil
+}
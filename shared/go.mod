@@ -0,0 +1,3 @@
+module github.com/jonhealy1/goapi-stac/shared
+
+go 1.19
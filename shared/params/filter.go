@@ -0,0 +1,196 @@
+package params
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jonhealy1/goapi-stac/shared/cql2"
+)
+
+// Filter parses the filter of a POST search, written in filter-lang lang
+// and filter-crs crs, or returns nil if there is none. A cql2-text filter
+// is sent as a JSON string.
+func Filter(filter json.RawMessage, lang string, crs string) (cql2.Expr, error) {
+	if len(filter) == 0 {
+		return nil, nil
+	}
+
+	if lang == "" {
+		lang = "cql2-json"
+	}
+	text := []byte(filter)
+	if lang == "cql2-text" {
+		var s string
+		if err := json.Unmarshal(filter, &s); err != nil {
+			return nil, fmt.Errorf("a cql2-text filter must be a string")
+		}
+		text = []byte(s)
+	}
+
+	expr, err := ParseFilter(text, lang, crs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %v", err)
+	}
+	return expr, nil
+}
+
+// ParseFilter parses a filter written in filter-lang lang.
+func ParseFilter(filter []byte, lang string, crs string) (cql2.Expr, error) {
+	if crs != "" && crs != CRS84 {
+		return nil, fmt.Errorf("unsupported filter-crs %q", crs)
+	}
+
+	switch lang {
+	case "cql2-json":
+		return cql2.ParseJSON(filter)
+	case "cql2-text":
+		return cql2.ParseText(string(filter))
+	}
+	return nil, fmt.Errorf("unsupported filter-lang %q", lang)
+}
+
+// ParseDatetime parses a STAC datetime parameter, either a single RFC 3339
+// instant or an interval, into an interval. An instant is an interval
+// starting and ending at it, and a date stands for the whole day.
+func ParseDatetime(datetime string) (cql2.Interval, error) {
+	start, end := datetime, datetime
+	if parts := strings.Split(datetime, "/"); len(parts) == 2 {
+		start, end = parts[0], parts[1]
+		if (start == "" || start == "..") && (end == "" || end == "..") {
+			return cql2.Interval{}, fmt.Errorf("datetime interval %q must have at least one bound", datetime)
+		}
+	} else if len(parts) > 2 || datetime == ".." {
+		return cql2.Interval{}, fmt.Errorf("invalid datetime %q", datetime)
+	}
+
+	interval, err := cql2.ParseInterval(start, end)
+	if err != nil {
+		return interval, fmt.Errorf("invalid datetime: %v", err)
+	}
+	return interval, nil
+}
+
+// DatetimeFilter turns a STAC datetime parameter, either a single RFC 3339
+// instant or an interval such as "2020-01-01T00:00:00Z/.." into a filter
+// matching the items whose datetime, or start_datetime to end_datetime
+// range, overlaps it. It returns nil for an empty datetime.
+func DatetimeFilter(datetime string) (cql2.Expr, error) {
+	if datetime == "" {
+		return nil, nil
+	}
+
+	interval, err := ParseDatetime(datetime)
+	if err != nil {
+		return nil, err
+	}
+	return cql2.Temporal{
+		Op:    "t_intersects",
+		Left:  cql2.Property{Name: "datetime"},
+		Right: interval,
+	}, nil
+}
+
+// queryComparisons maps the comparison operators of the query extension to
+// their CQL2 equivalents.
+var queryComparisons = map[string]string{
+	"eq":  "=",
+	"neq": "<>",
+	"lt":  "<",
+	"lte": "<=",
+	"gt":  ">",
+	"gte": ">=",
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// QueryFilter turns the query object of the legacy query extension into the
+// equivalent filter, or nil for an empty query. Strings holding RFC 3339
+// timestamps are compared as timestamps.
+func QueryFilter(query map[string]map[string]interface{}) (cql2.Expr, error) {
+	var names []string
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var args []cql2.Expr
+	for _, name := range names {
+		property := cql2.Property{Name: name}
+
+		var ops []string
+		for op := range query[name] {
+			ops = append(ops, op)
+		}
+		sort.Strings(ops)
+
+		for _, op := range ops {
+			value := query[name][op]
+			if cmp, ok := queryComparisons[op]; ok {
+				literal, err := queryValue(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s %s: %v", name, op, err)
+				}
+				args = append(args, cql2.Comparison{Op: cmp, Left: property, Right: literal})
+				continue
+			}
+
+			switch op {
+			case "startsWith", "endsWith", "contains":
+				s, ok := value.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s %s requires a string", name, op)
+				}
+				pattern := likeEscaper.Replace(s)
+				if op != "startsWith" {
+					pattern = "%" + pattern
+				}
+				if op != "endsWith" {
+					pattern += "%"
+				}
+				args = append(args, cql2.Like{Value: property, Pattern: cql2.Literal{Value: pattern}})
+			case "in":
+				values, ok := value.([]interface{})
+				if !ok || len(values) == 0 {
+					return nil, fmt.Errorf("%s in requires a non-empty array", name)
+				}
+				var list []cql2.Expr
+				for _, v := range values {
+					literal, err := queryValue(v)
+					if err != nil {
+						return nil, fmt.Errorf("%s in: %v", name, err)
+					}
+					list = append(list, literal)
+				}
+				args = append(args, cql2.In{Value: property, List: list})
+			default:
+				return nil, fmt.Errorf("unsupported operator %q", op)
+			}
+		}
+	}
+
+	switch len(args) {
+	case 0:
+		return nil, nil
+	case 1:
+		return args[0], nil
+	}
+	return cql2.Logical{Op: "and", Args: args}, nil
+}
+
+// queryValue turns a value of the query extension into a literal.
+func queryValue(value interface{}) (cql2.Expr, error) {
+	switch v := value.(type) {
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return cql2.Timestamp{Value: t}, nil
+		}
+		return cql2.Literal{Value: v}, nil
+	case float64, bool:
+		return cql2.Literal{Value: v}, nil
+	}
+	return nil, fmt.Errorf("expected a string, number or boolean")
+}
@@ -0,0 +1,118 @@
+// Package params parses the parameters of item and collection requests the
+// apis share, into values and CQL2 filters each api builds its queries
+// from.
+package params
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The CRSs a bbox-crs parameter can name: CRS84, with heights for 6 number
+// bboxes, and WGS 84 with latitude first. CRS84 is the only CRS of filters.
+const (
+	CRS84    = "http://www.opengis.net/def/crs/OGC/1.3/CRS84"
+	CRS84h   = "http://www.opengis.net/def/crs/OGC/0/CRS84h"
+	EPSG4326 = "http://www.opengis.net/def/crs/EPSG/0/4326"
+)
+
+// EnvInt reads an integer setting from the environment, or returns
+// fallback if it is not set or not an integer.
+func EnvInt(name string, fallback int) int {
+	if value, exists := os.LookupEnv(name); exists {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+// ParseBbox reads the bbox parameter of a GET request, a comma separated
+// list of numbers, or nil if it is empty.
+func ParseBbox(bbox string) ([]float64, error) {
+	if bbox == "" {
+		return nil, nil
+	}
+	var coordinates []float64
+	for _, s := range strings.Split(bbox, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bbox %q", bbox)
+		}
+		coordinates = append(coordinates, f)
+	}
+	return coordinates, nil
+}
+
+// BboxToCRS84 returns a bbox given in crs, the bbox-crs parameter of OGC API
+// Features, with longitudes first as CRS84 orders them.
+func BboxToCRS84(bbox []float64, crs string) ([]float64, error) {
+	switch crs {
+	case "", CRS84, CRS84h:
+		return bbox, nil
+	case EPSG4326:
+		swapped := append([]float64{}, bbox...)
+		half := len(bbox) / 2
+		for _, i := range []int{0, half} {
+			if i+1 < len(swapped) {
+				swapped[i], swapped[i+1] = swapped[i+1], swapped[i]
+			}
+		}
+		return swapped, nil
+	}
+	return nil, fmt.Errorf("unsupported bbox-crs %q, bboxes can be in %s or %s", crs, CRS84, EPSG4326)
+}
+
+// Fields lists the paths of the item JSON a search includes in or excludes
+// from its results.
+type Fields struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// ParseFields reads the fields parameter of a GET request, a comma
+// separated list of paths that are excluded if prefixed with "-" and
+// included otherwise, with an optional "+" prefix.
+func ParseFields(param string) Fields {
+	var fields Fields
+	for _, field := range strings.Split(param, ",") {
+		field = strings.TrimSpace(field)
+		switch {
+		case field == "" || field == "-" || field == "+":
+		case strings.HasPrefix(field, "-"):
+			fields.Exclude = append(fields.Exclude, field[1:])
+		case strings.HasPrefix(field, "+"):
+			fields.Include = append(fields.Include, field[1:])
+		default:
+			fields.Include = append(fields.Include, field)
+		}
+	}
+	return fields
+}
+
+// Sort is a field a search is sorted by, asc or desc.
+type Sort struct {
+	Field     string `json:"field"`
+	Direction string `json:"direction"`
+}
+
+// ParseSortby reads the sortby parameter of a GET request, a comma
+// separated list of fields that are sorted descending if prefixed with "-"
+// and ascending otherwise, with an optional "+" prefix.
+func ParseSortby(param string) []Sort {
+	var sortby []Sort
+	for _, field := range strings.Split(param, ",") {
+		// an unencoded "+" arrives as a space
+		field = strings.TrimSpace(field)
+		switch {
+		case field == "":
+		case strings.HasPrefix(field, "-"):
+			sortby = append(sortby, Sort{Field: field[1:], Direction: "desc"})
+		default:
+			sortby = append(sortby, Sort{Field: strings.TrimPrefix(field, "+"), Direction: "asc"})
+		}
+	}
+	return sortby
+}
@@ -0,0 +1,286 @@
+// Package queryables computes the properties filters on items can use, from
+// a sample of the items and the summaries of their collections, and checks
+// filters against them.
+package queryables
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonhealy1/goapi-stac/shared/cql2"
+)
+
+// SampleSize is the number of items whose properties are sampled to find
+// the queryables of a collection.
+const SampleSize = 1000
+
+// ttl is how long computed queryables are reused.
+const ttl = 5 * time.Minute
+
+// Core are the queryables of every item.
+var Core = map[string]map[string]interface{}{
+	"id":             {"title": "Item ID", "type": "string"},
+	"collection":     {"title": "Collection ID", "type": "string"},
+	"geometry":       {"title": "Geometry", "$ref": "https://geojson.org/schema/Geometry.json"},
+	"datetime":       {"title": "Datetime", "type": "string", "format": "date-time"},
+	"start_datetime": {"title": "Start datetime", "type": "string", "format": "date-time"},
+	"end_datetime":   {"title": "End datetime", "type": "string", "format": "date-time"},
+}
+
+// Source reads what the queryables of some collections, or of all items if
+// none is given, are made of.
+type Source interface {
+	// Sample returns the JSON types, as jsonb_typeof names them, of the
+	// properties of at most SampleSize items.
+	Sample(collections []string) (map[string][]string, error)
+	// Summaries returns the summaries of the collections.
+	Summaries(collections []string) ([]map[string]interface{}, error)
+}
+
+// overrides pin queryables to a schema, which adds them even if no item
+// has them, or hide them. They are read from the JSON file at
+// QUERYABLES_OVERRIDES, for example
+//
+//	{
+//		"pin": {"eo:cloud_cover": {"type": "number", "minimum": 0, "maximum": 100}},
+//		"hide": ["s2:granule_id"],
+//		"collections": {"sentinel-2-l2a": {"hide": ["s2:datatake_id"], "lenient": true}}
+//	}
+//
+// Collection overrides apply after the global ones. Filters can only use
+// the queryables, so a property no item of the sample has must be pinned,
+// unless the overrides are lenient: then filters can use any property but
+// those hidden.
+type overrides struct {
+	Lenient     bool                              `json:"lenient"`
+	Pin         map[string]map[string]interface{} `json:"pin"`
+	Hide        []string                          `json:"hide"`
+	Collections map[string]overrides              `json:"collections"`
+}
+
+func (o overrides) apply(q *Set) {
+	for name, schema := range o.Pin {
+		q.Properties[name] = schema
+		delete(q.hidden, name)
+	}
+	for _, name := range o.Hide {
+		delete(q.Properties, name)
+		q.hidden[name] = true
+	}
+}
+
+func readOverrides() (overrides, error) {
+	var o overrides
+	path, exists := os.LookupEnv("QUERYABLES_OVERRIDES")
+	if !exists || path == "" {
+		return o, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return o, err
+	}
+	if err := json.Unmarshal(b, &o); err != nil {
+		return o, fmt.Errorf("invalid queryables overrides %s: %v", path, err)
+	}
+	return o, nil
+}
+
+// Set is the queryables of some collections.
+type Set struct {
+	Properties map[string]map[string]interface{}
+	// Lenient is set if filters can use other properties than those
+	// hidden
+	Lenient bool
+	// hidden are the properties the overrides hide
+	hidden map[string]bool
+}
+
+type cached struct {
+	queryables Set
+	expires    time.Time
+}
+
+var cache = struct {
+	sync.Mutex
+	entries map[string]cached
+}{entries: map[string]cached{}}
+
+// Get returns the queryables of the items of the given collections, or of
+// all items if there are none. They are the core queryables, the
+// properties found in a sample of the items, and those described by
+// collection summaries, with the overrides applied last. They are lenient
+// if the global overrides are, or the overrides of every collection given.
+func Get(source Source, collections []string) (Set, error) {
+	collections = append([]string{}, collections...)
+	sort.Strings(collections)
+	// another overrides file computes them anew
+	key := os.Getenv("QUERYABLES_OVERRIDES") + "|" + strings.Join(collections, ",")
+
+	cache.Lock()
+	entry, ok := cache.entries[key]
+	cache.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.queryables, nil
+	}
+
+	q := Set{Properties: map[string]map[string]interface{}{}, hidden: map[string]bool{}}
+	for name, schema := range Core {
+		q.Properties[name] = schema
+	}
+	types, err := source.Sample(collections)
+	if err != nil {
+		return q, err
+	}
+	addSampled(types, q.Properties)
+	summaries, err := source.Summaries(collections)
+	if err != nil {
+		return q, err
+	}
+	addSummaries(summaries, q.Properties)
+
+	o, err := readOverrides()
+	if err != nil {
+		return q, err
+	}
+	o.apply(&q)
+	q.Lenient = o.Lenient || len(collections) > 0
+	for _, collection := range collections {
+		o.Collections[collection].apply(&q)
+		q.Lenient = q.Lenient && (o.Lenient || o.Collections[collection].Lenient)
+	}
+
+	cache.Lock()
+	cache.entries[key] = cached{q, time.Now().Add(ttl)}
+	cache.Unlock()
+	return q, nil
+}
+
+// addSampled adds the properties of a sample of the items, typed by the
+// JSON types of their values.
+func addSampled(types map[string][]string, properties map[string]map[string]interface{}) {
+	for name, sampled := range types {
+		if _, ok := Core[name]; ok {
+			continue
+		}
+		var jsonTypes []string
+		for _, jsonType := range sampled {
+			if jsonType != "null" {
+				jsonTypes = append(jsonTypes, jsonType)
+			}
+		}
+		if len(jsonTypes) == 0 {
+			continue
+		}
+		sort.Strings(jsonTypes)
+
+		schema := map[string]interface{}{"type": jsonTypes[0]}
+		if len(jsonTypes) > 1 {
+			schema["type"] = jsonTypes
+		}
+		if jsonTypes[0] == "string" && isTimestampProperty(name) {
+			schema["format"] = "date-time"
+		}
+		properties[name] = schema
+	}
+}
+
+// isTimestampProperty tells whether a property holds timestamps by its
+// name, as the STAC common metadata names them.
+func isTimestampProperty(name string) bool {
+	return name == "created" || name == "updated" || strings.HasSuffix(name, "datetime")
+}
+
+// addSummaries adds the properties described by collection summaries.
+func addSummaries(summaries []map[string]interface{}, properties map[string]map[string]interface{}) {
+	for _, summary := range summaries {
+		for name, value := range summary {
+			if _, ok := Core[name]; ok {
+				continue
+			}
+			if schema := summarySchema(value, properties[name]); schema != nil {
+				properties[name] = schema
+			}
+		}
+	}
+}
+
+// summarySchema returns the schema of a property given its summary: a
+// list of its values, a range, or a JSON schema. sampled is the schema
+// found by sampling items, if any, which knows whether the property is an
+// array of the values listed.
+func summarySchema(summary interface{}, sampled map[string]interface{}) map[string]interface{} {
+	switch v := summary.(type) {
+	case []interface{}:
+		if len(v) == 0 {
+			return nil
+		}
+		valueType := ""
+		switch v[0].(type) {
+		case string:
+			valueType = "string"
+		case float64:
+			valueType = "number"
+		case bool:
+			valueType = "boolean"
+		default:
+			return nil
+		}
+		schema := map[string]interface{}{"type": valueType, "enum": v}
+		if sampled != nil && sampled["type"] == "array" {
+			return map[string]interface{}{"type": "array", "items": schema}
+		}
+		return schema
+	case map[string]interface{}:
+		minimum, hasMinimum := v["minimum"]
+		maximum, hasMaximum := v["maximum"]
+		if !hasMinimum || !hasMaximum {
+			// a summary that is not a range is a JSON schema
+			return v
+		}
+		if _, ok := minimum.(string); ok {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		return map[string]interface{}{"type": "number", "minimum": minimum, "maximum": maximum}
+	}
+	return nil
+}
+
+// Check rejects a filter that references properties that are not
+// queryables, or, if the queryables are lenient, properties the overrides
+// hide, with an UnknownError.
+func (q Set) Check(filter cql2.Expr) error {
+	for _, name := range cql2.Properties(filter) {
+		if _, ok := q.Properties[name]; q.hidden[name] || !ok && !q.Lenient {
+			return &UnknownError{name}
+		}
+	}
+	return nil
+}
+
+// Schema returns the JSON schema of the queryables, whose $id is id.
+func (q Set) Schema(id string) map[string]interface{} {
+	return map[string]interface{}{
+		"$schema":              "https://json-schema.org/draft/2019-09/schema",
+		"$id":                  id,
+		"type":                 "object",
+		"title":                "Queryables",
+		"properties":           q.Properties,
+		"additionalProperties": q.Lenient,
+	}
+}
+
+// UnknownError is the error of a filter on a property that is not
+// queryable.
+type UnknownError struct {
+	Name string
+}
+
+func (e *UnknownError) Error() string {
+	return fmt.Sprintf("invalid filter: %q is not a queryable, see /queryables for the properties filters can use", e.Name)
+}
@@ -0,0 +1,184 @@
+// Package stac checks STAC items written to the apis.
+package stac
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jonhealy1/goapi-stac/shared/cql2"
+	"github.com/jonhealy1/goapi-stac/shared/patch"
+)
+
+// ErrInvalidItem is wrapped by the errors returned for items that are not
+// valid.
+var ErrInvalidItem = errors.New("invalid item")
+
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidItem, fmt.Sprintf(format, args...))
+}
+
+// item holds the members of an item that are checked.
+type item struct {
+	Id         string                 `json:"id"`
+	Type       string                 `json:"type"`
+	Collection string                 `json:"collection"`
+	Geometry   json.RawMessage        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// check checks that an item is a valid item of a collection, and returns
+// its geometry and the bbox of it.
+func (i *item) check(collectionId string) (cql2.Geometry, []float64, error) {
+	if i.Collection != "" && i.Collection != collectionId {
+		return cql2.Geometry{}, nil, invalid("the collection must be %s", collectionId)
+	}
+	if i.Type != "Feature" {
+		return cql2.Geometry{}, nil, invalid("the type must be Feature")
+	}
+	geometry, bbox, err := checkGeometry(i.Geometry)
+	if err != nil {
+		return cql2.Geometry{}, nil, err
+	}
+	if i.Properties == nil {
+		return cql2.Geometry{}, nil, invalid("properties must be an object")
+	}
+	if err := CheckDatetimes(i.Properties); err != nil {
+		return cql2.Geometry{}, nil, err
+	}
+	return geometry, bbox, nil
+}
+
+// CheckItem checks an item written to a collection, whose geometry may be
+// of any type, and returns it with its geometry. It is given its
+// collection if it names none; the rest of the item is kept as written.
+func CheckItem(data []byte, collectionId string) ([]byte, cql2.Geometry, error) {
+	var i item
+	if err := json.Unmarshal(data, &i); err != nil {
+		return nil, cql2.Geometry{}, invalid("%v", err)
+	}
+	if i.Id == "" {
+		return nil, cql2.Geometry{}, invalid("the id cannot be empty")
+	}
+	geometry, _, err := i.check(collectionId)
+	if err != nil {
+		return nil, cql2.Geometry{}, err
+	}
+	if i.Collection == "" {
+		merge, _ := json.Marshal(map[string]string{"collection": collectionId})
+		if data, err = patch.Apply(data, patch.MergePatchType, merge); err != nil {
+			return nil, cql2.Geometry{}, err
+		}
+	}
+	return data, geometry, nil
+}
+
+// ValidateItem checks a patched item, which must keep its id and be a
+// valid item of its collection, and returns it with its geometry. It is
+// given its collection if the patch removed it, and the bbox of an item
+// whose geometry changed is computed anew; the rest of the item is kept
+// as patched.
+func ValidateItem(doc, patched []byte, id, collectionId string) ([]byte, cql2.Geometry, error) {
+	var i item
+	if err := json.Unmarshal(patched, &i); err != nil {
+		return nil, cql2.Geometry{}, invalid("%v", err)
+	}
+	if i.Id != id {
+		return nil, cql2.Geometry{}, invalid("the id cannot change")
+	}
+	geometry, bbox, err := i.check(collectionId)
+	if err != nil {
+		return nil, cql2.Geometry{}, err
+	}
+
+	restored := map[string]interface{}{}
+	if i.Collection == "" {
+		restored["collection"] = collectionId
+	}
+	var before struct {
+		Geometry json.RawMessage `json:"geometry"`
+	}
+	json.Unmarshal(doc, &before)
+	if previous, err := cql2.ParseGeoJSON(before.Geometry); err != nil || !bytes.Equal(previous.GeoJSON, geometry.GeoJSON) {
+		restored["bbox"] = bbox
+	}
+	if len(restored) > 0 {
+		merge, err := json.Marshal(restored)
+		if err != nil {
+			return nil, cql2.Geometry{}, err
+		}
+		if patched, err = patch.Apply(patched, patch.MergePatchType, merge); err != nil {
+			return nil, cql2.Geometry{}, err
+		}
+	}
+	return patched, geometry, nil
+}
+
+// checkGeometry checks the geometry of an item, which may be of any type
+// but must have a position, and returns it with its bbox.
+func checkGeometry(data []byte) (cql2.Geometry, []float64, error) {
+	geometry, err := cql2.ParseGeoJSON(data)
+	if err != nil {
+		return cql2.Geometry{}, nil, invalid("%v", err)
+	}
+	bbox, ok := Bbox(geometry.GeoJSON)
+	if !ok {
+		return cql2.Geometry{}, nil, invalid("the geometry has no positions")
+	}
+	return geometry, bbox, nil
+}
+
+// CheckDatetimes checks that the properties of an item have a datetime, or
+// a start and end datetime.
+func CheckDatetimes(properties map[string]interface{}) error {
+	datetimes := []string{"datetime"}
+	if properties["datetime"] == nil {
+		datetimes = []string{"start_datetime", "end_datetime"}
+	}
+	for _, name := range datetimes {
+		value, _ := properties[name].(string)
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return invalid("properties.%s must be an RFC 3339 timestamp", name)
+		}
+	}
+	return nil
+}
+
+// Bbox returns the bbox of a valid GeoJSON geometry of any type, and false
+// if it has no positions, as an empty GeometryCollection.
+func Bbox(geometry []byte) ([]float64, bool) {
+	bbox := []float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	var extend func(value interface{})
+	extend = func(value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			extend(v["coordinates"])
+			extend(v["geometries"])
+		case []interface{}:
+			if len(v) == 0 {
+				return
+			}
+			if x, ok := v[0].(float64); ok && len(v) > 1 {
+				y, _ := v[1].(float64)
+				bbox[0] = math.Min(bbox[0], x)
+				bbox[1] = math.Min(bbox[1], y)
+				bbox[2] = math.Max(bbox[2], x)
+				bbox[3] = math.Max(bbox[3], y)
+				return
+			}
+			for _, element := range v {
+				extend(element)
+			}
+		}
+	}
+	var decoded interface{}
+	json.Unmarshal(geometry, &decoded)
+	extend(decoded)
+	if math.IsInf(bbox[0], 1) {
+		return nil, false
+	}
+	return bbox, true
+}